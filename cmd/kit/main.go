@@ -1,10 +1,15 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/systemshift/kit/pkg/repo"
 )
@@ -21,14 +26,32 @@ func main() {
 		fmt.Fprintf(os.Stderr, "Usage: kit <command> [arguments]\n\n")
 		fmt.Fprintf(os.Stderr, "Commands:\n")
 		fmt.Fprintf(os.Stderr, "  init             Initialize a new repository\n")
-		fmt.Fprintf(os.Stderr, "  add <file>       Add file contents to the staging area\n")
+		fmt.Fprintf(os.Stderr, "  add <paths>      Add file contents to the staging area\n")
+		fmt.Fprintf(os.Stderr, "  bisect <cmd>     Find the commit that introduced a bug by binary search\n")
+		fmt.Fprintf(os.Stderr, "  blame <path>     Show the commit that last changed each line of a file\n")
+		fmt.Fprintf(os.Stderr, "  check-attr       Show the attributes of paths\n")
+		fmt.Fprintf(os.Stderr, "  check-ignore     Debug ignore rules for paths\n")
 		fmt.Fprintf(os.Stderr, "  commit           Record changes to the repository\n")
+		fmt.Fprintf(os.Stderr, "  commit-graph     Write or verify the commit-graph file\n")
 		fmt.Fprintf(os.Stderr, "  branch [name]    List or create branches\n")
+		fmt.Fprintf(os.Stderr, "  cat-object       Show the type, size or contents of an object\n")
 		fmt.Fprintf(os.Stderr, "  checkout <name>  Switch branches\n")
+		fmt.Fprintf(os.Stderr, "  cherry-pick      Apply the changes of existing commits\n")
 		fmt.Fprintf(os.Stderr, "  diff [options]   Show changes between commits or working directory\n")
+		fmt.Fprintf(os.Stderr, "  fsmonitor <cmd>  Start, stop or query the filesystem monitor daemon\n")
 		fmt.Fprintf(os.Stderr, "  merge [options]  Merge changes from another branch\n")
+		fmt.Fprintf(os.Stderr, "  merge-base A B   Find the best common ancestors of two commits\n")
 		fmt.Fprintf(os.Stderr, "  log              Show commit logs\n")
+		fmt.Fprintf(os.Stderr, "  ls-tree <rev>    List the files in a commit's tree\n")
+		fmt.Fprintf(os.Stderr, "  rebase <branch>  Replay the current branch's commits on top of another\n")
+		fmt.Fprintf(os.Stderr, "  reset [<rev>]    Move the current branch and reset the index or working tree\n")
+		fmt.Fprintf(os.Stderr, "  restore <paths>  Restore files in the working tree or index\n")
+		fmt.Fprintf(os.Stderr, "  revert <rev>...  Record commits undoing the changes of existing ones\n")
+		fmt.Fprintf(os.Stderr, "  show <object>    Show a commit with its changes, or a file's contents\n")
+		fmt.Fprintf(os.Stderr, "  sparse-checkout  Limit the working tree to some directories or patterns\n")
 		fmt.Fprintf(os.Stderr, "  status           Show the working tree status\n")
+		fmt.Fprintf(os.Stderr, "  stash [command]  Shelve uncommitted changes\n")
+		fmt.Fprintf(os.Stderr, "  update-index     Upgrade or refresh the index file\n")
 		fmt.Fprintf(os.Stderr, "  verify           Verify repository integrity using kernel methods\n")
 		fmt.Fprintf(os.Stderr, "  help             Show help information for a command\n")
 		fmt.Fprintf(os.Stderr, "\n")
@@ -56,48 +79,57 @@ func main() {
 	case "init":
 		initCmd(cwd)
 	case "add":
-		if flag.NArg() < 2 {
-			fmt.Fprintf(os.Stderr, "Error: 'add' requires at least one file argument\n")
-			os.Exit(1)
-		}
 		addCmd(cwd, flag.Args()[1:])
+	case "check-attr":
+		checkAttrCmd(cwd, flag.Args()[1:])
+	case "check-ignore":
+		checkIgnoreCmd(cwd, flag.Args()[1:])
 	case "commit":
-		message := ""
-
-		// Check for -m flag
-		fs := flag.NewFlagSet("commit", flag.ExitOnError)
-		fs.StringVar(&message, "m", "", "Commit message")
-
-		// Parse the remaining arguments
-		err := fs.Parse(flag.Args()[1:])
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: Failed to parse commit arguments: %v\n", err)
-			os.Exit(1)
-		}
-
-		// Check if a message was provided
-		if message == "" {
-			fmt.Fprintf(os.Stderr, "Error: Commit message is required (use -m \"message\")\n")
-			os.Exit(1)
-		}
-
-		commitCmd(cwd, message)
+		commitCmd(cwd, flag.Args()[1:])
+	case "blame":
+		blameCmd(cwd, flag.Args()[1:])
 	case "branch":
 		branchCmd(cwd, flag.Args()[1:])
+	case "cat-object":
+		catObjectCmd(cwd, flag.Args()[1:])
 	case "checkout":
-		if flag.NArg() < 2 {
-			fmt.Fprintf(os.Stderr, "Error: 'checkout' requires a branch name\n")
-			os.Exit(1)
-		}
-		checkoutCmd(cwd, flag.Args()[1])
+		checkoutCmd(cwd, flag.Args()[1:])
+	case "commit-graph":
+		commitGraphCmd(cwd, flag.Args()[1:])
 	case "diff":
 		diffCmd(cwd, flag.Args()[1:])
+	case "fsmonitor":
+		fsmonitorCmd(cwd, flag.Args()[1:])
 	case "merge":
 		mergeCmd(cwd, flag.Args()[1:])
+	case "merge-base":
+		mergeBaseCmd(cwd, flag.Args()[1:])
+	case "bisect":
+		bisectCmd(cwd, flag.Args()[1:])
+	case "cherry-pick":
+		sequenceCmd(cwd, "cherry-pick", flag.Args()[1:])
+	case "revert":
+		sequenceCmd(cwd, "revert", flag.Args()[1:])
+	case "rebase":
+		rebaseCmd(cwd, flag.Args()[1:])
 	case "status":
-		statusCmd(cwd)
+		statusCmd(cwd, flag.Args()[1:])
 	case "log":
-		logCmd(cwd)
+		logCmd(cwd, flag.Args()[1:])
+	case "ls-tree":
+		lsTreeCmd(cwd, flag.Args()[1:])
+	case "reset":
+		resetCmd(cwd, flag.Args()[1:])
+	case "restore":
+		restoreCmd(cwd, flag.Args()[1:])
+	case "show":
+		showCmd(cwd, flag.Args()[1:])
+	case "sparse-checkout":
+		sparseCheckoutCmd(cwd, flag.Args()[1:])
+	case "stash":
+		stashCmd(cwd, flag.Args()[1:])
+	case "update-index":
+		updateIndexCmd(cwd, flag.Args()[1:])
 	case "verify":
 		verifyCmd(cwd)
 	case "help":
@@ -129,7 +161,7 @@ func initCmd(path string) {
 }
 
 // addCmd adds files to the staging area
-func addCmd(path string, files []string) {
+func addCmd(path string, args []string) {
 	// Check if this is a repository
 	if !repo.IsRepository(path) {
 		fmt.Fprintf(os.Stderr, "Error: Not a Kit repository\n")
@@ -143,19 +175,114 @@ func addCmd(path string, files []string) {
 		os.Exit(1)
 	}
 
-	// Add each file
-	for _, file := range files {
-		err = r.Add(file)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: Failed to add file %s: %v\n", file, err)
+	// Parse options
+	fs := flag.NewFlagSet("add", flag.ExitOnError)
+	var force, all, update, dryRun bool
+	var patchFile string
+	fs.BoolVar(&force, "f", false, "Allow adding otherwise ignored files")
+	fs.BoolVar(&force, "force", false, "Allow adding otherwise ignored files")
+	fs.BoolVar(&all, "A", false, "Also stage deletions of tracked files")
+	fs.BoolVar(&all, "all", false, "Also stage deletions of tracked files")
+	fs.BoolVar(&update, "u", false, "Only stage files that are already tracked")
+	fs.BoolVar(&update, "update", false, "Only stage files that are already tracked")
+	fs.BoolVar(&dryRun, "n", false, "Show what would be staged")
+	fs.BoolVar(&dryRun, "dry-run", false, "Show what would be staged")
+	fs.StringVar(&patchFile, "patch-file", "", "Stage the hunks in a diff file (- for stdin)")
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to parse add arguments: %v\n", err)
+		os.Exit(1)
+	}
+
+	if patchFile != "" {
+		addPatchFile(r, patchFile)
+		return
+	}
+
+	if fs.NArg() == 0 && !all && !update {
+		fmt.Fprintf(os.Stderr, "Error: 'add' requires at least one file argument (or -A / -u)\n")
+		os.Exit(1)
+	}
+
+	options := &repo.AddOptions{
+		Force:  force,
+		All:    all,
+		Update: update,
+		DryRun: dryRun,
+	}
+
+	// Stage the selected files
+	result, err := r.AddPaths(fs.Args(), options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to add files: %v\n", err)
+		os.Exit(1)
+	}
+
+	for _, file := range result.Added {
+		if dryRun {
+			fmt.Printf("Would add %s\n", file)
+		} else {
+			fmt.Printf("Added %s\n", file)
+		}
+	}
+	for _, file := range result.Removed {
+		if dryRun {
+			fmt.Printf("Would remove %s\n", file)
+		} else {
+			fmt.Printf("Removed %s\n", file)
+		}
+	}
+}
+
+// addPatchFile stages the hunks of a diff file onto the index versions of its files
+func addPatchFile(r *repo.Repository, patchFile string) {
+	var content []byte
+	var err error
+	if patchFile == "-" {
+		content, err = io.ReadAll(os.Stdin)
+	} else {
+		content, err = os.ReadFile(patchFile)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to read patch: %v\n", err)
+		os.Exit(1)
+	}
+
+	results, err := repo.ParsePatch(string(content))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to parse patch: %v\n", err)
+		os.Exit(1)
+	}
+
+	for _, result := range results {
+		if len(result.Chunks) == 0 && !result.Binary {
+			continue
+		}
+		if result.NewPath == "/dev/null" {
+			fmt.Fprintf(os.Stderr, "Error: Patch deletes %s; use 'kit add -A' to stage deletions\n", result.OldPath)
+			os.Exit(1)
+		}
+		if result.Binary {
+			if result.Patch == nil {
+				fmt.Fprintf(os.Stderr, "Error: Patch only says %s differs; make it with 'kit diff --binary'\n", result.NewPath)
+				os.Exit(1)
+			}
+			if err := r.StageBinaryPatch(result.NewPath, result.Patch, result.OldID, result.NewID); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: Failed to stage binary patch: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Staged binary patch of %s\n", result.NewPath)
+			continue
+		}
+		if err := r.StageHunks(result.NewPath, result.Chunks); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Failed to stage hunks: %v\n", err)
 			os.Exit(1)
 		}
-		fmt.Printf("Added %s\n", file)
+		fmt.Printf("Staged %d hunk(s) of %s\n", len(result.Chunks), result.NewPath)
 	}
 }
 
 // statusCmd shows the repository status
-func statusCmd(path string) {
+func statusCmd(path string, args []string) {
 	// Check if this is a repository
 	if !repo.IsRepository(path) {
 		fmt.Fprintf(os.Stderr, "Error: Not a Kit repository\n")
@@ -169,15 +296,40 @@ func statusCmd(path string) {
 		os.Exit(1)
 	}
 
+	// Parse options
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	porcelain := fs.Bool("porcelain", false, "Give the output in a stable format for scripts")
+	jsonOutput := fs.Bool("json", false, "Give the output as JSON")
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to parse status arguments: %v\n", err)
+		os.Exit(1)
+	}
+	if *porcelain && *jsonOutput {
+		fmt.Fprintf(os.Stderr, "Error: --porcelain and --json cannot be used together\n")
+		os.Exit(1)
+	}
+
 	// Get status
-	status, err := r.Status()
+	report, err := r.StatusReport()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: Failed to get repository status: %v\n", err)
 		os.Exit(1)
 	}
 
 	// Print status
-	fmt.Print(status)
+	switch {
+	case *porcelain:
+		fmt.Print(report.Porcelain())
+	case *jsonOutput:
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Failed to encode status: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(data))
+	default:
+		fmt.Print(report.String())
+	}
 }
 
 // verifyCmd verifies the repository integrity
@@ -212,7 +364,7 @@ func verifyCmd(path string) {
 }
 
 // commitCmd records changes to the repository
-func commitCmd(path string, message string) {
+func commitCmd(path string, args []string) {
 	// Check if this is a repository
 	if !repo.IsRepository(path) {
 		fmt.Fprintf(os.Stderr, "Error: Not a Kit repository\n")
@@ -226,23 +378,61 @@ func commitCmd(path string, message string) {
 		os.Exit(1)
 	}
 
+	options := &repo.CommitOptions{}
+	fs := flag.NewFlagSet("commit", flag.ExitOnError)
+	message := fs.String("m", "", "Commit message")
+	messageFile := fs.String("F", "", "Read the commit message from a file (- for standard input)")
+	fs.BoolVar(&options.All, "a", false, "Stage modified and deleted tracked files first")
+	fs.BoolVar(&options.Amend, "amend", false, "Replace the last commit, keeping its message unless one is given")
+	fs.BoolVar(&options.AllowEmpty, "allow-empty", false, "Commit even if nothing changed")
+	fs.StringVar(&options.Fixup, "fixup", "", "Make a fixup commit for a later rebase to meld into <rev>")
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to parse commit arguments: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *messageFile != "" {
+		if *message != "" {
+			fmt.Fprintf(os.Stderr, "Error: Only one of -m and -F can be used\n")
+			os.Exit(1)
+		}
+		var data []byte
+		if *messageFile == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(*messageFile)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Failed to read commit message: %v\n", err)
+			os.Exit(1)
+		}
+		*message = string(data)
+		options.Cleanup = repo.CleanupStrip
+	}
+
+	// Check if a message was provided
+	if *message == "" && !options.Amend && options.Fixup == "" {
+		fmt.Fprintf(os.Stderr, "Error: Commit message is required (use -m \"message\" or -F <file>)\n")
+		os.Exit(1)
+	}
+
 	// Commit changes
-	commitID, err := r.Commit(message)
+	commitID, err := r.CommitWithOptions(*message, options)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: Failed to commit changes: %v\n", err)
 		os.Exit(1)
 	}
 
-	// Print commit ID (abbreviated)
-	shortID := commitID
-	if len(shortID) > 8 {
-		shortID = shortID[:8]
+	info, err := r.CommitInfo(commitID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
 	}
-	fmt.Printf("[%s] %s\n", shortID, message)
+	fmt.Print(repo.FormatLog([]*repo.CommitLog{info}, "format:[%h] %s"))
 }
 
 // logCmd shows the commit log
-func logCmd(path string) {
+func logCmd(path string, args []string) {
 	// Check if this is a repository
 	if !repo.IsRepository(path) {
 		fmt.Fprintf(os.Stderr, "Error: Not a Kit repository\n")
@@ -256,8 +446,91 @@ func logCmd(path string) {
 		os.Exit(1)
 	}
 
+	// Parse options
+	fs := flag.NewFlagSet("log", flag.ExitOnError)
+	topoOrder := fs.Bool("topo-order", false, "Show no parents before all of their children, without interleaving lines of history")
+	dateOrder := fs.Bool("date-order", false, "Show no parents before all of their children, otherwise newest first")
+	firstParent := fs.Bool("first-parent", false, "Only follow the first parent of merge commits")
+	reverse := fs.Bool("reverse", false, "Show the oldest commits first")
+	graph := fs.Bool("graph", false, "Draw the commit graph next to the log")
+	var maxCount int
+	fs.IntVar(&maxCount, "n", 0, "Show at most this many commits")
+	fs.IntVar(&maxCount, "max-count", 0, "Show at most this many commits")
+	author := fs.String("author", "", "Only show commits whose author matches this regular expression")
+	grep := fs.String("grep", "", "Only show commits whose message matches this regular expression")
+	var since, until string
+	fs.StringVar(&since, "since", "", "Only show commits made after this date")
+	fs.StringVar(&since, "after", "", "Only show commits made after this date")
+	fs.StringVar(&until, "until", "", "Only show commits made before this date")
+	fs.StringVar(&until, "before", "", "Only show commits made before this date")
+	follow := fs.Bool("follow", false, "Follow the history of a single file across renames")
+	oneline := fs.Bool("oneline", false, "Show each commit on one line")
+	format := fs.String("format", "", "Format commits with a template such as \"%h %an %s\"")
+
+	// Paths come after "--"
+	var paths []string
+	for i, arg := range args {
+		if arg == "--" {
+			args, paths = args[:i], args[i+1:]
+			break
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to parse log arguments: %v\n", err)
+		os.Exit(1)
+	}
+	if *follow && len(paths) != 1 {
+		fmt.Fprintf(os.Stderr, "Error: --follow requires exactly one path\n")
+		os.Exit(1)
+	}
+	if *oneline && *format != "" {
+		fmt.Fprintf(os.Stderr, "Error: --oneline and --format cannot be used together\n")
+		os.Exit(1)
+	}
+	if *topoOrder && *dateOrder {
+		fmt.Fprintf(os.Stderr, "Error: --topo-order and --date-order cannot be used together\n")
+		os.Exit(1)
+	}
+	if *graph && *reverse {
+		fmt.Fprintf(os.Stderr, "Error: --graph cannot be used with --reverse\n")
+		os.Exit(1)
+	}
+
+	options := &repo.LogOptions{Author: *author, Grep: *grep}
+	options.FirstParent = *firstParent
+	options.Reverse = *reverse
+	options.MaxCount = maxCount
+	options.Paths = paths
+	options.Follow = *follow
+	now := time.Now()
+	if since != "" {
+		if options.Since, err = repo.ParseLogDate(since, now); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if until != "" {
+		if options.Until, err = repo.ParseLogDate(until, now); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	logFormat := *format
+	if *oneline {
+		logFormat = "oneline"
+	}
+	switch {
+	case *topoOrder:
+		options.Order = repo.OrderTopo
+	case *dateOrder:
+		options.Order = repo.OrderDate
+	case *graph:
+		// The graph needs children before parents
+		options.Order = repo.OrderTopo
+	}
+
 	// Get commit log
-	log, err := r.Log()
+	log, err := r.LogRevisions(fs.Args(), options)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: Failed to get commit log: %v\n", err)
 		os.Exit(1)
@@ -265,17 +538,22 @@ func logCmd(path string) {
 
 	// Check if there are any commits
 	if len(log) == 0 {
-		fmt.Println("No commits yet")
+		if _, err := r.ResolveRevision("HEAD"); err != nil && fs.NArg() == 0 {
+			fmt.Println("No commits yet")
+		}
 		return
 	}
 
 	// Format and print log
-	formattedLog := repo.FormatLog(log)
-	fmt.Println(formattedLog)
+	if *graph {
+		fmt.Print(repo.FormatLogGraph(log, logFormat))
+		return
+	}
+	fmt.Print(repo.FormatLog(log, logFormat))
 }
 
-// branchCmd handles branch operations (create/list)
-func branchCmd(path string, args []string) {
+// blameCmd shows the commit that introduced each line of a file
+func blameCmd(path string, args []string) {
 	// Check if this is a repository
 	if !repo.IsRepository(path) {
 		fmt.Fprintf(os.Stderr, "Error: Not a Kit repository\n")
@@ -289,44 +567,39 @@ func branchCmd(path string, args []string) {
 		os.Exit(1)
 	}
 
-	// Check if branch name was provided
-	if len(args) > 0 {
-		// Create a new branch
-		err := r.CreateBranch(args[0])
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: Failed to create branch: %v\n", err)
-			os.Exit(1)
-		}
-		fmt.Printf("Created branch '%s'\n", args[0])
-		return
+	// Parse options
+	fs := flag.NewFlagSet("blame", flag.ExitOnError)
+	moves := fs.Bool("M", false, "Trace lines moved within the file")
+	copies := fs.Bool("C", false, "Also trace lines moved or copied from other files changed in the same commit")
+	porcelain := fs.Bool("porcelain", false, "Give the output in a format for tools")
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to parse blame arguments: %v\n", err)
+		os.Exit(1)
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fmt.Fprintf(os.Stderr, "Error: 'blame' requires a file and optionally a revision\n")
+		os.Exit(1)
 	}
 
-	// List branches if no name provided
-	branches, err := r.ListBranches()
+	revision := ""
+	if fs.NArg() == 2 {
+		revision = fs.Arg(1)
+	}
+	lines, err := r.Blame(fs.Arg(0), revision, &repo.BlameOptions{Moves: *moves || *copies, Copies: *copies})
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: Failed to list branches: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error: Failed to blame: %v\n", err)
 		os.Exit(1)
 	}
 
-	// Check if there are any branches
-	if len(branches) == 0 {
-		fmt.Println("No branches yet")
+	if *porcelain {
+		fmt.Print(repo.FormatBlamePorcelain(lines))
 		return
 	}
-
-	// Print branches
-	currentBranch, _ := r.GetCurrentBranch()
-	for _, branch := range branches {
-		if branch.Name == currentBranch {
-			fmt.Printf("* %s\n", branch.Name)
-		} else {
-			fmt.Printf("  %s\n", branch.Name)
-		}
-	}
+	fmt.Print(repo.FormatBlame(lines))
 }
 
-// checkoutCmd switches branches
-func checkoutCmd(path string, branchName string) {
+// showCmd shows a commit with its changes, a tree or a file's contents
+func showCmd(path string, args []string) {
 	// Check if this is a repository
 	if !repo.IsRepository(path) {
 		fmt.Fprintf(os.Stderr, "Error: Not a Kit repository\n")
@@ -340,25 +613,43 @@ func checkoutCmd(path string, branchName string) {
 		os.Exit(1)
 	}
 
-	// Check if current branch is already the requested branch
-	currentBranch, err := r.GetCurrentBranch()
-	if err == nil && currentBranch == branchName {
-		fmt.Printf("Already on branch '%s'\n", branchName)
-		return
+	// Parse options
+	fs := flag.NewFlagSet("show", flag.ExitOnError)
+	options := repo.DefaultDiffOptions
+	fs.IntVar(&options.ContextLines, "U", options.ContextLines, "Number of context lines in diffs")
+	algorithm := fs.String("diff-algorithm", string(options.Algorithm), "How to match up lines in diffs: myers or histogram")
+	if args, err = renameFlags(args, &options); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
 	}
-
-	// Switch to the branch
-	err = r.CheckoutBranch(branchName)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: Failed to checkout branch: %v\n", err)
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to parse show arguments: %v\n", err)
+		os.Exit(1)
+	}
+	if options.Algorithm, err = repo.ParseDiffAlgorithm(*algorithm); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 
-	fmt.Printf("Switched to branch '%s'\n", branchName)
+	names := fs.Args()
+	if len(names) == 0 {
+		names = []string{"HEAD"}
+	}
+	for i, name := range names {
+		output, err := r.Show(name, &options)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Failed to show %s: %v\n", name, err)
+			os.Exit(1)
+		}
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Print(output)
+	}
 }
 
-// mergeCmd merges changes from another branch
-func mergeCmd(path string, args []string) {
+// catObjectCmd shows the type, size or contents of an object
+func catObjectCmd(path string, args []string) {
 	// Check if this is a repository
 	if !repo.IsRepository(path) {
 		fmt.Fprintf(os.Stderr, "Error: Not a Kit repository\n")
@@ -373,79 +664,94 @@ func mergeCmd(path string, args []string) {
 	}
 
 	// Parse options
-	fs := flag.NewFlagSet("merge", flag.ExitOnError)
-
-	// Merge options
-	noCommit := fs.Bool("no-commit", false, "Do not create a commit after merge")
-	message := fs.String("m", "", "Custom merge commit message")
-	semantic := fs.Bool("semantic", true, "Use semantic merge for conflicts")
-	strategyStr := fs.String("strategy", "auto", "Merge strategy: auto, ours, theirs, manual")
+	fs := flag.NewFlagSet("cat-object", flag.ExitOnError)
+	showType := fs.Bool("t", false, "Show the object's type")
+	showSize := fs.Bool("s", false, "Show the object's size in bytes")
+	pretty := fs.Bool("p", false, "Show the object's contents in a readable form")
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to parse cat-object arguments: %v\n", err)
+		os.Exit(1)
+	}
+	modes := 0
+	for _, set := range []bool{*showType, *showSize, *pretty} {
+		if set {
+			modes++
+		}
+	}
+	if modes != 1 || fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Error: 'cat-object' requires one of -t, -s or -p and an object\n")
+		os.Exit(1)
+	}
 
-	// Parse args
-	err = fs.Parse(args)
+	objID, objType, err := r.ResolveObjectType(fs.Arg(0))
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: Failed to parse merge arguments: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 
-	// Get branch to merge
-	remainingArgs := fs.Args()
-	if len(remainingArgs) != 1 {
-		fmt.Fprintf(os.Stderr, "Error: 'merge' requires exactly one branch name\n")
-		fmt.Fprintf(os.Stderr, "Usage: kit merge [options] <branch>\n")
-		os.Exit(1)
+	switch {
+	case *showType:
+		fmt.Println(objType)
+	case *showSize:
+		content, err := r.CatObject(objID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(len(content))
+	default:
+		content, err := r.PrettyObject(objID, objType)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(content)
 	}
+}
 
-	branchName := remainingArgs[0]
+// lsTreeCmd lists the files in a commit's tree
+func lsTreeCmd(path string, args []string) {
+	// Check if this is a repository
+	if !repo.IsRepository(path) {
+		fmt.Fprintf(os.Stderr, "Error: Not a Kit repository\n")
+		os.Exit(1)
+	}
 
-	// Convert strategy string to enum
-	var strategy repo.MergeStrategy
-	switch *strategyStr {
-	case "auto":
-		strategy = repo.AutoMerge
-	case "ours":
-		strategy = repo.Ours
-	case "theirs":
-		strategy = repo.Theirs
-	case "manual":
-		strategy = repo.Manual
-	default:
-		fmt.Fprintf(os.Stderr, "Error: Unknown merge strategy '%s'\n", *strategyStr)
+	// Create a repository instance
+	r, err := repo.NewRepository(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to open repository: %v\n", err)
 		os.Exit(1)
 	}
 
-	// Create merge options
-	options := &repo.MergeOptions{
-		Strategy:    strategy,
-		NoCommit:    *noCommit,
-		Message:     *message,
-		UseSemantic: *semantic,
+	// Parse options
+	fs := flag.NewFlagSet("ls-tree", flag.ExitOnError)
+	nameOnly := fs.Bool("name-only", false, "Only show the paths")
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to parse ls-tree arguments: %v\n", err)
+		os.Exit(1)
+	}
+	if fs.NArg() < 1 {
+		fmt.Fprintf(os.Stderr, "Error: 'ls-tree' requires a revision or tree\n")
+		os.Exit(1)
 	}
 
-	// Perform merge
-	result, err := r.Merge(branchName, options)
+	entries, err := r.LsTree(fs.Arg(0), fs.Args()[1:])
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: Failed to merge: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error: Failed to list tree: %v\n", err)
 		os.Exit(1)
 	}
-
-	// Show result
-	if result.FastForward {
-		fmt.Printf("Fast-forward merge of '%s' into current branch\n", branchName)
-	} else if result.Success {
-		if len(result.Conflicts) > 0 {
-			fmt.Printf("Merge completed with %d conflicts resolved\n", len(result.Conflicts))
-		} else {
-			fmt.Println("Merge completed successfully")
+	if *nameOnly {
+		for _, entry := range entries {
+			fmt.Println(entry.Path)
 		}
-	} else {
-		fmt.Printf("Merge resulted in %d conflicts\n", len(result.Conflicts))
-		fmt.Println("Fix conflicts and then commit the result")
+		return
 	}
+	fmt.Print(repo.FormatTree(entries))
 }
 
-// diffCmd shows differences between commits or working directory
-func diffCmd(path string, args []string) {
+// branchCmd handles branch operations (create/list)
+func branchCmd(path string, args []string) {
 	// Check if this is a repository
 	if !repo.IsRepository(path) {
 		fmt.Fprintf(os.Stderr, "Error: Not a Kit repository\n")
@@ -460,58 +766,1162 @@ func diffCmd(path string, args []string) {
 	}
 
 	// Parse options
-	fs := flag.NewFlagSet("diff", flag.ExitOnError)
-	semantic := fs.Bool("semantic", false, "Use semantic diff")
-	context := fs.Int("context", 3, "Number of context lines")
-
-	// Parse args (ignoring unknown flags, which might be commit IDs)
-	err = fs.Parse(args)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: Failed to parse diff arguments: %v\n", err)
+	fs := flag.NewFlagSet("branch", flag.ExitOnError)
+	contains := fs.String("contains", "", "Only list branches whose history includes this commit")
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to parse branch arguments: %v\n", err)
 		os.Exit(1)
 	}
+	args = fs.Args()
 
-	// Create diff options
-	options := &repo.DiffOptions{
-		ContextLines: *context,
-		Semantic:     *semantic,
-	}
-
-	// Get remaining args (for commit IDs)
-	remainingArgs := fs.Args()
-	var commitA, commitB string
-
-	// Handle different diff modes based on number of arguments
-	switch len(remainingArgs) {
-	case 0:
-		// No commits specified, diff working tree vs HEAD
-		commitA = "" // HEAD
-		commitB = "" // Working directory
-	case 1:
-		// One commit specified, diff working tree vs that commit
-		commitA = remainingArgs[0]
-		commitB = "" // Working directory
-	case 2:
-		// Two commits specified, diff between those commits
-		commitA = remainingArgs[0]
-		commitB = remainingArgs[1]
+	// Check if branch name was provided
+	if len(args) > 0 {
+		// Create a new branch
+		err := r.CreateBranch(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Failed to create branch: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Created branch '%s'\n", args[0])
+		return
+	}
+
+	// List branches if no name provided
+	var branches []repo.Branch
+	if *contains != "" {
+		commitID, err := r.ResolveRevision(*contains)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		branches, err = r.BranchesContaining(commitID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Failed to list branches: %v\n", err)
+			os.Exit(1)
+		}
+		if len(branches) == 0 {
+			return
+		}
+	} else {
+		branches, err = r.ListBranches()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Failed to list branches: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Check if there are any branches
+	if len(branches) == 0 {
+		fmt.Println("No branches yet")
+		return
+	}
+
+	// Print branches
+	currentBranch, _ := r.GetCurrentBranch()
+	for _, branch := range branches {
+		if branch.Name == currentBranch {
+			fmt.Printf("* %s\n", branch.Name)
+		} else {
+			fmt.Printf("  %s\n", branch.Name)
+		}
+	}
+}
+
+// commitGraphCmd writes or verifies the commit-graph file
+func commitGraphCmd(path string, args []string) {
+	// Check if this is a repository
+	if !repo.IsRepository(path) {
+		fmt.Fprintf(os.Stderr, "Error: Not a Kit repository\n")
+		os.Exit(1)
+	}
+
+	// Create a repository instance
+	r, err := repo.NewRepository(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to open repository: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Error: 'commit-graph' requires a subcommand: write or verify\n")
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "write":
+		added, err := r.WriteCommitGraph()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Failed to write commit graph: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Added %d commit(s) to the commit graph\n", added)
+	case "verify":
+		problems, err := r.VerifyCommitGraph()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Failed to verify commit graph: %v\n", err)
+			os.Exit(1)
+		}
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		if len(problems) > 0 {
+			os.Exit(1)
+		}
+		fmt.Println("Commit graph OK")
 	default:
-		fmt.Fprintf(os.Stderr, "Error: Too many arguments for diff\n")
+		fmt.Fprintf(os.Stderr, "Error: Unknown commit-graph subcommand '%s'\n", args[0])
 		os.Exit(1)
 	}
+}
 
-	// Perform the diff
-	diff, err := r.Diff(commitA, commitB, options)
+// checkoutCmd switches branches
+func checkoutCmd(path string, args []string) {
+	// Check if this is a repository
+	if !repo.IsRepository(path) {
+		fmt.Fprintf(os.Stderr, "Error: Not a Kit repository\n")
+		os.Exit(1)
+	}
+
+	// Create a repository instance
+	r, err := repo.NewRepository(path)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: Failed to perform diff: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error: Failed to open repository: %v\n", err)
 		os.Exit(1)
 	}
 
-	// Format and print the diff
-	output := repo.FormatDiff(diff)
-	if output == "" {
-		fmt.Println("No differences")
+	// Parse options
+	options := &repo.CheckoutOptions{}
+	fs := flag.NewFlagSet("checkout", flag.ExitOnError)
+	fs.BoolVar(&options.Force, "force", false, "Discard local changes")
+	fs.BoolVar(&options.Force, "f", false, "Discard local changes (shorthand)")
+	fs.BoolVar(&options.Merge, "merge", false, "Carry local changes over with a three-way merge")
+	fs.BoolVar(&options.Merge, "m", false, "Carry local changes over with a three-way merge (shorthand)")
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to parse checkout arguments: %v\n", err)
+		os.Exit(1)
+	}
+
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Error: 'checkout' requires a branch name\n")
+		os.Exit(1)
+	}
+	if options.Force && options.Merge {
+		fmt.Fprintf(os.Stderr, "Error: --force and --merge are incompatible\n")
+		os.Exit(1)
+	}
+	branchName := fs.Arg(0)
+
+	// Check if current branch is already the requested branch
+	currentBranch, err := r.GetCurrentBranch()
+	if err == nil && currentBranch == branchName {
+		fmt.Printf("Already on branch '%s'\n", branchName)
+		return
+	}
+
+	// Switch to the branch
+	conflicts, err := r.CheckoutBranchWithOptions(branchName, options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to checkout branch: %v\n", err)
+		os.Exit(1)
+	}
+
+	for _, conflict := range conflicts {
+		fmt.Println(conflict.Summary())
+	}
+	fmt.Printf("Switched to branch '%s'\n", branchName)
+}
+
+// mergeCmd merges changes from another branch
+func mergeCmd(path string, args []string) {
+	// Check if this is a repository
+	if !repo.IsRepository(path) {
+		fmt.Fprintf(os.Stderr, "Error: Not a Kit repository\n")
+		os.Exit(1)
+	}
+
+	// Create a repository instance
+	r, err := repo.NewRepository(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to open repository: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Parse options
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+
+	// Merge options
+	noCommit := fs.Bool("no-commit", false, "Do not create a commit after merge")
+	message := fs.String("m", "", "Custom merge commit message")
+	semantic := fs.Bool("semantic", true, "Use semantic merge for conflicts")
+	strategyStr := fs.String("strategy", "auto", "Merge strategy: auto, ours, theirs, manual")
+	allowUnrelated := fs.Bool("allow-unrelated-histories", false, "Merge a branch that shares no history with the current one")
+
+	// Parse args
+	err = fs.Parse(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to parse merge arguments: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Get branch to merge
+	remainingArgs := fs.Args()
+	if len(remainingArgs) != 1 {
+		fmt.Fprintf(os.Stderr, "Error: 'merge' requires exactly one branch name\n")
+		fmt.Fprintf(os.Stderr, "Usage: kit merge [options] <branch>\n")
+		os.Exit(1)
+	}
+
+	branchName := remainingArgs[0]
+
+	// Convert strategy string to enum
+	var strategy repo.MergeStrategy
+	switch *strategyStr {
+	case "auto":
+		strategy = repo.AutoMerge
+	case "ours":
+		strategy = repo.Ours
+	case "theirs":
+		strategy = repo.Theirs
+	case "manual":
+		strategy = repo.Manual
+	default:
+		fmt.Fprintf(os.Stderr, "Error: Unknown merge strategy '%s'\n", *strategyStr)
+		os.Exit(1)
+	}
+
+	// Create merge options
+	options := &repo.MergeOptions{
+		Strategy:                strategy,
+		NoCommit:                *noCommit,
+		Message:                 *message,
+		UseSemantic:             *semantic,
+		AllowUnrelatedHistories: *allowUnrelated,
+	}
+
+	// Perform merge
+	result, err := r.Merge(branchName, options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to merge: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Show result
+	if result.FastForward {
+		fmt.Printf("Fast-forward merge of '%s' into current branch\n", branchName)
+	} else if result.Success {
+		if len(result.Conflicts) > 0 {
+			fmt.Printf("Merge completed with %d conflicts resolved\n", len(result.Conflicts))
+		} else {
+			fmt.Println("Merge completed successfully")
+		}
 	} else {
-		fmt.Print(output)
+		fmt.Printf("Merge resulted in %d conflicts\n", len(result.Conflicts))
+		fmt.Println("Fix conflicts and then commit the result")
+	}
+}
+
+// mergeBaseCmd prints the best common ancestor of two commits
+func mergeBaseCmd(path string, args []string) {
+	// Check if this is a repository
+	if !repo.IsRepository(path) {
+		fmt.Fprintf(os.Stderr, "Error: Not a Kit repository\n")
+		os.Exit(1)
+	}
+
+	// Create a repository instance
+	r, err := repo.NewRepository(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to open repository: %v\n", err)
+		os.Exit(1)
+	}
+
+	fs := flag.NewFlagSet("merge-base", flag.ExitOnError)
+	all := fs.Bool("all", false, "Print all best common ancestors")
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to parse merge-base arguments: %v\n", err)
+		os.Exit(1)
+	}
+	if fs.NArg() != 2 {
+		fmt.Fprintf(os.Stderr, "Error: 'merge-base' requires two commits\n")
+		fmt.Fprintf(os.Stderr, "Usage: kit merge-base [--all] <commit> <commit>\n")
+		os.Exit(1)
+	}
+
+	commits := make([]string, 2)
+	for i, rev := range fs.Args() {
+		commitID, err := r.ResolveRevision(rev)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		commits[i] = commitID
+	}
+
+	bases, err := r.MergeBases(commits[0], commits[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to find merge base: %v\n", err)
+		os.Exit(1)
+	}
+	// Like git, unrelated histories are reported by the exit status alone
+	if len(bases) == 0 {
+		os.Exit(1)
+	}
+	if !*all {
+		bases = bases[:1]
+	}
+	for _, base := range bases {
+		fmt.Println(base)
+	}
+}
+
+// bisectCmd searches the history for the commit that introduced a bug
+func bisectCmd(path string, args []string) {
+	// Check if this is a repository
+	if !repo.IsRepository(path) {
+		fmt.Fprintf(os.Stderr, "Error: Not a Kit repository\n")
+		os.Exit(1)
+	}
+
+	// Create a repository instance
+	r, err := repo.NewRepository(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to open repository: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "Error: 'bisect' requires a subcommand: start, good, bad, skip, reset or run\n")
+		os.Exit(1)
+	}
+
+	// good, bad and skip take an optional revision, HEAD by default
+	rev := ""
+	if len(args) > 1 {
+		rev = args[1]
+	}
+
+	var status *repo.BisectStatus
+	switch args[0] {
+	case "start":
+		if len(args) < 3 {
+			fmt.Fprintf(os.Stderr, "Usage: kit bisect start <bad> <good>...\n")
+			os.Exit(1)
+		}
+		status, err = r.BisectStart(args[1], args[2:])
+	case "good":
+		status, err = r.BisectGood(rev)
+	case "bad":
+		status, err = r.BisectBad(rev)
+	case "skip":
+		status, err = r.BisectSkip(rev)
+	case "reset":
+		if err := r.BisectReset(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "run":
+		if len(args) < 2 {
+			fmt.Fprintf(os.Stderr, "Usage: kit bisect run <cmd> [<args>...]\n")
+			os.Exit(1)
+		}
+		if _, err := r.BisectRun(strings.Join(args[1:], " "), os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "Error: Unknown bisect subcommand '%s'\n", args[0])
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Print(repo.FormatBisectStatus(status))
+}
+
+// sequenceCmd cherry-picks or reverts commits, or continues, skips or
+// aborts an unfinished cherry-pick or revert
+func sequenceCmd(path, name string, args []string) {
+	// Check if this is a repository
+	if !repo.IsRepository(path) {
+		fmt.Fprintf(os.Stderr, "Error: Not a Kit repository\n")
+		os.Exit(1)
+	}
+
+	// Create a repository instance
+	r, err := repo.NewRepository(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to open repository: %v\n", err)
+		os.Exit(1)
+	}
+
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	cont := fs.Bool("continue", false, "Commit the resolved conflicts and go on")
+	skip := fs.Bool("skip", false, "Drop the conflicting commit and go on")
+	abort := fs.Bool("abort", false, "Go back to where things were before it started")
+	mainline := fs.Int("m", 0, "Parent number (1 or 2) of merge commits to take their changes relative to")
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to parse %s arguments: %v\n", name, err)
+		os.Exit(1)
+	}
+
+	var result *repo.SequenceResult
+	switch {
+	case *cont:
+		result, err = r.ContinueSequence()
+	case *skip:
+		result, err = r.SkipSequence()
+	case *abort:
+		err = r.AbortSequence()
+	case fs.NArg() == 0:
+		fmt.Fprintf(os.Stderr, "Usage: kit %s [-m <parent>] <rev>...\n", name)
+		os.Exit(1)
+	case name == "revert":
+		result, err = r.Revert(fs.Args(), &repo.SequenceOptions{Mainline: *mainline})
+	default:
+		result, err = r.CherryPick(fs.Args(), &repo.SequenceOptions{Mainline: *mainline})
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if result == nil {
+		return
+	}
+
+	for _, commitID := range result.Commits {
+		info, err := r.CommitInfo(commitID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Print(repo.FormatLog([]*repo.CommitLog{info}, "format:[%h] %s"))
+	}
+	for _, commitID := range result.Empty {
+		fmt.Printf("Dropped %s: its changes are already present\n", commitID[:8])
+	}
+	if result.Stopped != "" {
+		for _, conflict := range result.Conflicts {
+			fmt.Println(conflict.Summary())
+		}
+		fmt.Printf("Could not apply %s\n", result.Stopped[:8])
+		fmt.Printf("Resolve the conflicts, stage the files and run 'kit %s --continue', or use --skip or --abort\n", name)
+		os.Exit(1)
+	}
+}
+
+// rebaseCmd replays the current branch onto another commit, or continues,
+// skips or aborts an unfinished rebase
+func rebaseCmd(path string, args []string) {
+	// Check if this is a repository
+	if !repo.IsRepository(path) {
+		fmt.Fprintf(os.Stderr, "Error: Not a Kit repository\n")
+		os.Exit(1)
+	}
+
+	// Create a repository instance
+	r, err := repo.NewRepository(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to open repository: %v\n", err)
+		os.Exit(1)
+	}
+
+	fs := flag.NewFlagSet("rebase", flag.ExitOnError)
+	todoFile := fs.String("todo", "", "Run the steps of a todo file instead of picking every commit")
+	cont := fs.Bool("continue", false, "Commit the resolved conflicts or edits and go on")
+	skip := fs.Bool("skip", false, "Drop the conflicting commit and go on")
+	abort := fs.Bool("abort", false, "Go back to the branch as it was before the rebase")
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to parse rebase arguments: %v\n", err)
+		os.Exit(1)
+	}
+
+	var result *repo.RebaseResult
+	switch {
+	case *cont:
+		result, err = r.ContinueRebase()
+	case *skip:
+		result, err = r.SkipRebase()
+	case *abort:
+		err = r.AbortRebase()
+	case fs.NArg() != 1:
+		fmt.Fprintf(os.Stderr, "Usage: kit rebase [--todo <file>] <upstream>\n")
+		os.Exit(1)
+	default:
+		options := &repo.RebaseOptions{}
+		if *todoFile != "" {
+			content, err := os.ReadFile(*todoFile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: Failed to read todo file: %v\n", err)
+				os.Exit(1)
+			}
+			if options.Todo, err = repo.ParseRebaseTodo(string(content)); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		result, err = r.Rebase(fs.Arg(0), options)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	switch {
+	case result == nil:
+	case result.UpToDate:
+		fmt.Println("Current branch is up to date.")
+	case result.Stopped != nil && len(result.Conflicts) > 0:
+		for _, conflict := range result.Conflicts {
+			fmt.Println(conflict.Summary())
+		}
+		fmt.Printf("Could not apply %s... %s\n", result.Stopped.Commit[:8], result.Stopped.Text)
+		fmt.Printf("Resolve the conflicts, stage the files and run 'kit rebase --continue', or use --skip or --abort\n")
+		os.Exit(1)
+	case result.Stopped != nil:
+		fmt.Printf("Stopped at %s... %s\n", result.Stopped.Commit[:8], result.Stopped.Text)
+		fmt.Printf("Stage your changes and run 'kit rebase --continue' to amend the commit and go on\n")
+	default:
+		branch, _ := r.GetCurrentBranch()
+		fmt.Printf("Successfully rebased %s, now at %s\n", branch, result.Head[:8])
+	}
+}
+
+// diffCmd shows differences between commits or working directory
+func diffCmd(path string, args []string) {
+	// Check if this is a repository
+	if !repo.IsRepository(path) {
+		fmt.Fprintf(os.Stderr, "Error: Not a Kit repository\n")
+		os.Exit(1)
+	}
+
+	// Create a repository instance
+	r, err := repo.NewRepository(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to open repository: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Parse options
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	semantic := fs.Bool("semantic", false, "Use semantic diff")
+	context := fs.Int("context", 3, "Number of context lines")
+	algorithm := fs.String("diff-algorithm", string(repo.DiffMyers), "How to match up lines: myers or histogram")
+	binary := fs.Bool("binary", false, "Include binary patches that can be applied, rather than only saying binary files differ")
+	stat := fs.Bool("stat", false, "Show the number of lines changed in each file, and the sizes of binary files")
+
+	// Rename detection options don't fit the flag package
+	options := &repo.DiffOptions{}
+	if args, err = renameFlags(args, options); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Parse args (ignoring unknown flags, which might be commit IDs)
+	err = fs.Parse(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to parse diff arguments: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Create diff options
+	options.ContextLines = *context
+	options.Semantic = *semantic
+	options.Binary = *binary
+	if options.Algorithm, err = repo.ParseDiffAlgorithm(*algorithm); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Get remaining args (for commit IDs)
+	remainingArgs := fs.Args()
+	var commitA, commitB string
+
+	// Handle different diff modes based on number of arguments
+	switch len(remainingArgs) {
+	case 0:
+		// No commits specified, diff working tree vs HEAD
+		commitA = "" // HEAD
+		commitB = "" // Working directory
+	case 1:
+		// One commit specified, diff working tree vs that commit
+		commitA = remainingArgs[0]
+		commitB = "" // Working directory
+	case 2:
+		// Two commits specified, diff between those commits
+		commitA = remainingArgs[0]
+		commitB = remainingArgs[1]
+	default:
+		fmt.Fprintf(os.Stderr, "Error: Too many arguments for diff\n")
+		os.Exit(1)
+	}
+
+	// Perform the diff
+	diff, err := r.Diff(commitA, commitB, options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to perform diff: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Format and print the diff
+	output := repo.FormatDiff(diff)
+	if *stat {
+		output = repo.FormatDiffStat(diff)
+	}
+	if output == "" {
+		fmt.Println("No differences")
+	} else {
+		fmt.Print(output)
+	}
+}
+
+// renameFlags takes the rename and copy detection options out of diff
+// arguments and sets them: -M[<n>] or --find-renames[=<n>], and -C[<n>] or
+// --find-copies[=<n>], with an optional similarity threshold such as 50%.
+// The flag package can't parse a value joined to a short flag.
+func renameFlags(args []string, options *repo.DiffOptions) ([]string, error) {
+	remaining := []string{}
+	for i, arg := range args {
+		if arg == "--" {
+			return append(remaining, args[i:]...), nil
+		}
+		var value string
+		switch {
+		case strings.HasPrefix(arg, "-M"):
+			value = arg[2:]
+			options.DetectRenames = true
+		case arg == "--find-renames" || strings.HasPrefix(arg, "--find-renames="):
+			value = strings.TrimPrefix(arg[len("--find-renames"):], "=")
+			options.DetectRenames = true
+		case strings.HasPrefix(arg, "-C"):
+			value = arg[2:]
+			options.DetectCopies = true
+		case arg == "--find-copies" || strings.HasPrefix(arg, "--find-copies="):
+			value = strings.TrimPrefix(arg[len("--find-copies"):], "=")
+			options.DetectCopies = true
+		default:
+			remaining = append(remaining, arg)
+			continue
+		}
+		threshold, err := repo.ParseSimilarityThreshold(value)
+		if err != nil {
+			return nil, err
+		}
+		options.RenameThreshold = threshold
+	}
+	return remaining, nil
+}
+
+// sparseCheckoutCmd limits the working tree to part of the repository
+func sparseCheckoutCmd(path string, args []string) {
+	// Check if this is a repository
+	if !repo.IsRepository(path) {
+		fmt.Fprintf(os.Stderr, "Error: Not a Kit repository\n")
+		os.Exit(1)
+	}
+
+	// Create a repository instance
+	r, err := repo.NewRepository(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to open repository: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: kit sparse-checkout [set | add | expand | list | disable]\n")
+		os.Exit(1)
+	}
+	subcmd, args := args[0], args[1:]
+
+	sparse, err := r.SparseCheckout()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	var kept []string
+	switch subcmd {
+	case "set":
+		fs := flag.NewFlagSet("sparse-checkout set", flag.ExitOnError)
+		noCone := fs.Bool("no-cone", false, "Treat arguments as patterns instead of directories")
+		if err := fs.Parse(args); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Failed to parse sparse-checkout arguments: %v\n", err)
+			os.Exit(1)
+		}
+
+		kept, err = r.SetSparseCheckout(fs.Args(), !*noCone)
+	case "add":
+		if sparse == nil {
+			fmt.Fprintf(os.Stderr, "Error: Sparse checkout is not enabled; use 'kit sparse-checkout set'\n")
+			os.Exit(1)
+		}
+		kept, err = r.SetSparseCheckout(append(sparse.Patterns, args...), sparse.Cone)
+	case "list":
+		if sparse == nil {
+			fmt.Fprintf(os.Stderr, "Error: Sparse checkout is not enabled\n")
+			os.Exit(1)
+		}
+		for _, pattern := range sparse.Patterns {
+			fmt.Println(pattern)
+		}
+		return
+	case "expand":
+		fs := flag.NewFlagSet("sparse-checkout expand", flag.ExitOnError)
+		options := &repo.ExpandOptions{}
+		fs.BoolVar(&options.Semantic, "semantic", false, "Also add files similar to the working set")
+		fs.Float64Var(&options.Threshold, "threshold", 0, "Minimum semantic similarity (default: the semantic kernel's minimum score)")
+		fs.BoolVar(&options.DryRun, "dry-run", false, "Only show what would be added")
+		fs.BoolVar(&options.DryRun, "n", false, "Only show what would be added (shorthand)")
+		if err := fs.Parse(args); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Failed to parse sparse-checkout arguments: %v\n", err)
+			os.Exit(1)
+		}
+
+		expansions, err := r.ExpandSparseCheckout(options)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Failed to expand sparse checkout: %v\n", err)
+			os.Exit(1)
+		}
+		for _, expansion := range expansions {
+			fmt.Printf("add %s (%s: %s)\n", expansion.Pattern, expansion.Path, expansion.Reason)
+		}
+		return
+	case "disable":
+		err = r.DisableSparseCheckout()
+	default:
+		fmt.Fprintf(os.Stderr, "Usage: kit sparse-checkout [set | add | expand | list | disable]\n")
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to update sparse checkout: %v\n", err)
+		os.Exit(1)
+	}
+
+	for _, file := range kept {
+		fmt.Fprintf(os.Stderr, "warning: %s has local changes and was not removed\n", file)
+	}
+}
+
+// stashCmd shelves and restores uncommitted changes
+func stashCmd(path string, args []string) {
+	// Check if this is a repository
+	if !repo.IsRepository(path) {
+		fmt.Fprintf(os.Stderr, "Error: Not a Kit repository\n")
+		os.Exit(1)
+	}
+
+	// Create a repository instance
+	r, err := repo.NewRepository(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to open repository: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Default to push when no subcommand is given
+	subcmd := "push"
+	if len(args) > 0 {
+		subcmd = args[0]
+		args = args[1:]
+	}
+
+	switch subcmd {
+	case "push", "save":
+		fs := flag.NewFlagSet("stash push", flag.ExitOnError)
+		message := fs.String("m", "", "Stash message")
+		if err := fs.Parse(args); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Failed to parse stash arguments: %v\n", err)
+			os.Exit(1)
+		}
+
+		if _, err := r.StashPush(*message, fs.Args()); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Failed to stash changes: %v\n", err)
+			os.Exit(1)
+		}
+
+		stashes, err := r.StashList()
+		if err == nil && len(stashes) > 0 {
+			fmt.Printf("Saved working directory and index state %s\n", stashes[0].Message)
+		}
+	case "list":
+		stashes, err := r.StashList()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Failed to list stashes: %v\n", err)
+			os.Exit(1)
+		}
+		for _, stash := range stashes {
+			fmt.Printf("stash@{%d}: %s\n", stash.Index, stash.Message)
+		}
+	case "show":
+		index := parseStashArg(args)
+		diff, err := r.StashShow(index, nil)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Failed to show stash: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Print(repo.FormatDiff(diff))
+	case "apply", "pop":
+		fs := flag.NewFlagSet("stash "+subcmd, flag.ExitOnError)
+		restoreIndex := fs.Bool("index", false, "Also restore the staged changes")
+		if err := fs.Parse(args); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Failed to parse stash arguments: %v\n", err)
+			os.Exit(1)
+		}
+		index := parseStashArg(fs.Args())
+
+		options := &repo.StashApplyOptions{RestoreIndex: *restoreIndex}
+		var conflicts []repo.MergeConflict
+		if subcmd == "pop" {
+			conflicts, err = r.StashPop(index, options)
+		} else {
+			conflicts, err = r.StashApply(index, options)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Failed to apply stash: %v\n", err)
+			os.Exit(1)
+		}
+
+		if len(conflicts) > 0 {
+			for _, conflict := range conflicts {
+				fmt.Println(conflict.Summary())
+			}
+			if subcmd == "pop" {
+				fmt.Println("The stash entry is kept in case you need it again.")
+			}
+			os.Exit(1)
+		}
+		if subcmd == "pop" {
+			fmt.Printf("Dropped stash@{%d}\n", index)
+		}
+	case "drop":
+		index := parseStashArg(args)
+		if err := r.StashDrop(index); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Failed to drop stash: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Dropped stash@{%d}\n", index)
+	default:
+		fmt.Fprintf(os.Stderr, "Error: Unknown stash command '%s'\n", subcmd)
+		fmt.Fprintf(os.Stderr, "Usage: kit stash [push [-m <message>] [<paths>...] | list | show | apply | pop | drop] [stash@{n}]\n")
+		os.Exit(1)
+	}
+}
+
+// parseStashArg parses the optional stash reference argument of a stash subcommand
+func parseStashArg(args []string) int {
+	ref := ""
+	if len(args) > 0 {
+		ref = args[0]
+	}
+
+	index, err := repo.ParseStashIndex(ref)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	return index
+}
+
+// checkIgnoreCmd reports which paths are ignored and, with -v, why
+func checkIgnoreCmd(path string, args []string) {
+	// Check if this is a repository
+	if !repo.IsRepository(path) {
+		fmt.Fprintf(os.Stderr, "Error: Not a Kit repository\n")
+		os.Exit(1)
+	}
+
+	// Create a repository instance
+	r, err := repo.NewRepository(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to open repository: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Parse options
+	fs := flag.NewFlagSet("check-ignore", flag.ExitOnError)
+	verbose := fs.Bool("v", false, "Show the rule matching each path")
+	nonMatching := fs.Bool("n", false, "Also show paths not matching any rule (with -v)")
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to parse check-ignore arguments: %v\n", err)
+		os.Exit(1)
+	}
+
+	if fs.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "Error: 'check-ignore' requires at least one path\n")
+		os.Exit(1)
+	}
+
+	anyIgnored := false
+	for _, file := range fs.Args() {
+		rule, ignored, err := r.CheckIgnore(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Failed to check %s: %v\n", file, err)
+			os.Exit(1)
+		}
+		if ignored {
+			anyIgnored = true
+		}
+
+		switch {
+		case *verbose && rule != nil:
+			// Negated matches are shown too, since they explain why a path is included
+			fmt.Printf("%s\t%s\n", rule, file)
+		case *verbose && *nonMatching:
+			fmt.Printf("::\t%s\n", file)
+		case ignored:
+			fmt.Println(file)
+		}
+	}
+
+	// Exit with 1 when no path is ignored, like grep
+	if !anyIgnored {
+		os.Exit(1)
+	}
+}
+
+// checkAttrCmd shows the attributes .kitattributes files assign to paths
+func checkAttrCmd(path string, args []string) {
+	// Check if this is a repository
+	if !repo.IsRepository(path) {
+		fmt.Fprintf(os.Stderr, "Error: Not a Kit repository\n")
+		os.Exit(1)
+	}
+
+	// Create a repository instance
+	r, err := repo.NewRepository(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to open repository: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "Error: 'check-attr' requires at least one path\n")
+		os.Exit(1)
+	}
+
+	for _, file := range args {
+		file = filepath.ToSlash(filepath.Clean(file))
+		attrs, err := r.Attributes(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Failed to check %s: %v\n", file, err)
+			os.Exit(1)
+		}
+
+		names := make([]string, 0, len(attrs))
+		for name := range attrs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("%s: %s: %s\n", file, name, attrs[name])
+		}
+	}
+}
+
+// updateIndexCmd upgrades the index to the current format or refreshes its
+// cached stat data
+func updateIndexCmd(path string, args []string) {
+	// Check if this is a repository
+	if !repo.IsRepository(path) {
+		fmt.Fprintf(os.Stderr, "Error: Not a Kit repository\n")
+		os.Exit(1)
+	}
+
+	// Create a repository instance
+	r, err := repo.NewRepository(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to open repository: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Parse options
+	fs := flag.NewFlagSet("update-index", flag.ExitOnError)
+	upgrade := fs.Bool("upgrade", false, "Rewrite a legacy JSON index in the binary format")
+	refresh := fs.Bool("refresh", false, "Refresh cached stat data of indexed files")
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to parse update-index arguments: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !*upgrade && !*refresh {
+		fmt.Fprintf(os.Stderr, "Usage: kit update-index [--upgrade] [--refresh]\n")
+		os.Exit(1)
+	}
+
+	if *upgrade {
+		upgraded, err := r.UpgradeIndex()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Failed to upgrade index: %v\n", err)
+			os.Exit(1)
+		}
+		if upgraded {
+			fmt.Println("Upgraded index to the binary format")
+		} else {
+			fmt.Println("Index is already up to date")
+		}
+	}
+
+	if *refresh {
+		if err := r.RefreshIndex(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Failed to refresh index: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// fsmonitorCmd manages the filesystem monitor daemon
+func fsmonitorCmd(path string, args []string) {
+	// Check if this is a repository
+	if !repo.IsRepository(path) {
+		fmt.Fprintf(os.Stderr, "Error: Not a Kit repository\n")
+		os.Exit(1)
+	}
+
+	// Create a repository instance
+	r, err := repo.NewRepository(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to open repository: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: kit fsmonitor [start | stop | status | run]\n")
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "start":
+		exe, err := os.Executable()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Failed to locate kit executable: %v\n", err)
+			os.Exit(1)
+		}
+		if err := r.StartFSMonitor(exe, "fsmonitor", "run"); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("fsmonitor started")
+	case "stop":
+		if err := r.StopFSMonitor(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("fsmonitor stopped")
+	case "status":
+		if r.FSMonitorRunning() {
+			fmt.Println("fsmonitor is running")
+		} else {
+			fmt.Println("fsmonitor is not running")
+			os.Exit(1)
+		}
+	case "run":
+		// Runs in the foreground; "start" runs this in the background
+		if err := r.RunFSMonitor(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Usage: kit fsmonitor [start | stop | status | run]\n")
+		os.Exit(1)
+	}
+}
+
+// resetCmd moves the current branch to a revision
+func resetCmd(path string, args []string) {
+	// Check if this is a repository
+	if !repo.IsRepository(path) {
+		fmt.Fprintf(os.Stderr, "Error: Not a Kit repository\n")
+		os.Exit(1)
+	}
+
+	// Create a repository instance
+	r, err := repo.NewRepository(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to open repository: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Parse options
+	fs := flag.NewFlagSet("reset", flag.ExitOnError)
+	soft := fs.Bool("soft", false, "Only move the branch, keeping the index and working tree")
+	mixed := fs.Bool("mixed", false, "Reset the index but not the working tree (default)")
+	hard := fs.Bool("hard", false, "Reset the index and working tree")
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to parse reset arguments: %v\n", err)
+		os.Exit(1)
+	}
+
+	mode := repo.ResetMixed
+	modes := 0
+	if *soft {
+		mode = repo.ResetSoft
+		modes++
+	}
+	if *mixed {
+		mode = repo.ResetMixed
+		modes++
+	}
+	if *hard {
+		mode = repo.ResetHard
+		modes++
+	}
+	if modes > 1 || fs.NArg() > 1 {
+		fmt.Fprintf(os.Stderr, "Usage: kit reset [--soft | --mixed | --hard] [<rev>]\n")
+		os.Exit(1)
+	}
+
+	rev := "HEAD"
+	if fs.NArg() == 1 {
+		rev = fs.Arg(0)
+	}
+
+	commitID, err := r.Reset(rev, mode)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if mode == repo.ResetHard {
+		fmt.Printf("HEAD is now at %s\n", commitID[:8])
+	}
+}
+
+// restoreCmd restores files in the working tree or index
+func restoreCmd(path string, args []string) {
+	// Check if this is a repository
+	if !repo.IsRepository(path) {
+		fmt.Fprintf(os.Stderr, "Error: Not a Kit repository\n")
+		os.Exit(1)
+	}
+
+	// Create a repository instance
+	r, err := repo.NewRepository(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to open repository: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Parse options
+	options := &repo.RestoreOptions{}
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	fs.BoolVar(&options.Staged, "staged", false, "Restore the index")
+	fs.BoolVar(&options.Staged, "S", false, "Restore the index (shorthand)")
+	fs.BoolVar(&options.Worktree, "worktree", false, "Restore the working tree (default)")
+	fs.BoolVar(&options.Worktree, "W", false, "Restore the working tree (shorthand)")
+	fs.StringVar(&options.Source, "source", "", "Restore from the given revision")
+	fs.StringVar(&options.Source, "s", "", "Restore from the given revision (shorthand)")
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to parse restore arguments: %v\n", err)
+		os.Exit(1)
+	}
+
+	if fs.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: kit restore [--staged] [--worktree] [--source <rev>] <paths>...\n")
+		os.Exit(1)
+	}
+
+	if _, err := r.Restore(fs.Args(), options); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
 	}
 }
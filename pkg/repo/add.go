@@ -0,0 +1,266 @@
+package repo
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// AddResult lists the paths whose index entries were changed by AddPaths
+type AddResult struct {
+	Added   []string // Paths staged with new content
+	Removed []string // Tracked paths staged for deletion
+}
+
+// hashedFile is the result of hashing a working tree file
+type hashedFile struct {
+	path  string
+	objID string
+	info  os.FileInfo
+	err   error
+}
+
+// AddPaths stages the files selected by the given pathspecs. A pathspec may
+// name a file, a directory (staged recursively) or a glob. Ignored files are
+// skipped when found through a directory or glob, and refused when named
+// explicitly unless options.Force is set.
+//
+// With options.All deletions of tracked files are staged as well, and with
+// options.Update only files already in the index are considered. Either of
+// them without pathspecs applies to the whole working tree.
+func (r *Repository) AddPaths(pathspecs []string, options *AddOptions) (*AddResult, error) {
+	if options == nil {
+		options = &AddOptions{}
+	}
+
+	if len(pathspecs) == 0 && !options.All && !options.Update {
+		return nil, fmt.Errorf("nothing specified, nothing added")
+	}
+
+	specs := make([]string, len(pathspecs))
+	for i, spec := range pathspecs {
+		specs[i] = cleanPathspec(spec)
+	}
+
+	// Explicitly named files must not be ignored
+	if !options.Force {
+		for _, spec := range specs {
+			if isGlobPathspec(spec) || r.isInIndex(spec) {
+				continue
+			}
+			info, err := os.Stat(filepath.Join(r.Path, spec))
+			if err != nil || info.IsDir() {
+				continue
+			}
+			rule, ignored, err := r.CheckIgnore(spec)
+			if err != nil {
+				return nil, fmt.Errorf("failed to check ignore rules: %w", err)
+			}
+			if ignored {
+				return nil, fmt.Errorf("path %s is ignored by %s (use -f to add it anyway)", spec, rule)
+			}
+		}
+	}
+
+	// Collect candidate files from the working tree
+	matched := make(map[string]bool, len(specs))
+	candidates := []string{}
+	err := r.walkWorkTree(walkOptions{includeIgnored: options.Force, pathspecs: specs}, func(path string, d fs.DirEntry) error {
+		slashPath := filepath.ToSlash(path)
+		if (options.Update && !r.isInIndex(path)) || r.State.SkipWorktree[path] {
+			return nil
+		}
+		for _, spec := range specs {
+			if pathspecMatches(spec, slashPath) {
+				matched[spec] = true
+			}
+		}
+		if matchPathspec(slashPath, specs) {
+			candidates = append(candidates, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk directory: %w", err)
+	}
+
+	// Indexed files missing from the working tree are deletions
+	deleted := []string{}
+	for _, entries := range []map[string]string{r.State.Tracked, r.State.Stage} {
+		for path := range entries {
+			if r.State.Removed[path] || r.State.SkipWorktree[path] || !matchPathspec(filepath.ToSlash(path), specs) {
+				continue
+			}
+			if _, err := os.Lstat(filepath.Join(r.Path, path)); os.IsNotExist(err) {
+				for _, spec := range specs {
+					if pathspecMatches(spec, filepath.ToSlash(path)) {
+						matched[spec] = true
+					}
+				}
+				deleted = append(deleted, path)
+			}
+		}
+	}
+
+	for _, spec := range specs {
+		if matched[spec] {
+			continue
+		}
+		// Say why an existing path gave nothing to add
+		if info, err := os.Stat(filepath.Join(r.Path, spec)); err == nil && !isGlobPathspec(spec) {
+			if isDirSymlink(filepath.Join(r.Path, spec)) {
+				return nil, fmt.Errorf("path %s is a symbolic link to a directory, which can't be added", spec)
+			}
+			if info.IsDir() && !options.Force {
+				rule, ignored, err := r.CheckIgnore(spec)
+				if err != nil {
+					return nil, fmt.Errorf("failed to check ignore rules: %w", err)
+				}
+				if ignored {
+					return nil, fmt.Errorf("path %s is ignored by %s (use -f to add it anyway)", spec, rule)
+				}
+			}
+		}
+		return nil, fmt.Errorf("pathspec '%s' did not match any files", spec)
+	}
+
+	hashed, err := r.hashWorkingFiles(candidates, !options.DryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &AddResult{}
+	for _, file := range hashed {
+		if !options.DryRun {
+			r.State.WorkTree[file.path] = newWorkTreeEntry(file.path, file.objID, file.info)
+			r.forgetAttributes(file.path)
+			delete(r.State.Conflicts, file.path)
+		}
+
+		trackedID, tracked := r.State.Tracked[file.path]
+		stagedID, staged := r.State.Stage[file.path]
+
+		if tracked && trackedID == file.objID {
+			// Unchanged since the last commit, drop any staged version or deletion
+			if !options.DryRun {
+				delete(r.State.Stage, file.path)
+				delete(r.State.Removed, file.path)
+			}
+			continue
+		}
+		if staged && stagedID == file.objID {
+			continue
+		}
+
+		result.Added = append(result.Added, file.path)
+		if options.DryRun {
+			continue
+		}
+
+		r.State.Stage[file.path] = file.objID
+		delete(r.State.Removed, file.path)
+	}
+
+	if options.All || options.Update {
+		sort.Strings(deleted)
+		for _, path := range deleted {
+			result.Removed = append(result.Removed, path)
+			if options.DryRun {
+				continue
+			}
+
+			delete(r.State.Stage, path)
+			delete(r.State.WorkTree, path)
+			delete(r.State.Conflicts, path)
+			if _, tracked := r.State.Tracked[path]; tracked {
+				r.State.Removed[path] = true
+			}
+		}
+	}
+
+	if options.DryRun {
+		return result, nil
+	}
+
+	// Save index
+	if err := r.SaveIndex(); err != nil {
+		return nil, fmt.Errorf("failed to save index: %w", err)
+	}
+
+	return result, nil
+}
+
+// hashWorkingFiles hashes working tree files in parallel, optionally storing
+// their contents as objects. Results are returned sorted by path.
+func (r *Repository) hashWorkingFiles(paths []string, store bool) ([]hashedFile, error) {
+	results := make([]hashedFile, len(paths))
+
+	// Load the attribute rules before the workers share them
+	if _, err := r.attributeMatcher(); err != nil {
+		return nil, err
+	}
+
+	workers := runtime.NumCPU()
+	if workers > len(paths) {
+		workers = len(paths)
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = r.hashWorkingFile(paths[i], store)
+			}
+		}()
+	}
+	for i := range paths {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, result := range results {
+		if result.err != nil {
+			return nil, result.err
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].path < results[j].path })
+	return results, nil
+}
+
+// hashWorkingFile reads and hashes a single working tree file. Files whose
+// stat data matches the index are not read unless their object is missing.
+func (r *Repository) hashWorkingFile(path string, store bool) hashedFile {
+	absPath := filepath.Join(r.Path, path)
+
+	fileInfo, err := os.Stat(absPath)
+	if err != nil {
+		return hashedFile{path: path, err: fmt.Errorf("failed to get file info for %s: %w", path, err)}
+	}
+
+	if objID, ok := r.cachedHash(path, fileInfo); ok && (!store || r.hasObject(objID)) {
+		return hashedFile{path: path, objID: objID, info: fileInfo}
+	}
+
+	content, err := r.readWorkingFile(path)
+	if err != nil {
+		return hashedFile{path: path, err: fmt.Errorf("failed to read file %s: %w", path, err)}
+	}
+
+	objID := hashContent(content)
+	if store {
+		if err := r.storeObject(objID, content); err != nil {
+			return hashedFile{path: path, err: fmt.Errorf("failed to store object: %w", err)}
+		}
+	}
+
+	return hashedFile{path: path, objID: objID, info: fileInfo}
+}
@@ -0,0 +1,159 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestAddDirectoryAndGlob(t *testing.T) {
+	repo := newTestRepository(t)
+
+	writeTestFile(t, repo, ".kitignore", "*.o\n")
+	writeTestFile(t, repo, "src/main.go", "package main\n")
+	writeTestFile(t, repo, "src/util/util.go", "package util\n")
+	writeTestFile(t, repo, "src/util/util.o", "object\n")
+	writeTestFile(t, repo, "docs/readme.md", "# docs\n")
+
+	result, err := repo.AddPaths([]string{"src/"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to add directory: %v", err)
+	}
+	expected := []string{"src/main.go", "src/util/util.go"}
+	if strings.Join(result.Added, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected %v to be added, got %v", expected, result.Added)
+	}
+	if _, ok := repo.State.Stage["src/util/util.o"]; ok {
+		t.Error("Ignored file should not be staged through a directory")
+	}
+
+	result, err = repo.AddPaths([]string{"*.md"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to add glob: %v", err)
+	}
+	if len(result.Added) != 1 || result.Added[0] != "docs/readme.md" {
+		t.Errorf("Glob should match across directories, got %v", result.Added)
+	}
+
+	if _, err := repo.AddPaths([]string{"missing"}, nil); err == nil {
+		t.Error("Unmatched pathspec should fail")
+	}
+}
+
+func TestAddAllStagesDeletions(t *testing.T) {
+	repo := newTestRepository(t)
+	commitTestFiles(t, repo, "Initial commit", map[string]string{
+		"a.txt": "a\n",
+		"b.txt": "b\n",
+		"c.txt": "c\n",
+	})
+
+	if err := os.Remove(filepath.Join(repo.Path, "b.txt")); err != nil {
+		t.Fatalf("Failed to remove b.txt: %v", err)
+	}
+	writeTestFile(t, repo, "a.txt", "a changed\n")
+	writeTestFile(t, repo, "d.txt", "d\n")
+
+	// Update only touches tracked files
+	dryRun, err := repo.AddPaths(nil, &AddOptions{Update: true, DryRun: true})
+	if err != nil {
+		t.Fatalf("Dry run failed: %v", err)
+	}
+	if len(dryRun.Added) != 1 || dryRun.Added[0] != "a.txt" || len(dryRun.Removed) != 1 || dryRun.Removed[0] != "b.txt" {
+		t.Errorf("Unexpected dry run result: %+v", dryRun)
+	}
+	if repo.hasStagedChanges() {
+		t.Error("Dry run should not change the index")
+	}
+
+	result, err := repo.AddPaths(nil, &AddOptions{All: true})
+	if err != nil {
+		t.Fatalf("Failed to add all: %v", err)
+	}
+	sort.Strings(result.Added)
+	if strings.Join(result.Added, ",") != "a.txt,d.txt" {
+		t.Errorf("Expected a.txt and d.txt to be added, got %v", result.Added)
+	}
+	if !repo.State.Removed["b.txt"] {
+		t.Error("b.txt should be staged for deletion")
+	}
+
+	if _, err := repo.Commit("Second commit"); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	if _, ok := repo.State.Tracked["b.txt"]; ok {
+		t.Error("b.txt should no longer be tracked")
+	}
+
+	tree, err := repo.getTreeFromCommit(mustResolve(t, repo, repo.State.HEAD))
+	if err != nil {
+		t.Fatalf("Failed to read tree: %v", err)
+	}
+	var paths []string
+	for path := range tree.Entries {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	if strings.Join(paths, ",") != "a.txt,c.txt,d.txt" {
+		t.Errorf("Unexpected tree contents: %v", paths)
+	}
+}
+
+func TestAddUnchangedFileIsNoop(t *testing.T) {
+	repo := newTestRepository(t)
+	commitTestFiles(t, repo, "Initial commit", map[string]string{"a.txt": "a\n"})
+
+	if err := repo.Add("a.txt"); err != nil {
+		t.Fatalf("Failed to add: %v", err)
+	}
+	if repo.hasStagedChanges() {
+		t.Error("Adding an unchanged file should not stage anything")
+	}
+}
+
+// mustResolve resolves a reference or fails the test
+func mustResolve(t *testing.T, repo *Repository, ref string) string {
+	t.Helper()
+
+	id, err := repo.resolveReference(ref)
+	if err != nil {
+		t.Fatalf("Failed to resolve %s: %v", ref, err)
+	}
+	return id
+}
+
+func TestAddIgnoredDirectoryAndDirectoryLink(t *testing.T) {
+	repo := newTestRepository(t)
+
+	writeTestFile(t, repo, ".kitignore", "build/\n")
+	writeTestFile(t, repo, "build/out.o", "object\n")
+	writeTestFile(t, repo, "src/main.go", "package main\n")
+	if err := os.Symlink("src", filepath.Join(repo.Path, "link")); err != nil {
+		t.Skipf("Symbolic links not supported: %v", err)
+	}
+
+	// Naming an ignored directory is refused like an ignored file
+	if _, err := repo.AddPaths([]string{"build"}, nil); err == nil || !strings.Contains(err.Error(), "is ignored") {
+		t.Errorf("Expected an ignored directory to be refused, got %v", err)
+	}
+	result, err := repo.AddPaths([]string{"build"}, &AddOptions{Force: true})
+	if err != nil || len(result.Added) != 1 || result.Added[0] != "build/out.o" {
+		t.Errorf("Expected -f to add the ignored directory, got %+v (%v)", result, err)
+	}
+
+	// A link to a directory is left out of the walk, and can't be named
+	result, err = repo.AddPaths([]string{"."}, nil)
+	if err != nil {
+		t.Fatalf("Failed to add everything: %v", err)
+	}
+	for _, path := range result.Added {
+		if strings.HasPrefix(path, "link") {
+			t.Errorf("Expected the directory link to be skipped, got %v", result.Added)
+		}
+	}
+	if _, err := repo.AddPaths([]string{"link"}, nil); err == nil || !strings.Contains(err.Error(), "symbolic link") {
+		t.Errorf("Expected the directory link to be refused, got %v", err)
+	}
+}
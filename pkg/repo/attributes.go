@@ -0,0 +1,323 @@
+package repo
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// DefaultKitAttributesFile is the name of per-directory attribute files
+	DefaultKitAttributesFile = ".kitattributes"
+	// DefaultKitInfoAttributesFile is the repository-local attribute file, relative to the Kit directory
+	DefaultKitInfoAttributesFile = "info/attributes"
+)
+
+// States of attributes that are given without a value
+const (
+	AttrSet   = "set"   // "name"
+	AttrUnset = "unset" // "-name"
+)
+
+// binarySniffLength is how much of a file is checked for NUL bytes when
+// deciding whether it is binary
+const binarySniffLength = 8000
+
+// Attributes maps attribute names to their state (AttrSet or AttrUnset) or
+// value for one path. Unspecified attributes are missing from the map.
+type Attributes map[string]string
+
+// attributeRule is a pattern and the attributes it assigns, in order
+type attributeRule struct {
+	rule   *IgnoreRule
+	names  []string
+	values []string // "" unspecifies the attribute ("!name")
+}
+
+// AttributeMatcher looks up the attributes of paths. Rules are read from the
+// .kitattributes file of each directory, deeper files taking precedence, and
+// from .kit/info/attributes, which overrides them all. Within a file later
+// lines win. A .kitattributes file missing from the working tree is read
+// from the index.
+type AttributeMatcher struct {
+	repo     *Repository
+	rules    []*attributeRule            // Rules from .kit/info/attributes
+	dirRules map[string][]*attributeRule // Rules from .kitattributes files, by directory
+	mu       sync.Mutex
+}
+
+// NewAttributeMatcher creates an attribute matcher for the repository
+func (r *Repository) NewAttributeMatcher() (*AttributeMatcher, error) {
+	m := &AttributeMatcher{
+		repo:     r,
+		dirRules: make(map[string][]*attributeRule),
+	}
+
+	file := filepath.Join(r.Path, DefaultKitDir, DefaultKitInfoAttributesFile)
+	content, err := os.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read attributes file %s: %w", file, err)
+	}
+	m.rules = parseAttributes(content, "")
+
+	return m, nil
+}
+
+// attributeMatcher returns the repository's attribute matcher, creating it on first use
+func (r *Repository) attributeMatcher() (*AttributeMatcher, error) {
+	if r.attributes == nil {
+		matcher, err := r.NewAttributeMatcher()
+		if err != nil {
+			return nil, err
+		}
+		r.attributes = matcher
+	}
+	return r.attributes, nil
+}
+
+// forgetAttributes drops the cached attribute rules when an attributes
+// file in the working tree changes
+func (r *Repository) forgetAttributes(filePath string) {
+	if filepath.Base(filePath) == DefaultKitAttributesFile {
+		r.attributes = nil
+	}
+}
+
+// attributesStamp summarises the attribute files that can apply to indexed
+// files: .kit/info/attributes and the .kitattributes files of the root and
+// of every directory holding indexed files. Files are summarised by their
+// stat data, or by their index entry when missing from the working tree.
+func (r *Repository) attributesStamp() string {
+	files := []string{DefaultKitAttributesFile}
+	for dir := range r.indexedDirs() {
+		files = append(files, filepath.Join(dir, DefaultKitAttributesFile))
+	}
+	sort.Strings(files)
+	index := r.indexEntries()
+
+	var sb strings.Builder
+	if info, err := os.Stat(filepath.Join(r.Path, DefaultKitDir, DefaultKitInfoAttributesFile)); err == nil {
+		sb.WriteString(fmt.Sprintf("info %d.%d\n", info.Size(), info.ModTime().UnixNano()))
+	}
+	for _, file := range files {
+		if info, err := os.Stat(filepath.Join(r.Path, file)); err == nil {
+			sb.WriteString(fmt.Sprintf("%s %d.%d\n", file, info.Size(), info.ModTime().UnixNano()))
+		} else if objID, ok := index[file]; ok {
+			sb.WriteString(fmt.Sprintf("%s %s\n", file, objID))
+		}
+	}
+	return hashContent([]byte(sb.String()))
+}
+
+// Attributes returns the attributes of a path
+func (r *Repository) Attributes(filePath string) (Attributes, error) {
+	matcher, err := r.attributeMatcher()
+	if err != nil {
+		return nil, err
+	}
+	return matcher.Attributes(filePath)
+}
+
+// Attributes returns the attributes of a path
+func (m *AttributeMatcher) Attributes(filePath string) (Attributes, error) {
+	filePath = filepath.ToSlash(filePath)
+
+	// Collect rules from the top-level file down, then the repository-wide rules
+	dirs := []string{}
+	for dir := path.Dir(filePath); dir != "."; dir = path.Dir(dir) {
+		dirs = append(dirs, dir)
+	}
+	dirs = append(dirs, "")
+	var rules []*attributeRule
+	for i := len(dirs) - 1; i >= 0; i-- {
+		dirRules, err := m.rulesForDir(dirs[i])
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, dirRules...)
+	}
+	rules = append(rules, m.rules...)
+
+	attrs := make(Attributes)
+	for _, rule := range rules {
+		if !rule.rule.matches(filePath, false) {
+			continue
+		}
+		for i, name := range rule.names {
+			if rule.values[i] == "" {
+				delete(attrs, name)
+			} else {
+				attrs[name] = rule.values[i]
+			}
+		}
+	}
+
+	return attrs, nil
+}
+
+// rulesForDir returns the rules of a directory's .kitattributes file, loading them on first use
+func (m *AttributeMatcher) rulesForDir(dir string) ([]*attributeRule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if rules, ok := m.dirRules[dir]; ok {
+		return rules, nil
+	}
+
+	file := path.Join(dir, DefaultKitAttributesFile)
+	content, err := os.ReadFile(filepath.Join(m.repo.Path, file))
+	if os.IsNotExist(err) {
+		content, err = nil, nil
+		if objID, ok := m.repo.indexEntries()[file]; ok {
+			content, err = m.repo.readObject(objID)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attributes file %s: %w", file, err)
+	}
+
+	rules := parseAttributes(content, dir)
+	m.dirRules[dir] = rules
+	return rules, nil
+}
+
+// parseAttributes parses an attributes file. Each line is a pattern in
+// ignore file syntax followed by attributes: "name" sets an attribute,
+// "-name" unsets it, "name=value" gives it a value and "!name" makes it
+// unspecified. "binary" is short for "binary -text -diff -merge". Negated
+// patterns are not allowed and are skipped.
+func parseAttributes(content []byte, base string) []*attributeRule {
+	var rules []*attributeRule
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], "!") {
+			continue
+		}
+		rule := parseIgnoreRule(fields[0], base)
+		if rule == nil {
+			continue
+		}
+
+		attrRule := &attributeRule{rule: rule}
+		add := func(name, value string) {
+			attrRule.names = append(attrRule.names, name)
+			attrRule.values = append(attrRule.values, value)
+		}
+		for _, field := range fields[1:] {
+			switch {
+			case field == "binary":
+				add("binary", AttrSet)
+				add("text", AttrUnset)
+				add("diff", AttrUnset)
+				add("merge", AttrUnset)
+			case strings.HasPrefix(field, "-"):
+				add(field[1:], AttrUnset)
+			case strings.HasPrefix(field, "!"):
+				add(field[1:], "")
+			case strings.Contains(field, "="):
+				name, value, _ := strings.Cut(field, "=")
+				add(name, value)
+			default:
+				add(field, AttrSet)
+			}
+		}
+		rules = append(rules, attrRule)
+	}
+	return rules
+}
+
+// isBinaryContent reports whether content looks binary, that is whether its
+// first bytes contain a NUL byte
+func isBinaryContent(content []byte) bool {
+	if len(content) > binarySniffLength {
+		content = content[:binarySniffLength]
+	}
+	return bytes.IndexByte(content, 0) >= 0
+}
+
+// IsBinary reports whether the path is marked as binary ("binary" or "-text")
+func (a Attributes) IsBinary() bool {
+	return a["text"] == AttrUnset
+}
+
+// isText reports whether content gets its line endings normalised: "text"
+// always, "text=auto" unless the content looks binary, and otherwise when
+// an "eol" attribute is given
+func (a Attributes) isText(content []byte) bool {
+	switch a["text"] {
+	case AttrSet:
+		return true
+	case AttrUnset:
+		return false
+	case "auto":
+		return !isBinaryContent(content)
+	}
+	return a["eol"] == "lf" || a["eol"] == "crlf"
+}
+
+// clean converts working tree content to the form it is stored in: text
+// files are stored with LF line endings
+func (a Attributes) clean(content []byte) []byte {
+	if !a.isText(content) || !bytes.Contains(content, []byte("\r\n")) {
+		return content
+	}
+	return bytes.ReplaceAll(content, []byte("\r\n"), []byte("\n"))
+}
+
+// smudge converts stored content to the form it is checked out in: text
+// files with "eol=crlf" get CRLF line endings
+func (a Attributes) smudge(content []byte) []byte {
+	if a["eol"] != "crlf" || !a.isText(content) {
+		return content
+	}
+	content = bytes.ReplaceAll(content, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(content, []byte("\n"), []byte("\r\n"))
+}
+
+// binaryDiff reports whether changes to the path are shown without their
+// content: with "-diff", or with "-text" unless a diff driver is given
+func (a Attributes) binaryDiff() bool {
+	diff := a["diff"]
+	return diff == AttrUnset || (diff == "" && a.IsBinary())
+}
+
+// diffDriver returns the name of the diff driver for the path, if any
+func (a Attributes) diffDriver() string {
+	if diff := a["diff"]; diff != AttrSet && diff != AttrUnset {
+		return diff
+	}
+	return ""
+}
+
+// mergeDriver returns the merge driver for the path: "text" for the
+// default line-based merge, "binary" for "-merge" and otherwise the
+// driver's name
+func (a Attributes) mergeDriver() string {
+	switch merge := a["merge"]; merge {
+	case "", AttrSet:
+		return "text"
+	case AttrUnset:
+		return "binary"
+	default:
+		return merge
+	}
+}
+
+// semantic reports whether semantic diffs and merges apply to the path:
+// as given by "semantic=on|off", or for files that look like code
+func (a Attributes) semantic(filePath string) bool {
+	switch a["semantic"] {
+	case "on":
+		return true
+	case "off":
+		return false
+	}
+	return isCodeFile(filePath)
+}
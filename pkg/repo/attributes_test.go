@@ -0,0 +1,209 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAttributes(t *testing.T) {
+	repo := newTestRepository(t)
+	writeTestFile(t, repo, ".kitattributes", "*.txt text eol=crlf\n*.png binary\n# comment\n/root.go semantic=off\n")
+	writeTestFile(t, repo, "sub/.kitattributes", "*.txt -text !eol\nroot.go diff=gofmt\n")
+	writeTestFile(t, repo, ".kit/info/attributes", "special.txt merge=union\n")
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"a.txt", "eol=crlf text=set"},
+		{"deep/dir/a.txt", "eol=crlf text=set"},
+		{"sub/a.txt", "text=unset"},
+		{"sub/special.txt", "merge=union text=unset"},
+		{"logo.png", "binary=set diff=unset merge=unset text=unset"},
+		{"root.go", "semantic=off"},
+		{"sub/root.go", "diff=gofmt"},
+		{"other.go", ""},
+	}
+
+	for _, test := range tests {
+		attrs, err := repo.Attributes(test.path)
+		if err != nil {
+			t.Fatalf("Failed to get attributes for %s: %v", test.path, err)
+		}
+		var got []string
+		for _, name := range []string{"binary", "diff", "eol", "merge", "semantic", "text"} {
+			if value, ok := attrs[name]; ok {
+				got = append(got, name+"="+value)
+			}
+		}
+		if strings.Join(got, " ") != test.want {
+			t.Errorf("Attributes(%s): expected %q, got %q", test.path, test.want, strings.Join(got, " "))
+		}
+	}
+}
+
+func TestAttributesLineEndings(t *testing.T) {
+	repo := newTestRepository(t)
+	writeTestFile(t, repo, ".kitattributes", "*.txt text eol=crlf\n*.dat -text\n")
+	writeTestFile(t, repo, "a.txt", "one\r\ntwo\r\n")
+	writeTestFile(t, repo, "b.dat", "raw\r\n")
+
+	if err := repo.Add("."); err != nil {
+		t.Fatalf("Failed to add: %v", err)
+	}
+
+	// Text is stored with LF line endings, other files as they are
+	content, err := repo.readObject(repo.State.Stage["a.txt"])
+	if err != nil {
+		t.Fatalf("Failed to read blob: %v", err)
+	}
+	if string(content) != "one\ntwo\n" {
+		t.Errorf("Expected normalised blob, got %q", content)
+	}
+	content, err = repo.readObject(repo.State.Stage["b.dat"])
+	if err != nil {
+		t.Fatalf("Failed to read blob: %v", err)
+	}
+	if string(content) != "raw\r\n" {
+		t.Errorf("Expected -text file to be stored as is, got %q", content)
+	}
+
+	if _, err := repo.Commit("Add files"); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	status, err := repo.Status()
+	if err != nil {
+		t.Fatalf("Failed to get status: %v", err)
+	}
+	if !strings.Contains(status, "working tree clean") {
+		t.Errorf("Expected clean working tree, got:\n%s", status)
+	}
+
+	// Checking the file out converts it back
+	if err := os.Remove(filepath.Join(repo.Path, "a.txt")); err != nil {
+		t.Fatalf("Failed to remove a.txt: %v", err)
+	}
+	if _, err := repo.Restore([]string{"a.txt"}, nil); err != nil {
+		t.Fatalf("Failed to restore: %v", err)
+	}
+	if content := readTestFile(t, repo, "a.txt"); content != "one\r\ntwo\r\n" {
+		t.Errorf("Expected CRLF line endings in the working tree, got %q", content)
+	}
+}
+
+func TestAttributesDiff(t *testing.T) {
+	repo := newTestRepository(t)
+	commitTestFiles(t, repo, "Initial commit", map[string]string{
+		".kitattributes": "*.bin binary\n*.up diff=upper\n",
+		"data.bin":       "abc\n",
+		"name.up":        "hello\n",
+	})
+	if err := repo.SetConfigValue(`diff "upper"`, "textconv", "tr a-z A-Z"); err != nil {
+		t.Fatalf("Failed to set config: %v", err)
+	}
+
+	writeTestFile(t, repo, "data.bin", "abd\n")
+	writeTestFile(t, repo, "name.up", "world\n")
+
+	results, err := repo.DiffWorkingTree(mustResolve(t, repo, "HEAD"), nil)
+	if err != nil {
+		t.Fatalf("Failed to diff: %v", err)
+	}
+	diff := FormatDiff(results)
+	if !strings.Contains(diff, "Binary files a/data.bin and b/data.bin differ") {
+		t.Errorf("Expected binary diff for data.bin, got:\n%s", diff)
+	}
+	if strings.Contains(diff, "abd") {
+		t.Errorf("Expected binary content not to be shown, got:\n%s", diff)
+	}
+	if !strings.Contains(diff, "-HELLO") || !strings.Contains(diff, "+WORLD") {
+		t.Errorf("Expected textconv output in diff, got:\n%s", diff)
+	}
+}
+
+func TestAttributesMergeDrivers(t *testing.T) {
+	repo := newTestRepository(t)
+	writeTestFile(t, repo, ".kitattributes", "*.log merge=union\n*.cfg merge=theirs-wins\n*.bin binary\n")
+	if err := repo.SetConfigValue(`merge "theirs-wins"`, "driver", "cat %B > %A"); err != nil {
+		t.Fatalf("Failed to set config: %v", err)
+	}
+
+	tree := func(files map[string]string) *TreeObject {
+		tree := &TreeObject{Entries: make(map[string]TreeEntry)}
+		for path, content := range files {
+			objID := hashContent([]byte(content))
+			if err := repo.storeObject(objID, []byte(content)); err != nil {
+				t.Fatalf("Failed to store object: %v", err)
+			}
+			tree.Entries[path] = TreeEntry{Path: path, Mode: "100644", Type: "blob", ObjID: objID}
+		}
+		return tree
+	}
+	base := tree(map[string]string{"app.log": "start\n", "app.cfg": "a=1\n", "img.bin": "\x00base"})
+	ours := tree(map[string]string{"app.log": "start\nours\n", "app.cfg": "a=2\n", "img.bin": "\x00ours"})
+	theirs := tree(map[string]string{"app.log": "start\ntheirs\n", "app.cfg": "a=3\n", "img.bin": "\x00theirs"})
+
+	merged, conflicts, err := repo.MergeTrees(base, ours, theirs, &MergeOptions{Strategy: Manual})
+	if err != nil {
+		t.Fatalf("Failed to merge: %v", err)
+	}
+
+	read := func(path string) string {
+		content, err := repo.readObject(merged.Entries[path].ObjID)
+		if err != nil {
+			t.Fatalf("Failed to read merged %s: %v", path, err)
+		}
+		return string(content)
+	}
+	if content := read("app.log"); content != "start\nours\ntheirs\n" {
+		t.Errorf("Expected union merge, got %q", content)
+	}
+	if content := read("app.cfg"); content != "a=3\n" {
+		t.Errorf("Expected custom driver result, got %q", content)
+	}
+	if len(conflicts) != 1 || conflicts[0].Path != "img.bin" {
+		t.Errorf("Expected only the binary file to conflict, got %v", conflicts)
+	}
+}
+
+func TestAttributesChangeInvalidatesStatCache(t *testing.T) {
+	repo := newTestRepository(t)
+	commitTestFiles(t, repo, "Initial commit", map[string]string{"a.txt": "one\r\ntwo\r\n"})
+
+	// Backdate the file so its cached hash is trusted, then cache it
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(repo.Path, "a.txt"), past, past); err != nil {
+		t.Fatalf("Failed to set file times: %v", err)
+	}
+	if err := repo.RefreshIndex(); err != nil {
+		t.Fatalf("Failed to refresh index: %v", err)
+	}
+	reopened, err := NewRepository(repo.Path)
+	if err != nil {
+		t.Fatalf("Failed to reopen repository: %v", err)
+	}
+	if _, ok := reopened.State.WorkTree["a.txt"]; !ok {
+		t.Fatal("Expected a.txt's hash to be cached")
+	}
+
+	// With line endings normalised the stored CRLF version differs from
+	// what the file now cleans to, without the file being touched
+	writeTestFile(t, repo, ".kitattributes", "*.txt text\n")
+	reopened, err = NewRepository(repo.Path)
+	if err != nil {
+		t.Fatalf("Failed to reopen repository: %v", err)
+	}
+	if _, ok := reopened.State.WorkTree["a.txt"]; ok {
+		t.Error("Expected the stat cache to be dropped after the attributes changed")
+	}
+	status, err := reopened.Status()
+	if err != nil {
+		t.Fatalf("Failed to get status: %v", err)
+	}
+	if !strings.Contains(status, "modified: a.txt") {
+		t.Errorf("Expected a.txt to be re-cleaned and modified, got:\n%s", status)
+	}
+}
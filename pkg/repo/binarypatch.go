@@ -0,0 +1,341 @@
+package repo
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// BinaryPatch is the change to a binary file in the "GIT binary patch"
+// format, which holds the change in both directions so it can be applied
+// or reversed
+type BinaryPatch struct {
+	Forward BinaryHunk // Turns the old version into the new one
+	Reverse BinaryHunk // Turns the new version back into the old one
+}
+
+// BinaryHunk is one direction of a binary patch
+type BinaryHunk struct {
+	Delta bool   // Data is a delta against the other version rather than the whole content
+	Data  []byte // The content or delta, uncompressed
+}
+
+// binaryDeltaBlock is the length of the runs of source bytes a delta looks
+// for in the target
+const binaryDeltaBlock = 16
+
+// binaryLineBytes is the most data bytes encoded on one line of a patch
+const binaryLineBytes = 52
+
+// base85Alphabet is the alphabet of git's base85 encoding
+const base85Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz!#$%&()*+-;<=>?@^_`{|}~"
+
+// makeBinaryPatch builds the patch from old to new. Each direction is a
+// delta against the other version when that compresses smaller than the
+// literal content.
+func makeBinaryPatch(oldContent, newContent []byte) *BinaryPatch {
+	return &BinaryPatch{
+		Forward: makeBinaryHunk(oldContent, newContent),
+		Reverse: makeBinaryHunk(newContent, oldContent),
+	}
+}
+
+// makeBinaryHunk builds the hunk that turns source into target
+func makeBinaryHunk(source, target []byte) BinaryHunk {
+	literal := BinaryHunk{Data: target}
+	if len(source) == 0 || len(target) == 0 {
+		return literal
+	}
+	delta := BinaryHunk{Delta: true, Data: binaryDelta(source, target)}
+	if len(deflate(delta.Data)) < len(deflate(literal.Data)) {
+		return delta
+	}
+	return literal
+}
+
+// Apply returns the content the hunk makes from the other version
+func (h BinaryHunk) Apply(content []byte) ([]byte, error) {
+	if !h.Delta {
+		return append([]byte{}, h.Data...), nil
+	}
+	return applyBinaryDelta(content, h.Data)
+}
+
+// binaryDelta encodes target as a git delta against source: the sizes of
+// both, then instructions that copy ranges of source or insert new bytes.
+// Blocks of source are indexed, and each match found in target is
+// extended as far as it goes.
+func binaryDelta(source, target []byte) []byte {
+	index := make(map[string]int)
+	for i := 0; i+binaryDeltaBlock <= len(source); i += binaryDeltaBlock {
+		block := string(source[i : i+binaryDeltaBlock])
+		if _, ok := index[block]; !ok {
+			index[block] = i
+		}
+	}
+
+	delta := binary.AppendUvarint(nil, uint64(len(source)))
+	delta = binary.AppendUvarint(delta, uint64(len(target)))
+	insert := []byte{}
+	flush := func() {
+		for len(insert) > 0 {
+			n := min(len(insert), 0x7f)
+			delta = append(delta, byte(n))
+			delta = append(delta, insert[:n]...)
+			insert = insert[n:]
+		}
+	}
+
+	for pos := 0; pos < len(target); {
+		offset, ok := -1, false
+		if pos+binaryDeltaBlock <= len(target) {
+			offset, ok = index[string(target[pos:pos+binaryDeltaBlock])]
+		}
+		if !ok {
+			insert = append(insert, target[pos])
+			pos++
+			continue
+		}
+		length := binaryDeltaBlock
+		for offset+length < len(source) && pos+length < len(target) && source[offset+length] == target[pos+length] {
+			length++
+		}
+		flush()
+		for copied := 0; copied < length; {
+			n := min(length-copied, 0xffffff)
+			delta = appendDeltaCopy(delta, offset+copied, n)
+			copied += n
+		}
+		pos += length
+	}
+	flush()
+
+	return delta
+}
+
+// appendDeltaCopy appends the instruction to copy size bytes of the source
+// from offset. Only the non-zero bytes of each are written, flagged in the
+// opcode.
+func appendDeltaCopy(delta []byte, offset, size int) []byte {
+	at := len(delta)
+	opcode := byte(0x80)
+	delta = append(delta, opcode)
+	for i := 0; i < 4; i++ {
+		if b := byte(offset >> (8 * i)); b != 0 {
+			opcode |= 1 << i
+			delta = append(delta, b)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if b := byte(size >> (8 * i)); b != 0 {
+			opcode |= 1 << (4 + i)
+			delta = append(delta, b)
+		}
+	}
+	delta[at] = opcode
+	return delta
+}
+
+// applyBinaryDelta rebuilds the target of a delta from its source
+func applyBinaryDelta(source, delta []byte) ([]byte, error) {
+	reader := bytes.NewReader(delta)
+	sourceSize, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, fmt.Errorf("invalid delta header: %w", err)
+	}
+	targetSize, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, fmt.Errorf("invalid delta header: %w", err)
+	}
+	if sourceSize != uint64(len(source)) {
+		return nil, fmt.Errorf("delta is for %d bytes, not %d", sourceSize, len(source))
+	}
+
+	target := make([]byte, 0, targetSize)
+	for reader.Len() > 0 {
+		opcode, _ := reader.ReadByte()
+		switch {
+		case opcode&0x80 != 0:
+			offset, size := 0, 0
+			for i := 0; i < 7; i++ {
+				if opcode&(1<<i) == 0 {
+					continue
+				}
+				b, err := reader.ReadByte()
+				if err != nil {
+					return nil, fmt.Errorf("truncated delta copy")
+				}
+				if i < 4 {
+					offset |= int(b) << (8 * i)
+				} else {
+					size |= int(b) << (8 * (i - 4))
+				}
+			}
+			if size == 0 {
+				size = 0x10000
+			}
+			if offset+size > len(source) {
+				return nil, fmt.Errorf("delta copies past the end of the source")
+			}
+			target = append(target, source[offset:offset+size]...)
+		case opcode != 0:
+			data := make([]byte, opcode)
+			if _, err := io.ReadFull(reader, data); err != nil {
+				return nil, fmt.Errorf("truncated delta insert")
+			}
+			target = append(target, data...)
+		default:
+			return nil, fmt.Errorf("invalid delta opcode 0")
+		}
+	}
+	if uint64(len(target)) != targetSize {
+		return nil, fmt.Errorf("delta makes %d bytes, not %d", len(target), targetSize)
+	}
+
+	return target, nil
+}
+
+// formatBinaryPatch writes a patch as its two hunks, each a "literal" or
+// "delta" header with the uncompressed size, then the deflated data in
+// base85 lines and a blank line
+func formatBinaryPatch(buf *strings.Builder, patch *BinaryPatch) {
+	buf.WriteString("GIT binary patch\n")
+	for _, hunk := range []BinaryHunk{patch.Forward, patch.Reverse} {
+		kind := "literal"
+		if hunk.Delta {
+			kind = "delta"
+		}
+		buf.WriteString(fmt.Sprintf("%s %d\n", kind, len(hunk.Data)))
+		data := deflate(hunk.Data)
+		for len(data) > 0 {
+			n := min(len(data), binaryLineBytes)
+			// The first character gives the line's length, A-Z for 1-26 and a-z for 27-52
+			if n <= 26 {
+				buf.WriteByte(byte('A' + n - 1))
+			} else {
+				buf.WriteByte(byte('a' + n - 27))
+			}
+			buf.WriteString(encodeBase85(data[:n]))
+			buf.WriteByte('\n')
+			data = data[n:]
+		}
+		buf.WriteByte('\n')
+	}
+}
+
+// parseBinaryHunk parses one hunk of a binary patch from its header line,
+// reading its data lines up to the blank line after them
+func parseBinaryHunk(header string, next func() (string, bool)) (BinaryHunk, error) {
+	kind, sizeText, _ := strings.Cut(header, " ")
+	size, err := strconv.Atoi(sizeText)
+	if (kind != "literal" && kind != "delta") || err != nil || size < 0 {
+		return BinaryHunk{}, fmt.Errorf("invalid binary hunk header: %s", header)
+	}
+
+	var compressed []byte
+	for {
+		line, ok := next()
+		if !ok || line == "" {
+			break
+		}
+		var n int
+		switch c := line[0]; {
+		case c >= 'A' && c <= 'Z':
+			n = int(c-'A') + 1
+		case c >= 'a' && c <= 'z':
+			n = int(c-'a') + 27
+		default:
+			return BinaryHunk{}, fmt.Errorf("invalid binary patch line length %q", c)
+		}
+		data, err := decodeBase85(line[1:])
+		if err != nil || len(data) < n {
+			return BinaryHunk{}, fmt.Errorf("invalid binary patch line: %s", line)
+		}
+		compressed = append(compressed, data[:n]...)
+	}
+
+	reader, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return BinaryHunk{}, fmt.Errorf("failed to inflate binary hunk: %w", err)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return BinaryHunk{}, fmt.Errorf("failed to inflate binary hunk: %w", err)
+	}
+	if len(data) != size {
+		return BinaryHunk{}, fmt.Errorf("binary hunk has %d bytes, not %d", len(data), size)
+	}
+
+	return BinaryHunk{Delta: kind == "delta", Data: data}, nil
+}
+
+// deflate compresses data with zlib
+func deflate(data []byte) []byte {
+	var buf bytes.Buffer
+	writer := zlib.NewWriter(&buf)
+	writer.Write(data)
+	writer.Close()
+	return buf.Bytes()
+}
+
+// encodeBase85 encodes data in git's base85, five characters for every four
+// bytes, with the last group padded with zeros
+func encodeBase85(data []byte) string {
+	var sb strings.Builder
+	for i := 0; i < len(data); i += 4 {
+		var group [4]byte
+		copy(group[:], data[i:])
+		value := binary.BigEndian.Uint32(group[:])
+		var encoded [5]byte
+		for j := 4; j >= 0; j-- {
+			encoded[j] = base85Alphabet[value%85]
+			value /= 85
+		}
+		sb.Write(encoded[:])
+	}
+	return sb.String()
+}
+
+// decodeBase85 decodes git's base85, including any padding
+func decodeBase85(text string) ([]byte, error) {
+	if len(text)%5 != 0 {
+		return nil, fmt.Errorf("base85 text is not a multiple of 5 characters")
+	}
+	data := make([]byte, 0, len(text)/5*4)
+	for i := 0; i < len(text); i += 5 {
+		var value uint64
+		for j := 0; j < 5; j++ {
+			digit := strings.IndexByte(base85Alphabet, text[i+j])
+			if digit < 0 {
+				return nil, fmt.Errorf("invalid base85 character %q", text[i+j])
+			}
+			value = value*85 + uint64(digit)
+		}
+		if value > 0xffffffff {
+			return nil, fmt.Errorf("base85 group out of range")
+		}
+		data = binary.BigEndian.AppendUint32(data, uint32(value))
+	}
+	return data, nil
+}
+
+// binaryPatchID returns an object ID for the index line of a binary patch,
+// all zeros for a missing side
+func binaryPatchID(objID string) string {
+	if objID == "" {
+		return strings.Repeat("0", 64)
+	}
+	return objID
+}
+
+// patchHeaderPath returns a path as written in a ---/+++ header
+func patchHeaderPath(path, prefix string) string {
+	if path == "/dev/null" {
+		return path
+	}
+	return prefix + path
+}
@@ -0,0 +1,189 @@
+package repo
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// binaryContent returns n random bytes, NULs included
+func binaryContent(seed int64, n int) []byte {
+	content := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(content)
+	content[0] = 0
+	return content
+}
+
+func TestBinaryDiff(t *testing.T) {
+	repo := newTestRepository(t)
+	oldImage := binaryContent(1, 3000)
+	a := commitTestFiles(t, repo, "A", map[string]string{
+		".kitattributes": "*.dat diff\n",
+		"image.png":      string(oldImage),
+		"forced.dat":     "a\x00b\n",
+		"notes.txt":      "one\n",
+	})
+
+	// Change a few bytes in the middle and append some
+	changed := append([]byte{}, oldImage...)
+	copy(changed[1500:], "edited")
+	changed = append(changed, binaryContent(2, 100)...)
+	b := commitTestFiles(t, repo, "B", map[string]string{
+		"image.png":  string(changed),
+		"forced.dat": "a\x00c\n",
+		"notes.txt":  "one\ntwo\n",
+		"added.bin":  "\x00\x01\x02",
+	})
+
+	results, err := repo.Diff(a, b, nil)
+	if err != nil {
+		t.Fatalf("Failed to diff: %v", err)
+	}
+	byPath := diffByPath(results)
+	if image := byPath["image.png"]; !image.Binary || len(image.Chunks) != 0 || image.Patch != nil ||
+		image.OldSize != 3000 || image.NewSize != 3100 || image.NewID != hashContent(changed) {
+		t.Errorf("Expected image.png to be binary, got %+v", image)
+	}
+	if added := byPath["added.bin"]; !added.Binary || added.OldID != "" || added.NewSize != 3 {
+		t.Errorf("Expected added.bin to be binary, got %+v", added)
+	}
+	if forced := byPath["forced.dat"]; forced.Binary || len(forced.Chunks) != 1 {
+		t.Errorf("Expected the diff attribute to force a text diff, got %+v", forced)
+	}
+	formatted := FormatDiff(results)
+	if !strings.Contains(formatted, "Binary files a/image.png and b/image.png differ\n") ||
+		!strings.Contains(formatted, "Binary files /dev/null and b/added.bin differ\n") {
+		t.Errorf("Expected binary files to differ, got:\n%s", formatted)
+	}
+
+	// Binary patches apply both ways after a round trip through the text
+	results, err = repo.Diff(a, b, &DiffOptions{ContextLines: 3, Binary: true})
+	if err != nil {
+		t.Fatalf("Failed to diff: %v", err)
+	}
+	formatted = FormatDiff(results)
+	if !strings.Contains(formatted, "GIT binary patch\ndelta ") {
+		t.Errorf("Expected a delta for the edited image, got:\n%s", formatted)
+	}
+	parsed, err := ParsePatch(formatted)
+	if err != nil {
+		t.Fatalf("Failed to parse patch: %v", err)
+	}
+	byPath = diffByPath(parsed)
+	image := byPath["image.png"]
+	if image.Patch == nil || image.OldID != hashContent(oldImage) || image.NewID != hashContent(changed) {
+		t.Fatalf("Expected a binary patch for image.png, got %+v", image)
+	}
+	if got, err := image.Patch.Forward.Apply(oldImage); err != nil || !bytes.Equal(got, changed) {
+		t.Errorf("Forward hunk did not rebuild the new image (%v)", err)
+	}
+	if got, err := image.Patch.Reverse.Apply(changed); err != nil || !bytes.Equal(got, oldImage) {
+		t.Errorf("Reverse hunk did not rebuild the old image (%v)", err)
+	}
+	added := byPath["added.bin"]
+	if added.Patch == nil || added.OldPath != "/dev/null" || added.OldID != "" || added.Patch.Forward.Delta {
+		t.Fatalf("Expected a literal patch for added.bin, got %+v", added)
+	}
+	if got, _ := added.Patch.Forward.Apply(nil); string(got) != "\x00\x01\x02" {
+		t.Errorf("Unexpected added content %q", got)
+	}
+	if notes := byPath["notes.txt"]; notes.Binary || !containsString(notes.Chunks[0].Lines, "+two") {
+		t.Errorf("Expected text hunks to survive, got %+v", notes)
+	}
+}
+
+func TestStageBinaryPatch(t *testing.T) {
+	repo := newTestRepository(t)
+	oldImage := binaryContent(4, 2000)
+	commitTestFiles(t, repo, "A", map[string]string{"image.png": string(oldImage)})
+
+	changed := append([]byte{}, oldImage...)
+	copy(changed[1000:], "edited")
+	added := binaryContent(5, 50)
+	patch := FormatDiff([]DiffResult{
+		{OldPath: "image.png", NewPath: "image.png", Binary: true, OldID: hashContent(oldImage), NewID: hashContent(changed),
+			Patch: makeBinaryPatch(oldImage, changed)},
+		{OldPath: "/dev/null", NewPath: "added.bin", Binary: true, NewID: hashContent(added), Patch: makeBinaryPatch(nil, added)},
+	})
+	results, err := ParsePatch(patch)
+	if err != nil {
+		t.Fatalf("Failed to parse patch: %v", err)
+	}
+	for _, result := range results {
+		if err := repo.StageBinaryPatch(result.NewPath, result.Patch, result.OldID, result.NewID); err != nil {
+			t.Fatalf("Failed to stage binary patch of %s: %v", result.NewPath, err)
+		}
+	}
+	if repo.State.Stage["image.png"] != hashContent(changed) || repo.State.Stage["added.bin"] != hashContent(added) {
+		t.Errorf("Expected the patched versions to be staged, got %v", repo.State.Stage)
+	}
+	if content := readTestFile(t, repo, "image.png"); content != string(oldImage) {
+		t.Errorf("Expected the working file to be untouched")
+	}
+
+	// The index no longer holds the version the patch was made from
+	image := diffByPath(results)["image.png"]
+	if err := repo.StageBinaryPatch("image.png", image.Patch, image.OldID, image.NewID); err == nil {
+		t.Error("Expected the patch not to apply twice")
+	}
+	// Nor is a result other than the one recorded in the patch staged
+	if err := repo.StageBinaryPatch("image.png", image.Patch, hashContent(changed), hashContent(oldImage)); err == nil {
+		t.Error("Expected a result with the wrong ID to be refused")
+	}
+
+	// A binary file without patch data is still reported
+	results, err = ParsePatch("Binary files a/image.png and b/image.png differ\n\n")
+	if err != nil {
+		t.Fatalf("Failed to parse patch: %v", err)
+	}
+	if len(results) != 1 || !results[0].Binary || results[0].Patch != nil || results[0].NewPath != "image.png" {
+		t.Errorf("Expected a binary result without a patch, got %+v", results)
+	}
+}
+
+func TestBinaryDelta(t *testing.T) {
+	source := binaryContent(3, 200000)
+	target := append(append([]byte("header"), source[:70000]...), source[90000:]...)
+	delta := binaryDelta(source, target)
+	if len(delta) > 100 {
+		t.Errorf("Expected a small delta, got %d bytes", len(delta))
+	}
+	if got, err := applyBinaryDelta(source, delta); err != nil || !bytes.Equal(got, target) {
+		t.Errorf("Delta did not rebuild the target (%v)", err)
+	}
+	if _, err := applyBinaryDelta(source[1:], delta); err == nil {
+		t.Errorf("Expected a delta for another source to be refused")
+	}
+
+	for _, data := range [][]byte{{}, {1}, {0, 0, 0, 0, 0}, []byte("base85 round trip")} {
+		decoded, err := decodeBase85(encodeBase85(data))
+		if err != nil || !bytes.HasPrefix(decoded, data) || len(decoded)%4 != 0 {
+			t.Errorf("base85 round trip of %q gave %q (%v)", data, decoded, err)
+		}
+	}
+}
+
+func TestFormatDiffStat(t *testing.T) {
+	results := []DiffResult{
+		{OldPath: "a.txt", NewPath: "a.txt", Chunks: []DiffChunk{{Lines: []string{" x", "-y", "+z", "+w"}}}},
+		{OldPath: "old.go", NewPath: "new.go", Renamed: true, Similarity: 1},
+		{OldPath: "/dev/null", NewPath: "logo.png", Binary: true, NewID: "abcdef0123456789", NewSize: 120},
+	}
+	want := " a.txt            | 3 ++-\n" +
+		" old.go => new.go | 0\n" +
+		" logo.png         | Bin 0 -> 120 bytes (00000000 -> abcdef01)\n" +
+		" 3 files changed, 2 insertions(+), 1 deletion(-)\n"
+	if got := FormatDiffStat(results); got != want {
+		t.Errorf("Unexpected stat:\n%s\nwant:\n%s", got, want)
+	}
+
+	lines := make([]string, 400)
+	for i := range lines {
+		lines[i] = "+line"
+	}
+	got := FormatDiffStat([]DiffResult{{OldPath: "big", NewPath: "big", Chunks: []DiffChunk{{Lines: lines}}}})
+	if got != " big | 400 "+strings.Repeat("+", diffStatWidth)+"\n 1 file changed, 400 insertions(+)\n" {
+		t.Errorf("Expected the bar to be scaled, got:\n%s", got)
+	}
+}
@@ -0,0 +1,400 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Files holding the state of a bisection in the .kit directory
+const (
+	bisectStartFile = "BISECT_START" // Branch (or commit) checked out before the bisection
+	bisectBadFile   = "BISECT_BAD"   // The bad commit
+	bisectGoodFile  = "BISECT_GOOD"  // Good commits, one per line
+	bisectSkipFile  = "BISECT_SKIP"  // Skipped commits, one per line
+)
+
+// Exit codes of a bisect run command
+const (
+	bisectRunSkip  = 125 // The commit can't be tested
+	bisectRunAbort = 128 // Codes from here on stop the bisection
+)
+
+// BisectStatus describes where a bisection stands after a step
+type BisectStatus struct {
+	Current   string   // Commit checked out to be tested next ("" when done)
+	Summary   string   // First line of the message of Current or FirstBad
+	Remaining int      // Untested commits that could be the first bad one
+	Steps     int      // Roughly how many more tests are needed
+	FirstBad  string   // The first bad commit, once found
+	Skipped   []string // The possible first bad commits, when only skipped ones are left
+}
+
+// Done reports whether the bisection can't go any further
+func (s *BisectStatus) Done() bool {
+	return s.FirstBad != "" || len(s.Skipped) > 0
+}
+
+// bisectState is the state of a bisection kept under .kit
+type bisectState struct {
+	start string
+	bad   string
+	good  []string
+	skip  []string
+}
+
+// IsBisecting reports whether a bisection is in progress
+func (r *Repository) IsBisecting() bool {
+	_, err := os.Stat(filepath.Join(r.Path, DefaultKitDir, bisectStartFile))
+	return err == nil
+}
+
+// BisectStart starts looking for the commit that introduced a bug between
+// a bad revision and one or more good ones, and checks out the first commit
+// to test. The search covers every commit reachable from the bad one but
+// not from a good one, following both parents of merges.
+func (r *Repository) BisectStart(bad string, good []string) (*BisectStatus, error) {
+	if r.IsBisecting() {
+		return nil, fmt.Errorf("already bisecting, run bisect reset first")
+	}
+	if len(good) == 0 {
+		return nil, fmt.Errorf("bisect needs at least one good revision")
+	}
+
+	state := &bisectState{}
+	var err error
+	if state.bad, err = r.ResolveRevision(bad); err != nil {
+		return nil, err
+	}
+	for _, rev := range good {
+		commitID, err := r.ResolveRevision(rev)
+		if err != nil {
+			return nil, err
+		}
+		state.good = append(state.good, commitID)
+	}
+
+	// Remember where to go back to
+	if state.start, err = r.GetCurrentBranch(); err != nil {
+		if state.start, err = r.resolveReference("HEAD"); err != nil {
+			return nil, fmt.Errorf("failed to resolve HEAD: %w", err)
+		}
+	}
+
+	status, err := r.bisectNext(state)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.saveBisectState(state); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// BisectGood marks a revision (HEAD if empty) as good and checks out the next commit to test
+func (r *Repository) BisectGood(rev string) (*BisectStatus, error) {
+	return r.bisectMark(rev, func(state *bisectState, commitID string) {
+		state.good = append(state.good, commitID)
+	})
+}
+
+// BisectBad marks a revision (HEAD if empty) as bad and checks out the next commit to test
+func (r *Repository) BisectBad(rev string) (*BisectStatus, error) {
+	return r.bisectMark(rev, func(state *bisectState, commitID string) {
+		state.bad = commitID
+	})
+}
+
+// BisectSkip marks a revision (HEAD if empty) as untestable and checks out
+// another commit to test instead
+func (r *Repository) BisectSkip(rev string) (*BisectStatus, error) {
+	return r.bisectMark(rev, func(state *bisectState, commitID string) {
+		if !containsString(state.skip, commitID) {
+			state.skip = append(state.skip, commitID)
+		}
+	})
+}
+
+// bisectMark records a verdict on a revision and moves on to the next step
+func (r *Repository) bisectMark(rev string, mark func(*bisectState, string)) (*BisectStatus, error) {
+	state, err := r.readBisectState()
+	if err != nil {
+		return nil, err
+	}
+	if rev == "" {
+		rev = "HEAD"
+	}
+	commitID, err := r.ResolveRevision(rev)
+	if err != nil {
+		return nil, err
+	}
+	mark(state, commitID)
+
+	status, err := r.bisectNext(state)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.saveBisectState(state); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// BisectReset ends the bisection, going back to the branch that was
+// checked out when it started
+func (r *Repository) BisectReset() error {
+	state, err := r.readBisectState()
+	if err != nil {
+		return err
+	}
+
+	branchRef := fmt.Sprintf("refs/heads/%s", state.start)
+	if _, err := os.Stat(r.refPath(branchRef)); err == nil {
+		err = r.CheckoutBranch(state.start)
+	} else {
+		_, err = r.CheckoutDetached(state.start, nil)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check out %s: %w", state.start, err)
+	}
+
+	for _, name := range []string{bisectStartFile, bisectBadFile, bisectGoodFile, bisectSkipFile} {
+		if err := os.Remove(filepath.Join(r.Path, DefaultKitDir, name)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// BisectRun drives the bisection with a shell command run in the working
+// tree of each commit to test. An exit code of 0 marks the commit good, 125
+// skips it, and any other code below 128 marks it bad; higher codes, as
+// from a crash or a signal, stop the bisection. The command's output and a
+// description of each step are written to output.
+func (r *Repository) BisectRun(command string, output io.Writer) (*BisectStatus, error) {
+	if !r.IsBisecting() {
+		return nil, fmt.Errorf("not bisecting")
+	}
+
+	for {
+		cmd := exec.Command("sh", "-c", command)
+		cmd.Dir = r.Path
+		cmd.Stdout = output
+		cmd.Stderr = output
+		code := 0
+		if err := cmd.Run(); err != nil {
+			var exitErr *exec.ExitError
+			if !errors.As(err, &exitErr) {
+				return nil, fmt.Errorf("failed to run %s: %w", command, err)
+			}
+			code = exitErr.ExitCode()
+		}
+
+		var status *BisectStatus
+		var err error
+		switch {
+		case code == 0:
+			status, err = r.BisectGood("")
+		case code == bisectRunSkip:
+			status, err = r.BisectSkip("")
+		case code > 0 && code < bisectRunAbort:
+			status, err = r.BisectBad("")
+		default:
+			return nil, fmt.Errorf("bisect run stopped: %s exited with code %d", command, code)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		fmt.Fprint(output, FormatBisectStatus(status))
+		if status.Done() {
+			return status, nil
+		}
+	}
+}
+
+// bisectNext works out the commits that could still be the first bad one
+// and checks out the one that best halves them: the commit with the closest
+// to half of them among its ancestors. When only the bad commit is left, it
+// is the first bad one.
+func (r *Repository) bisectNext(state *bisectState) (*BisectStatus, error) {
+	// Everything reachable from a good commit is good
+	good := make(map[string]bool)
+	stack := append([]string{}, state.good...)
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if good[id] {
+			continue
+		}
+		good[id] = true
+		node, err := r.commitNode(id)
+		if err != nil {
+			return nil, err
+		}
+		stack = append(stack, node.Parents...)
+	}
+	if good[state.bad] {
+		return nil, fmt.Errorf("the bad commit %s is an ancestor of a good commit, were good and bad mixed up?", shortID(state.bad))
+	}
+
+	// The candidates are the rest of the bad commit's history
+	nodes := make(map[string]*commitNode)
+	stack = []string{state.bad}
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if good[id] || nodes[id] != nil {
+			continue
+		}
+		node, err := r.commitNode(id)
+		if err != nil {
+			return nil, err
+		}
+		nodes[id] = node
+		stack = append(stack, node.Parents...)
+	}
+	candidates := make(nodeQueue, 0, len(nodes))
+	for _, node := range nodes {
+		candidates = append(candidates, node)
+	}
+	sort.Sort(candidates)
+
+	if len(candidates) == 1 {
+		return r.bisectStatus(&BisectStatus{FirstBad: state.bad}, state.bad)
+	}
+
+	// Pick the testable candidate whose ancestors among the candidates
+	// come closest to half of them
+	best, bestScore := "", -1
+	for _, node := range candidates {
+		if node.ID == state.bad || containsString(state.skip, node.ID) {
+			continue
+		}
+		ancestors := bisectAncestors(node.ID, nodes)
+		score := min(ancestors, len(candidates)-ancestors)
+		if score > bestScore {
+			best, bestScore = node.ID, score
+		}
+	}
+	if best == "" {
+		status := &BisectStatus{}
+		for _, node := range candidates {
+			status.Skipped = append(status.Skipped, node.ID)
+		}
+		return status, nil
+	}
+
+	if _, err := r.CheckoutDetached(best, nil); err != nil {
+		return nil, err
+	}
+	remaining := len(candidates) - 1
+	return r.bisectStatus(&BisectStatus{Current: best, Remaining: remaining, Steps: bits.Len(uint(remaining))}, best)
+}
+
+// bisectAncestors counts the candidates reachable from a commit, itself included
+func bisectAncestors(commitID string, nodes map[string]*commitNode) int {
+	seen := make(map[string]bool)
+	stack := []string{commitID}
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		node := nodes[id]
+		if node == nil || seen[id] {
+			continue
+		}
+		seen[id] = true
+		stack = append(stack, node.Parents...)
+	}
+	return len(seen)
+}
+
+// bisectStatus fills in the summary of the commit a status is about
+func (r *Repository) bisectStatus(status *BisectStatus, commitID string) (*BisectStatus, error) {
+	commit, err := r.readCommit(commitID)
+	if err != nil {
+		return nil, err
+	}
+	status.Summary, _, _ = strings.Cut(commit.Message, "\n")
+	return status, nil
+}
+
+// FormatBisectStatus describes a bisection step for the user
+func FormatBisectStatus(status *BisectStatus) string {
+	var sb strings.Builder
+	switch {
+	case status.FirstBad != "":
+		sb.WriteString(fmt.Sprintf("%s is the first bad commit\n", status.FirstBad))
+		sb.WriteString(fmt.Sprintf("    %s\n", status.Summary))
+	case len(status.Skipped) > 0:
+		sb.WriteString("There are only skipped commits left to test.\n")
+		sb.WriteString("The first bad commit could be any of:\n")
+		for _, commitID := range status.Skipped {
+			sb.WriteString(commitID + "\n")
+		}
+	default:
+		sb.WriteString(fmt.Sprintf("Bisecting: %d revision(s) left to test (roughly %d step(s))\n", status.Remaining, status.Steps))
+		sb.WriteString(fmt.Sprintf("[%s] %s\n", status.Current, status.Summary))
+	}
+	return sb.String()
+}
+
+// readBisectState reads the state of the bisection in progress
+func (r *Repository) readBisectState() (*bisectState, error) {
+	if !r.IsBisecting() {
+		return nil, fmt.Errorf("not bisecting")
+	}
+	read := func(name string) ([]string, error) {
+		data, err := os.ReadFile(filepath.Join(r.Path, DefaultKitDir, name))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		return strings.Fields(string(data)), nil
+	}
+
+	start, err := read(bisectStartFile)
+	if err != nil {
+		return nil, err
+	}
+	bad, err := read(bisectBadFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(start) != 1 || len(bad) != 1 {
+		return nil, fmt.Errorf("corrupt bisect state")
+	}
+	state := &bisectState{start: start[0], bad: bad[0]}
+	if state.good, err = read(bisectGoodFile); err != nil {
+		return nil, err
+	}
+	if state.skip, err = read(bisectSkipFile); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// saveBisectState writes the state of the bisection
+func (r *Repository) saveBisectState(state *bisectState) error {
+	files := map[string][]string{
+		bisectStartFile: {state.start},
+		bisectBadFile:   {state.bad},
+		bisectGoodFile:  state.good,
+		bisectSkipFile:  state.skip,
+	}
+	for name, lines := range files {
+		content := ""
+		if len(lines) > 0 {
+			content = strings.Join(lines, "\n") + "\n"
+		}
+		if err := os.WriteFile(filepath.Join(r.Path, DefaultKitDir, name), []byte(content), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
+	return nil
+}
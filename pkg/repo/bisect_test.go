@@ -0,0 +1,152 @@
+package repo
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// linearHistory commits v.txt with contents 1 to n and returns the commit IDs
+func linearHistory(t *testing.T, repo *Repository, n int) []string {
+	t.Helper()
+	ids := []string{}
+	for i := 1; i <= n; i++ {
+		ids = append(ids, commitTestFiles(t, repo, fmt.Sprintf("v%d", i), map[string]string{"v.txt": fmt.Sprintf("%d\n", i)}))
+	}
+	return ids
+}
+
+func TestBisect(t *testing.T) {
+	repo := newTestRepository(t)
+	ids := linearHistory(t, repo, 8)
+
+	// The bug appears with v5
+	status, err := repo.BisectStart("HEAD", []string{ids[0]})
+	if err != nil {
+		t.Fatalf("Failed to start bisect: %v", err)
+	}
+	tested := 0
+	for !status.Done() {
+		tested++
+		if tested > 4 {
+			t.Fatalf("Too many steps, at %+v", status)
+		}
+		data, err := os.ReadFile(filepath.Join(repo.Path, "v.txt"))
+		if err != nil {
+			t.Fatalf("Failed to read v.txt: %v", err)
+		}
+		if head, _ := repo.resolveReference("HEAD"); head != status.Current {
+			t.Fatalf("Expected HEAD at %s, got %s", status.Current, head)
+		}
+		if strings.TrimSpace(string(data)) < "5" {
+			status, err = repo.BisectGood("")
+		} else {
+			status, err = repo.BisectBad("")
+		}
+		if err != nil {
+			t.Fatalf("Failed to mark commit: %v", err)
+		}
+	}
+	if status.FirstBad != ids[4] || status.Summary != "v5" {
+		t.Errorf("Expected v5 to be the first bad commit, got %+v", status)
+	}
+
+	if err := repo.BisectReset(); err != nil {
+		t.Fatalf("Failed to reset bisect: %v", err)
+	}
+	if branch, err := repo.GetCurrentBranch(); err != nil || branch != "main" {
+		t.Errorf("Expected to be back on main, got %q (%v)", branch, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(repo.Path, "v.txt")); string(data) != "8\n" {
+		t.Errorf("Expected v.txt from the branch, got %q", data)
+	}
+	if repo.IsBisecting() {
+		t.Errorf("Expected the bisect state to be removed")
+	}
+	if _, err := repo.BisectGood(""); err == nil {
+		t.Errorf("Expected marking to fail when not bisecting")
+	}
+}
+
+func TestBisectMerge(t *testing.T) {
+	repo := newTestRepository(t)
+	ids := mergeHistory(t, repo)
+	names := make(map[string]string)
+	for name, id := range ids {
+		names[id] = name
+	}
+
+	// The bug came in with c.txt on the feature branch
+	status, err := repo.BisectStart("main", []string{ids["A"]})
+	if err != nil {
+		t.Fatalf("Failed to start bisect: %v", err)
+	}
+	if status.Remaining != 4 {
+		t.Errorf("Expected B, C, D and E left to test, got %d", status.Remaining)
+	}
+	for i := 0; !status.Done(); i++ {
+		if i > 3 {
+			t.Fatalf("Too many steps, at %+v", status)
+		}
+		if _, err := os.Stat(filepath.Join(repo.Path, "c.txt")); err != nil {
+			status, err = repo.BisectGood("")
+		} else {
+			status, err = repo.BisectBad("")
+		}
+		if err != nil {
+			t.Fatalf("Failed to mark commit: %v", err)
+		}
+	}
+	if status.FirstBad != ids["C"] {
+		t.Errorf("Expected C to be the first bad commit, got %s", names[status.FirstBad])
+	}
+	if err := repo.BisectReset(); err != nil {
+		t.Fatalf("Failed to reset bisect: %v", err)
+	}
+}
+
+func TestBisectRun(t *testing.T) {
+	repo := newTestRepository(t)
+	ids := linearHistory(t, repo, 8)
+
+	if _, err := repo.BisectStart("HEAD", []string{ids[0]}); err != nil {
+		t.Fatalf("Failed to start bisect: %v", err)
+	}
+	status, err := repo.BisectRun(`test "$(cat v.txt)" -lt 6`, io.Discard)
+	if err != nil || status.FirstBad != ids[5] {
+		t.Errorf("Expected v6 to be the first bad commit, got %+v (%v)", status, err)
+	}
+	if err := repo.BisectReset(); err != nil {
+		t.Fatalf("Failed to reset bisect: %v", err)
+	}
+
+	// With v5 untestable, v5 and v6 can't be told apart
+	if _, err := repo.BisectStart("HEAD", []string{ids[0]}); err != nil {
+		t.Fatalf("Failed to start bisect: %v", err)
+	}
+	status, err = repo.BisectRun(`v=$(cat v.txt); [ "$v" = 5 ] && exit 125; test "$v" -lt 6`, io.Discard)
+	if err != nil {
+		t.Fatalf("Failed to run bisect: %v", err)
+	}
+	sort.Strings(status.Skipped)
+	want := []string{ids[4], ids[5]}
+	sort.Strings(want)
+	if strings.Join(status.Skipped, " ") != strings.Join(want, " ") {
+		t.Errorf("Expected v5 and v6 as possible first bad commits, got %+v", status)
+	}
+
+	// Codes from 128 stop the run
+	if err := repo.BisectReset(); err != nil {
+		t.Fatalf("Failed to reset bisect: %v", err)
+	}
+	if _, err := repo.BisectStart("HEAD", []string{ids[0]}); err != nil {
+		t.Fatalf("Failed to start bisect: %v", err)
+	}
+	if _, err := repo.BisectRun("exit 129", io.Discard); err == nil {
+		t.Errorf("Expected the run to stop")
+	}
+}
@@ -0,0 +1,491 @@
+package repo
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// BlameOptions represents options for attributing lines to commits
+type BlameOptions struct {
+	Moves  bool // Trace lines that were moved within the file (-M)
+	Copies bool // Also trace lines moved or copied from other files changed in the same commit (-C)
+}
+
+// BlameLine represents a line of a file and the commit that introduced it
+type BlameLine struct {
+	Line      int       // Line number in the blamed file (1-based)
+	Content   string    // Line content, without the line ending
+	Commit    string    // Commit that introduced the line
+	Path      string    // Path of the file in that commit
+	OrigLine  int       // Line number in that commit's file (1-based)
+	Author    string    // Author of the commit
+	Timestamp time.Time // Timestamp of the commit
+	Summary   string    // First line of the commit message
+}
+
+// minMoveChars is the number of letters and digits a block of lines needs
+// before -M/-C will trace it to another place, so that lines such as "}"
+// aren't attributed to whatever unrelated code they happen to match
+const minMoveChars = 20
+
+// minMoveSimilarity is the shingle similarity a block of lines needs with
+// the code it was moved from
+const minMoveSimilarity = 0.5
+
+// blameRef is a line of the blamed file, at a position in a suspect's file
+type blameRef struct {
+	final int // Index in the blamed file
+	index int // Index in the suspect's file
+}
+
+// Blame attributes each line of a file at a revision (HEAD if empty) to the
+// commit that introduced it. Lines are passed from a commit to its parents
+// wherever the diff between them leaves them unchanged, and a commit is
+// blamed for the lines that none of its parents had. A file missing from a
+// parent is looked for under the name it was renamed from. With Moves or
+// Copies, blocks of lines that are new in a commit are compared, by shingle
+// similarity, with the parent's version of the file or of the other files
+// changed in the commit, and passed on if the parent had them elsewhere.
+func (r *Repository) Blame(path, revision string, options *BlameOptions) ([]BlameLine, error) {
+	if options == nil {
+		options = &BlameOptions{}
+	}
+	if revision == "" {
+		revision = "HEAD"
+	}
+	commitID, err := r.ResolveRevision(revision)
+	if err != nil {
+		return nil, err
+	}
+	path = cleanPathspec(path)
+
+	b := &blamer{
+		repo:    r,
+		options: options,
+		walk:    &revWalk{repo: r, commits: make(map[string]*CommitLog), files: make(map[string]map[string]string)},
+		pending: make(map[string]map[string][]blameRef),
+		queued:  make(map[string]bool),
+		queue:   &commitQueue{},
+	}
+
+	lines, err := b.fileLines(commitID, path)
+	if err != nil {
+		return nil, err
+	}
+	if lines == nil {
+		return nil, fmt.Errorf("no such path %s in %s", path, revision)
+	}
+
+	b.result = make([]BlameLine, len(lines))
+	refs := make([]blameRef, len(lines))
+	for i, line := range lines {
+		b.result[i] = BlameLine{Line: i + 1, Content: line}
+		refs[i] = blameRef{final: i, index: i}
+	}
+	if err := b.pass(commitID, path, refs); err != nil {
+		return nil, err
+	}
+
+	// Newest commits first, so that a commit gets the lines of all its
+	// children before it is looked at
+	for b.queue.Len() > 0 {
+		commit := heap.Pop(b.queue).(*CommitLog)
+		delete(b.queued, commit.ID)
+		suspects := b.pending[commit.ID]
+		delete(b.pending, commit.ID)
+
+		paths := make([]string, 0, len(suspects))
+		for suspectPath := range suspects {
+			paths = append(paths, suspectPath)
+		}
+		sort.Strings(paths)
+		for _, suspectPath := range paths {
+			if err := b.blameSuspect(commit, suspectPath, suspects[suspectPath]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return b.result, nil
+}
+
+// blamer holds the state of a blame
+type blamer struct {
+	repo    *Repository
+	options *BlameOptions
+	walk    *revWalk                         // Reads and caches commits and their files
+	pending map[string]map[string][]blameRef // Lines waiting to be looked at, by commit and path
+	queued  map[string]bool                  // Commits in the queue
+	queue   *commitQueue                     // Commits with pending lines, newest first
+	result  []BlameLine
+}
+
+// pass hands lines over to a commit's version of a file
+func (b *blamer) pass(commitID, path string, refs []blameRef) error {
+	if len(refs) == 0 {
+		return nil
+	}
+	commit, err := b.walk.commit(commitID)
+	if err != nil {
+		return err
+	}
+	if b.pending[commitID] == nil {
+		b.pending[commitID] = make(map[string][]blameRef)
+	}
+	b.pending[commitID][path] = append(b.pending[commitID][path], refs...)
+	if !b.queued[commitID] {
+		b.queued[commitID] = true
+		heap.Push(b.queue, commit)
+	}
+	return nil
+}
+
+// blameSuspect passes the lines of a commit's file that its parents had to
+// them, and blames the commit for the rest
+func (b *blamer) blameSuspect(commit *CommitLog, path string, refs []blameRef) error {
+	lines, err := b.fileLines(commit.ID, path)
+	if err != nil {
+		return err
+	}
+
+	for _, parent := range commit.Parents {
+		if len(refs) == 0 {
+			return nil
+		}
+		parentPath, err := b.parentPath(commit.ID, parent, path)
+		if err != nil {
+			return err
+		}
+		if parentPath == "" {
+			continue
+		}
+		parentLines, err := b.fileLines(parent, parentPath)
+		if err != nil {
+			return err
+		}
+
+		// Lines the diff leaves unchanged come from the parent
+		unchanged := make(map[int]int)
+		for _, pair := range longestCommonSubsequence(lines, parentLines) {
+			unchanged[pair[0]] = pair[1]
+		}
+		passed, rest := []blameRef{}, []blameRef{}
+		for _, ref := range refs {
+			if index, ok := unchanged[ref.index]; ok {
+				passed = append(passed, blameRef{final: ref.final, index: index})
+			} else {
+				rest = append(rest, ref)
+			}
+		}
+		if err := b.pass(parent, parentPath, passed); err != nil {
+			return err
+		}
+		refs = rest
+	}
+
+	if (b.options.Moves || b.options.Copies) && len(refs) > 0 {
+		if refs, err = b.traceMoves(commit, path, lines, refs); err != nil {
+			return err
+		}
+	}
+
+	if len(refs) > 0 {
+		if err := b.walk.details(commit); err != nil {
+			return err
+		}
+	}
+	for _, ref := range refs {
+		line := &b.result[ref.final]
+		line.Commit = commit.ID
+		line.Path = path
+		line.OrigLine = ref.index + 1
+		line.Author = commit.Author
+		line.Timestamp = commit.Timestamp
+		line.Summary, _, _ = strings.Cut(commit.Message, "\n")
+	}
+	return nil
+}
+
+// traceMoves looks for blocks of new lines in the parents: in their version
+// of the file, and with Copies in the other files changed by the commit.
+// It returns the lines that weren't found.
+func (b *blamer) traceMoves(commit *CommitLog, path string, lines []string, refs []blameRef) ([]blameRef, error) {
+	for _, parent := range commit.Parents {
+		candidates, err := b.moveCandidates(commit.ID, parent, path)
+		if err != nil {
+			return nil, err
+		}
+
+		rest := []blameRef{}
+		for _, block := range blameBlocks(refs) {
+			text := blockText(lines, block)
+			if alphanumericCount(text) < minMoveChars {
+				rest = append(rest, block...)
+				continue
+			}
+
+			// Find the most similar place for the block among the candidates
+			bestPath, bestStart, bestScore := "", 0, minMoveSimilarity
+			var bestLines []string
+			for _, candidate := range candidates {
+				candidateLines, err := b.fileLines(parent, candidate)
+				if err != nil {
+					return nil, err
+				}
+				for _, start := range blockAnchors(lines, block, candidateLines) {
+					end := start + len(block)
+					if end > len(candidateLines) {
+						end = len(candidateLines)
+					}
+					window := strings.Join(candidateLines[start:end], "\n")
+					if score := b.repo.RetrievalKernel.EstimateSimilarity(text, window); score > bestScore {
+						bestPath, bestStart, bestScore, bestLines = candidate, start, score, candidateLines[start:end]
+					}
+				}
+			}
+			if bestPath == "" {
+				rest = append(rest, block...)
+				continue
+			}
+
+			// Lines of the block that are unchanged in that place came from it
+			blockLines := make([]string, len(block))
+			for i, ref := range block {
+				blockLines[i] = lines[ref.index]
+			}
+			unchanged := make(map[int]int)
+			for _, pair := range longestCommonSubsequence(blockLines, bestLines) {
+				unchanged[pair[0]] = bestStart + pair[1]
+			}
+			passed := []blameRef{}
+			for i, ref := range block {
+				if index, ok := unchanged[i]; ok {
+					passed = append(passed, blameRef{final: ref.final, index: index})
+				} else {
+					rest = append(rest, ref)
+				}
+			}
+			if err := b.pass(parent, bestPath, passed); err != nil {
+				return nil, err
+			}
+		}
+		refs = rest
+	}
+	return refs, nil
+}
+
+// moveCandidates returns the files of a parent that lines of a commit's
+// file may have been moved from
+func (b *blamer) moveCandidates(commitID, parent, path string) ([]string, error) {
+	candidates := []string{}
+	parentPath, err := b.parentPath(commitID, parent, path)
+	if err != nil {
+		return nil, err
+	}
+	if parentPath != "" {
+		candidates = append(candidates, parentPath)
+	}
+	if !b.options.Copies {
+		return candidates, nil
+	}
+
+	files, err := b.walk.commitFiles(commitID)
+	if err != nil {
+		return nil, err
+	}
+	parentFiles, err := b.walk.commitFiles(parent)
+	if err != nil {
+		return nil, err
+	}
+	others := []string{}
+	for candidate, objID := range parentFiles {
+		if candidate != parentPath && files[candidate] != objID {
+			others = append(others, candidate)
+		}
+	}
+	sort.Strings(others)
+	return append(candidates, others...), nil
+}
+
+// parentPath returns the name of a commit's file in a parent, following a
+// rename, or "" if the parent doesn't have it
+func (b *blamer) parentPath(commitID, parent, path string) (string, error) {
+	parentFiles, err := b.walk.commitFiles(parent)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := parentFiles[path]; ok {
+		return path, nil
+	}
+	files, err := b.walk.commitFiles(commitID)
+	if err != nil {
+		return "", err
+	}
+	return followRenameSource(b.repo, parentFiles, files, path), nil
+}
+
+// fileLines returns the lines of a file in a commit, or nil if it doesn't have it
+func (b *blamer) fileLines(commitID, path string) ([]string, error) {
+	files, err := b.walk.commitFiles(commitID)
+	if err != nil {
+		return nil, err
+	}
+	objID, ok := files[path]
+	if !ok {
+		return nil, nil
+	}
+	content, err := b.repo.readObject(objID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	lines := strings.Split(string(content), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines, nil
+}
+
+// blameBlocks groups lines into blocks of consecutive lines
+func blameBlocks(refs []blameRef) [][]blameRef {
+	sorted := append([]blameRef{}, refs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].index < sorted[j].index })
+
+	blocks := [][]blameRef{}
+	for i, ref := range sorted {
+		if i > 0 && ref.index == sorted[i-1].index+1 {
+			blocks[len(blocks)-1] = append(blocks[len(blocks)-1], ref)
+		} else {
+			blocks = append(blocks, []blameRef{ref})
+		}
+	}
+	return blocks
+}
+
+// blockText returns the text of a block of lines
+func blockText(lines []string, block []blameRef) string {
+	text := make([]string, len(block))
+	for i, ref := range block {
+		text[i] = lines[ref.index]
+	}
+	return strings.Join(text, "\n")
+}
+
+// blockAnchors returns the positions in a file where a block of lines could
+// start, going by the non-blank lines they have in common
+func blockAnchors(lines []string, block []blameRef, candidateLines []string) []int {
+	positions := make(map[string][]int)
+	for i, line := range candidateLines {
+		if strings.TrimSpace(line) != "" {
+			positions[line] = append(positions[line], i)
+		}
+	}
+
+	seen := make(map[int]bool)
+	starts := []int{}
+	for offset, ref := range block {
+		for _, i := range positions[lines[ref.index]] {
+			start := i - offset
+			if start < 0 {
+				start = 0
+			}
+			if !seen[start] {
+				seen[start] = true
+				starts = append(starts, start)
+			}
+		}
+	}
+	sort.Ints(starts)
+	return starts
+}
+
+// alphanumericCount returns the number of letters and digits in a text
+func alphanumericCount(text string) int {
+	count := 0
+	for _, c := range text {
+		if unicode.IsLetter(c) || unicode.IsDigit(c) {
+			count++
+		}
+	}
+	return count
+}
+
+// FormatBlame formats blamed lines for display: the abbreviated commit,
+// the original path if any line comes from another file, the author name,
+// the date and the line number, followed by the line
+func FormatBlame(lines []BlameLine) string {
+	showPaths := false
+	for _, line := range lines {
+		if line.Path != lines[0].Path {
+			showPaths = true
+		}
+	}
+	pathWidth, numberWidth := 0, len(fmt.Sprint(len(lines)))
+	for _, line := range lines {
+		if len(line.Path) > pathWidth {
+			pathWidth = len(line.Path)
+		}
+	}
+
+	var sb strings.Builder
+	for _, line := range lines {
+		name, _ := splitAuthor(line.Author)
+		sb.WriteString(shortID(line.Commit))
+		if showPaths {
+			sb.WriteString(fmt.Sprintf(" %-*s", pathWidth, line.Path))
+		}
+		sb.WriteString(fmt.Sprintf(" (%s %s %*d) %s\n", name, line.Timestamp.Format("2006-01-02 15:04:05 -0700"), numberWidth, line.Line, line.Content))
+	}
+	return sb.String()
+}
+
+// FormatBlamePorcelain formats blamed lines for tools. Each group of
+// consecutive lines from the same commit starts with "<commit> <orig line>
+// <line> <lines in group>", other lines with "<commit> <orig line> <line>".
+// The first line from a commit is followed by "author", "author-mail",
+// "author-time", "author-tz" and "summary" headers; every line is followed
+// by a "filename" header and then the line's content after a tab.
+func FormatBlamePorcelain(lines []BlameLine) string {
+	var sb strings.Builder
+	described := make(map[string]bool)
+	for i, line := range lines {
+		header := fmt.Sprintf("%s %d %d", line.Commit, line.OrigLine, line.Line)
+		if i == 0 || !sameBlameGroup(lines[i-1], line) {
+			count := 1
+			for j := i + 1; j < len(lines) && sameBlameGroup(lines[j-1], lines[j]); j++ {
+				count++
+			}
+			header += fmt.Sprintf(" %d", count)
+		}
+		sb.WriteString(header + "\n")
+
+		if !described[line.Commit] {
+			described[line.Commit] = true
+			name, email := splitAuthor(line.Author)
+			sb.WriteString("author " + name + "\n")
+			sb.WriteString("author-mail <" + email + ">\n")
+			sb.WriteString(fmt.Sprintf("author-time %d\n", line.Timestamp.Unix()))
+			sb.WriteString("author-tz " + line.Timestamp.Format("-0700") + "\n")
+			sb.WriteString("summary " + line.Summary + "\n")
+		}
+		sb.WriteString("filename " + line.Path + "\n")
+		sb.WriteString("\t" + line.Content + "\n")
+	}
+	return sb.String()
+}
+
+// sameBlameGroup reports whether a blamed line continues the previous one's group
+func sameBlameGroup(previous, line BlameLine) bool {
+	return line.Commit == previous.Commit && line.Path == previous.Path && line.OrigLine == previous.OrigLine+1
+}
+
+// splitAuthor splits "Name <email>" into the name and the email
+func splitAuthor(author string) (string, string) {
+	if i := strings.Index(author, " <"); i >= 0 && strings.HasSuffix(author, ">") {
+		return author[:i], author[i+2 : len(author)-1]
+	}
+	return author, ""
+}
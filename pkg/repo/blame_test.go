@@ -0,0 +1,106 @@
+package repo
+
+import (
+	"strings"
+	"testing"
+)
+
+// blameCommits returns the commit and path each line is blamed on, by name
+func blameCommits(t *testing.T, repo *Repository, path string, options *BlameOptions, names map[string]string) string {
+	t.Helper()
+	lines, err := repo.Blame(path, "", options)
+	if err != nil {
+		t.Fatalf("Failed to blame %s: %v", path, err)
+	}
+	got := []string{}
+	for _, line := range lines {
+		got = append(got, names[line.Commit]+":"+line.Path)
+	}
+	return strings.Join(got, " ")
+}
+
+func TestBlame(t *testing.T) {
+	repo := newTestRepository(t)
+	names := map[string]string{}
+	names[commitTestFiles(t, repo, "First", map[string]string{"a.txt": "one\ntwo\nthree\n"})] = "1"
+	names[commitTestFiles(t, repo, "Second", map[string]string{"a.txt": "one\nTWO\nthree\n"})] = "2"
+	names[commitTestFiles(t, repo, "Third", map[string]string{"a.txt": "one\nTWO\nthree\nfour\n"})] = "3"
+
+	if got := blameCommits(t, repo, "a.txt", nil, names); got != "1:a.txt 2:a.txt 1:a.txt 3:a.txt" {
+		t.Errorf("Unexpected blame: %s", got)
+	}
+
+	lines, err := repo.Blame("a.txt", "HEAD~1", nil)
+	if err != nil {
+		t.Fatalf("Failed to blame: %v", err)
+	}
+	if len(lines) != 3 || lines[1].Summary != "Second" || lines[2].OrigLine != 3 {
+		t.Errorf("Unexpected blame at HEAD~1: %+v", lines)
+	}
+
+	porcelain := FormatBlamePorcelain(lines)
+	for _, expected := range []string{
+		lines[0].Commit + " 1 1 1\nauthor Kit User\nauthor-mail <kit@example.com>\n",
+		"summary First\nfilename a.txt\n\tone\n",
+		lines[1].Commit + " 2 2 1\n",
+		lines[2].Commit + " 3 3 1\nfilename a.txt\n\tthree\n",
+	} {
+		if !strings.Contains(porcelain, expected) {
+			t.Errorf("Expected porcelain blame to contain %q, got:\n%s", expected, porcelain)
+		}
+	}
+
+	if _, err := repo.Blame("missing.txt", "", nil); err == nil {
+		t.Error("Expected blaming a missing file to fail")
+	}
+}
+
+func TestBlameMerge(t *testing.T) {
+	repo := newTestRepository(t)
+	ids := mergeHistory(t, repo)
+	names := make(map[string]string)
+	for name, id := range ids {
+		names[id] = name
+	}
+
+	if got := blameCommits(t, repo, "c.txt", nil, names); got != "E:c.txt" {
+		t.Errorf("Expected c.txt to be blamed on E through the merge, got %s", got)
+	}
+	if got := blameCommits(t, repo, "a.txt", nil, names); got != "B:a.txt" {
+		t.Errorf("Expected a.txt to be blamed on B, got %s", got)
+	}
+}
+
+func TestBlameMovesAndCopies(t *testing.T) {
+	repo := newTestRepository(t)
+	first := "func first() {\n\treturn computeFirstValue()\n}\n"
+	second := "func second() {\n\treturn computeSecondValue()\n}\n"
+	third := "func third() {\n\treturn computeThirdValue()\n}\n"
+
+	names := map[string]string{}
+	names[commitTestFiles(t, repo, "Add functions", map[string]string{
+		"a.go": first + "\n" + second,
+		"b.go": third,
+	})] = "1"
+	// Swap the functions in a.go and move third to it from b.go
+	names[commitTestFiles(t, repo, "Reorganise", map[string]string{
+		"a.go": second + "\n" + first + "\n" + third,
+		"b.go": "// empty\n",
+	})] = "2"
+
+	if got := blameCommits(t, repo, "a.go", nil, names); strings.Count(got, "2:a.go") < 6 {
+		t.Errorf("Expected moved lines to be blamed on the commit moving them without -M, got %s", got)
+	}
+
+	// The closing brace of third matches one in the old a.go, so only the
+	// rest of third is new
+	expected := "1:a.go 1:a.go 1:a.go 2:a.go 1:a.go 1:a.go 1:a.go 1:a.go 2:a.go 2:a.go 1:a.go"
+	if got := blameCommits(t, repo, "a.go", &BlameOptions{Moves: true}, names); got != expected {
+		t.Errorf("Expected lines moved within the file to be traced with -M:\nexpected %s\ngot      %s", expected, got)
+	}
+
+	expected = "1:a.go 1:a.go 1:a.go 2:a.go 1:a.go 1:a.go 1:a.go 1:a.go 1:b.go 1:b.go 1:a.go"
+	if got := blameCommits(t, repo, "a.go", &BlameOptions{Moves: true, Copies: true}, names); got != expected {
+		t.Errorf("Expected lines moved from another file to be traced with -C:\nexpected %s\ngot      %s", expected, got)
+	}
+}
@@ -1,7 +1,6 @@
 package repo
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -119,128 +118,8 @@ func (r *Repository) CreateBranch(name string) error {
 
 // CheckoutBranch switches to a different branch
 func (r *Repository) CheckoutBranch(name string) error {
-	// Check if branch exists
-	branchPath := filepath.Join(r.Path, DefaultKitDir, DefaultKitRefsDir, "heads", name)
-	if _, err := os.Stat(branchPath); os.IsNotExist(err) {
-		return fmt.Errorf("branch '%s' does not exist", name)
-	}
-
-	// Check for uncommitted changes
-	if len(r.State.Stage) > 0 {
-		return fmt.Errorf("you have uncommitted changes, please commit or stash them before switching branches")
-	}
-
-	// Get current branch tracking state for comparison
-	oldTracked := make(map[string]string)
-	for k, v := range r.State.Tracked {
-		oldTracked[k] = v
-	}
-
-	// Get commit ID for the target branch
-	targetCommitID, err := r.resolveReference(fmt.Sprintf("refs/heads/%s", name))
-	if err != nil {
-		return fmt.Errorf("failed to resolve branch reference: %w", err)
-	}
-
-	// Read tree object for the target commit
-	commitData, err := r.readObject(targetCommitID)
-	if err != nil {
-		return fmt.Errorf("failed to read commit object: %w", err)
-	}
-
-	// Unmarshal commit object
-	var commit CommitObject
-	if err := json.Unmarshal(commitData, &commit); err != nil {
-		return fmt.Errorf("failed to unmarshal commit: %w", err)
-	}
-
-	// Read tree object for the commit
-	treeData, err := r.readObject(commit.Tree)
-	if err != nil {
-		return fmt.Errorf("failed to read tree object: %w", err)
-	}
-
-	// Unmarshal tree object
-	var tree TreeObject
-	if err := json.Unmarshal(treeData, &tree); err != nil {
-		return fmt.Errorf("failed to unmarshal tree: %w", err)
-	}
-
-	// Create a map to keep track of files to remove (files in old branch but not in new branch)
-	filesToRemove := make(map[string]bool)
-	for path := range oldTracked {
-		filesToRemove[path] = true
-	}
-
-	// Reset tracked files
-	r.State.Tracked = make(map[string]string)
-
-	// Update working directory to match the branch content
-	for path, entry := range tree.Entries {
-		// Remove from filesToRemove since it's in the new branch
-		delete(filesToRemove, path)
-
-		// Track this file
-		r.State.Tracked[path] = entry.ObjID
-
-		// Get the object content
-		objectData, err := r.readObject(entry.ObjID)
-		if err != nil {
-			return fmt.Errorf("failed to read object %s: %w", entry.ObjID, err)
-		}
-
-		// Get the absolute file path
-		filePath := filepath.Join(r.Path, path)
-
-		// Ensure parent directories exist
-		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
-			return fmt.Errorf("failed to create directory %s: %w", filepath.Dir(filePath), err)
-		}
-
-		// Write the file
-		if err := os.WriteFile(filePath, objectData, 0644); err != nil {
-			return fmt.Errorf("failed to write file %s: %w", filePath, err)
-		}
-
-		// Update working tree
-		fileInfo, err := os.Stat(filePath)
-		if err != nil {
-			return fmt.Errorf("failed to get file info for %s: %w", filePath, err)
-		}
-
-		r.State.WorkTree[path] = WorkTreeEntry{
-			Path:    path,
-			Size:    fileInfo.Size(),
-			ModTime: fileInfo.ModTime(),
-			Hash:    entry.ObjID,
-		}
-	}
-
-	// Remove files from the old branch that aren't in the new branch
-	for path := range filesToRemove {
-		// For now, we just remove it from tracking but don't delete the files
-		// This matches Git's behavior - files stay in the working directory but become untracked
-		delete(r.State.WorkTree, path)
-	}
-
-	// Clear staging area - after checkout, nothing is staged
-	r.State.Stage = make(map[string]string)
-
-	// Update HEAD to point to the branch
-	headPath := filepath.Join(r.Path, DefaultKitDir, DefaultKitHeadFile)
-	if err := os.WriteFile(headPath, []byte(fmt.Sprintf("ref: refs/heads/%s\n", name)), 0644); err != nil {
-		return fmt.Errorf("failed to update HEAD reference: %w", err)
-	}
-
-	// Update repository state
-	r.State.HEAD = fmt.Sprintf("refs/heads/%s", name)
-
-	// Save the updated index
-	if err := r.SaveIndex(); err != nil {
-		return fmt.Errorf("failed to save index after checkout: %w", err)
-	}
-
-	return nil
+	_, err := r.CheckoutBranchWithOptions(name, nil)
+	return err
 }
 
 // GetCurrentBranch returns the name of the current branch
@@ -0,0 +1,398 @@
+package repo
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// CheckoutOptions represents options for switching branches
+type CheckoutOptions struct {
+	Force bool // Discard local changes and untracked files that are in the way
+	Merge bool // Carry local changes over with a three-way merge
+}
+
+// CheckoutError lists the paths that prevent a checkout
+type CheckoutError struct {
+	Modified  []string // Files whose local changes would be overwritten
+	Untracked []string // Untracked files that would be overwritten
+}
+
+// Error formats the blocking paths, one per line
+func (e *CheckoutError) Error() string {
+	var sb strings.Builder
+	if len(e.Modified) > 0 {
+		sb.WriteString("your local changes to the following files would be overwritten by checkout:\n")
+		for _, path := range e.Modified {
+			sb.WriteString("\t" + path + "\n")
+		}
+	}
+	if len(e.Untracked) > 0 {
+		sb.WriteString("the following untracked working tree files would be overwritten by checkout:\n")
+		for _, path := range e.Untracked {
+			sb.WriteString("\t" + path + "\n")
+		}
+	}
+	sb.WriteString("please commit or stash your changes before switching branches")
+	return sb.String()
+}
+
+// CheckoutBranchWithOptions switches to a different branch with a two-tree
+// checkout from HEAD to the branch. Files that differ between the two are
+// written or deleted, and local changes to all other files are carried
+// over. The checkout is refused with a CheckoutError if it would overwrite
+// local changes or untracked files, unless options.Force discards them or
+// options.Merge merges local changes into the branch's version. Files that
+// could not be merged cleanly are written with conflict markers and returned.
+func (r *Repository) CheckoutBranchWithOptions(name string, options *CheckoutOptions) ([]MergeConflict, error) {
+	if options == nil {
+		options = &CheckoutOptions{}
+	}
+
+	if len(r.State.Conflicts) > 0 && !options.Force {
+		return nil, fmt.Errorf("cannot switch branches with unresolved conflicts in %s", strings.Join(r.conflictPaths(), ", "))
+	}
+
+	// Check if branch exists
+	branchRef := fmt.Sprintf("refs/heads/%s", name)
+	if _, err := os.Stat(r.refPath(branchRef)); os.IsNotExist(err) {
+		return nil, fmt.Errorf("branch '%s' does not exist", name)
+	}
+
+	// Get commit ID for the target branch
+	targetCommitID, err := r.resolveReference(branchRef)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve branch reference: %w", err)
+	}
+	return r.checkoutCommit(targetCommitID, branchRef, name, "", options)
+}
+
+// CheckoutDetached checks out a revision without a branch, leaving HEAD
+// pointing directly at the commit. Local changes are handled like by
+// CheckoutBranchWithOptions.
+func (r *Repository) CheckoutDetached(rev string, options *CheckoutOptions) ([]MergeConflict, error) {
+	if options == nil {
+		options = &CheckoutOptions{}
+	}
+
+	if len(r.State.Conflicts) > 0 && !options.Force {
+		return nil, fmt.Errorf("cannot switch branches with unresolved conflicts in %s", strings.Join(r.conflictPaths(), ", "))
+	}
+
+	targetCommitID, err := r.ResolveRevision(rev)
+	if err != nil {
+		return nil, err
+	}
+	return r.checkoutCommit(targetCommitID, "HEAD", rev, "", options)
+}
+
+// checkoutCommit switches the index and working tree to a commit and points
+// HEAD at ref: a branch, or "HEAD" to detach it at the commit. name is what
+// the reflog calls the target, unless a reflog message is given.
+func (r *Repository) checkoutCommit(targetCommitID, ref, name, message string, options *CheckoutOptions) ([]MergeConflict, error) {
+	tree, err := r.getTreeFromCommit(targetCommitID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tree for '%s': %w", name, err)
+	}
+	target := treeBlobs(tree)
+
+	plan, err := r.planCheckout(target, options)
+	if err != nil {
+		return nil, err
+	}
+
+	// Merge local changes while the base and local versions are still readable
+	conflicts := []MergeConflict{}
+	merged := make(map[string][]byte, len(plan.merge))
+	for _, path := range plan.merge {
+		content, conflict, err := r.mergeLocalChanges(path, target[path])
+		if err != nil {
+			return nil, err
+		}
+		if conflict != nil {
+			conflicts = append(conflicts, *conflict)
+			continue
+		}
+		merged[path] = content
+	}
+
+	// Update the working tree, then write the merge results over it
+	if err := r.materializeTree(plan.remove, plan.write); err != nil {
+		return nil, fmt.Errorf("failed to update working tree: %w", err)
+	}
+	for _, path := range plan.merge {
+		content, ok := merged[path]
+		if !ok {
+			continue
+		}
+		objID := hashContent(content)
+		if err := r.storeObject(objID, content); err != nil {
+			return nil, fmt.Errorf("failed to store merged %s: %w", path, err)
+		}
+		if err := r.writeWorkingFile(path, objID); err != nil {
+			return nil, err
+		}
+	}
+	if err := r.WriteConflictMarkers(conflicts); err != nil {
+		return nil, fmt.Errorf("failed to write conflict markers: %w", err)
+	}
+
+	oldCommitID, _ := r.resolveReference("HEAD")
+	oldBranch, err := r.GetCurrentBranch()
+	if err != nil {
+		oldBranch = oldCommitID
+	}
+
+	// Remember the conflicting versions until the files are staged; the
+	// local version is ours and the branch's is theirs
+	entries := make(map[string]ConflictEntry, len(conflicts))
+	for _, conflict := range conflicts {
+		ours := []byte(conflict.OurContent)
+		oursID := hashContent(ours)
+		if err := r.storeObject(oursID, ours); err != nil {
+			return nil, fmt.Errorf("failed to store local version of %s: %w", conflict.Path, err)
+		}
+		entries[conflict.Path] = ConflictEntry{
+			Base:   r.State.Tracked[conflict.Path],
+			Ours:   oursID,
+			Theirs: target[conflict.Path],
+		}
+	}
+
+	// Track the branch's files, keeping carried over staged changes
+	r.State.Tracked = target
+	r.setIndexEntries(plan.index)
+	r.State.Conflicts = entries
+
+	// Update HEAD to point to the branch, or to the commit itself
+	head := fmt.Sprintf("ref: %s\n", ref)
+	if ref == "HEAD" {
+		head = targetCommitID
+	}
+	headPath := filepath.Join(r.Path, DefaultKitDir, DefaultKitHeadFile)
+	if err := os.WriteFile(headPath, []byte(head), 0644); err != nil {
+		return nil, fmt.Errorf("failed to update HEAD reference: %w", err)
+	}
+	r.State.HEAD = ref
+
+	if message == "" {
+		message = fmt.Sprintf("checkout: moving from %s to %s", oldBranch, name)
+	}
+	if err := r.appendReflog("HEAD", oldCommitID, targetCommitID, message); err != nil {
+		return nil, err
+	}
+
+	// Save the updated index
+	if err := r.SaveIndex(); err != nil {
+		return nil, fmt.Errorf("failed to save index after checkout: %w", err)
+	}
+
+	return conflicts, nil
+}
+
+// checkoutPlan describes how a checkout changes the index and working tree
+type checkoutPlan struct {
+	index  map[string]string // Full index after the checkout
+	write  map[string]string // Working tree files to write (path -> blob ID)
+	remove map[string]string // Working tree files to delete
+	merge  []string          // Files whose local changes are merged into the target version
+}
+
+// planCheckout compares HEAD, the index and the working tree with the target
+// files and decides what to do with every path that differs between HEAD
+// and the target. Paths that don't are left as they are, except with
+// options.Force, which resets everything to the target.
+func (r *Repository) planCheckout(target map[string]string, options *CheckoutOptions) (*checkoutPlan, error) {
+	head := r.State.Tracked
+	index := r.indexEntries()
+
+	plan := &checkoutPlan{
+		index:  make(map[string]string, len(index)),
+		write:  make(map[string]string),
+		remove: make(map[string]string),
+	}
+	for path, objID := range index {
+		plan.index[path] = objID
+	}
+
+	matcher, err := r.NewIgnoreMatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make(map[string]bool, len(head)+len(target))
+	for _, files := range []map[string]string{head, target, index} {
+		for path := range files {
+			paths[path] = true
+		}
+	}
+	sorted := make([]string, 0, len(paths))
+	for path := range paths {
+		sorted = append(sorted, path)
+	}
+	sort.Strings(sorted)
+
+	blocked := &CheckoutError{}
+	for _, path := range sorted {
+		h, inHead := head[path]
+		m, inTarget := target[path]
+		i, inIndex := index[path]
+
+		// Local changes to paths the checkout doesn't touch are carried over
+		if h == m && !options.Force {
+			continue
+		}
+
+		// Files outside a sparse checkout stand for their index entry
+		w := i
+		if !r.State.SkipWorktree[path] {
+			w, err = r.workingFileID(path)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if i == m && w == m {
+			continue
+		}
+
+		if i != h || w != h {
+			switch {
+			case options.Force:
+			case !inHead && !inIndex:
+				// Ignored files are expendable, other untracked files are not
+				ignored, _, err := matcher.IsIgnored(path, false)
+				if err != nil {
+					return nil, err
+				}
+				if !ignored {
+					blocked.Untracked = append(blocked.Untracked, path)
+					continue
+				}
+			case options.Merge && w != "":
+				plan.merge = append(plan.merge, path)
+				setOrDelete(plan.index, path, m, inTarget)
+				continue
+			default:
+				blocked.Modified = append(blocked.Modified, path)
+				continue
+			}
+		}
+
+		setOrDelete(plan.index, path, m, inTarget)
+		if inTarget {
+			plan.write[path] = m
+		} else {
+			plan.remove[path] = h
+		}
+	}
+
+	// New files must not replace untracked files or directories in their way
+	for path := range plan.write {
+		if _, inHead := head[path]; inHead {
+			continue
+		}
+		for _, blocker := range r.checkoutBlockers(path, plan.remove) {
+			if options.Force {
+				plan.remove[blocker] = ""
+			} else {
+				blocked.Untracked = append(blocked.Untracked, blocker)
+			}
+		}
+	}
+
+	if len(blocked.Modified) > 0 || len(blocked.Untracked) > 0 {
+		sort.Strings(blocked.Untracked)
+		return nil, blocked
+	}
+
+	return plan, nil
+}
+
+// checkoutBlockers returns the files that prevent writing a new file at
+// path: a file where one of its parent directories has to be, or files
+// inside a directory where it has to be. Files about to be removed don't count.
+func (r *Repository) checkoutBlockers(path string, removed map[string]string) []string {
+	var blockers []string
+
+	for dir := filepath.Dir(path); dir != "."; dir = filepath.Dir(dir) {
+		info, err := os.Lstat(filepath.Join(r.Path, dir))
+		if err != nil || info.IsDir() {
+			continue
+		}
+		if _, ok := removed[dir]; !ok {
+			blockers = append(blockers, dir)
+		}
+	}
+
+	root := filepath.Join(r.Path, path)
+	if info, err := os.Lstat(root); err == nil && info.IsDir() {
+		filepath.WalkDir(root, func(file string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			relPath, err := filepath.Rel(r.Path, file)
+			if err != nil {
+				return nil
+			}
+			if _, ok := removed[relPath]; !ok {
+				blockers = append(blockers, relPath)
+			}
+			return nil
+		})
+	}
+
+	return blockers
+}
+
+// mergeLocalChanges merges the local version of a file into the target
+// version, using the HEAD version as the base. It returns the merged
+// content, or a conflict if the changes overlap.
+func (r *Repository) mergeLocalChanges(path, targetID string) ([]byte, *MergeConflict, error) {
+	readBlob := func(objID string) (string, error) {
+		if objID == "" {
+			return "", nil
+		}
+		content, err := r.readObject(objID)
+		return string(content), err
+	}
+
+	base, err := readBlob(r.State.Tracked[path])
+	if err != nil {
+		return nil, nil, err
+	}
+	theirs, err := readBlob(targetID)
+	if err != nil {
+		return nil, nil, err
+	}
+	ours, err := r.readWorkingFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read file %s: %w", path, err)
+	}
+
+	content, conflict, err := r.mergeBlobs(path, []byte(base), ours, []byte(theirs), &MergeOptions{Strategy: Manual})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to merge %s: %w", path, err)
+	}
+	if conflict {
+		return nil, &MergeConflict{
+			Path:         path,
+			OurContent:   string(ours),
+			TheirContent: theirs,
+			BaseContent:  base,
+		}, nil
+	}
+
+	return []byte(content), nil, nil
+}
+
+// setOrDelete sets a map entry, or deletes it if present is false
+func setOrDelete(entries map[string]string, path, objID string, present bool) {
+	if present {
+		entries[path] = objID
+	} else {
+		delete(entries, path)
+	}
+}
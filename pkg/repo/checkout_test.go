@@ -0,0 +1,177 @@
+package repo
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setupCheckoutBranches creates a main branch and a feature branch that
+// changes a.txt and adds c.txt, leaving main checked out
+func setupCheckoutBranches(t *testing.T) *Repository {
+	t.Helper()
+
+	repo := newTestRepository(t)
+	commitTestFiles(t, repo, "Initial commit", map[string]string{
+		"a.txt": "one\ntwo\nthree\n",
+		"b.txt": "unchanged\n",
+	})
+
+	if err := repo.CreateBranch("feature"); err != nil {
+		t.Fatalf("Failed to create branch: %v", err)
+	}
+	if err := repo.CheckoutBranch("feature"); err != nil {
+		t.Fatalf("Failed to checkout feature: %v", err)
+	}
+	commitTestFiles(t, repo, "Feature", map[string]string{
+		"a.txt":     "one\ntwo\nTHREE\n",
+		"dir/c.txt": "new\n",
+	})
+	if err := repo.CheckoutBranch("main"); err != nil {
+		t.Fatalf("Failed to checkout main: %v", err)
+	}
+
+	return repo
+}
+
+func TestCheckoutRemovesStaleFiles(t *testing.T) {
+	repo := setupCheckoutBranches(t)
+
+	if _, err := os.Stat(filepath.Join(repo.Path, "dir")); !os.IsNotExist(err) {
+		t.Error("Expected files only on the feature branch to be removed")
+	}
+	if content := readTestFile(t, repo, "a.txt"); content != "one\ntwo\nthree\n" {
+		t.Errorf("Expected a.txt from main, got %q", content)
+	}
+
+	status, err := repo.Status()
+	if err != nil {
+		t.Fatalf("Failed to get status: %v", err)
+	}
+	if !strings.Contains(status, "working tree clean") {
+		t.Errorf("Expected clean working tree, got:\n%s", status)
+	}
+}
+
+func TestCheckoutProtectsLocalChanges(t *testing.T) {
+	repo := setupCheckoutBranches(t)
+	writeTestFile(t, repo, "a.txt", "local\n")
+	writeTestFile(t, repo, "dir/c.txt", "untracked\n")
+
+	err := repo.CheckoutBranch("feature")
+	var checkoutErr *CheckoutError
+	if !errors.As(err, &checkoutErr) {
+		t.Fatalf("Expected CheckoutError, got %v", err)
+	}
+	if strings.Join(checkoutErr.Modified, ",") != "a.txt" {
+		t.Errorf("Expected a.txt to be reported as modified, got %v", checkoutErr.Modified)
+	}
+	if strings.Join(checkoutErr.Untracked, ",") != "dir/c.txt" {
+		t.Errorf("Expected dir/c.txt to be reported as untracked, got %v", checkoutErr.Untracked)
+	}
+
+	// Nothing may change when the checkout is refused
+	if content := readTestFile(t, repo, "a.txt"); content != "local\n" {
+		t.Errorf("Expected local change to be kept, got %q", content)
+	}
+	if branch, _ := repo.GetCurrentBranch(); branch != "main" {
+		t.Errorf("Expected to stay on main, got %s", branch)
+	}
+}
+
+func TestCheckoutCarriesUnrelatedChanges(t *testing.T) {
+	repo := setupCheckoutBranches(t)
+	writeTestFile(t, repo, "b.txt", "edited\n")
+
+	if err := repo.CheckoutBranch("feature"); err != nil {
+		t.Fatalf("Failed to checkout: %v", err)
+	}
+	if content := readTestFile(t, repo, "b.txt"); content != "edited\n" {
+		t.Errorf("Expected local change to be carried over, got %q", content)
+	}
+	if content := readTestFile(t, repo, "dir/c.txt"); content != "new\n" {
+		t.Errorf("Expected dir/c.txt from feature, got %q", content)
+	}
+}
+
+func TestCheckoutForce(t *testing.T) {
+	repo := setupCheckoutBranches(t)
+	writeTestFile(t, repo, "a.txt", "local\n")
+	writeTestFile(t, repo, "b.txt", "edited\n")
+	writeTestFile(t, repo, "dir/c.txt", "untracked\n")
+
+	if _, err := repo.CheckoutBranchWithOptions("feature", &CheckoutOptions{Force: true}); err != nil {
+		t.Fatalf("Failed to force checkout: %v", err)
+	}
+	expected := map[string]string{
+		"a.txt":     "one\ntwo\nTHREE\n",
+		"b.txt":     "unchanged\n",
+		"dir/c.txt": "new\n",
+	}
+	for path, want := range expected {
+		if content := readTestFile(t, repo, path); content != want {
+			t.Errorf("Expected %s to be %q, got %q", path, want, content)
+		}
+	}
+}
+
+func TestCheckoutMerge(t *testing.T) {
+	repo := setupCheckoutBranches(t)
+	writeTestFile(t, repo, "a.txt", "ONE\ntwo\nthree\n")
+
+	conflicts, err := repo.CheckoutBranchWithOptions("feature", &CheckoutOptions{Merge: true})
+	if err != nil {
+		t.Fatalf("Failed to checkout with merge: %v", err)
+	}
+	if len(conflicts) != 0 {
+		t.Fatalf("Expected no conflicts, got %v", conflicts)
+	}
+	if content := readTestFile(t, repo, "a.txt"); content != "ONE\ntwo\nTHREE\n" {
+		t.Errorf("Expected local change merged into feature's version, got %q", content)
+	}
+	if repo.State.Tracked["a.txt"] != hashContent([]byte("one\ntwo\nTHREE\n")) {
+		t.Error("Expected feature's version to be tracked")
+	}
+}
+
+func TestCheckoutMergeConflict(t *testing.T) {
+	repo := setupCheckoutBranches(t)
+	writeTestFile(t, repo, "a.txt", "one\ntwo\nlocal\n")
+
+	conflicts, err := repo.CheckoutBranchWithOptions("feature", &CheckoutOptions{Merge: true})
+	if err != nil {
+		t.Fatalf("Failed to checkout with merge: %v", err)
+	}
+	if len(conflicts) != 1 || conflicts[0].Path != "a.txt" {
+		t.Fatalf("Expected a conflict in a.txt, got %v", conflicts)
+	}
+	if content := readTestFile(t, repo, "a.txt"); !strings.Contains(content, "<<<<<<<") {
+		t.Errorf("Expected conflict markers in a.txt, got %q", content)
+	}
+	want := ConflictEntry{
+		Base:   hashContent([]byte("one\ntwo\nthree\n")),
+		Ours:   hashContent([]byte("one\ntwo\nlocal\n")),
+		Theirs: hashContent([]byte("one\ntwo\nTHREE\n")),
+	}
+	if got := repo.State.Conflicts["a.txt"]; got != want {
+		t.Errorf("Expected the conflict to be recorded as %+v, got %+v", want, got)
+	}
+
+	// Nothing can be committed until the file is resolved and staged
+	writeTestFile(t, repo, "b.txt", "edited\n")
+	if _, err := repo.AddPaths([]string{"b.txt"}, nil); err != nil {
+		t.Fatalf("Failed to stage b.txt: %v", err)
+	}
+	if _, err := repo.Commit("Commit the markers"); err == nil || !strings.Contains(err.Error(), "unresolved conflicts") {
+		t.Fatalf("Expected commit to be refused with an unresolved conflict, got %v", err)
+	}
+	writeTestFile(t, repo, "a.txt", "one\ntwo\nTHREE local\n")
+	if _, err := repo.AddPaths([]string{"a.txt"}, nil); err != nil {
+		t.Fatalf("Failed to stage resolution: %v", err)
+	}
+	if _, err := repo.Commit("Resolve"); err != nil {
+		t.Errorf("Failed to commit the resolution: %v", err)
+	}
+}
@@ -1,17 +1,19 @@
 package repo
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 )
 
+// defaultSignature is the author and committer recorded on new commits
+const defaultSignature = "Kit User <kit@example.com>" // Hardcoded for now
+
 // CommitObject represents a commit in the repository
 type CommitObject struct {
 	Tree      string    `json:"tree"`      // Tree object ID
@@ -36,42 +38,138 @@ type TreeEntry struct {
 	ObjID string `json:"obj_id"` // Object ID
 }
 
+// CleanupMode selects how a commit message is cleaned up
+type CleanupMode int
+
+const (
+	CleanupWhitespace CleanupMode = iota // Strip trailing whitespace and surrounding and repeated blank lines
+	CleanupStrip                         // Also strip comment lines starting with '#', for messages from a file or editor
+)
+
+// CommitOptions represents options for committing
+type CommitOptions struct {
+	Amend      bool        // Replace the HEAD commit instead of adding one on top
+	All        bool        // Stage modified and deleted tracked files first
+	AllowEmpty bool        // Commit even if the tree is the same as HEAD's
+	Fixup      string      // Revision to mark the commit as a fixup of, for a later rebase
+	Cleanup    CleanupMode // How the message is cleaned up
+}
+
 // Commit creates a new commit from the staging area
 func (r *Repository) Commit(message string) (string, error) {
-	// Check if there's anything to commit
-	if len(r.State.Stage) == 0 {
-		return "", fmt.Errorf("nothing to commit, working tree clean")
+	return r.CommitWithOptions(message, nil)
+}
+
+// CommitWithOptions creates a commit from the staging area. The message is
+// cleaned up with CleanupCommitMessage in the options' mode; when amending,
+// an empty message keeps the message of the commit being replaced, and the
+// replacement is recorded in the reflogs of HEAD and the branch.
+func (r *Repository) CommitWithOptions(message string, options *CommitOptions) (string, error) {
+	if options == nil {
+		options = &CommitOptions{}
 	}
 
-	// Create a tree object from the staging area
-	tree := TreeObject{
-		Entries: make(map[string]TreeEntry),
+	message = CleanupCommitMessage(message, options.Cleanup)
+	if options.Fixup != "" {
+		targetID, err := r.ResolveRevision(options.Fixup)
+		if err != nil {
+			return "", err
+		}
+		target, err := r.readCommit(targetID)
+		if err != nil {
+			return "", fmt.Errorf("failed to read commit %s: %w", targetID, err)
+		}
+		subject, _, _ := strings.Cut(target.Message, "\n")
+		fixup := "fixup! " + subject
+		if message != "" {
+			fixup += "\n\n" + message
+		}
+		message = fixup
 	}
 
-	for path, objID := range r.State.Stage {
-		// For now, all objects are blob files
-		tree.Entries[path] = TreeEntry{
-			Path:  path,
-			Mode:  "100644", // Regular file
-			Type:  "blob",
-			ObjID: objID,
+	if message == "" && !options.Amend {
+		return "", fmt.Errorf("aborting commit due to empty commit message")
+	}
+
+	if options.All {
+		if _, err := r.AddPaths(nil, &AddOptions{Update: true}); err != nil {
+			return "", fmt.Errorf("failed to stage tracked files: %w", err)
 		}
 	}
 
-	// Serialize tree object
-	treeData, err := json.MarshalIndent(tree, "", "  ")
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal tree: %w", err)
+	if options.Amend {
+		headID, err := r.resolveReference(r.State.HEAD)
+		if err != nil || headID == "" {
+			return "", fmt.Errorf("no commit to amend")
+		}
+		head, err := r.readCommit(headID)
+		if err != nil {
+			return "", fmt.Errorf("failed to read HEAD commit: %w", err)
+		}
+		if message == "" {
+			message = head.Message
+		}
+		commitID, err := r.amendHead(head, message)
+		if err != nil {
+			return "", err
+		}
+		subject, _, _ := strings.Cut(message, "\n")
+		refs := []string{"HEAD"}
+		if r.State.HEAD != "HEAD" {
+			refs = append(refs, r.State.HEAD)
+		}
+		for _, ref := range refs {
+			if err := r.appendReflog(ref, headID, commitID, "commit (amend): "+subject); err != nil {
+				return "", err
+			}
+		}
+		return commitID, nil
 	}
 
-	// Compute tree hash
-	treeHash := sha256.Sum256(treeData)
-	treeID := hex.EncodeToString(treeHash[:])
+	if options.AllowEmpty && !r.hasStagedChanges() && len(r.State.Conflicts) == 0 {
+		parentID, err := r.resolveReference(r.State.HEAD)
+		if err != nil && !os.IsNotExist(err) {
+			return "", fmt.Errorf("failed to resolve HEAD: %w", err)
+		}
+		return r.commitTree(message, defaultSignature, parentID, "")
+	}
+	return r.commitIndex(message, defaultSignature)
+}
 
-	// Store tree object
-	err = r.storeObject(treeID, treeData)
-	if err != nil {
-		return "", fmt.Errorf("failed to store tree: %w", err)
+// CleanupCommitMessage strips trailing whitespace from a commit message,
+// collapses runs of blank lines and removes leading and trailing blank
+// lines. CleanupStrip also strips comment lines starting with '#'.
+func CleanupCommitMessage(message string, mode CleanupMode) string {
+	lines := []string{}
+	blank := false
+	for _, line := range strings.Split(message, "\n") {
+		if mode == CleanupStrip && strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" {
+			blank = len(lines) > 0
+			continue
+		}
+		if blank {
+			lines = append(lines, "")
+			blank = false
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// commitIndex commits the staging area on top of HEAD with the given author
+func (r *Repository) commitIndex(message, author string) (string, error) {
+	// Unmerged files must be resolved and staged first
+	if len(r.State.Conflicts) > 0 {
+		return "", fmt.Errorf("cannot commit with unresolved conflicts in %s", strings.Join(r.conflictPaths(), ", "))
+	}
+
+	// Check if there's anything to commit
+	if !r.hasStagedChanges() {
+		return "", fmt.Errorf("nothing to commit, working tree clean")
 	}
 
 	// Get parent commit ID
@@ -80,30 +178,43 @@ func (r *Repository) Commit(message string) (string, error) {
 		return "", fmt.Errorf("failed to resolve HEAD: %w", err)
 	}
 
+	return r.commitTree(message, author, parentID, "")
+}
+
+// amendHead replaces head, the HEAD commit, with a commit of the staging
+// area that has the same parents and author
+func (r *Repository) amendHead(head *CommitObject, message string) (string, error) {
+	if len(r.State.Conflicts) > 0 {
+		return "", fmt.Errorf("cannot commit with unresolved conflicts in %s", strings.Join(r.conflictPaths(), ", "))
+	}
+
+	return r.commitTree(message, head.Author, head.Parent, head.Parent2)
+}
+
+// commitTree records the full index as a commit with the given parents and
+// moves HEAD to it
+func (r *Repository) commitTree(message, author, parentID, parent2ID string) (string, error) {
+	// Create a tree object from the full index (tracked files overlaid with staged ones)
+	treeID, err := r.storeTree(buildTree(r.indexEntries()))
+	if err != nil {
+		return "", fmt.Errorf("failed to store tree: %w", err)
+	}
+
 	// Create commit object
 	commit := CommitObject{
 		Tree:      treeID,
 		Parent:    parentID,
-		Author:    "Kit User <kit@example.com>", // Hardcoded for now
-		Committer: "Kit User <kit@example.com>", // Hardcoded for now
+		Parent2:   parent2ID,
+		Author:    author,
+		Committer: defaultSignature,
 		Message:   message,
 		Timestamp: time.Now(),
 	}
 
-	// Serialize commit object
-	commitData, err := json.MarshalIndent(commit, "", "  ")
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal commit: %w", err)
-	}
-
-	// Compute commit hash
-	commitHash := sha256.Sum256(commitData)
-	commitID := hex.EncodeToString(commitHash[:])
-
 	// Store commit object
-	err = r.storeObject(commitID, commitData)
+	commitID, err := r.storeCommit(&commit)
 	if err != nil {
-		return "", fmt.Errorf("failed to store commit: %w", err)
+		return "", err
 	}
 
 	// Update HEAD reference
@@ -116,9 +227,13 @@ func (r *Repository) Commit(message string) (string, error) {
 	for path, objID := range r.State.Stage {
 		r.State.Tracked[path] = objID
 	}
+	for path := range r.State.Removed {
+		delete(r.State.Tracked, path)
+	}
 
 	// Clear staging area after successful commit
 	r.State.Stage = make(map[string]string)
+	r.State.Removed = make(map[string]bool)
 
 	// Save the updated index
 	err = r.SaveIndex()
@@ -129,6 +244,153 @@ func (r *Repository) Commit(message string) (string, error) {
 	return commitID, nil
 }
 
+// indexEntries returns the full index: tracked files overlaid with staged
+// ones, minus staged deletions
+func (r *Repository) indexEntries() map[string]string {
+	entries := make(map[string]string, len(r.State.Tracked)+len(r.State.Stage))
+	for path, objID := range r.State.Tracked {
+		entries[path] = objID
+	}
+	for path, objID := range r.State.Stage {
+		entries[path] = objID
+	}
+	for path := range r.State.Removed {
+		delete(entries, path)
+	}
+	return entries
+}
+
+// setIndexEntries replaces the full index, recording the differences from
+// the tracked files as staged changes and deletions
+func (r *Repository) setIndexEntries(entries map[string]string) {
+	r.State.Stage = make(map[string]string)
+	r.State.Removed = make(map[string]bool)
+	for path, objID := range entries {
+		if r.State.Tracked[path] != objID {
+			r.State.Stage[path] = objID
+		}
+	}
+	for path := range r.State.Tracked {
+		if _, ok := entries[path]; !ok {
+			r.State.Removed[path] = true
+		}
+	}
+}
+
+// hasStagedChanges reports whether the index differs from the tracked files
+func (r *Repository) hasStagedChanges() bool {
+	return len(r.State.Stage) > 0 || len(r.State.Removed) > 0
+}
+
+// unstagedChanges returns the files in the index whose working tree content
+// differs from it, sorted. Files outside a sparse checkout are skipped.
+func (r *Repository) unstagedChanges() ([]string, error) {
+	changed := []string{}
+	for path, objID := range r.indexEntries() {
+		if r.State.SkipWorktree[path] {
+			continue
+		}
+		workID, err := r.workingFileID(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file %s: %w", path, err)
+		}
+		if workID != objID {
+			changed = append(changed, path)
+		}
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+// buildTree creates a tree object from a map of path -> blob object ID
+func buildTree(entries map[string]string) *TreeObject {
+	tree := &TreeObject{
+		Entries: make(map[string]TreeEntry, len(entries)),
+	}
+	for path, objID := range entries {
+		// For now, all objects are blob files
+		tree.Entries[path] = TreeEntry{
+			Path:  path,
+			Mode:  "100644", // Regular file
+			Type:  "blob",
+			ObjID: objID,
+		}
+	}
+	return tree
+}
+
+// treeBlobs returns the path -> blob object ID map of a tree
+func treeBlobs(tree *TreeObject) map[string]string {
+	blobs := make(map[string]string, len(tree.Entries))
+	for path, entry := range tree.Entries {
+		blobs[path] = entry.ObjID
+	}
+	return blobs
+}
+
+// storeTree serializes and stores a tree object, returning its ID
+func (r *Repository) storeTree(tree *TreeObject) (string, error) {
+	treeData, err := json.MarshalIndent(tree, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal tree: %w", err)
+	}
+
+	treeID := hashContent(treeData)
+	if err := r.storeObject(treeID, treeData); err != nil {
+		return "", fmt.Errorf("failed to store tree: %w", err)
+	}
+
+	return treeID, nil
+}
+
+// storeCommit serializes and stores a commit object, returning its ID
+func (r *Repository) storeCommit(commit *CommitObject) (string, error) {
+	commitData, err := json.MarshalIndent(commit, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal commit: %w", err)
+	}
+
+	commitID := hashContent(commitData)
+	if err := r.storeObject(commitID, commitData); err != nil {
+		return "", fmt.Errorf("failed to store commit: %w", err)
+	}
+
+	return commitID, nil
+}
+
+// readCommit reads and unmarshals a commit object
+func (r *Repository) readCommit(commitID string) (*CommitObject, error) {
+	commitData, err := r.readObject(commitID)
+	if err != nil {
+		return nil, err
+	}
+
+	var commit CommitObject
+	if err := json.Unmarshal(commitData, &commit); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal commit %s: %w", commitID, err)
+	}
+
+	return &commit, nil
+}
+
+// readTree reads and unmarshals a tree object
+func (r *Repository) readTree(treeID string) (*TreeObject, error) {
+	treeData, err := r.readObject(treeID)
+	if err != nil {
+		return nil, err
+	}
+
+	var tree TreeObject
+	if err := json.Unmarshal(treeData, &tree); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal tree %s: %w", treeID, err)
+	}
+	if tree.Entries == nil {
+		tree.Entries = make(map[string]TreeEntry)
+	}
+
+	return &tree, nil
+}
+
 // resolveReference resolves a reference to a commit ID
 func (r *Repository) resolveReference(ref string) (string, error) {
 	// If it's a symbolic reference, resolve it
@@ -161,6 +423,11 @@ func (r *Repository) resolveReference(ref string) (string, error) {
 	return strings.TrimSpace(string(data)), nil
 }
 
+// refPath returns the path of the file backing a reference
+func (r *Repository) refPath(ref string) string {
+	return filepath.Join(r.Path, DefaultKitDir, ref)
+}
+
 // updateReference updates a reference to point to a commit ID
 func (r *Repository) updateReference(ref, commitID string) error {
 	// If it's HEAD, we need to find what it points to
@@ -0,0 +1,146 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCleanupCommitMessage(t *testing.T) {
+	tests := []struct {
+		message string
+		mode    CleanupMode
+		want    string
+	}{
+		{"Subject", CleanupStrip, "Subject"},
+		{"Subject  \n\n\n\nBody\t\n", CleanupStrip, "Subject\n\nBody"},
+		{"\n\n# Please enter a message\nSubject\n# comment\n\nBody\n\n", CleanupStrip, "Subject\n\nBody"},
+		{"  indented\n#\n", CleanupStrip, "  indented"},
+		{"# only comments\n\n", CleanupStrip, ""},
+		{"#42 fix crash on empty input \n\n\n# not a comment\n", CleanupWhitespace, "#42 fix crash on empty input\n\n# not a comment"},
+	}
+	for _, test := range tests {
+		if got := CleanupCommitMessage(test.message, test.mode); got != test.want {
+			t.Errorf("CleanupCommitMessage(%q, %d) = %q, want %q", test.message, test.mode, got, test.want)
+		}
+	}
+}
+
+func TestCommitWithOptions(t *testing.T) {
+	repo := newTestRepository(t)
+	a := commitTestFiles(t, repo, "A", map[string]string{"a.txt": "a\n", "b.txt": "b\n"})
+
+	if _, err := repo.CommitWithOptions("# nothing but a comment", &CommitOptions{Cleanup: CleanupStrip}); err == nil {
+		t.Errorf("Expected an empty message to be refused")
+	}
+
+	// -a stages modified and deleted tracked files, but not new ones
+	writeTestFile(t, repo, "a.txt", "changed\n")
+	writeTestFile(t, repo, "new.txt", "new\n")
+	if err := os.Remove(filepath.Join(repo.Path, "b.txt")); err != nil {
+		t.Fatalf("Failed to remove b.txt: %v", err)
+	}
+	b, err := repo.CommitWithOptions("B\n# comment\n", &CommitOptions{All: true, Cleanup: CleanupStrip})
+	if err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	commit, _ := repo.readCommit(b)
+	if commit.Message != "B" {
+		t.Errorf("Expected the message to be cleaned up, got %q", commit.Message)
+	}
+	tree, err := repo.getTreeFromCommit(b)
+	if err != nil || len(tree.Entries) != 1 || tree.Entries["a.txt"].ObjID != hashContent([]byte("changed\n")) {
+		t.Errorf("Expected only the changed a.txt in the tree, got %+v (%v)", tree, err)
+	}
+
+	if _, err := repo.Commit("Empty"); err == nil {
+		t.Errorf("Expected a commit without changes to be refused")
+	}
+	empty, err := repo.CommitWithOptions("Empty", &CommitOptions{AllowEmpty: true})
+	if err != nil {
+		t.Fatalf("Failed to commit with --allow-empty: %v", err)
+	}
+	if emptyCommit, _ := repo.readCommit(empty); emptyCommit.Parent != b || emptyCommit.Tree != commit.Tree {
+		t.Errorf("Expected an empty commit on top of B, got %+v", emptyCommit)
+	}
+
+	// Amending keeps the parents, and the message unless a new one is given
+	if err := repo.Add("new.txt"); err != nil {
+		t.Fatalf("Failed to add: %v", err)
+	}
+	amended, err := repo.CommitWithOptions("", &CommitOptions{Amend: true})
+	if err != nil {
+		t.Fatalf("Failed to amend: %v", err)
+	}
+	commit, _ = repo.readCommit(amended)
+	if commit.Parent != b || commit.Message != "Empty" {
+		t.Errorf("Expected the amended commit on top of B with its message, got %+v", commit)
+	}
+	if tree, _ := repo.getTreeFromCommit(amended); len(tree.Entries) != 2 {
+		t.Errorf("Expected new.txt in the amended commit, got %+v", tree)
+	}
+	amended, err = repo.CommitWithOptions("Add new.txt", &CommitOptions{Amend: true})
+	if commit, _ := repo.readCommit(amended); err != nil || commit.Parent != b || commit.Message != "Add new.txt" {
+		t.Errorf("Expected the amended message, got %+v (%v)", commit, err)
+	}
+	if head, _ := repo.resolveReference("HEAD"); head != amended {
+		t.Errorf("Expected HEAD at the amended commit")
+	}
+	for _, ref := range []string{"HEAD", "refs/heads/main"} {
+		entries, err := repo.ReadReflog(ref)
+		if err != nil || len(entries) == 0 || entries[len(entries)-1].NewID != amended ||
+			entries[len(entries)-1].Message != "commit (amend): Add new.txt" {
+			t.Errorf("Expected the amend in the reflog of %s, got %+v (%v)", ref, entries, err)
+		}
+	}
+
+	// A fixup commit names the commit it fixes
+	writeTestFile(t, repo, "a.txt", "fixed\n")
+	fixup, err := repo.CommitWithOptions("", &CommitOptions{All: true, Fixup: a[:8]})
+	if err != nil {
+		t.Fatalf("Failed to commit fixup: %v", err)
+	}
+	if commit, _ := repo.readCommit(fixup); commit.Message != "fixup! A" {
+		t.Errorf("Expected a fixup message, got %q", commit.Message)
+	}
+
+	// Messages given directly keep lines starting with '#'
+	writeTestFile(t, repo, "a.txt", "crash fixed\n")
+	if err := repo.Add("a.txt"); err != nil {
+		t.Fatalf("Failed to add: %v", err)
+	}
+	issue, err := repo.Commit("#42 fix crash on empty input")
+	if err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	if commit, _ := repo.readCommit(issue); commit.Message != "#42 fix crash on empty input" {
+		t.Errorf("Expected the message to be kept, got %q", commit.Message)
+	}
+}
+
+func TestCommitSnapshotsIndex(t *testing.T) {
+	repo := newTestRepository(t)
+	a := commitTestFiles(t, repo, "A", map[string]string{"a.txt": "a\n"})
+	b := commitTestFiles(t, repo, "B", map[string]string{"b.txt": "b\n"})
+
+	// The second commit's tree holds the file committed earlier as well as
+	// the one staged for it
+	for commitID, want := range map[string][]string{a: {"a.txt"}, b: {"a.txt", "b.txt"}} {
+		commit, err := repo.readCommit(commitID)
+		if err != nil {
+			t.Fatalf("Failed to read commit: %v", err)
+		}
+		tree, err := repo.readTree(commit.Tree)
+		if err != nil {
+			t.Fatalf("Failed to read tree: %v", err)
+		}
+		if len(tree.Entries) != len(want) {
+			t.Errorf("Expected tree of %s to hold %v, got %v", shortID(commitID), want, tree.Entries)
+		}
+		for _, path := range want {
+			if _, ok := tree.Entries[path]; !ok {
+				t.Errorf("Expected tree of %s to hold %s", shortID(commitID), path)
+			}
+		}
+	}
+}
@@ -0,0 +1,544 @@
+package repo
+
+import (
+	"bytes"
+	"container/heap"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The commit-graph file caches what history walks need to know about
+// commits, so they don't have to read and parse commit objects:
+//
+//	header:  "KCGR" | version uint32 | commit count uint32
+//	commit:  commit ID 32 bytes | tree ID 32 bytes |
+//	         parent index uint32 | second parent index uint32 |
+//	         commit time int64 (Unix nanoseconds) | generation uint32
+//	trailer: SHA-256 of everything before it
+//
+// All integers are big-endian. Parents are referred to by their position
+// in the file and always come before their children, so new commits are
+// appended when the file is updated. A commit's generation is one more
+// than the largest generation of its parents, and 1 for a root commit, so
+// a commit can only be an ancestor of commits with a larger generation.
+const (
+	commitGraphSignature = "KCGR"
+	commitGraphVersion   = 1
+	commitGraphFile      = "commit-graph"
+)
+
+// commitGraphNoParent is the parent index of a missing parent
+const commitGraphNoParent = math.MaxUint32
+
+// generationInfinity is the generation of commits missing from the commit
+// graph, which are newer than it
+const generationInfinity = math.MaxUint32
+
+// commitGraphData is a loaded commit-graph file
+type commitGraphData struct {
+	ids         []string
+	trees       []string
+	parents     [][2]uint32
+	times       []int64
+	generations []uint32
+	positions   map[string]uint32 // Position of each commit in the file
+}
+
+// commitNode is what history walks need to know about a commit
+type commitNode struct {
+	ID         string
+	Parents    []string
+	Tree       string
+	Time       time.Time
+	Generation uint32 // generationInfinity if not in the commit graph
+}
+
+// commitNode looks a commit up in the commit graph, reading the commit
+// object only if it was made since the graph was written
+func (r *Repository) commitNode(commitID string) (*commitNode, error) {
+	graph := r.loadedCommitGraph()
+	if pos, ok := graph.positions[commitID]; ok {
+		node := &commitNode{
+			ID:         commitID,
+			Tree:       graph.trees[pos],
+			Time:       time.Unix(0, graph.times[pos]),
+			Generation: graph.generations[pos],
+		}
+		for _, parent := range graph.parents[pos] {
+			if parent != commitGraphNoParent {
+				node.Parents = append(node.Parents, graph.ids[parent])
+			}
+		}
+		return node, nil
+	}
+
+	commit, err := r.readCommit(commitID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commit %s: %w", commitID, err)
+	}
+	node := &commitNode{ID: commitID, Tree: commit.Tree, Time: commit.Timestamp, Generation: generationInfinity}
+	for _, parent := range []string{commit.Parent, commit.Parent2} {
+		if parent != "" {
+			node.Parents = append(node.Parents, parent)
+		}
+	}
+	return node, nil
+}
+
+// loadedCommitGraph returns the commit graph, reading it on first use. A
+// missing or unreadable file gives an empty graph, so that everything
+// falls back to reading commit objects.
+func (r *Repository) loadedCommitGraph() *commitGraphData {
+	if r.commitGraph == nil {
+		graph, err := r.readCommitGraph()
+		if err != nil {
+			graph = newCommitGraphData()
+		}
+		r.commitGraph = graph
+	}
+	return r.commitGraph
+}
+
+// newCommitGraphData creates an empty commit graph
+func newCommitGraphData() *commitGraphData {
+	return &commitGraphData{positions: make(map[string]uint32)}
+}
+
+// commitGraphPath returns the path of the commit-graph file
+func (r *Repository) commitGraphPath() string {
+	return filepath.Join(r.Path, DefaultKitDir, commitGraphFile)
+}
+
+// readCommitGraph reads the commit-graph file, returning an empty graph if there is none
+func (r *Repository) readCommitGraph() (*commitGraphData, error) {
+	data, err := os.ReadFile(r.commitGraphPath())
+	if os.IsNotExist(err) {
+		return newCommitGraphData(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commit graph: %w", err)
+	}
+	return decodeCommitGraph(data)
+}
+
+// decodeCommitGraph decodes the contents of a commit-graph file
+func decodeCommitGraph(data []byte) (*commitGraphData, error) {
+	if len(data) < sha256.Size {
+		return nil, fmt.Errorf("commit graph is truncated")
+	}
+	body, checksum := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+	if sum := sha256.Sum256(body); !bytes.Equal(sum[:], checksum) {
+		return nil, fmt.Errorf("commit graph checksum mismatch")
+	}
+
+	reader := &indexReader{data: body}
+	if signature := string(reader.bytes(4)); signature != commitGraphSignature {
+		return nil, fmt.Errorf("invalid commit graph signature %q", signature)
+	}
+	if version := reader.uint32(); version != commitGraphVersion {
+		return nil, fmt.Errorf("unsupported commit graph version %d", version)
+	}
+	count := reader.uint32()
+	if reader.err == nil && uint64(count)*(2*sha256.Size+20) != uint64(len(body)-reader.pos) {
+		return nil, fmt.Errorf("commit graph has the wrong size for %d commits", count)
+	}
+
+	graph := newCommitGraphData()
+	for i := uint32(0); i < count && reader.err == nil; i++ {
+		id := reader.objectID()
+		tree := reader.objectID()
+		parents := [2]uint32{reader.uint32(), reader.uint32()}
+		commitTime := int64(reader.uint64())
+		generation := reader.uint32()
+		for _, parent := range parents {
+			if parent != commitGraphNoParent && parent >= i {
+				return nil, fmt.Errorf("commit %s in the commit graph comes before its parent", id)
+			}
+		}
+		graph.add(id, tree, parents, commitTime, generation)
+	}
+	if reader.err != nil {
+		return nil, fmt.Errorf("failed to decode commit graph: %w", reader.err)
+	}
+	return graph, nil
+}
+
+// add appends a commit to the graph
+func (g *commitGraphData) add(id, tree string, parents [2]uint32, commitTime int64, generation uint32) {
+	g.positions[id] = uint32(len(g.ids))
+	g.ids = append(g.ids, id)
+	g.trees = append(g.trees, tree)
+	g.parents = append(g.parents, parents)
+	g.times = append(g.times, commitTime)
+	g.generations = append(g.generations, generation)
+}
+
+// encode encodes the graph as a commit-graph file
+func (g *commitGraphData) encode() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(commitGraphSignature)
+	binary.Write(&buf, binary.BigEndian, uint32(commitGraphVersion))
+	binary.Write(&buf, binary.BigEndian, uint32(len(g.ids)))
+	for i, id := range g.ids {
+		if err := writeObjectID(&buf, id); err != nil {
+			return nil, err
+		}
+		if err := writeObjectID(&buf, g.trees[i]); err != nil {
+			return nil, err
+		}
+		binary.Write(&buf, binary.BigEndian, g.parents[i])
+		binary.Write(&buf, binary.BigEndian, g.times[i])
+		binary.Write(&buf, binary.BigEndian, g.generations[i])
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	buf.Write(sum[:])
+	return buf.Bytes(), nil
+}
+
+// WriteCommitGraph adds the commits reachable from HEAD and the references
+// that aren't in the commit-graph file yet, and returns how many there were.
+// Commits already in the file are kept as they are, so only new commits
+// are read.
+func (r *Repository) WriteCommitGraph() (int, error) {
+	graph, err := r.readCommitGraph()
+	if err != nil {
+		// A damaged graph is rebuilt from scratch
+		graph = newCommitGraphData()
+	}
+	before := len(graph.ids)
+
+	tips, err := r.referenceTips()
+	if err != nil {
+		return 0, err
+	}
+
+	// Add commits after their parents, walking depth first from each tip
+	type frame struct {
+		id     string
+		commit *CommitObject
+	}
+	for _, tip := range tips {
+		if _, ok := graph.positions[tip]; ok {
+			continue
+		}
+		visiting := map[string]bool{}
+		stack := []frame{}
+		push := func(id string) error {
+			commit, err := r.readCommit(id)
+			if err != nil {
+				return fmt.Errorf("failed to read commit %s: %w", id, err)
+			}
+			visiting[id] = true
+			stack = append(stack, frame{id: id, commit: commit})
+			return nil
+		}
+		if err := push(tip); err != nil {
+			return 0, err
+		}
+		for len(stack) > 0 {
+			top := stack[len(stack)-1]
+			pending := ""
+			for _, parent := range []string{top.commit.Parent, top.commit.Parent2} {
+				if _, ok := graph.positions[parent]; parent != "" && !ok && !visiting[parent] {
+					pending = parent
+					break
+				}
+			}
+			if pending != "" {
+				if err := push(pending); err != nil {
+					return 0, err
+				}
+				continue
+			}
+
+			stack = stack[:len(stack)-1]
+			parents := [2]uint32{commitGraphNoParent, commitGraphNoParent}
+			generation := uint32(1)
+			for i, parent := range []string{top.commit.Parent, top.commit.Parent2} {
+				if parent == "" {
+					continue
+				}
+				pos := graph.positions[parent]
+				parents[i] = pos
+				if g := graph.generations[pos] + 1; g > generation {
+					generation = g
+				}
+			}
+			graph.add(top.id, top.commit.Tree, parents, top.commit.Timestamp.UnixNano(), generation)
+		}
+	}
+
+	added := len(graph.ids) - before
+	if added == 0 {
+		if _, err := os.Stat(r.commitGraphPath()); err == nil {
+			r.commitGraph = graph
+			return 0, nil
+		}
+	}
+
+	data, err := graph.encode()
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode commit graph: %w", err)
+	}
+	tmpPath := r.commitGraphPath() + ".lock"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return 0, fmt.Errorf("failed to write commit graph: %w", err)
+	}
+	if err := os.Rename(tmpPath, r.commitGraphPath()); err != nil {
+		os.Remove(tmpPath)
+		return 0, fmt.Errorf("failed to write commit graph: %w", err)
+	}
+	r.commitGraph = graph
+	return added, nil
+}
+
+// VerifyCommitGraph checks the commit-graph file against the commit
+// objects, returning a description of each problem found
+func (r *Repository) VerifyCommitGraph() ([]string, error) {
+	graph, err := r.readCommitGraph()
+	if err != nil {
+		return []string{err.Error()}, nil
+	}
+
+	problems := []string{}
+	for i, id := range graph.ids {
+		commit, err := r.readCommit(id)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("commit %s: %v", id, err))
+			continue
+		}
+		if graph.trees[i] != commit.Tree {
+			problems = append(problems, fmt.Sprintf("commit %s: tree %s, expected %s", id, graph.trees[i], commit.Tree))
+		}
+		if graph.times[i] != commit.Timestamp.UnixNano() {
+			problems = append(problems, fmt.Sprintf("commit %s: wrong commit time", id))
+		}
+		generation := uint32(1)
+		for j, parent := range []string{commit.Parent, commit.Parent2} {
+			pos := graph.parents[i][j]
+			switch {
+			case parent == "" && pos == commitGraphNoParent:
+				continue
+			case parent == "" || pos == commitGraphNoParent || graph.ids[pos] != parent:
+				problems = append(problems, fmt.Sprintf("commit %s: wrong parent %d", id, j+1))
+				continue
+			}
+			if g := graph.generations[pos] + 1; g > generation {
+				generation = g
+			}
+		}
+		if graph.generations[i] != generation {
+			problems = append(problems, fmt.Sprintf("commit %s: generation %d, expected %d", id, graph.generations[i], generation))
+		}
+	}
+	return problems, nil
+}
+
+// referenceTips returns the commits HEAD and the references point to
+func (r *Repository) referenceTips() ([]string, error) {
+	seen := make(map[string]bool)
+	tips := []string{}
+	add := func(commitID string) {
+		if commitID != "" && !seen[commitID] {
+			seen[commitID] = true
+			tips = append(tips, commitID)
+		}
+	}
+
+	if commitID, err := r.resolveReference("HEAD"); err == nil {
+		add(commitID)
+	}
+
+	refsDir := filepath.Join(r.Path, DefaultKitDir, DefaultKitRefsDir)
+	refs := []string{}
+	err := filepath.WalkDir(refsDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !entry.IsDir() {
+			refs = append(refs, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list references: %w", err)
+	}
+	sort.Strings(refs)
+	for _, path := range refs {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read reference: %w", err)
+		}
+		commitID := strings.TrimSpace(string(data))
+		if commit, err := r.readCommit(commitID); err == nil && commit.Tree != "" {
+			add(commitID)
+		}
+	}
+	return tips, nil
+}
+
+// IsAncestor reports whether a commit is an ancestor of another one, or
+// the same commit. Commits with a generation below the ancestor's can't
+// lead to it and aren't looked at.
+func (r *Repository) IsAncestor(ancestor, descendant string) (bool, error) {
+	target, err := r.commitNode(ancestor)
+	if err != nil {
+		return false, err
+	}
+
+	visited := make(map[string]bool)
+	stack := []string{descendant}
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if id == ancestor {
+			return true, nil
+		}
+		if visited[id] {
+			continue
+		}
+		visited[id] = true
+
+		node, err := r.commitNode(id)
+		if err != nil {
+			return false, err
+		}
+		if node.Generation <= target.Generation && target.Generation != generationInfinity {
+			continue
+		}
+		stack = append(stack, node.Parents...)
+	}
+	return false, nil
+}
+
+// BranchesContaining returns the branches whose history includes a commit
+func (r *Repository) BranchesContaining(commitID string) ([]Branch, error) {
+	branches, err := r.ListBranches()
+	if err != nil {
+		return nil, err
+	}
+	containing := []Branch{}
+	for _, branch := range branches {
+		ok, err := r.IsAncestor(commitID, branch.CommitID)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			containing = append(containing, branch)
+		}
+	}
+	return containing, nil
+}
+
+// Flags painted on commits while looking for merge bases
+const (
+	paintOurs uint8 = 1 << iota
+	paintTheirs
+	paintStale
+)
+
+// paintDownToCommon walks down from our commits and theirs at once, newest
+// generation first, and returns the commits reached from both sides whose
+// descendants weren't (the merge base candidates), in the order they were found
+func (r *Repository) paintDownToCommon(ours []string, theirs string) ([]string, error) {
+	if containsString(ours, theirs) {
+		return []string{theirs}, nil
+	}
+
+	// The entries of each commit in the queue are counted, along with those
+	// of commits not painted stale, so the walk knows when only stale
+	// commits are left without looking through the queue
+	flags := make(map[string]uint8)
+	queue := &nodeQueue{}
+	queued := make(map[string]int)
+	interesting := 0
+	push := func(id string, paint uint8) error {
+		node, err := r.commitNode(id)
+		if err != nil {
+			return err
+		}
+		if flags[id]&paintStale == 0 && paint&paintStale != 0 {
+			interesting -= queued[id]
+		}
+		flags[id] |= paint
+		if flags[id]&paintStale == 0 {
+			interesting++
+		}
+		queued[id]++
+		heap.Push(queue, node)
+		return nil
+	}
+	for _, id := range ours {
+		if err := push(id, paintOurs); err != nil {
+			return nil, err
+		}
+	}
+	if err := push(theirs, paintTheirs); err != nil {
+		return nil, err
+	}
+
+	// Stop once everything left in the queue is below a found base
+	candidates := []string{}
+	found := make(map[string]bool)
+	for queue.Len() > 0 && interesting > 0 {
+		node := heap.Pop(queue).(*commitNode)
+		queued[node.ID]--
+		if flags[node.ID]&paintStale == 0 {
+			interesting--
+		}
+		paint := flags[node.ID] & (paintOurs | paintTheirs | paintStale)
+		if paint == paintOurs|paintTheirs {
+			if !found[node.ID] {
+				found[node.ID] = true
+				candidates = append(candidates, node.ID)
+			}
+			paint |= paintStale
+		}
+		for _, parent := range node.Parents {
+			if flags[parent]&paint == paint {
+				continue
+			}
+			if err := push(parent, paint); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return candidates, nil
+}
+
+// nodeQueue orders commits by generation and then commit time, newest first
+type nodeQueue []*commitNode
+
+func (q nodeQueue) Len() int { return len(q) }
+
+func (q nodeQueue) Less(i, j int) bool {
+	if q[i].Generation != q[j].Generation {
+		return q[i].Generation > q[j].Generation
+	}
+	if !q[i].Time.Equal(q[j].Time) {
+		return q[i].Time.After(q[j].Time)
+	}
+	return q[i].ID < q[j].ID
+}
+
+func (q nodeQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *nodeQueue) Push(x any) { *q = append(*q, x.(*commitNode)) }
+
+func (q *nodeQueue) Pop() any {
+	old := *q
+	node := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return node
+}
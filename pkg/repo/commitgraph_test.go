@@ -0,0 +1,84 @@
+package repo
+
+import (
+	"os"
+	"testing"
+)
+
+func TestCommitGraph(t *testing.T) {
+	repo := newTestRepository(t)
+	ids := mergeHistory(t, repo)
+
+	// Queries give the same answers with and without the graph
+	check := func(stage string, head string, count int) {
+		t.Helper()
+		base, err := repo.FindMergeBase(ids["D"], ids["E"])
+		if err != nil || base != ids["B"] {
+			t.Errorf("%s: expected merge base B, got %s (%v)", stage, base, err)
+		}
+		for _, test := range []struct {
+			ancestor, descendant string
+			want                 bool
+		}{
+			{"B", "M", true}, {"C", "M", true}, {"M", "M", true}, {"D", "E", false}, {"M", "A", false},
+		} {
+			got, err := repo.IsAncestor(ids[test.ancestor], ids[test.descendant])
+			if err != nil || got != test.want {
+				t.Errorf("%s: IsAncestor(%s, %s) = %v (%v), expected %v", stage, test.ancestor, test.descendant, got, err, test.want)
+			}
+		}
+		branches, err := repo.BranchesContaining(ids["D"])
+		if err != nil || len(branches) != 1 || branches[0].Name != "main" {
+			t.Errorf("%s: expected only main to contain D, got %+v (%v)", stage, branches, err)
+		}
+		log, err := repo.Log()
+		if err != nil || len(log) != count || log[0].Message != head || log[count-1].Author == "" {
+			t.Errorf("%s: expected the full log, got %+v (%v)", stage, log, err)
+		}
+	}
+	check("without graph", "M", 6)
+
+	added, err := repo.WriteCommitGraph()
+	if err != nil || added != 6 {
+		t.Fatalf("Expected 6 commits to be added, got %d (%v)", added, err)
+	}
+	repo, err = NewRepository(repo.Path)
+	if err != nil {
+		t.Fatalf("Failed to open repository: %v", err)
+	}
+	for name, generation := range map[string]uint32{"A": 1, "B": 2, "C": 3, "D": 3, "E": 4, "M": 5} {
+		node, err := repo.commitNode(ids[name])
+		if err != nil || node.Generation != generation {
+			t.Errorf("Expected generation %d for %s, got %+v (%v)", generation, name, node, err)
+		}
+	}
+	check("with graph", "M", 6)
+
+	// Only new commits are added
+	ids["N"] = commitTestFiles(t, repo, "N", map[string]string{"n.txt": "n\n"})
+	check("with a commit missing from the graph", "N", 7)
+	if added, err := repo.WriteCommitGraph(); err != nil || added != 1 {
+		t.Errorf("Expected 1 commit to be added, got %d (%v)", added, err)
+	}
+	if problems, err := repo.VerifyCommitGraph(); err != nil || len(problems) != 0 {
+		t.Errorf("Expected a valid graph, got %v (%v)", problems, err)
+	}
+
+	// A damaged graph is reported by verify and otherwise ignored
+	data, err := os.ReadFile(repo.commitGraphPath())
+	if err != nil {
+		t.Fatalf("Failed to read commit graph: %v", err)
+	}
+	data[20] ^= 0xff
+	if err := os.WriteFile(repo.commitGraphPath(), data, 0644); err != nil {
+		t.Fatalf("Failed to write commit graph: %v", err)
+	}
+	if problems, err := repo.VerifyCommitGraph(); err != nil || len(problems) == 0 {
+		t.Errorf("Expected a damaged graph to be reported, got %v (%v)", problems, err)
+	}
+	repo, err = NewRepository(repo.Path)
+	if err != nil {
+		t.Fatalf("Failed to open repository: %v", err)
+	}
+	check("with a damaged graph", "N", 7)
+}
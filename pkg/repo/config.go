@@ -0,0 +1,114 @@
+package repo
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ConfigValue reads a value from the repository configuration file.
+// Section and key names are case-insensitive, as in the "[core]" section
+// written by Initialize.
+func (r *Repository) ConfigValue(section, key string) (string, bool) {
+	f, err := os.Open(filepath.Join(r.Path, DefaultKitDir, DefaultKitConfig))
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+
+	current := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			current = strings.ToLower(strings.TrimSpace(line[1 : len(line)-1]))
+			continue
+		}
+
+		name, value, found := strings.Cut(line, "=")
+		if !found || current != strings.ToLower(section) {
+			continue
+		}
+		if strings.EqualFold(strings.TrimSpace(name), key) {
+			return strings.TrimSpace(value), true
+		}
+	}
+
+	return "", false
+}
+
+// configBool reads a boolean configuration value. Missing or malformed
+// values are false.
+func (r *Repository) configBool(section, key string) bool {
+	value, ok := r.ConfigValue(section, key)
+	if !ok {
+		return false
+	}
+	b, err := strconv.ParseBool(value)
+	return err == nil && b
+}
+
+// SetConfigValue sets a value in the repository configuration file,
+// replacing an existing value for the key or adding it to the section.
+// The section is created if needed.
+func (r *Repository) SetConfigValue(section, key, value string) error {
+	configPath := filepath.Join(r.Path, DefaultKitDir, DefaultKitConfig)
+	data, err := os.ReadFile(configPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(data) == 0 {
+		lines = nil
+	}
+	entry := fmt.Sprintf("\t%s = %s", key, value)
+
+	current := ""
+	insertAt := -1 // Line after the last entry of the section
+	replaced := false
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			current = strings.ToLower(strings.TrimSpace(trimmed[1 : len(trimmed)-1]))
+			if current == strings.ToLower(section) {
+				insertAt = i + 1
+			}
+			continue
+		}
+		if current != strings.ToLower(section) {
+			continue
+		}
+
+		name, _, found := strings.Cut(trimmed, "=")
+		if found && strings.EqualFold(strings.TrimSpace(name), key) {
+			lines[i] = entry
+			replaced = true
+			break
+		}
+		if trimmed != "" {
+			insertAt = i + 1
+		}
+	}
+
+	switch {
+	case replaced:
+	case insertAt < 0:
+		lines = append(lines, "["+section+"]", entry)
+	default:
+		lines = append(lines[:insertAt], append([]string{entry}, lines[insertAt:]...)...)
+	}
+
+	if err := os.WriteFile(configPath, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+
+	return nil
+}
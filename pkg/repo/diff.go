@@ -4,16 +4,29 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io/fs"
+	"math"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
 // DiffResult represents the result of a diff operation
 type DiffResult struct {
-	OldPath string      // Path in the old version
-	NewPath string      // Path in the new version
-	Chunks  []DiffChunk // Chunks of changes
+	OldPath    string       // Path in the old version
+	NewPath    string       // Path in the new version
+	Chunks     []DiffChunk  // Chunks of changes
+	Binary     bool         // The file is binary and its changes are not shown as lines
+	Renamed    bool         // NewPath was moved from OldPath
+	Copied     bool         // NewPath was copied from OldPath, which is kept
+	Similarity float64      // For renames and copies, how alike the contents are, from 0 to 1
+	OldID      string       // For binary files, the object ID of the old version; empty if missing
+	NewID      string       // For binary files, the object ID of the new version; empty if missing
+	OldSize    int          // For binary files, the size of the old version in bytes
+	NewSize    int          // For binary files, the size of the new version in bytes
+	Patch      *BinaryPatch // With DiffOptions.Binary, the change to a binary file in a form that can be applied
 }
 
 // DiffChunk represents a chunk of changes in a diff
@@ -27,14 +40,20 @@ type DiffChunk struct {
 
 // DiffOptions represents options for diff operations
 type DiffOptions struct {
-	ContextLines int  // Number of context lines to show
-	Semantic     bool // Whether to use semantic diff
+	ContextLines    int           // Number of context lines to show
+	Semantic        bool          // Whether to use semantic diff
+	Algorithm       DiffAlgorithm // How lines are matched up; Myers if empty
+	DetectRenames   bool          // Pair deleted and added files in tree diffs into renames
+	DetectCopies    bool          // Also find added files copied from deleted or modified ones
+	RenameThreshold float64       // Similarity needed for a rename or copy; DefaultRenameThreshold if zero
+	Binary          bool          // Include binary patches for binary files rather than only saying they differ
 }
 
 // DefaultDiffOptions provides default diff options
 var DefaultDiffOptions = DiffOptions{
 	ContextLines: 3,
 	Semantic:     false,
+	Algorithm:    DiffMyers,
 }
 
 // Diff compares two items and returns the differences
@@ -87,14 +106,11 @@ func (r *Repository) Diff(itemA, itemB string, options *DiffOptions) ([]DiffResu
 				}
 
 				// Compare the files
-				chunks := diffContent(string(file2Content), string(file1Content), options.ContextLines)
-				return []DiffResult{
-					{
-						OldPath: itemA,
-						NewPath: itemA,
-						Chunks:  chunks,
-					},
-				}, nil
+				result, err := r.diffBlobs(itemA, itemA, file2Content, file1Content, options)
+				if err != nil {
+					return nil, err
+				}
+				return []DiffResult{result}, nil
 			}
 		}
 
@@ -127,14 +143,11 @@ func (r *Repository) Diff(itemA, itemB string, options *DiffOptions) ([]DiffResu
 				}
 
 				// Compare the files
-				chunks := diffContent(string(file1Content), string(file2Content), options.ContextLines)
-				return []DiffResult{
-					{
-						OldPath: itemB,
-						NewPath: itemB,
-						Chunks:  chunks,
-					},
-				}, nil
+				result, err := r.diffBlobs(itemB, itemB, file1Content, file2Content, options)
+				if err != nil {
+					return nil, err
+				}
+				return []DiffResult{result}, nil
 			}
 		}
 
@@ -171,30 +184,12 @@ func (r *Repository) DiffFiles(file1Path, file2Path string, options *DiffOptions
 		return nil, fmt.Errorf("failed to read file %s: %w", file2Path, err)
 	}
 
-	// Perform diff based on options
-	var chunks []DiffChunk
-	if options.Semantic && (isCodeFile(file1Path) || isCodeFile(file2Path)) {
-		// Use semantic diff for code files
-		semanticChunks, err := r.semanticDiffContent(string(file1Content), string(file2Content), options.ContextLines)
-		if err == nil {
-			chunks = semanticChunks
-		} else {
-			// Fall back to regular diff if semantic diff fails
-			chunks = diffContent(string(file1Content), string(file2Content), options.ContextLines)
-		}
-	} else {
-		// Use regular diff for non-code files
-		chunks = diffContent(string(file1Content), string(file2Content), options.ContextLines)
+	// Perform diff based on options and the attributes of the second file
+	result, err := r.diffBlobs(file1Path, file2Path, file1Content, file2Content, options)
+	if err != nil {
+		return nil, err
 	}
-
-	// Return the diff result
-	return []DiffResult{
-		{
-			OldPath: file1Path,
-			NewPath: file2Path,
-			Chunks:  chunks,
-		},
-	}, nil
+	return []DiffResult{result}, nil
 }
 
 // DiffWorkingTree compares a commit with the working tree
@@ -212,71 +207,90 @@ func (r *Repository) DiffWorkingTree(commit string, options *DiffOptions) ([]Dif
 	// Create a list to hold the diff results
 	results := []DiffResult{}
 
-	// Compare each file in the tree with the working tree
-	for path, entry := range tree.Entries {
-		// Get the content from the blob
-		blobContent, err := r.readObject(entry.ObjID)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read blob %s: %w", entry.ObjID, err)
+	// Compare the working tree with the commit, reading only files whose
+	// cached stat data doesn't show them unchanged
+	seen := make(map[string]bool)
+	err = r.walkWorkTree(walkOptions{}, func(path string, d fs.DirEntry) error {
+		entry, inTree := tree.Entries[path]
+		if (!inTree && !r.isInIndex(path)) || r.State.SkipWorktree[path] {
+			return nil
 		}
+		seen[path] = true
 
-		// Try to read the file from the working tree
-		workingContent, err := r.readWorkingFile(path)
+		info, err := d.Info()
 		if err != nil {
-			// File doesn't exist in working tree, consider it deleted
-			chunks := []DiffChunk{
-				{
-					OldStart:  1,
-					OldLength: len(bytes.Split(blobContent, []byte{'\n'})),
-					NewStart:  0,
-					NewLength: 0,
-					Lines:     prefixLines(string(blobContent), "-"),
-				},
-			}
-			results = append(results, DiffResult{
-				OldPath: path,
-				NewPath: "/dev/null",
-				Chunks:  chunks,
-			})
-			continue
+			return err
 		}
-
-		// File exists in both commit and working tree, diff them
-		if !bytes.Equal(blobContent, workingContent) {
-			chunks := diffContent(string(blobContent), string(workingContent), options.ContextLines)
-			results = append(results, DiffResult{
-				OldPath: path,
-				NewPath: path,
-				Chunks:  chunks,
-			})
+		objID, _, err := r.cachedWorkingFileID(path, info)
+		if err != nil {
+			return err
 		}
-	}
 
-	// Check for new files in working tree
-	for path := range r.State.WorkTree {
-		if _, ok := tree.Entries[path]; !ok {
+		if !inTree {
 			// File exists in working tree but not in commit, consider it new
 			workingContent, err := r.readWorkingFile(path)
 			if err != nil {
-				continue // Shouldn't happen, but skip if it does
+				return nil // Removed while diffing, skip it
 			}
 
-			chunks := []DiffChunk{
-				{
-					OldStart:  0,
-					OldLength: 0,
-					NewStart:  1,
-					NewLength: len(bytes.Split(workingContent, []byte{'\n'})),
-					Lines:     prefixLines(string(workingContent), "+"),
-				},
+			result, err := r.diffBlobs("/dev/null", path, nil, workingContent, options)
+			if err != nil {
+				return err
 			}
-			results = append(results, DiffResult{
-				OldPath: "/dev/null",
-				NewPath: path,
-				Chunks:  chunks,
-			})
+			results = append(results, result)
+			return nil
+		}
+
+		if objID == entry.ObjID {
+			return nil
+		}
+
+		// File exists in both commit and working tree, diff them
+		blobContent, err := r.readObject(entry.ObjID)
+		if err != nil {
+			return fmt.Errorf("failed to read blob %s: %w", entry.ObjID, err)
+		}
+		workingContent, err := r.readWorkingFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read file %s: %w", path, err)
+		}
+
+		result, err := r.diffBlobs(path, path, blobContent, workingContent, options)
+		if err != nil {
+			return err
+		}
+		results = append(results, result)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk directory: %w", err)
+	}
+
+	// Files from the commit that weren't found have been deleted
+	deleted := []string{}
+	for path := range tree.Entries {
+		if !seen[path] && !r.State.SkipWorktree[path] {
+			deleted = append(deleted, path)
 		}
 	}
+	sort.Strings(deleted)
+
+	for _, path := range deleted {
+		entry := tree.Entries[path]
+
+		// Get the content from the blob
+		blobContent, err := r.readObject(entry.ObjID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read blob %s: %w", entry.ObjID, err)
+		}
+
+		// File doesn't exist in working tree, consider it deleted
+		result, err := r.diffBlobs(path, "/dev/null", blobContent, nil, options)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
 
 	return results, nil
 }
@@ -324,11 +338,29 @@ func (r *Repository) diffTrees(treeA, treeB *TreeObject, options *DiffOptions) (
 		allPaths[path] = true
 	}
 
+	// With rename detection, deleted and added files are set aside to be
+	// paired up first
+	detect := options.DetectRenames || options.DetectCopies
+	deleted, modified, added := []string{}, []string{}, []string{}
+
 	// Compare each file in the trees
 	for path := range allPaths {
 		entryA, okA := treeA.Entries[path]
 		entryB, okB := treeB.Entries[path]
 
+		if detect {
+			switch {
+			case okA && !okB:
+				deleted = append(deleted, path)
+				continue
+			case !okA && okB:
+				added = append(added, path)
+				continue
+			case entryA.ObjID != entryB.ObjID:
+				modified = append(modified, path)
+			}
+		}
+
 		// File deleted (exists in A but not B)
 		if okA && !okB {
 			blobContent, err := r.readObject(entryA.ObjID)
@@ -336,20 +368,11 @@ func (r *Repository) diffTrees(treeA, treeB *TreeObject, options *DiffOptions) (
 				return nil, fmt.Errorf("failed to read blob %s: %w", entryA.ObjID, err)
 			}
 
-			chunks := []DiffChunk{
-				{
-					OldStart:  1,
-					OldLength: len(bytes.Split(blobContent, []byte{'\n'})),
-					NewStart:  0,
-					NewLength: 0,
-					Lines:     prefixLines(string(blobContent), "-"),
-				},
+			result, err := r.diffBlobs(path, "/dev/null", blobContent, nil, options)
+			if err != nil {
+				return nil, err
 			}
-			results = append(results, DiffResult{
-				OldPath: path,
-				NewPath: "/dev/null",
-				Chunks:  chunks,
-			})
+			results = append(results, result)
 			continue
 		}
 
@@ -360,20 +383,11 @@ func (r *Repository) diffTrees(treeA, treeB *TreeObject, options *DiffOptions) (
 				return nil, fmt.Errorf("failed to read blob %s: %w", entryB.ObjID, err)
 			}
 
-			chunks := []DiffChunk{
-				{
-					OldStart:  0,
-					OldLength: 0,
-					NewStart:  1,
-					NewLength: len(bytes.Split(blobContent, []byte{'\n'})),
-					Lines:     prefixLines(string(blobContent), "+"),
-				},
+			result, err := r.diffBlobs("/dev/null", path, nil, blobContent, options)
+			if err != nil {
+				return nil, err
 			}
-			results = append(results, DiffResult{
-				OldPath: "/dev/null",
-				NewPath: path,
-				Chunks:  chunks,
-			})
+			results = append(results, result)
 			continue
 		}
 
@@ -389,72 +403,257 @@ func (r *Repository) diffTrees(treeA, treeB *TreeObject, options *DiffOptions) (
 				return nil, fmt.Errorf("failed to read blob %s: %w", entryB.ObjID, err)
 			}
 
-			// If using semantic diff and appropriate file type, use semantic diff
-			if options.Semantic && isCodeFile(path) {
-				chunks, err := r.semanticDiffContent(string(blobContentA), string(blobContentB), options.ContextLines)
-				if err != nil {
-					// Fall back to regular diff if semantic diff fails
-					chunks = diffContent(string(blobContentA), string(blobContentB), options.ContextLines)
-				}
-				results = append(results, DiffResult{
-					OldPath: path,
-					NewPath: path,
-					Chunks:  chunks,
-				})
-			} else {
-				// Use regular text diff
-				chunks := diffContent(string(blobContentA), string(blobContentB), options.ContextLines)
-				results = append(results, DiffResult{
-					OldPath: path,
-					NewPath: path,
-					Chunks:  chunks,
-				})
+			result, err := r.diffBlobs(path, path, blobContentA, blobContentB, options)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, result)
+		}
+	}
+
+	if !detect {
+		return results, nil
+	}
+
+	pairs, err := r.findRenames(treeA, treeB, deleted, modified, added, options)
+	if err != nil {
+		return nil, err
+	}
+	renamed := make(map[string]bool, len(pairs))
+	for _, pair := range pairs {
+		if !pair.copied {
+			renamed[pair.source] = true
+		}
+	}
+	for _, path := range deleted {
+		if renamed[path] {
+			continue
+		}
+		blobContent, err := r.readObject(treeA.Entries[path].ObjID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read blob %s: %w", treeA.Entries[path].ObjID, err)
+		}
+		result, err := r.diffBlobs(path, "/dev/null", blobContent, nil, options)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	for _, path := range added {
+		pair, paired := pairs[path]
+		if paired && treeA.Entries[pair.source].ObjID == treeB.Entries[path].ObjID {
+			// Moved or copied unchanged: there is nothing to show but the header
+			results = append(results, DiffResult{OldPath: pair.source, NewPath: path, Renamed: !pair.copied, Copied: pair.copied, Similarity: 1})
+			continue
+		}
+
+		var oldContent []byte
+		oldPath := "/dev/null"
+		if paired {
+			oldPath = pair.source
+			if oldContent, err = r.readObject(treeA.Entries[oldPath].ObjID); err != nil {
+				return nil, fmt.Errorf("failed to read blob %s: %w", treeA.Entries[oldPath].ObjID, err)
 			}
 		}
+		newContent, err := r.readObject(treeB.Entries[path].ObjID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read blob %s: %w", treeB.Entries[path].ObjID, err)
+		}
+		result, err := r.diffBlobs(oldPath, path, oldContent, newContent, options)
+		if err != nil {
+			return nil, err
+		}
+		if paired {
+			result.Renamed = !pair.copied
+			result.Copied = pair.copied
+			result.Similarity = pair.similarity
+		}
+		results = append(results, result)
 	}
 
 	return results, nil
 }
 
-// readWorkingFile reads a file from the working tree
+// diffBlobs compares two versions of a file as its attributes ask. Binary
+// files get no chunks, a diff driver's textconv command converts both
+// versions to text first, and semantic diffs only apply to files with
+// semantic diffing enabled. Files without a "diff" attribute are binary
+// when either version has a NUL byte near its start. A side named
+// "/dev/null" is missing.
+func (r *Repository) diffBlobs(oldPath, newPath string, oldContent, newContent []byte, options *DiffOptions) (DiffResult, error) {
+	result := DiffResult{OldPath: oldPath, NewPath: newPath}
+
+	path := newPath
+	if path == "/dev/null" {
+		path = oldPath
+	}
+	attrs, err := r.Attributes(path)
+	if err != nil {
+		return result, err
+	}
+	if attrs.binaryDiff() {
+		return binaryDiffResult(result, oldContent, newContent, options), nil
+	}
+
+	oldText, newText := oldContent, newContent
+	if driver := attrs.diffDriver(); driver != "" {
+		if command, ok := r.ConfigValue(fmt.Sprintf("diff \"%s\"", driver), "textconv"); ok && command != "" {
+			if oldPath != "/dev/null" {
+				if oldText, err = runTextconv(command, oldContent); err != nil {
+					return result, fmt.Errorf("diff driver %s failed for %s: %w", driver, oldPath, err)
+				}
+			}
+			if newPath != "/dev/null" {
+				if newText, err = runTextconv(command, newContent); err != nil {
+					return result, fmt.Errorf("diff driver %s failed for %s: %w", driver, newPath, err)
+				}
+			}
+		}
+	}
+	if attrs["diff"] != AttrSet && (isBinaryContent(oldText) || isBinaryContent(newText)) {
+		return binaryDiffResult(result, oldContent, newContent, options), nil
+	}
+	oldContent, newContent = oldText, newText
+
+	switch {
+	case oldPath == "/dev/null":
+		lines := prefixLines(string(newContent), "+")
+		result.Chunks = []DiffChunk{
+			{
+				OldStart:  0,
+				OldLength: 0,
+				NewStart:  1,
+				NewLength: len(lines),
+				Lines:     markMissingNewline(lines, string(newContent)),
+			},
+		}
+	case newPath == "/dev/null":
+		lines := prefixLines(string(oldContent), "-")
+		result.Chunks = []DiffChunk{
+			{
+				OldStart:  1,
+				OldLength: len(lines),
+				NewStart:  0,
+				NewLength: 0,
+				Lines:     markMissingNewline(lines, string(oldContent)),
+			},
+		}
+	case options.Semantic && attrs.semantic(path):
+		chunks, err := r.semanticDiffContent(string(oldContent), string(newContent), options)
+		if err != nil {
+			// Fall back to regular diff if semantic diff fails
+			chunks = diffContent(string(oldContent), string(newContent), options)
+		}
+		result.Chunks = chunks
+	default:
+		result.Chunks = diffContent(string(oldContent), string(newContent), options)
+	}
+
+	return result, nil
+}
+
+// binaryDiffResult fills in a result for a binary file: the IDs and sizes
+// of both versions, and the binary patch if the options ask for one
+func binaryDiffResult(result DiffResult, oldContent, newContent []byte, options *DiffOptions) DiffResult {
+	result.Binary = true
+	if result.OldPath != "/dev/null" {
+		result.OldID = hashContent(oldContent)
+		result.OldSize = len(oldContent)
+	}
+	if result.NewPath != "/dev/null" {
+		result.NewID = hashContent(newContent)
+		result.NewSize = len(newContent)
+	}
+	if options.Binary {
+		result.Patch = makeBinaryPatch(oldContent, newContent)
+	}
+	return result
+}
+
+// runTextconv converts content to text with a shell command reading it on stdin
+func runTextconv(command string, content []byte) ([]byte, error) {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdin = bytes.NewReader(content)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return output, nil
+}
+
+// readWorkingFile reads a file from the working tree in the form it is
+// stored in, with line endings normalised as its attributes ask
 func (r *Repository) readWorkingFile(path string) ([]byte, error) {
 	absPath := filepath.Join(r.Path, path)
 	content, err := os.ReadFile(absPath)
 	if err != nil {
 		return nil, err
 	}
-	return content, nil
+	attrs, err := r.Attributes(path)
+	if err != nil {
+		return nil, err
+	}
+	return attrs.clean(content), nil
 }
 
-// diffContent compares two strings line by line and returns the differences
-// This is a simple implementation of the Myers diff algorithm
-func diffContent(oldContent, newContent string, contextLines int) []DiffChunk {
+// noNewlineMarker follows the last line of a file that doesn't end in a
+// newline in a diff
+const noNewlineMarker = `\ No newline at end of file`
+
+// missingNewline is appended to a last line without a newline while lines
+// are matched, so that it differs from the same line with one
+const missingNewline = "\x00" + noNewlineMarker
+
+// diffContent compares two strings line by line and returns the differences,
+// matching lines up with the algorithm of the options. A last line without
+// a newline is followed by noNewlineMarker.
+func diffContent(oldContent, newContent string, options *DiffOptions) []DiffChunk {
 	// Split content into lines
 	oldLines := strings.Split(oldContent, "\n")
 	newLines := strings.Split(newContent, "\n")
 
-	// Remove trailing empty line if present
+	// Remove trailing empty line if present, or flag the last line as
+	// missing its newline
 	if len(oldLines) > 0 && oldLines[len(oldLines)-1] == "" {
 		oldLines = oldLines[:len(oldLines)-1]
+	} else {
+		oldLines[len(oldLines)-1] += missingNewline
 	}
 	if len(newLines) > 0 && newLines[len(newLines)-1] == "" {
 		newLines = newLines[:len(newLines)-1]
+	} else {
+		newLines[len(newLines)-1] += missingNewline
 	}
 
-	// Find the longest common subsequence (LCS)
-	lcs := longestCommonSubsequence(oldLines, newLines)
+	// Find the lines both versions keep
+	lcs := matchLines(oldLines, newLines, options.Algorithm)
 
 	// Convert LCS to edit script
 	edits := convertToEdits(oldLines, newLines, lcs)
 
 	// Group edits into chunks with context
-	chunks := groupEditsIntoChunks(oldLines, newLines, edits, contextLines)
+	chunks := groupEditsIntoChunks(oldLines, newLines, edits, options.ContextLines)
+
+	// Put the markers on lines of their own
+	for i, chunk := range chunks {
+		lines := make([]string, 0, len(chunk.Lines)+2)
+		for _, line := range chunk.Lines {
+			if trimmed, ok := strings.CutSuffix(line, missingNewline); ok {
+				lines = append(lines, trimmed, noNewlineMarker)
+			} else {
+				lines = append(lines, line)
+			}
+		}
+		chunks[i].Lines = lines
+	}
 
 	return chunks
 }
 
 // semanticDiffContent performs a semantic diff on code content
-func (r *Repository) semanticDiffContent(oldContent, newContent string, contextLines int) ([]DiffChunk, error) {
+func (r *Repository) semanticDiffContent(oldContent, newContent string, options *DiffOptions) ([]DiffChunk, error) {
 	// First check if there's a semantic difference using the semantic kernel
 	similarity, _ := r.SemanticKernel.SemanticDiff(oldContent, newContent)
 
@@ -475,7 +674,7 @@ func (r *Repository) semanticDiffContent(oldContent, newContent string, contextL
 	}
 
 	// For less similar code, fall back to regular diff but add semantic annotations
-	chunks := diffContent(oldContent, newContent, contextLines)
+	chunks := diffContent(oldContent, newContent, options)
 
 	// Add semantic analysis as first chunk
 	analysisChunk := DiffChunk{
@@ -493,44 +692,6 @@ func (r *Repository) semanticDiffContent(oldContent, newContent string, contextL
 	return append([]DiffChunk{analysisChunk}, chunks...), nil
 }
 
-// longestCommonSubsequence finds the longest common subsequence of lines
-func longestCommonSubsequence(a, b []string) [][]int {
-	// Create a matrix of LCS lengths
-	m, n := len(a), len(b)
-	dp := make([][]int, m+1)
-	for i := range dp {
-		dp[i] = make([]int, n+1)
-	}
-
-	// Fill the matrix
-	for i := 1; i <= m; i++ {
-		for j := 1; j <= n; j++ {
-			if a[i-1] == b[j-1] {
-				dp[i][j] = dp[i-1][j-1] + 1
-			} else {
-				dp[i][j] = max(dp[i-1][j], dp[i][j-1])
-			}
-		}
-	}
-
-	// Build the LCS
-	lcs := [][]int{}
-	i, j := m, n
-	for i > 0 && j > 0 {
-		if a[i-1] == b[j-1] {
-			lcs = append([][]int{{i - 1, j - 1}}, lcs...)
-			i--
-			j--
-		} else if dp[i-1][j] > dp[i][j-1] {
-			i--
-		} else {
-			j--
-		}
-	}
-
-	return lcs
-}
-
 // Edit represents an edit operation (insert, delete, or unchanged)
 type Edit struct {
 	Type      string // "insert", "delete", or "unchanged"
@@ -539,52 +700,40 @@ type Edit struct {
 	LineValue string // The line content
 }
 
-// convertToEdits converts an LCS to a list of edit operations
+// convertToEdits converts an LCS to a list of edit operations. Between
+// common lines, deletions come before insertions.
 func convertToEdits(oldLines, newLines []string, lcs [][]int) []Edit {
 	edits := []Edit{}
 	oldIdx, newIdx := 0, 0
 
-	// Map LCS indices for easier lookup
-	lcsMap := make(map[int]map[int]bool)
-	for _, pair := range lcs {
-		if lcsMap[pair[0]] == nil {
-			lcsMap[pair[0]] = make(map[int]bool)
-		}
-		lcsMap[pair[0]][pair[1]] = true
-	}
-
-	// Process all lines and generate edits
-	for oldIdx < len(oldLines) || newIdx < len(newLines) {
-		// Common line (part of LCS)
-		if oldIdx < len(oldLines) && newIdx < len(newLines) &&
-			lcsMap[oldIdx] != nil && lcsMap[oldIdx][newIdx] {
-			edits = append(edits, Edit{
-				Type:      "unchanged",
-				OldIndex:  oldIdx,
-				NewIndex:  newIdx,
-				LineValue: oldLines[oldIdx],
-			})
-			oldIdx++
-			newIdx++
-		} else if oldIdx < len(oldLines) && (newIdx >= len(newLines) ||
-			(oldIdx+1 < len(oldLines) && newIdx < len(newLines) &&
-				lcsMap[oldIdx+1] != nil && lcsMap[oldIdx+1][newIdx])) {
-			// Line deleted from old content
+	for _, pair := range append(lcs, []int{len(oldLines), len(newLines)}) {
+		// Lines deleted from old content
+		for ; oldIdx < pair[0]; oldIdx++ {
 			edits = append(edits, Edit{
 				Type:      "delete",
 				OldIndex:  oldIdx,
 				NewIndex:  -1,
 				LineValue: oldLines[oldIdx],
 			})
-			oldIdx++
-		} else if newIdx < len(newLines) {
-			// Line added in new content
+		}
+		// Lines added in new content
+		for ; newIdx < pair[1]; newIdx++ {
 			edits = append(edits, Edit{
 				Type:      "insert",
 				OldIndex:  -1,
 				NewIndex:  newIdx,
 				LineValue: newLines[newIdx],
 			})
+		}
+		// Common line (part of LCS)
+		if oldIdx < len(oldLines) && newIdx < len(newLines) {
+			edits = append(edits, Edit{
+				Type:      "unchanged",
+				OldIndex:  oldIdx,
+				NewIndex:  newIdx,
+				LineValue: oldLines[oldIdx],
+			})
+			oldIdx++
 			newIdx++
 		}
 	}
@@ -608,10 +757,10 @@ func groupEditsIntoChunks(_ []string, _ []string, edits []Edit, contextLines int
 		Lines:     []string{},
 	}
 
-	// Unroll edits into chunks
+	// Unroll edits into chunks, counting the old and new lines before each edit
+	oldPos, newPos := 0, 0
 	for i, edit := range edits {
 		isChange := edit.Type != "unchanged"
-		isFirstOrLast := i == 0 || i == len(edits)-1
 		isNearChange := false
 
 		// Check if this is near a change
@@ -622,21 +771,12 @@ func groupEditsIntoChunks(_ []string, _ []string, edits []Edit, contextLines int
 			}
 		}
 
-		// If this is a change, first/last line, or near a change, include it
-		if isChange || isFirstOrLast || isNearChange {
+		// If this is a change or near a change, include it
+		if isChange || isNearChange {
 			// If starting a new chunk
 			if currentChunk.OldStart == -1 {
-				if edit.OldIndex != -1 {
-					currentChunk.OldStart = edit.OldIndex + 1 // 1-based indexing for output
-				} else {
-					currentChunk.OldStart = 0
-				}
-
-				if edit.NewIndex != -1 {
-					currentChunk.NewStart = edit.NewIndex + 1 // 1-based indexing for output
-				} else {
-					currentChunk.NewStart = 0
-				}
+				currentChunk.OldStart = oldPos + 1 // 1-based indexing for output
+				currentChunk.NewStart = newPos + 1
 			}
 
 			// Add line to chunk
@@ -656,7 +796,7 @@ func groupEditsIntoChunks(_ []string, _ []string, edits []Edit, contextLines int
 			currentChunk.Lines = append(currentChunk.Lines, prefix+edit.LineValue)
 		} else if len(currentChunk.Lines) > 0 {
 			// Not including this line and we have a chunk, so finalize it
-			chunks = append(chunks, currentChunk)
+			chunks = append(chunks, finishChunk(currentChunk))
 			currentChunk = DiffChunk{
 				OldStart:  -1,
 				OldLength: 0,
@@ -665,21 +805,71 @@ func groupEditsIntoChunks(_ []string, _ []string, edits []Edit, contextLines int
 				Lines:     []string{},
 			}
 		}
+
+		if edit.Type != "insert" {
+			oldPos++
+		}
+		if edit.Type != "delete" {
+			newPos++
+		}
 	}
 
 	// Add the last chunk if it has lines
 	if len(currentChunk.Lines) > 0 {
-		chunks = append(chunks, currentChunk)
+		chunks = append(chunks, finishChunk(currentChunk))
 	}
 
 	return chunks
 }
 
+// finishChunk makes the start of an empty side of a chunk the line before it
+func finishChunk(chunk DiffChunk) DiffChunk {
+	if chunk.OldLength == 0 {
+		chunk.OldStart--
+	}
+	if chunk.NewLength == 0 {
+		chunk.NewStart--
+	}
+	return chunk
+}
+
 // FormatDiff formats a diff result into a string
 func FormatDiff(results []DiffResult) string {
 	var buf strings.Builder
 
 	for _, result := range results {
+		if result.Renamed || result.Copied {
+			kind := "rename"
+			if result.Copied {
+				kind = "copy"
+			}
+			buf.WriteString(fmt.Sprintf("similarity index %d%%\n", int(math.Round(result.Similarity*100))))
+			buf.WriteString(fmt.Sprintf("%s from %s\n%s to %s\n", kind, result.OldPath, kind, result.NewPath))
+			if len(result.Chunks) == 0 && !result.Binary {
+				buf.WriteString("\n")
+				continue
+			}
+		}
+
+		if result.Patch != nil {
+			buf.WriteString(fmt.Sprintf("index %s..%s\n", binaryPatchID(result.OldID), binaryPatchID(result.NewID)))
+			buf.WriteString(fmt.Sprintf("--- %s\n+++ %s\n", patchHeaderPath(result.OldPath, "a/"), patchHeaderPath(result.NewPath, "b/")))
+			formatBinaryPatch(&buf, result.Patch)
+			continue
+		}
+
+		if result.Binary {
+			oldPath, newPath := "a/"+result.OldPath, "b/"+result.NewPath
+			if result.OldPath == "/dev/null" {
+				oldPath = result.OldPath
+			}
+			if result.NewPath == "/dev/null" {
+				newPath = result.NewPath
+			}
+			buf.WriteString(fmt.Sprintf("Binary files %s and %s differ\n\n", oldPath, newPath))
+			continue
+		}
+
 		// File header
 		if result.OldPath == "/dev/null" {
 			buf.WriteString("--- /dev/null\n")
@@ -712,6 +902,100 @@ func FormatDiff(results []DiffResult) string {
 	return buf.String()
 }
 
+// diffStatWidth is the most +/- characters in a line of FormatDiffStat
+const diffStatWidth = 40
+
+// FormatDiffStat summarises diff results with a line per file giving the
+// number of lines changed and a bar of +/- characters, scaled down when a
+// file has more changes than fit. Binary files show the sizes and short
+// object IDs of both versions instead. A last line totals it all up.
+func FormatDiffStat(results []DiffResult) string {
+	if len(results) == 0 {
+		return ""
+	}
+
+	names := make([]string, len(results))
+	added := make([]int, len(results))
+	removed := make([]int, len(results))
+	nameWidth, countWidth, most := 0, 1, 0
+	totalAdded, totalRemoved := 0, 0
+	for i, result := range results {
+		names[i] = diffResultPath(result)
+		if result.Renamed || result.Copied {
+			names[i] = fmt.Sprintf("%s => %s", result.OldPath, result.NewPath)
+		}
+		for _, chunk := range result.Chunks {
+			for _, line := range chunk.Lines {
+				if strings.HasPrefix(line, "+") {
+					added[i]++
+				} else if strings.HasPrefix(line, "-") {
+					removed[i]++
+				}
+			}
+		}
+		nameWidth = max(nameWidth, len(names[i]))
+		countWidth = max(countWidth, len(fmt.Sprint(added[i]+removed[i])))
+		most = max(most, added[i]+removed[i])
+		totalAdded += added[i]
+		totalRemoved += removed[i]
+	}
+
+	var buf strings.Builder
+	for i, result := range results {
+		if result.Binary {
+			buf.WriteString(fmt.Sprintf(" %-*s | Bin %d -> %d bytes (%s -> %s)\n", nameWidth, names[i],
+				result.OldSize, result.NewSize, statID(result.OldID), statID(result.NewID)))
+			continue
+		}
+		plus, minus := added[i], removed[i]
+		if most > diffStatWidth {
+			// Scale the bar, keeping at least one character for any change
+			plus = (added[i]*diffStatWidth + most - 1) / most
+			minus = (removed[i]*diffStatWidth + most - 1) / most
+		}
+		line := fmt.Sprintf(" %-*s | %*d %s%s", nameWidth, names[i], countWidth, added[i]+removed[i],
+			strings.Repeat("+", plus), strings.Repeat("-", minus))
+		buf.WriteString(strings.TrimRight(line, " ") + "\n")
+	}
+
+	buf.WriteString(fmt.Sprintf(" %d %s changed", len(results), plural(len(results), "file")))
+	if totalAdded > 0 {
+		buf.WriteString(fmt.Sprintf(", %d %s(+)", totalAdded, plural(totalAdded, "insertion")))
+	}
+	if totalRemoved > 0 {
+		buf.WriteString(fmt.Sprintf(", %d %s(-)", totalRemoved, plural(totalRemoved, "deletion")))
+	}
+	buf.WriteString("\n")
+
+	return buf.String()
+}
+
+// statID returns the short form of an object ID in a diff stat, zeros for
+// a missing version
+func statID(objID string) string {
+	if objID == "" {
+		return strings.Repeat("0", 8)
+	}
+	return shortID(objID)
+}
+
+// plural adds an s to a noun unless there is exactly one
+func plural(n int, noun string) string {
+	if n == 1 {
+		return noun
+	}
+	return noun + "s"
+}
+
+// markMissingNewline appends noNewlineMarker to the lines of a whole file
+// when its content doesn't end in a newline
+func markMissingNewline(lines []string, content string) []string {
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		return append(lines, noNewlineMarker)
+	}
+	return lines
+}
+
 // prefixLines adds a prefix to each line in a string
 func prefixLines(content, prefix string) []string {
 	lines := strings.Split(content, "\n")
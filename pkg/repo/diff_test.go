@@ -0,0 +1,201 @@
+package repo
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// lcsLength is the length of the longest common subsequence of a and b,
+// by dynamic programming
+func lcsLength(a, b []string) int {
+	row := make([]int, len(b)+1)
+	for i := range a {
+		diagonal := 0
+		for j := range b {
+			above := row[j+1]
+			if a[i] == b[j] {
+				row[j+1] = diagonal + 1
+			} else {
+				row[j+1] = max(row[j+1], row[j])
+			}
+			diagonal = above
+		}
+	}
+	return row[len(b)]
+}
+
+// checkMatches fails unless the matches pair up equal lines in order
+func checkMatches(t *testing.T, a, b []string, matches [][]int) {
+	t.Helper()
+	lastA, lastB := -1, -1
+	for _, pair := range matches {
+		if pair[0] <= lastA || pair[1] <= lastB || pair[0] >= len(a) || pair[1] >= len(b) || a[pair[0]] != b[pair[1]] {
+			t.Fatalf("Invalid match %v after (%d, %d) for %q and %q", pair, lastA, lastB, a, b)
+		}
+		lastA, lastB = pair[0], pair[1]
+	}
+}
+
+func TestMatchLines(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	randomLines := func(n, alphabet int) []string {
+		lines := make([]string, n)
+		for i := range lines {
+			lines[i] = string(rune('a' + random.Intn(alphabet)))
+		}
+		return lines
+	}
+
+	for i := 0; i < 2000; i++ {
+		a := randomLines(random.Intn(30), 1+random.Intn(6))
+		b := randomLines(random.Intn(30), 1+random.Intn(6))
+		if i%2 == 0 && len(a) > 0 {
+			// Mostly the same, with a few edits
+			b = append([]string{}, a...)
+			for edits := random.Intn(4); edits > 0 && len(b) > 0; edits-- {
+				at := random.Intn(len(b))
+				b = append(b[:at], append(randomLines(random.Intn(3), 8), b[at+1:]...)...)
+			}
+		}
+
+		myers := matchLines(a, b, DiffMyers)
+		checkMatches(t, a, b, myers)
+		if want := lcsLength(a, b); len(myers) != want {
+			t.Fatalf("Expected %d matches for %q and %q, got %v", want, a, b, myers)
+		}
+		checkMatches(t, a, b, matchLines(a, b, DiffHistogram))
+	}
+}
+
+func TestDiffHistogram(t *testing.T) {
+	// The histogram diff anchors on the line that is unique on both sides,
+	// even where matching the common braces instead would keep more lines
+	a := []string{"}", "}", "}", "main()"}
+	b := []string{"main()", "}", "}", "}"}
+	if matches := matchLines(a, b, DiffMyers); len(matches) != 3 {
+		t.Errorf("Expected Myers to keep the braces, got %v", matches)
+	}
+	if matches := matchLines(a, b, DiffHistogram); fmt.Sprint(matches) != "[[3 0]]" {
+		t.Errorf("Expected histogram to keep main(), got %v", matches)
+	}
+
+	chunks := diffContent("a\nb\nc\n", "a\nB\nc\n", &DiffOptions{ContextLines: 1, Algorithm: DiffHistogram})
+	if len(chunks) != 1 || strings.Join(chunks[0].Lines, ",") != " a,-b,+B, c" {
+		t.Errorf("Unexpected histogram chunks %+v", chunks)
+	}
+
+	if _, err := ParseDiffAlgorithm("patience"); err == nil {
+		t.Errorf("Expected an unknown algorithm to be refused")
+	}
+	if algorithm, err := ParseDiffAlgorithm("histogram"); err != nil || algorithm != DiffHistogram {
+		t.Errorf("Expected histogram, got %q (%v)", algorithm, err)
+	}
+}
+
+func TestDiffContentHunks(t *testing.T) {
+	tests := []struct {
+		old, new string
+		context  int
+		want     string // Each hunk as "-start,length +start,length" and its lines
+	}{
+		// Edits follow the matched lines, deletions before insertions
+		{"a\nb\nc\n", "c\n", 1, "-1,3 +1,1 -a -b  c"},
+		{"a\nb\n", "b\na\n", 0, "-0,0 +1,1 +b -2,1 +2,0 -b"},
+		// An empty side starts at the line before the hunk
+		{"a\nb\n", "a\nx\nb\n", 0, "-1,0 +2,1 +x"},
+		{"a\nx\nb\n", "a\nb\n", 0, "-2,1 +1,0 -x"},
+		{"b\n", "a\nb\n", 1, "-1,1 +1,2 +a  b"},
+		{"", "a\n", 3, "-0,0 +1,1 +a"},
+	}
+	for _, test := range tests {
+		var got []string
+		for _, chunk := range diffContent(test.old, test.new, &DiffOptions{ContextLines: test.context}) {
+			got = append(got, fmt.Sprintf("-%d,%d +%d,%d", chunk.OldStart, chunk.OldLength, chunk.NewStart, chunk.NewLength))
+			got = append(got, chunk.Lines...)
+		}
+		if strings.Join(got, " ") != test.want {
+			t.Errorf("Diff of %q and %q: expected %q, got %q", test.old, test.new, test.want, strings.Join(got, " "))
+		}
+	}
+
+	// Hunks rebuild the new content from the old with either algorithm
+	random := rand.New(rand.NewSource(2))
+	randomContent := func() string {
+		lines := make([]string, random.Intn(20))
+		for i := range lines {
+			lines[i] = string(rune('a' + random.Intn(4)))
+		}
+		return strings.Join(lines, "\n") + "\n"
+	}
+	for i := 0; i < 1000; i++ {
+		oldContent, newContent := randomContent(), randomContent()
+		for _, algorithm := range []DiffAlgorithm{DiffMyers, DiffHistogram} {
+			chunks := diffContent(oldContent, newContent, &DiffOptions{ContextLines: i % 4, Algorithm: algorithm})
+			if got, err := applyHunks(oldContent, chunks); err != nil || got != newContent {
+				t.Fatalf("Hunks %+v of %q turn it into %q, not %q (%v)", chunks, oldContent, got, newContent, err)
+			}
+		}
+	}
+}
+
+// generatedFile returns a synthetic source file of n lines with some
+// repetition, and a copy with edits every 100 lines on average
+func generatedFile(n int) (string, string) {
+	random := rand.New(rand.NewSource(42))
+	lines := make([]string, n)
+	for i := range lines {
+		switch i % 10 {
+		case 0:
+			lines[i] = fmt.Sprintf("func f%d() {", i)
+		case 9:
+			lines[i] = "}"
+		default:
+			lines[i] = fmt.Sprintf("\tx += %d", random.Intn(1000))
+		}
+	}
+	changed := []string{}
+	for _, line := range lines {
+		switch random.Intn(100) {
+		case 0:
+			continue
+		case 1:
+			changed = append(changed, "\t// inserted")
+		case 2:
+			line = "\tx -= 1"
+		}
+		changed = append(changed, line)
+	}
+	return strings.Join(lines, "\n") + "\n", strings.Join(changed, "\n") + "\n"
+}
+
+func benchmarkDiff(b *testing.B, n int, algorithm DiffAlgorithm) {
+	oldContent, newContent := generatedFile(n)
+	options := &DiffOptions{ContextLines: 3, Algorithm: algorithm}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		diffContent(oldContent, newContent, options)
+	}
+}
+
+func BenchmarkDiffMyers1k(b *testing.B)      { benchmarkDiff(b, 1000, DiffMyers) }
+func BenchmarkDiffMyers50k(b *testing.B)     { benchmarkDiff(b, 50000, DiffMyers) }
+func BenchmarkDiffHistogram1k(b *testing.B)  { benchmarkDiff(b, 1000, DiffHistogram) }
+func BenchmarkDiffHistogram50k(b *testing.B) { benchmarkDiff(b, 50000, DiffHistogram) }
+
+// BenchmarkDiffUnrelated50k diffs two files with no lines in common
+func BenchmarkDiffUnrelated50k(b *testing.B) {
+	oldLines, newLines := make([]string, 50000), make([]string, 50000)
+	for i := range oldLines {
+		oldLines[i] = fmt.Sprintf("old %d", i)
+		newLines[i] = fmt.Sprintf("new %d", i)
+	}
+	oldContent, newContent := strings.Join(oldLines, "\n"), strings.Join(newLines, "\n")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		diffContent(oldContent, newContent, &DefaultDiffOptions)
+	}
+}
@@ -0,0 +1,281 @@
+package repo
+
+import (
+	"fmt"
+)
+
+// DiffAlgorithm selects how the lines of two versions are matched up
+type DiffAlgorithm string
+
+const (
+	DiffMyers     DiffAlgorithm = "myers"     // Fewest added and removed lines, in linear space
+	DiffHistogram DiffAlgorithm = "histogram" // Matches rare lines first, which reads better for moved code
+)
+
+// histogramMaxChain is how often a line may occur in a region for the
+// histogram diff to match on it; regions with only commoner lines are left
+// to the Myers diff
+const histogramMaxChain = 64
+
+// ParseDiffAlgorithm returns the diff algorithm with the given name
+func ParseDiffAlgorithm(name string) (DiffAlgorithm, error) {
+	switch algorithm := DiffAlgorithm(name); algorithm {
+	case DiffMyers, DiffHistogram:
+		return algorithm, nil
+	}
+	return "", fmt.Errorf("unknown diff algorithm %q (use myers or histogram)", name)
+}
+
+// longestCommonSubsequence finds the longest common subsequence of lines,
+// as pairs of indexes into a and b
+func longestCommonSubsequence(a, b []string) [][]int {
+	return matchLines(a, b, DiffMyers)
+}
+
+// matchLines pairs up the lines of a and b that a diff keeps, in order, as
+// pairs of indexes into a and b
+func matchLines(a, b []string, algorithm DiffAlgorithm) [][]int {
+	// Compare small integers rather than strings
+	ids := make(map[string]int, len(a))
+	intern := func(lines []string) []int {
+		result := make([]int, len(lines))
+		for i, line := range lines {
+			id, ok := ids[line]
+			if !ok {
+				id = len(ids)
+				ids[line] = id
+			}
+			result[i] = id
+		}
+		return result
+	}
+	aIDs, bIDs := intern(a), intern(b)
+
+	matcher := &lineMatcher{a: aIDs, b: bIDs, matches: [][]int{}}
+	if algorithm == DiffHistogram {
+		matcher.histogram(0, len(aIDs), 0, len(bIDs))
+	} else {
+		matcher.myersUnique(0, len(aIDs), 0, len(bIDs))
+	}
+	return matcher.matches
+}
+
+// lineMatcher collects the matching lines of two sequences of line IDs.
+// Regions are diffed in order, so matches are appended in order.
+type lineMatcher struct {
+	a, b    []int
+	matches [][]int
+}
+
+// match records line i of a as matching line j of b
+func (m *lineMatcher) match(i, j int) {
+	m.matches = append(m.matches, []int{i, j})
+}
+
+// myersUnique runs the Myers diff on a region without the lines that occur
+// on one side only. They can never match, and leaving them out keeps the
+// diff of mostly different files fast.
+func (m *lineMatcher) myersUnique(aLo, aHi, bLo, bHi int) {
+	inA := make(map[int]bool, aHi-aLo)
+	for _, id := range m.a[aLo:aHi] {
+		inA[id] = true
+	}
+	inB := make(map[int]bool, bHi-bLo)
+	for _, id := range m.b[bLo:bHi] {
+		inB[id] = true
+	}
+
+	// Diff the lines found on both sides, remembering where they came from
+	sub := &lineMatcher{matches: [][]int{}}
+	aIndex, bIndex := []int{}, []int{}
+	for i := aLo; i < aHi; i++ {
+		if inB[m.a[i]] {
+			sub.a = append(sub.a, m.a[i])
+			aIndex = append(aIndex, i)
+		}
+	}
+	for j := bLo; j < bHi; j++ {
+		if inA[m.b[j]] {
+			sub.b = append(sub.b, m.b[j])
+			bIndex = append(bIndex, j)
+		}
+	}
+	sub.myers(0, len(sub.a), 0, len(sub.b))
+	for _, pair := range sub.matches {
+		m.match(aIndex[pair[0]], bIndex[pair[1]])
+	}
+}
+
+// myers matches up a region with the linear space variant of Myers' diff:
+// it finds the middle snake of an optimal edit path and recurses on both
+// sides of it
+func (m *lineMatcher) myers(aLo, aHi, bLo, bHi int) {
+	// Common lines at the start and end need no search
+	for aLo < aHi && bLo < bHi && m.a[aLo] == m.b[bLo] {
+		m.match(aLo, bLo)
+		aLo++
+		bLo++
+	}
+	suffix := 0
+	for aLo < aHi-suffix && bLo < bHi-suffix && m.a[aHi-suffix-1] == m.b[bHi-suffix-1] {
+		suffix++
+	}
+	aHi, bHi = aHi-suffix, bHi-suffix
+
+	// With one side empty, everything left is added or removed. Otherwise
+	// at least two edits are needed, so both sides of the snake are smaller.
+	if aLo < aHi && bLo < bHi {
+		x1, y1, x2, y2 := middleSnake(m.a[aLo:aHi], m.b[bLo:bHi])
+		m.myers(aLo, aLo+x1, bLo, bLo+y1)
+		m.myers(aLo+x1, aLo+x2, bLo+y1, bLo+y2)
+		m.myers(aLo+x2, aHi, bLo+y2, bHi)
+	}
+
+	for i := 0; i < suffix; i++ {
+		m.match(aHi+i, bHi+i)
+	}
+}
+
+// middleSnake finds the middle of an optimal edit path between a and b by
+// searching forwards from the start and backwards from the end at once. It
+// returns the start and end of a step of the path that is one edit
+// followed or preceded by common lines.
+func middleSnake(a, b []int) (int, int, int, int) {
+	n, m := len(a), len(b)
+	limit := (n + m + 1) / 2
+	offset := limit + 1
+
+	// forward[k] is the furthest x reached on diagonal k = x - y from the
+	// start; backward[c] the smallest y reached on diagonal c = k - delta
+	// from the end
+	forward := make([]int, 2*limit+3)
+	backward := make([]int, 2*limit+3)
+	backward[offset+1] = m
+	delta := n - m
+
+	for d := 0; d <= limit; d++ {
+		for k := -d; k <= d; k += 2 {
+			var px, x int
+			if k == -d || (k != d && forward[offset+k-1] < forward[offset+k+1]) {
+				px = forward[offset+k+1]
+				x = px
+			} else {
+				px = forward[offset+k-1]
+				x = px + 1
+			}
+			y := x - k
+			py := y
+			if d != 0 && x == px {
+				py = y - 1
+			}
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			forward[offset+k] = x
+
+			c := k - delta
+			if delta%2 != 0 && c >= -(d-1) && c <= d-1 && y >= backward[offset+c] {
+				return px, py, x, y
+			}
+		}
+
+		for c := -d; c <= d; c += 2 {
+			var py, y int
+			if c == -d || (c != d && backward[offset+c-1] > backward[offset+c+1]) {
+				py = backward[offset+c+1]
+				y = py
+			} else {
+				py = backward[offset+c-1]
+				y = py - 1
+			}
+			k := c + delta
+			x := y + k
+			px := x
+			if d != 0 && y == py {
+				px = x + 1
+			}
+			for x > 0 && y > 0 && a[x-1] == b[y-1] {
+				x--
+				y--
+			}
+			backward[offset+c] = y
+
+			if delta%2 == 0 && k >= -d && k <= d && x <= forward[offset+k] {
+				return x, y, px, py
+			}
+		}
+	}
+
+	// Not reached: the two searches always meet
+	return 0, 0, n, m
+}
+
+// histogram matches up a region by finding the longest run of common lines
+// containing the line that occurs least often in the region, then recursing
+// on both sides of it. Unique lines anchor the diff as in a patience diff,
+// and the Myers diff takes over in regions made of common lines.
+func (m *lineMatcher) histogram(aLo, aHi, bLo, bHi int) {
+	for aLo < aHi && bLo < bHi && m.a[aLo] == m.b[bLo] {
+		m.match(aLo, bLo)
+		aLo++
+		bLo++
+	}
+	suffix := 0
+	for aLo < aHi-suffix && bLo < bHi-suffix && m.a[aHi-suffix-1] == m.b[bHi-suffix-1] {
+		suffix++
+	}
+	aHi, bHi = aHi-suffix, bHi-suffix
+
+	if aLo < aHi && bLo < bHi {
+		// Where each line of the region occurs on the a side
+		occurrences := make(map[int][]int)
+		for i := aLo; i < aHi; i++ {
+			occurrences[m.a[i]] = append(occurrences[m.a[i]], i)
+		}
+
+		bestCount, bestA, bestB, bestLength := histogramMaxChain+1, 0, 0, 0
+		for j := bLo; j < bHi; {
+			positions := occurrences[m.b[j]]
+			next := j + 1
+			if len(positions) == 0 || len(positions) > bestCount {
+				j = next
+				continue
+			}
+			for _, i := range positions {
+				// Extend the match both ways, tracking its rarest line
+				aStart, bStart, aEnd, bEnd := i, j, i+1, j+1
+				count := len(positions)
+				for aStart > aLo && bStart > bLo && m.a[aStart-1] == m.b[bStart-1] {
+					aStart--
+					bStart--
+					count = min(count, len(occurrences[m.a[aStart]]))
+				}
+				for aEnd < aHi && bEnd < bHi && m.a[aEnd] == m.b[bEnd] {
+					count = min(count, len(occurrences[m.a[aEnd]]))
+					aEnd++
+					bEnd++
+				}
+				if count < bestCount || (count == bestCount && aEnd-aStart > bestLength) {
+					bestCount, bestA, bestB, bestLength = count, aStart, bStart, aEnd-aStart
+				}
+				next = max(next, bEnd)
+			}
+			j = next
+		}
+
+		if bestCount > histogramMaxChain {
+			m.myersUnique(aLo, aHi, bLo, bHi)
+		} else {
+			m.histogram(aLo, bestA, bLo, bestB)
+			for i := 0; i < bestLength; i++ {
+				m.match(bestA+i, bestB+i)
+			}
+			m.histogram(bestA+bestLength, aHi, bestB+bestLength, bHi)
+		}
+	}
+
+	for i := 0; i < suffix; i++ {
+		m.match(aHi+i, bHi+i)
+	}
+}
@@ -0,0 +1,357 @@
+package repo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultKitFSMonitorSocket is the socket the fsmonitor daemon listens on, relative to the Kit directory
+	DefaultKitFSMonitorSocket = "fsmonitor.sock"
+	// DefaultKitFSMonitorLog is the fsmonitor daemon's log file, relative to the Kit directory
+	DefaultKitFSMonitorLog = "fsmonitor.log"
+)
+
+// fsmonitorTimeout bounds a single request to the daemon, so a hung daemon
+// only delays a command instead of blocking it
+const fsmonitorTimeout = 2 * time.Second
+
+// fsmonitorRequest is a request sent to the fsmonitor daemon
+type fsmonitorRequest struct {
+	Command string `json:"command"`         // "query", "ping" or "stop"
+	Token   string `json:"token,omitempty"` // Token from a previous query
+}
+
+// fsmonitorResponse is the daemon's answer to a request. For a query it holds
+// a new token and the paths changed since the given token, or Full when the
+// token is unknown or has expired and every path must be looked at.
+type fsmonitorResponse struct {
+	Token string   `json:"token,omitempty"`
+	Full  bool     `json:"full,omitempty"`
+	Paths []string `json:"paths,omitempty"`
+	Error string   `json:"error,omitempty"`
+}
+
+// fsmonitorState is what a repository remembers about its last complete
+// monitored walk. It is kept in the index's fsmonitor extension.
+type fsmonitorState struct {
+	Token string          // Token the walk was based on
+	Stamp string          // Stat data of the excludes files at the time
+	Files map[string]bool // Every file the walk visited
+}
+
+// fsmonitorSocket returns the path of the daemon's socket
+func (r *Repository) fsmonitorSocket() string {
+	return filepath.Join(r.Path, DefaultKitDir, DefaultKitFSMonitorSocket)
+}
+
+// fsmonitorCall sends a single request to the daemon
+func (r *Repository) fsmonitorCall(request fsmonitorRequest) (*fsmonitorResponse, error) {
+	conn, err := net.DialTimeout("unix", r.fsmonitorSocket(), fsmonitorTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(fsmonitorTimeout)); err != nil {
+		return nil, err
+	}
+	if err := json.NewEncoder(conn).Encode(request); err != nil {
+		return nil, fmt.Errorf("failed to send fsmonitor request: %w", err)
+	}
+
+	var response fsmonitorResponse
+	if err := json.NewDecoder(conn).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to read fsmonitor response: %w", err)
+	}
+	if response.Error != "" {
+		return nil, fmt.Errorf("fsmonitor: %s", response.Error)
+	}
+
+	return &response, nil
+}
+
+// FSMonitorRunning reports whether an fsmonitor daemon is answering for the repository
+func (r *Repository) FSMonitorRunning() bool {
+	_, err := r.fsmonitorCall(fsmonitorRequest{Command: "ping"})
+	return err == nil
+}
+
+// StopFSMonitor asks the repository's fsmonitor daemon to exit
+func (r *Repository) StopFSMonitor() error {
+	if _, err := r.fsmonitorCall(fsmonitorRequest{Command: "stop"}); err != nil {
+		return fmt.Errorf("fsmonitor is not running: %w", err)
+	}
+
+	// Wait for the socket to go away so a following start doesn't race it
+	deadline := time.Now().Add(fsmonitorTimeout)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(r.fsmonitorSocket()); os.IsNotExist(err) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return nil
+}
+
+// queryFSMonitor asks the daemon for the paths changed since the last
+// complete walk. It returns nil when no daemon is available, in which case
+// any remembered monitor state is dropped since it can no longer be kept
+// up to date.
+func (r *Repository) queryFSMonitor() *fsmonitorResponse {
+	if _, err := os.Stat(r.fsmonitorSocket()); err == nil {
+		token := ""
+		if r.fsmonitor != nil {
+			token = r.fsmonitor.Token
+		}
+		if response, err := r.fsmonitorCall(fsmonitorRequest{Command: "query", Token: token}); err == nil {
+			return response
+		}
+	}
+
+	if r.fsmonitor != nil {
+		r.fsmonitor = nil
+		r.fsmonitorDirty = true
+	}
+	return nil
+}
+
+// walkMonitoredWorkTree is walkWorkTree for repositories with a running
+// fsmonitor daemon. Only the paths the daemon reports as changed are read
+// from disk; every other file is known from the last complete walk, with
+// its stat data and hash taken from the index rather than the disk. A complete walk is done instead when the
+// daemon can't vouch for the time since that walk, or when ignore rules
+// may have changed.
+func (r *Repository) walkMonitoredWorkTree(response *fsmonitorResponse, options walkOptions, fn func(path string, d fs.DirEntry) error) error {
+	// Only unrestricted walks see every file and can be remembered
+	record := len(options.pathspecs) == 0
+	visited := make(map[string]bool)
+	visit := func(path string, d fs.DirEntry) error {
+		if record {
+			visited[path] = true
+		}
+		return fn(path, d)
+	}
+
+	stamp := r.excludesStamp()
+	if response.Full || r.fsmonitor == nil || r.fsmonitor.Stamp != stamp || touchesIgnoreFile(response.Paths) {
+		if err := r.walkFullWorkTree(options, visit); err != nil {
+			return err
+		}
+	} else {
+		entries, err := r.monitoredEntries(response.Paths, options.pathspecs)
+		if err != nil {
+			return err
+		}
+
+		// Visit files in the order a full walk would
+		paths := make([]string, 0, len(entries))
+		for path := range entries {
+			paths = append(paths, path)
+		}
+		sort.Slice(paths, func(i, j int) bool {
+			return strings.ReplaceAll(paths[i], "/", "\x00") < strings.ReplaceAll(paths[j], "/", "\x00")
+		})
+
+		for _, path := range paths {
+			if err := visit(path, entries[path]); err != nil {
+				return err
+			}
+		}
+	}
+
+	if record {
+		r.fsmonitor = &fsmonitorState{Token: response.Token, Stamp: stamp, Files: visited}
+		r.fsmonitorDirty = true
+	}
+
+	return nil
+}
+
+// monitoredEntries collects the working tree files selected by pathspecs,
+// walking only below the changed paths
+func (r *Repository) monitoredEntries(changed, pathspecs []string) (map[string]fs.DirEntry, error) {
+	entries := make(map[string]fs.DirEntry)
+
+	// Changed paths may be new files or whole new directories
+	if len(changed) > 0 {
+		err := r.walkFullWorkTree(walkOptions{pathspecs: changed}, func(path string, d fs.DirEntry) error {
+			slashPath := filepath.ToSlash(path)
+			if matchPathspec(slashPath, changed) && matchPathspec(slashPath, pathspecs) {
+				entries[path] = d
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	matcher, err := r.NewIgnoreMatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make(map[string]bool, len(r.fsmonitor.Files))
+	for path := range r.fsmonitor.Files {
+		candidates[path] = true
+	}
+	for _, indexed := range []map[string]string{r.State.Tracked, r.State.Stage} {
+		for path := range indexed {
+			candidates[path] = true
+		}
+	}
+
+	for path := range candidates {
+		slashPath := filepath.ToSlash(path)
+		if _, ok := entries[path]; ok || !matchPathspec(slashPath, pathspecs) {
+			continue
+		}
+		if len(changed) > 0 && matchPathspec(slashPath, changed) {
+			continue
+		}
+
+		// Files may have left the index since the last walk
+		if !r.isInIndex(path) {
+			ignored, _, err := matcher.IsIgnored(path, false)
+			if err != nil {
+				return nil, err
+			}
+			if ignored {
+				continue
+			}
+		}
+
+		// The last walk saw the file and the daemon has seen no change to
+		// it since, so it is still there and needs no stat
+		if r.fsmonitor.Files[path] {
+			entry := monitoredEntry{absPath: filepath.Join(r.Path, path)}
+			if cached, ok := r.State.WorkTree[path]; ok && cached.Hash != "" {
+				entry.cached = &cached
+			}
+			entries[path] = entry
+			continue
+		}
+
+		info, err := os.Lstat(filepath.Join(r.Path, path))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to get file info for %s: %w", path, err)
+		}
+		if info.IsDir() || (info.Mode()&fs.ModeSymlink != 0 && isDirSymlink(filepath.Join(r.Path, path))) {
+			continue
+		}
+		entries[path] = fs.FileInfoToDirEntry(info)
+	}
+
+	return entries, nil
+}
+
+// monitoredEntry is the directory entry of a file the fsmonitor daemon has
+// seen no change to since the last complete walk. Its file info comes from
+// the stat cache, which the daemon vouches for, and the file is only
+// stat'ed when the cache has nothing for it.
+type monitoredEntry struct {
+	absPath string
+	cached  *WorkTreeEntry
+}
+
+func (e monitoredEntry) Name() string { return filepath.Base(e.absPath) }
+func (e monitoredEntry) IsDir() bool  { return false }
+
+func (e monitoredEntry) Type() fs.FileMode {
+	if e.cached == nil {
+		return 0
+	}
+	return fs.FileMode(e.cached.Mode).Type()
+}
+
+func (e monitoredEntry) Info() (fs.FileInfo, error) {
+	if e.cached == nil {
+		return os.Lstat(e.absPath)
+	}
+	return monitoredFileInfo{*e.cached}, nil
+}
+
+// monitoredFileInfo is file info served from the stat cache for a file the
+// fsmonitor daemon reports unchanged. cachedHash trusts its hash as is.
+type monitoredFileInfo struct {
+	entry WorkTreeEntry
+}
+
+func (i monitoredFileInfo) Name() string       { return filepath.Base(i.entry.Path) }
+func (i monitoredFileInfo) Size() int64        { return i.entry.Size }
+func (i monitoredFileInfo) Mode() fs.FileMode  { return fs.FileMode(i.entry.Mode) }
+func (i monitoredFileInfo) ModTime() time.Time { return i.entry.ModTime }
+func (i monitoredFileInfo) IsDir() bool        { return false }
+func (i monitoredFileInfo) Sys() any           { return nil }
+
+// excludesStamp summarises the stat data of the repository-wide excludes
+// files, which the daemon doesn't watch
+func (r *Repository) excludesStamp() string {
+	files := []string{filepath.Join(r.Path, DefaultKitDir, DefaultKitExcludeFile)}
+	if global := r.globalExcludesFile(); global != "" {
+		files = append(files, global)
+	}
+
+	parts := make([]string, len(files))
+	for i, file := range files {
+		parts[i] = "-"
+		if info, err := os.Stat(file); err == nil {
+			parts[i] = fmt.Sprintf("%d.%d", info.Size(), info.ModTime().UnixNano())
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// touchesIgnoreFile reports whether any of the paths is a .kitignore file
+func touchesIgnoreFile(paths []string) bool {
+	for _, p := range paths {
+		if path.Base(p) == DefaultKitIgnoreFile {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeFSMonitorState serialises monitor state for the index extension
+func encodeFSMonitorState(state *fsmonitorState) []byte {
+	files := make([]string, 0, len(state.Files))
+	for path := range state.Files {
+		files = append(files, path)
+	}
+	sort.Strings(files)
+
+	return []byte(strings.Join(append([]string{state.Token, state.Stamp}, files...), "\n"))
+}
+
+// decodeFSMonitorState parses the index extension written by encodeFSMonitorState
+func decodeFSMonitorState(data []byte) *fsmonitorState {
+	lines := strings.Split(string(data), "\n")
+	if len(lines) < 2 || lines[0] == "" {
+		return nil
+	}
+
+	state := &fsmonitorState{
+		Token: lines[0],
+		Stamp: lines[1],
+		Files: make(map[string]bool, len(lines)-2),
+	}
+	for _, path := range lines[2:] {
+		if path != "" {
+			state.Files[path] = true
+		}
+	}
+	return state
+}
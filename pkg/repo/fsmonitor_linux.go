@@ -0,0 +1,431 @@
+//go:build linux
+
+package repo
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// fsmonitorMask selects the inotify events that can change a path's status
+const fsmonitorMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY |
+	syscall.IN_CLOSE_WRITE | syscall.IN_ATTRIB | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+	syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF | syscall.IN_ONLYDIR | syscall.IN_DONT_FOLLOW
+
+// maxFSMonitorChanges bounds the number of changed paths the daemon keeps.
+// When it is exceeded all outstanding tokens expire.
+const maxFSMonitorChanges = 1 << 16
+
+// fsmonitor watches every directory of a working tree with inotify and
+// records which paths changed, each with the sequence number of its latest
+// change. A token names a daemon instance and a sequence number.
+type fsmonitor struct {
+	root     string
+	fd       int // inotify instance
+	epfd     int // epoll instance waiting on fd
+	instance string
+
+	mu       sync.Mutex
+	watches  map[int32]string // Watch descriptor -> directory
+	dirs     map[string]int32 // Directory -> watch descriptor
+	changes  map[string]uint64
+	seq      uint64 // Sequence number of the latest change
+	base     uint64 // Changes before this sequence number were forgotten
+	degraded bool   // A directory could not be watched, so no token is trustworthy
+	done     bool   // The working tree itself went away
+	buf      []byte
+}
+
+// RunFSMonitor watches the working tree and answers queries on the
+// repository's fsmonitor socket until asked to stop or until the working
+// tree is removed
+func (r *Repository) RunFSMonitor() error {
+	if r.FSMonitorRunning() {
+		return fmt.Errorf("fsmonitor is already running")
+	}
+
+	m, err := newFSMonitor(r.Path)
+	if err != nil {
+		return err
+	}
+	defer m.close()
+
+	// A socket left behind by a daemon that died can't be listened on
+	socketPath := r.fsmonitorSocket()
+	os.Remove(socketPath)
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", socketPath, err)
+	}
+
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	shutdown := func() {
+		stopOnce.Do(func() {
+			close(stop)
+			listener.Close()
+		})
+	}
+
+	watchErr := make(chan error, 1)
+	go func() {
+		watchErr <- m.run(stop)
+		shutdown()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-stop:
+				return <-watchErr
+			default:
+				shutdown()
+				<-watchErr
+				return fmt.Errorf("failed to accept fsmonitor connection: %w", err)
+			}
+		}
+		go m.serve(conn, shutdown)
+	}
+}
+
+// StartFSMonitor starts an fsmonitor daemon in the background by running the
+// given command, which is expected to call RunFSMonitor, and waits until it
+// answers. The daemon's output goes to the fsmonitor log in the Kit directory.
+func (r *Repository) StartFSMonitor(name string, args ...string) error {
+	if r.FSMonitorRunning() {
+		return fmt.Errorf("fsmonitor is already running")
+	}
+
+	logPath := filepath.Join(r.Path, DefaultKitDir, DefaultKitFSMonitorLog)
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open fsmonitor log: %w", err)
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(name, args...)
+	cmd.Dir = r.Path
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start fsmonitor: %w", err)
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	// Watching a large tree takes a while before the first query is answered
+	deadline := time.Now().Add(30 * time.Second)
+	for time.Now().Before(deadline) {
+		select {
+		case <-exited:
+			return fmt.Errorf("fsmonitor exited during startup; see %s", logPath)
+		case <-time.After(50 * time.Millisecond):
+		}
+		if r.FSMonitorRunning() {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("fsmonitor did not start in time; see %s", logPath)
+}
+
+// newFSMonitor creates a monitor watching every directory below root
+func newFSMonitor(root string) (*fsmonitor, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialise inotify: %w", err)
+	}
+
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("failed to initialise epoll: %w", err)
+	}
+	event := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(fd)}
+	if err := syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, fd, &event); err != nil {
+		syscall.Close(epfd)
+		syscall.Close(fd)
+		return nil, fmt.Errorf("failed to initialise epoll: %w", err)
+	}
+
+	m := &fsmonitor{
+		root:     root,
+		fd:       fd,
+		epfd:     epfd,
+		instance: fmt.Sprintf("%d.%d", os.Getpid(), time.Now().UnixNano()),
+		watches:  make(map[int32]string),
+		dirs:     make(map[string]int32),
+		changes:  make(map[string]uint64),
+		buf:      make([]byte, 64*1024),
+	}
+
+	if err := m.watchTree(""); err != nil {
+		m.close()
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// close releases the monitor's inotify and epoll instances
+func (m *fsmonitor) close() {
+	syscall.Close(m.epfd)
+	syscall.Close(m.fd)
+}
+
+// run processes inotify events until stop is closed or the working tree
+// goes away
+func (m *fsmonitor) run(stop <-chan struct{}) error {
+	events := make([]syscall.EpollEvent, 1)
+	for {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+
+		// Wake up regularly to notice stop requests
+		n, err := syscall.EpollWait(m.epfd, events, 250)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			return fmt.Errorf("failed to wait for inotify events: %w", err)
+		}
+		if n == 0 {
+			continue
+		}
+
+		m.mu.Lock()
+		err = m.drain()
+		done := m.done
+		m.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+// drain processes every queued inotify event. inotify queues events as
+// part of the filesystem operation causing them, so after draining every
+// change completed before the call has been recorded. The caller must hold mu.
+func (m *fsmonitor) drain() error {
+	for {
+		n, err := syscall.Read(m.fd, m.buf)
+		if err != nil {
+			if err == syscall.EAGAIN {
+				return nil
+			}
+			if err == syscall.EINTR {
+				continue
+			}
+			return fmt.Errorf("failed to read inotify events: %w", err)
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			wd := int32(binary.NativeEndian.Uint32(m.buf[offset:]))
+			mask := binary.NativeEndian.Uint32(m.buf[offset+4:])
+			nameLen := int(binary.NativeEndian.Uint32(m.buf[offset+12:]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			name := strings.TrimRight(string(m.buf[nameStart:nameStart+nameLen]), "\x00")
+			offset = nameStart + nameLen
+
+			m.handleEvent(wd, mask, name)
+		}
+	}
+}
+
+// handleEvent records the path affected by a single inotify event
+func (m *fsmonitor) handleEvent(wd int32, mask uint32, name string) {
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		// Events were lost, so no earlier token can be trusted
+		m.forget()
+		return
+	}
+
+	dir, ok := m.watches[wd]
+	if !ok {
+		return
+	}
+	if mask&syscall.IN_IGNORED != 0 {
+		delete(m.watches, wd)
+		if m.dirs[dir] == wd {
+			delete(m.dirs, dir)
+		}
+		return
+	}
+	if mask&(syscall.IN_DELETE_SELF|syscall.IN_MOVE_SELF) != 0 {
+		// Changes to other directories are reported by their parents
+		if dir == "" {
+			m.done = true
+		}
+		return
+	}
+
+	// The Kit directory isn't part of the working tree
+	if dir == "" && name == DefaultKitDir {
+		return
+	}
+
+	path := name
+	if dir != "" {
+		path = dir + "/" + name
+	}
+
+	if mask&syscall.IN_ISDIR != 0 {
+		if mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM) != 0 {
+			m.unwatchTree(path)
+		}
+		if mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+			if err := m.watchTree(path); err != nil {
+				fmt.Fprintf(os.Stderr, "fsmonitor: %v\n", err)
+				m.degraded = true
+			}
+		}
+	}
+
+	m.seq++
+	m.changes[path] = m.seq
+	if len(m.changes) > maxFSMonitorChanges {
+		m.forget()
+	}
+}
+
+// forget drops every recorded change, expiring all outstanding tokens
+func (m *fsmonitor) forget() {
+	m.seq++
+	m.base = m.seq
+	m.changes = make(map[string]uint64)
+}
+
+// watchTree adds watches for a directory and every directory below it
+func (m *fsmonitor) watchTree(dir string) error {
+	return filepath.WalkDir(filepath.Join(m.root, filepath.FromSlash(dir)), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Directories may disappear while they are being watched
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(m.root, path)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		if relPath == "." {
+			relPath = ""
+		}
+		if relPath == DefaultKitDir {
+			return filepath.SkipDir
+		}
+
+		wd, err := syscall.InotifyAddWatch(m.fd, path, fsmonitorMask)
+		if err != nil {
+			if err == syscall.ENOENT || err == syscall.ENOTDIR {
+				return nil
+			}
+			if err == syscall.ENOSPC {
+				return fmt.Errorf("failed to watch %s: inotify watch limit reached (see fs.inotify.max_user_watches)", path)
+			}
+			return fmt.Errorf("failed to watch %s: %w", path, err)
+		}
+		m.watches[int32(wd)] = relPath
+		m.dirs[relPath] = int32(wd)
+		return nil
+	})
+}
+
+// unwatchTree removes the watches for a directory and every directory below it
+func (m *fsmonitor) unwatchTree(dir string) {
+	for path, wd := range m.dirs {
+		if path == dir || strings.HasPrefix(path, dir+"/") {
+			syscall.InotifyRmWatch(m.fd, uint32(wd))
+			delete(m.dirs, path)
+			delete(m.watches, wd)
+		}
+	}
+}
+
+// query returns a new token and the paths changed since the given one
+func (m *fsmonitor) query(token string) (*fsmonitorResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.drain(); err != nil {
+		return nil, err
+	}
+
+	response := &fsmonitorResponse{Token: fmt.Sprintf("%s:%d", m.instance, m.seq)}
+
+	instance, seq, found := strings.Cut(token, ":")
+	since, err := strconv.ParseUint(seq, 10, 64)
+	if !found || err != nil || instance != m.instance || since < m.base || m.degraded {
+		response.Full = true
+		return response, nil
+	}
+
+	for path, changed := range m.changes {
+		if changed > since {
+			response.Paths = append(response.Paths, path)
+		}
+	}
+	sort.Strings(response.Paths)
+
+	return response, nil
+}
+
+// serve answers a single request
+func (m *fsmonitor) serve(conn net.Conn, shutdown func()) {
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(fsmonitorTimeout)); err != nil {
+		return
+	}
+
+	var request fsmonitorRequest
+	if err := json.NewDecoder(conn).Decode(&request); err != nil {
+		return
+	}
+
+	response := &fsmonitorResponse{}
+	switch request.Command {
+	case "query":
+		result, err := m.query(request.Token)
+		if err != nil {
+			response.Error = err.Error()
+		} else {
+			response = result
+		}
+	case "ping":
+	case "stop":
+		defer shutdown()
+	default:
+		response.Error = fmt.Sprintf("unknown command %q", request.Command)
+	}
+
+	json.NewEncoder(conn).Encode(response)
+}
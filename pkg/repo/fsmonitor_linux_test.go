@@ -0,0 +1,187 @@
+//go:build linux
+
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startTestFSMonitor runs an fsmonitor daemon for the repository until the test ends
+func startTestFSMonitor(t *testing.T, repo *Repository) {
+	t.Helper()
+
+	done := make(chan error, 1)
+	go func() { done <- repo.RunFSMonitor() }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !repo.FSMonitorRunning() {
+		if time.Now().After(deadline) {
+			t.Fatal("fsmonitor did not start")
+		}
+		select {
+		case err := <-done:
+			t.Fatalf("fsmonitor exited: %v", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	t.Cleanup(func() {
+		if repo.FSMonitorRunning() {
+			repo.StopFSMonitor()
+		}
+		if err := <-done; err != nil {
+			t.Errorf("fsmonitor failed: %v", err)
+		}
+	})
+}
+
+func TestFSMonitorStatus(t *testing.T) {
+	repo := newTestRepository(t)
+	commitTestFiles(t, repo, "Initial commit", map[string]string{"a.txt": "alpha\n"})
+
+	startTestFSMonitor(t, repo)
+
+	// The first status walks everything and remembers the daemon's token
+	if _, err := repo.Status(); err != nil {
+		t.Fatalf("Failed to get status: %v", err)
+	}
+	if repo.fsmonitor == nil {
+		t.Fatal("Expected fsmonitor state after status")
+	}
+
+	writeTestFile(t, repo, "a.txt", "alpha changed\n")
+	writeTestFile(t, repo, "dir/sub/new.txt", "new\n")
+
+	status, err := repo.Status()
+	if err != nil {
+		t.Fatalf("Failed to get status: %v", err)
+	}
+	if !strings.Contains(status, "modified: a.txt") {
+		t.Errorf("Expected a.txt to be modified, got:\n%s", status)
+	}
+	if !strings.Contains(status, "dir/sub/new.txt") {
+		t.Errorf("Expected file in new directory to be untracked, got:\n%s", status)
+	}
+
+	// A file the daemon didn't report and the last walk didn't see is only
+	// missed if the walk really was skipped
+	delete(repo.fsmonitor.Files, "dir/sub/new.txt")
+	status, err = repo.Status()
+	if err != nil {
+		t.Fatalf("Failed to get status: %v", err)
+	}
+	if strings.Contains(status, "dir/sub/new.txt") {
+		t.Errorf("Expected unchanged paths not to be walked, got:\n%s", status)
+	}
+
+	// An unknown token makes the daemon ask for a full walk
+	repo.fsmonitor.Token = "expired:0"
+	status, err = repo.Status()
+	if err != nil {
+		t.Fatalf("Failed to get status: %v", err)
+	}
+	if !strings.Contains(status, "dir/sub/new.txt") {
+		t.Errorf("Expected full walk for an expired token, got:\n%s", status)
+	}
+}
+
+func TestFSMonitorFallback(t *testing.T) {
+	repo := newTestRepository(t)
+	commitTestFiles(t, repo, "Initial commit", map[string]string{"a.txt": "alpha\n"})
+
+	startTestFSMonitor(t, repo)
+	if _, err := repo.Status(); err != nil {
+		t.Fatalf("Failed to get status: %v", err)
+	}
+	writeTestFile(t, repo, "b.txt", "beta\n")
+	if _, err := repo.Status(); err != nil {
+		t.Fatalf("Failed to get status: %v", err)
+	}
+	delete(repo.fsmonitor.Files, "b.txt")
+
+	if err := repo.StopFSMonitor(); err != nil {
+		t.Fatalf("Failed to stop fsmonitor: %v", err)
+	}
+
+	// Without the daemon the whole tree is walked again
+	status, err := repo.Status()
+	if err != nil {
+		t.Fatalf("Failed to get status: %v", err)
+	}
+	if !strings.Contains(status, "b.txt") {
+		t.Errorf("Expected b.txt to be untracked, got:\n%s", status)
+	}
+	if repo.fsmonitor != nil {
+		t.Error("Expected fsmonitor state to be dropped without a daemon")
+	}
+}
+
+func TestFSMonitorAddAll(t *testing.T) {
+	repo := newTestRepository(t)
+	commitTestFiles(t, repo, "Initial commit", map[string]string{"a.txt": "alpha\n", "b.txt": "beta\n"})
+
+	startTestFSMonitor(t, repo)
+	if _, err := repo.Status(); err != nil {
+		t.Fatalf("Failed to get status: %v", err)
+	}
+
+	writeTestFile(t, repo, "c.txt", "gamma\n")
+	if err := os.Remove(filepath.Join(repo.Path, "b.txt")); err != nil {
+		t.Fatalf("Failed to remove b.txt: %v", err)
+	}
+
+	result, err := repo.AddPaths(nil, &AddOptions{All: true})
+	if err != nil {
+		t.Fatalf("Failed to add: %v", err)
+	}
+	if strings.Join(result.Added, ",") != "c.txt" {
+		t.Errorf("Expected c.txt to be added, got %v", result.Added)
+	}
+	if strings.Join(result.Removed, ",") != "b.txt" {
+		t.Errorf("Expected b.txt to be removed, got %v", result.Removed)
+	}
+}
+
+func TestFSMonitorTrustsUnchangedFiles(t *testing.T) {
+	repo := newTestRepository(t)
+	commitTestFiles(t, repo, "Initial commit", map[string]string{"a.txt": "alpha\n", "b.txt": "beta\n"})
+
+	startTestFSMonitor(t, repo)
+	for i := 0; i < 2; i++ {
+		if _, err := repo.Status(); err != nil {
+			t.Fatalf("Failed to get status: %v", err)
+		}
+	}
+
+	// Make a.txt's cached stat data disagree with the disk and give it a
+	// wrong hash. Had the file been stat'ed, the mismatch would have it
+	// hashed again and found unchanged; trusting the daemon, the cached
+	// hash is used as it is.
+	cached := repo.State.WorkTree["a.txt"]
+	cached.Size++
+	cached.Hash = hashContent([]byte("something else\n"))
+	repo.State.WorkTree["a.txt"] = cached
+
+	status, err := repo.Status()
+	if err != nil {
+		t.Fatalf("Failed to get status: %v", err)
+	}
+	if !strings.Contains(status, "modified: a.txt") {
+		t.Errorf("Expected the cached hash of an unchanged file to be used without a stat, got:\n%s", status)
+	}
+
+	// Files the daemon reports changed are still read from disk
+	writeTestFile(t, repo, "a.txt", "alpha\n")
+	writeTestFile(t, repo, "b.txt", "beta changed\n")
+	status, err = repo.Status()
+	if err != nil {
+		t.Fatalf("Failed to get status: %v", err)
+	}
+	if strings.Contains(status, "modified: a.txt") || !strings.Contains(status, "modified: b.txt") {
+		t.Errorf("Expected changed files to be looked at again, got:\n%s", status)
+	}
+}
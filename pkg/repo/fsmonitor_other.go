@@ -0,0 +1,18 @@
+//go:build !linux
+
+package repo
+
+import "errors"
+
+// errFSMonitorUnsupported is returned where no fsmonitor backend exists
+var errFSMonitorUnsupported = errors.New("fsmonitor is only supported on Linux")
+
+// RunFSMonitor watches the working tree for changes. It requires inotify.
+func (r *Repository) RunFSMonitor() error {
+	return errFSMonitorUnsupported
+}
+
+// StartFSMonitor starts an fsmonitor daemon in the background. It requires inotify.
+func (r *Repository) StartFSMonitor(name string, args ...string) error {
+	return errFSMonitorUnsupported
+}
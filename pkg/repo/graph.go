@@ -0,0 +1,167 @@
+package repo
+
+import "strings"
+
+// commitGraph draws the history of a log as ASCII art, one commit at a
+// time. Each column holds the commit its line of history leads to next.
+type commitGraph struct {
+	columns []string        // Commit expected next in each column
+	shown   map[string]bool // Commits in the log
+}
+
+// graphRows are the graph rows drawn for one commit
+type graphRows struct {
+	before  []string // Rows joining lines that lead to the commit
+	commit  string   // Row with the commit's node
+	padding string   // Row for the rest of the commit's description
+	after   []string // Rows leading from the commit to its parents
+}
+
+// graphLine is a line of history moving from one column to another
+type graphLine struct {
+	from, to int
+}
+
+// newCommitGraph creates a graph for the commits of a log
+func newCommitGraph(log []*CommitLog) *commitGraph {
+	shown := make(map[string]bool, len(log))
+	for _, commit := range log {
+		shown[commit.ID] = true
+	}
+	return &commitGraph{shown: shown}
+}
+
+// next draws the rows for the next commit of the log
+func (g *commitGraph) next(commit *CommitLog) graphRows {
+	var rows graphRows
+
+	idx := -1
+	for i, id := range g.columns {
+		if id == commit.ID {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		g.columns = append(g.columns, commit.ID)
+		idx = len(g.columns) - 1
+	}
+
+	// Join every line leading to the commit into its first column
+	columns := []string{}
+	lines := []graphLine{}
+	for i, id := range g.columns {
+		if id == commit.ID && i != idx {
+			lines = append(lines, graphLine{from: i, to: idx})
+			continue
+		}
+		lines = append(lines, graphLine{from: i, to: len(columns)})
+		columns = append(columns, id)
+	}
+	rows.before = drawGraphLines(lines)
+	g.columns = columns
+
+	width := 2*len(g.columns) - 1
+	commitRow := []byte(strings.Repeat(" ", width))
+	paddingRow := []byte(strings.Repeat(" ", width))
+	for i := range g.columns {
+		commitRow[2*i] = '|'
+		paddingRow[2*i] = '|'
+	}
+	commitRow[2*idx] = '*'
+
+	parents := []string{}
+	for _, parent := range commit.Parents {
+		if g.shown[parent] {
+			parents = append(parents, parent)
+		}
+	}
+	if len(parents) == 0 {
+		paddingRow[2*idx] = ' '
+	}
+	rows.commit = string(commitRow)
+	rows.padding = string(paddingRow)
+
+	// Replace the commit by its parents: the first parent takes over its
+	// column, other parents join an existing line or get new columns
+	next := []string{}
+	lines = []graphLine{}
+	for i, id := range g.columns {
+		if i != idx {
+			lines = append(lines, graphLine{from: i, to: len(next)})
+			next = append(next, id)
+			continue
+		}
+		for j, parent := range parents {
+			existing := -1
+			for k, other := range g.columns {
+				if other == parent && k != idx {
+					existing = k
+				}
+			}
+			if j > 0 && existing >= 0 {
+				// Resolved below, once the target column's final position is known
+				lines = append(lines, graphLine{from: idx, to: -1 - existing})
+				continue
+			}
+			lines = append(lines, graphLine{from: idx, to: len(next)})
+			next = append(next, parent)
+		}
+	}
+	for i, line := range lines {
+		if line.to >= 0 {
+			continue
+		}
+		existing := -1 - line.to
+		for _, other := range lines {
+			if other.from == existing {
+				lines[i].to = other.to
+			}
+		}
+	}
+	rows.after = drawGraphLines(lines)
+	g.columns = next
+
+	return rows
+}
+
+// drawGraphLines draws rows moving each line one column per row towards its
+// target, until all lines have arrived. Nothing is drawn if no line moves.
+func drawGraphLines(lines []graphLine) []string {
+	rows := []string{}
+	current := make([]int, len(lines))
+	for i, line := range lines {
+		current[i] = line.from
+	}
+
+	for {
+		moving := false
+		width := 0
+		for i, line := range lines {
+			if current[i] != line.to {
+				moving = true
+			}
+			if w := 2*max(current[i], line.to) + 2; w > width {
+				width = w
+			}
+		}
+		if !moving {
+			return rows
+		}
+
+		row := []byte(strings.Repeat(" ", width))
+		for i, line := range lines {
+			switch {
+			case current[i] < line.to:
+				row[2*current[i]+1] = '\\'
+				current[i]++
+			case current[i] > line.to:
+				row[2*current[i]-1] = '/'
+				current[i]--
+			default:
+				row[2*current[i]] = '|'
+			}
+		}
+		rows = append(rows, strings.TrimRight(string(row), " "))
+	}
+}
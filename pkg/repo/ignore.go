@@ -0,0 +1,350 @@
+package repo
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// DefaultKitIgnoreFile is the name of per-directory ignore files
+	DefaultKitIgnoreFile = ".kitignore"
+	// DefaultKitExcludeFile is the repository-local exclude file, relative to the Kit directory
+	DefaultKitExcludeFile = "info/exclude"
+)
+
+// IgnoreRule represents a single pattern from an ignore file
+type IgnoreRule struct {
+	Pattern  string // Pattern as written in the ignore file
+	Source   string // File the pattern was read from
+	Line     int    // Line number in the source file
+	Base     string // Directory the pattern is relative to ("" for the repository root)
+	Negate   bool   // Pattern re-includes paths ("!pattern")
+	DirOnly  bool   // Pattern only matches directories ("pattern/")
+	Anchored bool   // Pattern is matched against the full path below Base
+	regex    *regexp.Regexp
+}
+
+// IgnoreMatcher decides whether working tree paths are ignored. Rules are
+// read from the global excludes file, .kit/info/exclude and the .kitignore
+// file of each directory, in increasing order of precedence.
+type IgnoreMatcher struct {
+	repoPath string
+	rules    []*IgnoreRule            // Global and repository-wide rules
+	dirRules map[string][]*IgnoreRule // Rules from .kitignore files, by directory
+}
+
+// NewIgnoreMatcher creates an ignore matcher for the repository
+func (r *Repository) NewIgnoreMatcher() (*IgnoreMatcher, error) {
+	m := &IgnoreMatcher{
+		repoPath: r.Path,
+		dirRules: make(map[string][]*IgnoreRule),
+	}
+
+	if global := r.globalExcludesFile(); global != "" {
+		rules, err := readIgnoreFile(global, "")
+		if err != nil {
+			return nil, err
+		}
+		m.rules = append(m.rules, rules...)
+	}
+
+	rules, err := readIgnoreFile(filepath.Join(r.Path, DefaultKitDir, DefaultKitExcludeFile), "")
+	if err != nil {
+		return nil, err
+	}
+	m.rules = append(m.rules, rules...)
+
+	return m, nil
+}
+
+// globalExcludesFile returns the path of the user's global excludes file,
+// taken from core.excludesfile or the XDG configuration directory
+func (r *Repository) globalExcludesFile() string {
+	if value, ok := r.ConfigValue("core", "excludesfile"); ok && value != "" {
+		if strings.HasPrefix(value, "~/") {
+			if home, err := os.UserHomeDir(); err == nil {
+				value = filepath.Join(home, value[2:])
+			}
+		}
+		return value
+	}
+
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		return filepath.Join(xdg, "kit", "ignore")
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".config", "kit", "ignore")
+	}
+	return ""
+}
+
+// Match returns the last rule matching a repository path, or nil if no rule
+// matches. The returned rule may be a negation, in which case the path is
+// not ignored. Parent directories are not considered; see IsIgnored.
+func (m *IgnoreMatcher) Match(filePath string, isDir bool) (*IgnoreRule, error) {
+	filePath = filepath.ToSlash(filePath)
+
+	var match *IgnoreRule
+	for _, rule := range m.rules {
+		if rule.matches(filePath, isDir) {
+			match = rule
+		}
+	}
+
+	// Walk the .kitignore files from the root down to the path's directory
+	dir := ""
+	rest := filePath
+	for {
+		rules, err := m.rulesForDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, rule := range rules {
+			if rule.matches(filePath, isDir) {
+				match = rule
+			}
+		}
+
+		next, remainder, found := strings.Cut(rest, "/")
+		if !found {
+			break
+		}
+		dir = path.Join(dir, next)
+		rest = remainder
+	}
+
+	return match, nil
+}
+
+// IsIgnored reports whether a path is ignored, either directly or because one
+// of its parent directories is ignored. It also returns the deciding rule.
+func (m *IgnoreMatcher) IsIgnored(filePath string, isDir bool) (bool, *IgnoreRule, error) {
+	filePath = filepath.ToSlash(filePath)
+
+	// A file inside an ignored directory cannot be re-included
+	parts := strings.Split(filePath, "/")
+	for i := 1; i < len(parts); i++ {
+		rule, err := m.Match(strings.Join(parts[:i], "/"), true)
+		if err != nil {
+			return false, nil, err
+		}
+		if rule != nil && !rule.Negate {
+			return true, rule, nil
+		}
+	}
+
+	rule, err := m.Match(filePath, isDir)
+	if err != nil {
+		return false, nil, err
+	}
+	return rule != nil && !rule.Negate, rule, nil
+}
+
+// rulesForDir loads (and caches) the .kitignore rules of a directory
+func (m *IgnoreMatcher) rulesForDir(dir string) ([]*IgnoreRule, error) {
+	if rules, ok := m.dirRules[dir]; ok {
+		return rules, nil
+	}
+
+	rules, err := readIgnoreFile(filepath.Join(m.repoPath, filepath.FromSlash(dir), DefaultKitIgnoreFile), dir)
+	if err != nil {
+		return nil, err
+	}
+	m.dirRules[dir] = rules
+	return rules, nil
+}
+
+// String formats a rule as "source:line:pattern", as shown by check-ignore
+func (rule *IgnoreRule) String() string {
+	return fmt.Sprintf("%s:%d:%s", rule.Source, rule.Line, rule.Pattern)
+}
+
+// matches reports whether the rule's pattern matches a path, ignoring negation
+func (rule *IgnoreRule) matches(filePath string, isDir bool) bool {
+	if rule.DirOnly && !isDir {
+		return false
+	}
+
+	if rule.Base != "" {
+		if !strings.HasPrefix(filePath, rule.Base+"/") {
+			return false
+		}
+		filePath = filePath[len(rule.Base)+1:]
+	}
+
+	return rule.regex.MatchString(filePath)
+}
+
+// readIgnoreFile parses an ignore file. A missing file yields no rules.
+func readIgnoreFile(file, base string) ([]*IgnoreRule, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read ignore file %s: %w", file, err)
+	}
+	defer f.Close()
+
+	var rules []*IgnoreRule
+	lineNum := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lineNum++
+		rule := parseIgnoreRule(scanner.Text(), base)
+		if rule == nil {
+			continue
+		}
+		rule.Source = file
+		rule.Line = lineNum
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read ignore file %s: %w", file, err)
+	}
+
+	return rules, nil
+}
+
+// parseIgnoreRule parses one line of an ignore file using gitignore syntax.
+// It returns nil for blank lines, comments and invalid patterns.
+func parseIgnoreRule(line, base string) *IgnoreRule {
+	rule := &IgnoreRule{Pattern: line, Base: base}
+
+	// Trailing spaces are ignored unless escaped
+	pattern := strings.TrimRight(line, " \t")
+	if strings.HasSuffix(pattern, "\\") && len(pattern) < len(line) {
+		pattern += " "
+	}
+	rule.Pattern = pattern
+
+	if pattern == "" || strings.HasPrefix(pattern, "#") {
+		return nil
+	}
+
+	if strings.HasPrefix(pattern, "!") {
+		rule.Negate = true
+		pattern = pattern[1:]
+	} else if strings.HasPrefix(pattern, "\\!") || strings.HasPrefix(pattern, "\\#") {
+		pattern = pattern[1:]
+	}
+
+	if strings.HasSuffix(pattern, "/") {
+		rule.DirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+
+	// A slash anywhere but the end anchors the pattern to its base directory
+	if strings.Contains(pattern, "/") {
+		rule.Anchored = true
+		pattern = strings.TrimPrefix(pattern, "/")
+	}
+
+	if pattern == "" {
+		return nil
+	}
+
+	expr := globToRegexp(pattern)
+	if !rule.Anchored {
+		expr = "(?:.*/)?" + expr
+	}
+
+	regex, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return nil
+	}
+	rule.regex = regex
+
+	return rule
+}
+
+// globToRegexp translates a gitignore glob into a regular expression.
+// "*" and "?" do not match "/", while "**" matches across directories.
+func globToRegexp(pattern string) string {
+	var sb strings.Builder
+
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				atStart := i == 0 || pattern[i-1] == '/'
+				if atStart && i+2 < len(pattern) && pattern[i+2] == '/' {
+					// "**/" matches zero or more directories
+					sb.WriteString("(?:.*/)?")
+					i += 2
+					continue
+				}
+				if atStart && i+2 == len(pattern) {
+					// Trailing "**" matches everything inside
+					sb.WriteString(".*")
+					i++
+					continue
+				}
+			}
+			sb.WriteString("[^/]*")
+		case '?':
+			sb.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				sb.WriteString(regexp.QuoteMeta("["))
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + strings.ReplaceAll(class, "\\", "\\\\") + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				sb.WriteString(regexp.QuoteMeta(string(pattern[i])))
+			}
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	return sb.String()
+}
+
+// CheckIgnore returns the rule that decides whether a path is ignored, or nil
+// if no rule applies. Paths already in the index are never ignored.
+func (r *Repository) CheckIgnore(filePath string) (*IgnoreRule, bool, error) {
+	filePath = filepath.ToSlash(filepath.Clean(filePath))
+	if r.isInIndex(filePath) {
+		return nil, false, nil
+	}
+
+	matcher, err := r.NewIgnoreMatcher()
+	if err != nil {
+		return nil, false, err
+	}
+
+	isDir := false
+	if info, err := os.Stat(filepath.Join(r.Path, filePath)); err == nil {
+		isDir = info.IsDir()
+	}
+
+	ignored, rule, err := matcher.IsIgnored(filePath, isDir)
+	if err != nil {
+		return nil, false, err
+	}
+	return rule, ignored, nil
+}
+
+// isInIndex reports whether a path is tracked or staged
+func (r *Repository) isInIndex(filePath string) bool {
+	if _, ok := r.State.Tracked[filePath]; ok {
+		return true
+	}
+	_, ok := r.State.Stage[filePath]
+	return ok
+}
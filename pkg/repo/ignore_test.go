@@ -0,0 +1,165 @@
+package repo
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIgnorePatterns(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		isDir   bool
+		match   bool
+	}{
+		{"*.log", "debug.log", false, true},
+		{"*.log", "logs/debug.log", false, true},
+		{"*.log", "debug.txt", false, false},
+		{"bin/", "bin", true, true},
+		{"bin/", "bin", false, false},
+		{"bin/", "src/bin", true, true},
+		{"/build", "build", true, true},
+		{"/build", "src/build", true, false},
+		{"doc/*.txt", "doc/notes.txt", false, true},
+		{"doc/*.txt", "doc/sub/notes.txt", false, false},
+		{"doc/**/*.txt", "doc/sub/deep/notes.txt", false, true},
+		{"doc/**/*.txt", "doc/notes.txt", false, true},
+		{"**/cache", "a/b/cache", true, true},
+		{"out/**", "out/a/b.o", false, true},
+		{"file[0-9].go", "file7.go", false, true},
+		{"file[!0-9].go", "file7.go", false, false},
+		{"\\#notes", "#notes", false, true},
+		{"a?c", "abc", false, true},
+		{"a?c", "a/c", false, false},
+	}
+
+	for _, tt := range tests {
+		rule := parseIgnoreRule(tt.pattern, "")
+		if rule == nil {
+			t.Fatalf("Pattern %q should parse", tt.pattern)
+		}
+		if got := rule.matches(tt.path, tt.isDir); got != tt.match {
+			t.Errorf("Pattern %q on %q (dir=%v): expected %v, got %v", tt.pattern, tt.path, tt.isDir, tt.match, got)
+		}
+	}
+
+	for _, line := range []string{"", "   ", "# comment"} {
+		if rule := parseIgnoreRule(line, ""); rule != nil {
+			t.Errorf("Line %q should not produce a rule", line)
+		}
+	}
+}
+
+func TestIgnoreMatcherPrecedence(t *testing.T) {
+	repo := newTestRepository(t)
+
+	writeTestFile(t, repo, ".kit/info/exclude", "*.tmp\n")
+	writeTestFile(t, repo, ".kitignore", "*.log\n/build/\n")
+	writeTestFile(t, repo, "src/.kitignore", "!keep.log\n")
+
+	matcher, err := repo.NewIgnoreMatcher()
+	if err != nil {
+		t.Fatalf("Failed to create matcher: %v", err)
+	}
+
+	tests := []struct {
+		path    string
+		ignored bool
+	}{
+		{"a.tmp", true},
+		{"a.log", true},
+		{"src/a.log", true},
+		{"src/keep.log", false},
+		{"keep.log", true},
+		{"build/out.o", true},
+		{"src/build/out.o", false},
+		{"main.go", false},
+	}
+
+	for _, tt := range tests {
+		ignored, _, err := matcher.IsIgnored(tt.path, false)
+		if err != nil {
+			t.Fatalf("IsIgnored(%s) failed: %v", tt.path, err)
+		}
+		if ignored != tt.ignored {
+			t.Errorf("IsIgnored(%s): expected %v, got %v", tt.path, tt.ignored, ignored)
+		}
+	}
+}
+
+func TestStatusAndAddRespectIgnore(t *testing.T) {
+	repo := newTestRepository(t)
+
+	writeTestFile(t, repo, ".kitignore", "bin/\n*.o\n")
+	writeTestFile(t, repo, "main.go", "package main\n")
+	writeTestFile(t, repo, "bin/kit", "binary")
+	writeTestFile(t, repo, "lib/util.o", "object")
+
+	status, err := repo.Status()
+	if err != nil {
+		t.Fatalf("Failed to get status: %v", err)
+	}
+	if !strings.Contains(status, "main.go") {
+		t.Error("Status should list untracked main.go")
+	}
+	if strings.Contains(status, "bin/kit") || strings.Contains(status, "util.o") {
+		t.Errorf("Status should not list ignored files:\n%s", status)
+	}
+
+	if err := repo.Add("lib/util.o"); err == nil {
+		t.Error("Adding an ignored file should fail")
+	}
+	if err := repo.AddWithOptions("lib/util.o", &AddOptions{Force: true}); err != nil {
+		t.Fatalf("Force adding an ignored file should succeed: %v", err)
+	}
+
+	// Once in the index the file is no longer ignored
+	rule, ignored, err := repo.CheckIgnore("lib/util.o")
+	if err != nil {
+		t.Fatalf("CheckIgnore failed: %v", err)
+	}
+	if ignored || rule != nil {
+		t.Error("Indexed files should not be reported as ignored")
+	}
+
+	rule, ignored, err = repo.CheckIgnore("bin/kit")
+	if err != nil {
+		t.Fatalf("CheckIgnore failed: %v", err)
+	}
+	if !ignored || rule == nil || rule.Pattern != "bin/" || rule.Line != 1 {
+		t.Errorf("Expected bin/kit to be ignored by bin/ on line 1, got %v", rule)
+	}
+	if rule.Source != filepath.Join(repo.Path, ".kitignore") {
+		t.Errorf("Unexpected rule source %s", rule.Source)
+	}
+}
+
+func TestIgnoredDirectoryWithTrackedFiles(t *testing.T) {
+	repo := newTestRepository(t)
+
+	writeTestFile(t, repo, ".kitignore", "build/\n")
+	writeTestFile(t, repo, "build/keep", "tracked\n")
+	if err := repo.AddWithOptions("build/keep", &AddOptions{Force: true}); err != nil {
+		t.Fatalf("Failed to force add build/keep: %v", err)
+	}
+	if _, err := repo.Commit("Track build/keep"); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+
+	// The walk enters build/ for keep, but what else is there stays ignored
+	writeTestFile(t, repo, "build/new.o", "object")
+	writeTestFile(t, repo, "build/sub/deep.o", "object")
+	writeTestFile(t, repo, "build/keep", "changed\n")
+
+	status, err := repo.Status()
+	if err != nil {
+		t.Fatalf("Failed to get status: %v", err)
+	}
+	if strings.Contains(status, "new.o") || strings.Contains(status, "deep.o") {
+		t.Errorf("Status should not list files in an ignored directory:\n%s", status)
+	}
+	if !strings.Contains(status, "build/keep") {
+		t.Errorf("Status should list the modified tracked file:\n%s", status)
+	}
+}
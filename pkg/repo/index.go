@@ -1,12 +1,79 @@
 package repo
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"time"
 )
 
+// The index is stored in a binary format:
+//
+//	header:    "KIDX" | version uint32 | entry count uint32
+//	entry:     flags uint16 | path length uint16 | path
+//	           [tracked ID 32 bytes] [staged ID 32 bytes]
+//	           [ctime sec int64 | ctime nsec uint32 | mtime sec int64 | mtime nsec uint32 |
+//	            size uint64 | inode uint64 | mode uint32 | content ID 32 bytes]
+//	extension: signature 4 bytes | length uint32 | data
+//	trailer:   SHA-256 of everything before it
+//
+// All integers are big-endian. Extensions whose signature starts with an
+// uppercase letter are optional and skipped by readers that don't know them.
+// Version 1 of the index was pretty-printed JSON; it is still read and is
+// replaced by the binary format the next time the index is written.
+const (
+	indexSignature = "KIDX"
+	indexVersion   = 2
+)
+
+// Index entry flags
+const (
+	indexFlagTracked      uint16 = 1 << iota // Entry has a tracked object ID
+	indexFlagStaged                          // Entry has a staged object ID
+	indexFlagRemoved                         // Entry is staged for deletion
+	indexFlagStat                            // Entry has cached stat data
+	indexFlagSkipWorktree                    // Entry is outside the sparse checkout
+)
+
+// Index extension signatures
+const (
+	indexExtHEAD      = "HEAD" // Current HEAD reference
+	indexExtFSMonitor = "FSMN" // State of the last complete fsmonitor walk
+	indexExtConflicts = "UNMG" // Versions of files left unmerged by a merge
+	indexExtAttrStamp = "ATTR" // Attribute files the stat cache's hashes were made under
+)
+
+// Versions present in an entry of the conflicts extension
+const (
+	conflictHasBase uint8 = 1 << iota
+	conflictHasOurs
+	conflictHasTheirs
+)
+
+// indexExtension is an extension section of the index
+type indexExtension struct {
+	signature string
+	data      []byte
+}
+
+// indexFile is the decoded content of an index file
+type indexFile struct {
+	Version      int
+	Stage        map[string]string
+	Removed      map[string]bool
+	Tracked      map[string]string
+	WorkTree     map[string]WorkTreeEntry
+	SkipWorktree map[string]bool
+	Extensions   map[string][]byte
+}
+
 // SaveIndex saves the repository state to the index file
 func (r *Repository) SaveIndex() error {
 	// Check for nil state
@@ -14,31 +81,49 @@ func (r *Repository) SaveIndex() error {
 		return fmt.Errorf("repository state is nil")
 	}
 
-	// Create index data structure
-	index := struct {
-		Stage    map[string]string        `json:"stage"`
-		Tracked  map[string]string        `json:"tracked"`
-		WorkTree map[string]WorkTreeEntry `json:"worktree"`
-		HEAD     string                   `json:"head"`
-	}{
-		Stage:    r.State.Stage,
-		Tracked:  r.State.Tracked,
-		WorkTree: r.State.WorkTree,
-		HEAD:     r.State.HEAD,
+	// Hashes cached under other attributes would be trusted wrongly later
+	if stamp := r.attributesStamp(); stamp != r.attrStamp {
+		r.State.WorkTree = make(map[string]WorkTreeEntry)
+		r.attrStamp = stamp
 	}
 
-	// Marshal to JSON
-	data, err := json.MarshalIndent(index, "", "  ")
+	extensions := []indexExtension{
+		{signature: indexExtHEAD, data: []byte(r.State.HEAD)},
+		{signature: indexExtAttrStamp, data: []byte(r.attrStamp)},
+	}
+	if r.fsmonitor != nil {
+		extensions = append(extensions, indexExtension{signature: indexExtFSMonitor, data: encodeFSMonitorState(r.fsmonitor)})
+	}
+	if len(r.State.Conflicts) > 0 {
+		data, err := encodeConflicts(r.State.Conflicts)
+		if err != nil {
+			return fmt.Errorf("failed to encode conflicts: %w", err)
+		}
+		extensions = append(extensions, indexExtension{signature: indexExtConflicts, data: data})
+	}
+
+	data, err := encodeIndex(r.State, extensions)
 	if err != nil {
-		return fmt.Errorf("failed to marshal index: %w", err)
+		return fmt.Errorf("failed to encode index: %w", err)
 	}
 
-	// Write to file
+	// Write to a temporary file and rename so readers never see a partial index
 	indexPath := filepath.Join(r.Path, DefaultKitDir, DefaultKitIndexFile)
-	if err := os.WriteFile(indexPath, data, 0644); err != nil {
+	tmpPath := indexPath + ".lock"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write index file: %w", err)
+	}
+	if err := os.Rename(tmpPath, indexPath); err != nil {
+		os.Remove(tmpPath)
 		return fmt.Errorf("failed to write index file: %w", err)
 	}
 
+	// Remember when the index was written for racy timestamp detection
+	if info, err := os.Stat(indexPath); err == nil {
+		r.indexTime = info.ModTime()
+	}
+	r.fsmonitorDirty = false
+
 	return nil
 }
 
@@ -46,13 +131,17 @@ func (r *Repository) SaveIndex() error {
 func (r *Repository) LoadIndex() error {
 	// Check if index file exists
 	indexPath := filepath.Join(r.Path, DefaultKitDir, DefaultKitIndexFile)
-	if _, err := os.Stat(indexPath); os.IsNotExist(err) {
+	info, err := os.Stat(indexPath)
+	if os.IsNotExist(err) {
 		// No index file, initialize empty state
 		r.State = &RepositoryState{
-			HEAD:     "refs/heads/main",
-			Stage:    make(map[string]string),
-			Tracked:  make(map[string]string),
-			WorkTree: make(map[string]WorkTreeEntry),
+			HEAD:         "refs/heads/main",
+			Stage:        make(map[string]string),
+			Removed:      make(map[string]bool),
+			Tracked:      make(map[string]string),
+			WorkTree:     make(map[string]WorkTreeEntry),
+			SkipWorktree: make(map[string]bool),
+			Conflicts:    make(map[string]ConflictEntry),
 		}
 		return nil
 	}
@@ -66,34 +155,53 @@ func (r *Repository) LoadIndex() error {
 	// Skip if the file is empty
 	if len(data) == 0 {
 		r.State = &RepositoryState{
-			HEAD:     "refs/heads/main",
-			Stage:    make(map[string]string),
-			Tracked:  make(map[string]string),
-			WorkTree: make(map[string]WorkTreeEntry),
+			HEAD:         "refs/heads/main",
+			Stage:        make(map[string]string),
+			Removed:      make(map[string]bool),
+			Tracked:      make(map[string]string),
+			WorkTree:     make(map[string]WorkTreeEntry),
+			SkipWorktree: make(map[string]bool),
+			Conflicts:    make(map[string]ConflictEntry),
 		}
 		return nil
 	}
 
-	// Unmarshal JSON
-	var index struct {
-		Stage    map[string]string        `json:"stage"`
-		Tracked  map[string]string        `json:"tracked"`
-		WorkTree map[string]WorkTreeEntry `json:"worktree"`
-		HEAD     string                   `json:"head"`
-	}
-
-	if err := json.Unmarshal(data, &index); err != nil {
-		return fmt.Errorf("failed to unmarshal index: %w", err)
+	index, err := decodeIndex(data)
+	if err != nil {
+		return err
 	}
 
 	// Update repository state
 	r.State.Stage = index.Stage
+	r.State.Removed = index.Removed
 	r.State.Tracked = index.Tracked
 	r.State.WorkTree = index.WorkTree
+	r.State.SkipWorktree = index.SkipWorktree
+	r.indexTime = info.ModTime()
+	r.indexVersion = index.Version
+	r.fsmonitor = nil
+	if data, ok := index.Extensions[indexExtFSMonitor]; ok {
+		r.fsmonitor = decodeFSMonitorState(data)
+	}
+	// Cached hashes are of content cleaned with the attributes of the time,
+	// so they are dropped once the attribute files change
+	r.attrStamp = string(index.Extensions[indexExtAttrStamp])
+	if stamp := r.attributesStamp(); stamp != r.attrStamp {
+		r.State.WorkTree = make(map[string]WorkTreeEntry)
+		r.attrStamp = stamp
+	}
+	r.State.Conflicts = make(map[string]ConflictEntry)
+	if data, ok := index.Extensions[indexExtConflicts]; ok {
+		conflicts, err := decodeConflicts(data)
+		if err != nil {
+			return fmt.Errorf("corrupt index file: %w", err)
+		}
+		r.State.Conflicts = conflicts
+	}
 
 	// Only update HEAD if it exists in the index
-	if index.HEAD != "" {
-		r.State.HEAD = index.HEAD
+	if head := string(index.Extensions[indexExtHEAD]); head != "" {
+		r.State.HEAD = head
 	} else {
 		// Try to read HEAD from file
 		headPath := filepath.Join(r.Path, DefaultKitDir, DefaultKitHeadFile)
@@ -107,3 +215,400 @@ func (r *Repository) LoadIndex() error {
 
 	return nil
 }
+
+// UpgradeIndex rewrites an index in the legacy JSON format using the
+// current binary format. It reports whether an upgrade was needed.
+func (r *Repository) UpgradeIndex() (bool, error) {
+	if r.indexVersion == 0 || r.indexVersion >= indexVersion {
+		return false, nil
+	}
+
+	if err := r.SaveIndex(); err != nil {
+		return false, err
+	}
+	r.indexVersion = indexVersion
+
+	return true, nil
+}
+
+// RefreshIndex re-reads the stat data of every indexed file, rehashing
+// only the files whose stat data changed, and saves the index
+func (r *Repository) RefreshIndex() error {
+	for path := range r.indexEntries() {
+		info, err := os.Stat(filepath.Join(r.Path, path))
+		if err != nil {
+			if os.IsNotExist(err) {
+				delete(r.State.WorkTree, path)
+				continue
+			}
+			return fmt.Errorf("failed to get file info for %s: %w", path, err)
+		}
+
+		if _, _, err := r.cachedWorkingFileID(path, info); err != nil {
+			return err
+		}
+	}
+
+	return r.SaveIndex()
+}
+
+// encodeIndex serializes the repository state in the binary index format
+func encodeIndex(state *RepositoryState, extensions []indexExtension) ([]byte, error) {
+	// Collect every path that has an entry
+	paths := make(map[string]bool)
+	for path := range state.Tracked {
+		paths[path] = true
+	}
+	for path := range state.Stage {
+		paths[path] = true
+	}
+	for path := range state.Removed {
+		paths[path] = true
+	}
+	for path, entry := range state.WorkTree {
+		// Stat data without a hash can't vouch for anything
+		if entry.Hash != "" {
+			paths[path] = true
+		}
+	}
+
+	sorted := make([]string, 0, len(paths))
+	for path := range paths {
+		sorted = append(sorted, path)
+	}
+	sort.Strings(sorted)
+
+	var buf bytes.Buffer
+	buf.WriteString(indexSignature)
+	binary.Write(&buf, binary.BigEndian, uint32(indexVersion))
+	binary.Write(&buf, binary.BigEndian, uint32(len(sorted)))
+
+	for _, path := range sorted {
+		if len(path) > 0xFFFF {
+			return nil, fmt.Errorf("path too long: %s", path)
+		}
+
+		var flags uint16
+		trackedID, tracked := state.Tracked[path]
+		stagedID, staged := state.Stage[path]
+		workEntry, hasStat := state.WorkTree[path]
+		hasStat = hasStat && workEntry.Hash != ""
+		if tracked {
+			flags |= indexFlagTracked
+		}
+		if staged {
+			flags |= indexFlagStaged
+		}
+		if state.Removed[path] {
+			flags |= indexFlagRemoved
+		}
+		if hasStat {
+			flags |= indexFlagStat
+		}
+		if state.SkipWorktree[path] && (tracked || staged) {
+			flags |= indexFlagSkipWorktree
+		}
+
+		binary.Write(&buf, binary.BigEndian, flags)
+		binary.Write(&buf, binary.BigEndian, uint16(len(path)))
+		buf.WriteString(path)
+
+		if tracked {
+			if err := writeObjectID(&buf, trackedID); err != nil {
+				return nil, fmt.Errorf("invalid tracked object for %s: %w", path, err)
+			}
+		}
+		if staged {
+			if err := writeObjectID(&buf, stagedID); err != nil {
+				return nil, fmt.Errorf("invalid staged object for %s: %w", path, err)
+			}
+		}
+		if hasStat {
+			binary.Write(&buf, binary.BigEndian, workEntry.CTime.Unix())
+			binary.Write(&buf, binary.BigEndian, uint32(workEntry.CTime.Nanosecond()))
+			binary.Write(&buf, binary.BigEndian, workEntry.ModTime.Unix())
+			binary.Write(&buf, binary.BigEndian, uint32(workEntry.ModTime.Nanosecond()))
+			binary.Write(&buf, binary.BigEndian, uint64(workEntry.Size))
+			binary.Write(&buf, binary.BigEndian, workEntry.Inode)
+			binary.Write(&buf, binary.BigEndian, workEntry.Mode)
+			if err := writeObjectID(&buf, workEntry.Hash); err != nil {
+				return nil, fmt.Errorf("invalid working tree hash for %s: %w", path, err)
+			}
+		}
+	}
+
+	for _, ext := range extensions {
+		if len(ext.signature) != 4 {
+			return nil, fmt.Errorf("invalid extension signature %q", ext.signature)
+		}
+		buf.WriteString(ext.signature)
+		binary.Write(&buf, binary.BigEndian, uint32(len(ext.data)))
+		buf.Write(ext.data)
+	}
+
+	checksum := sha256.Sum256(buf.Bytes())
+	buf.Write(checksum[:])
+
+	return buf.Bytes(), nil
+}
+
+// decodeIndex parses an index file in either the binary or legacy JSON format
+func decodeIndex(data []byte) (*indexFile, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		return decodeJSONIndex(trimmed)
+	}
+
+	if len(data) < len(indexSignature)+8+sha256.Size || string(data[:4]) != indexSignature {
+		return nil, fmt.Errorf("index file is not in a recognised format")
+	}
+
+	// Verify the checksum trailer before trusting anything else
+	body := data[:len(data)-sha256.Size]
+	checksum := sha256.Sum256(body)
+	if !bytes.Equal(checksum[:], data[len(data)-sha256.Size:]) {
+		return nil, fmt.Errorf("index file checksum mismatch")
+	}
+
+	reader := &indexReader{data: body, pos: len(indexSignature)}
+	version := reader.uint32()
+	if version != indexVersion {
+		return nil, fmt.Errorf("unsupported index version %d", version)
+	}
+	count := reader.uint32()
+
+	index := &indexFile{
+		Version:      int(version),
+		Stage:        make(map[string]string),
+		Removed:      make(map[string]bool),
+		Tracked:      make(map[string]string),
+		WorkTree:     make(map[string]WorkTreeEntry),
+		SkipWorktree: make(map[string]bool),
+		Extensions:   make(map[string][]byte),
+	}
+
+	for i := uint32(0); i < count && reader.err == nil; i++ {
+		flags := reader.uint16()
+		path := string(reader.bytes(int(reader.uint16())))
+
+		if flags&indexFlagTracked != 0 {
+			index.Tracked[path] = reader.objectID()
+		}
+		if flags&indexFlagStaged != 0 {
+			index.Stage[path] = reader.objectID()
+		}
+		if flags&indexFlagRemoved != 0 {
+			index.Removed[path] = true
+		}
+		if flags&indexFlagSkipWorktree != 0 {
+			index.SkipWorktree[path] = true
+		}
+		if flags&indexFlagStat != 0 {
+			ctimeSec, ctimeNsec := int64(reader.uint64()), reader.uint32()
+			mtimeSec, mtimeNsec := int64(reader.uint64()), reader.uint32()
+			index.WorkTree[path] = WorkTreeEntry{
+				Path:    path,
+				CTime:   time.Unix(ctimeSec, int64(ctimeNsec)),
+				ModTime: time.Unix(mtimeSec, int64(mtimeNsec)),
+				Size:    int64(reader.uint64()),
+				Inode:   reader.uint64(),
+				Mode:    reader.uint32(),
+				Hash:    reader.objectID(),
+			}
+		}
+	}
+
+	for reader.err == nil && reader.pos < len(reader.data) {
+		signature := string(reader.bytes(4))
+		extData := reader.bytes(int(reader.uint32()))
+		if reader.err != nil {
+			break
+		}
+
+		// Extensions that aren't optional can't be safely ignored
+		if signature[0] < 'A' || signature[0] > 'Z' {
+			return nil, fmt.Errorf("unsupported index extension %q", signature)
+		}
+		index.Extensions[signature] = extData
+	}
+
+	if reader.err != nil {
+		return nil, fmt.Errorf("corrupt index file: %w", reader.err)
+	}
+
+	return index, nil
+}
+
+// decodeJSONIndex parses the legacy (version 1) JSON index
+func decodeJSONIndex(data []byte) (*indexFile, error) {
+	var index struct {
+		Stage    map[string]string        `json:"stage"`
+		Removed  map[string]bool          `json:"removed,omitempty"`
+		Tracked  map[string]string        `json:"tracked"`
+		WorkTree map[string]WorkTreeEntry `json:"worktree"`
+		HEAD     string                   `json:"head"`
+	}
+
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal index: %w", err)
+	}
+
+	result := &indexFile{
+		Version:      1,
+		Stage:        index.Stage,
+		Removed:      index.Removed,
+		Tracked:      index.Tracked,
+		WorkTree:     index.WorkTree,
+		SkipWorktree: make(map[string]bool),
+		Extensions:   map[string][]byte{indexExtHEAD: []byte(index.HEAD)},
+	}
+	if result.Stage == nil {
+		result.Stage = make(map[string]string)
+	}
+	if result.Removed == nil {
+		result.Removed = make(map[string]bool)
+	}
+	if result.Tracked == nil {
+		result.Tracked = make(map[string]string)
+	}
+	if result.WorkTree == nil {
+		result.WorkTree = make(map[string]WorkTreeEntry)
+	}
+
+	return result, nil
+}
+
+// encodeConflicts serializes unmerged files for the conflicts extension:
+// for each path, path length uint16 | path | versions uint8 | the object
+// IDs of the versions present, in base, ours, theirs order
+func encodeConflicts(conflicts map[string]ConflictEntry) ([]byte, error) {
+	paths := make([]string, 0, len(conflicts))
+	for path := range conflicts {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var buf bytes.Buffer
+	for _, path := range paths {
+		if len(path) > 0xFFFF {
+			return nil, fmt.Errorf("path too long: %s", path)
+		}
+		conflict := conflicts[path]
+		versions := []struct {
+			flag  uint8
+			objID string
+		}{
+			{conflictHasBase, conflict.Base},
+			{conflictHasOurs, conflict.Ours},
+			{conflictHasTheirs, conflict.Theirs},
+		}
+
+		var flags uint8
+		for _, version := range versions {
+			if version.objID != "" {
+				flags |= version.flag
+			}
+		}
+
+		binary.Write(&buf, binary.BigEndian, uint16(len(path)))
+		buf.WriteString(path)
+		buf.WriteByte(flags)
+		for _, version := range versions {
+			if version.objID == "" {
+				continue
+			}
+			if err := writeObjectID(&buf, version.objID); err != nil {
+				return nil, fmt.Errorf("invalid conflict object for %s: %w", path, err)
+			}
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeConflicts parses the conflicts extension written by encodeConflicts
+func decodeConflicts(data []byte) (map[string]ConflictEntry, error) {
+	conflicts := make(map[string]ConflictEntry)
+	reader := &indexReader{data: data}
+	for reader.err == nil && reader.pos < len(reader.data) {
+		path := string(reader.bytes(int(reader.uint16())))
+		flags := reader.bytes(1)
+		if reader.err != nil {
+			break
+		}
+
+		var conflict ConflictEntry
+		if flags[0]&conflictHasBase != 0 {
+			conflict.Base = reader.objectID()
+		}
+		if flags[0]&conflictHasOurs != 0 {
+			conflict.Ours = reader.objectID()
+		}
+		if flags[0]&conflictHasTheirs != 0 {
+			conflict.Theirs = reader.objectID()
+		}
+		conflicts[path] = conflict
+	}
+	if reader.err != nil {
+		return nil, fmt.Errorf("conflicts extension: %w", reader.err)
+	}
+	return conflicts, nil
+}
+
+// writeObjectID writes a hex object ID as raw bytes
+func writeObjectID(buf *bytes.Buffer, objID string) error {
+	raw, err := hex.DecodeString(objID)
+	if err != nil || len(raw) != sha256.Size {
+		return fmt.Errorf("malformed object ID %q", objID)
+	}
+	buf.Write(raw)
+	return nil
+}
+
+// indexReader reads big-endian values from an index, remembering the first error
+type indexReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+// bytes returns the next n bytes
+func (r *indexReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || r.pos+n > len(r.data) {
+		r.err = errors.New("unexpected end of data")
+		return nil
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *indexReader) uint16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *indexReader) uint32() uint32 {
+	if b := r.bytes(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *indexReader) uint64() uint64 {
+	if b := r.bytes(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+// objectID reads a raw object ID and returns it in hex
+func (r *indexReader) objectID() string {
+	if b := r.bytes(sha256.Size); b != nil {
+		return hex.EncodeToString(b)
+	}
+	return ""
+}
@@ -0,0 +1,194 @@
+package repo
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIndexRoundTrip(t *testing.T) {
+	repo := newTestRepository(t)
+
+	commitTestFiles(t, repo, "Initial commit", map[string]string{
+		"a.txt":     "alpha\n",
+		"dir/b.txt": "beta\n",
+	})
+	writeTestFile(t, repo, "a.txt", "alpha changed\n")
+	if err := repo.Add("a.txt"); err != nil {
+		t.Fatalf("Failed to add: %v", err)
+	}
+	repo.State.Removed["dir/b.txt"] = true
+	if err := repo.SaveIndex(); err != nil {
+		t.Fatalf("Failed to save index: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(repo.Path, DefaultKitDir, DefaultKitIndexFile))
+	if err != nil {
+		t.Fatalf("Failed to read index: %v", err)
+	}
+	if !strings.HasPrefix(string(data), indexSignature) {
+		t.Fatalf("Expected index to start with %q", indexSignature)
+	}
+
+	reopened, err := NewRepository(repo.Path)
+	if err != nil {
+		t.Fatalf("Failed to reopen repository: %v", err)
+	}
+
+	if reopened.State.HEAD != repo.State.HEAD {
+		t.Errorf("Expected HEAD %s, got %s", repo.State.HEAD, reopened.State.HEAD)
+	}
+	if reopened.State.Stage["a.txt"] != repo.State.Stage["a.txt"] {
+		t.Errorf("Expected staged ID %s, got %s", repo.State.Stage["a.txt"], reopened.State.Stage["a.txt"])
+	}
+	if reopened.State.Tracked["dir/b.txt"] != repo.State.Tracked["dir/b.txt"] {
+		t.Errorf("Expected tracked ID %s, got %s", repo.State.Tracked["dir/b.txt"], reopened.State.Tracked["dir/b.txt"])
+	}
+	if !reopened.State.Removed["dir/b.txt"] {
+		t.Error("Expected staged deletion to survive a round trip")
+	}
+
+	want := repo.State.WorkTree["a.txt"]
+	got := reopened.State.WorkTree["a.txt"]
+	if !got.ModTime.Equal(want.ModTime) || !got.CTime.Equal(want.CTime) ||
+		got.Size != want.Size || got.Inode != want.Inode || got.Mode != want.Mode || got.Hash != want.Hash {
+		t.Errorf("Expected stat data %+v, got %+v", want, got)
+	}
+}
+
+func TestIndexSkipsStatWithoutHash(t *testing.T) {
+	repo := newTestRepository(t)
+
+	commitTestFiles(t, repo, "Initial commit", map[string]string{"a.txt": "alpha\n"})
+	repo.State.WorkTree["a.txt"] = WorkTreeEntry{Path: "a.txt", Size: 6, ModTime: time.Now()}
+	repo.State.WorkTree["untracked.txt"] = WorkTreeEntry{Path: "untracked.txt", Size: 1, ModTime: time.Now()}
+	if err := repo.SaveIndex(); err != nil {
+		t.Fatalf("Failed to save index with unhashed stat data: %v", err)
+	}
+
+	reopened, err := NewRepository(repo.Path)
+	if err != nil {
+		t.Fatalf("Failed to reopen repository: %v", err)
+	}
+	if _, ok := reopened.State.Tracked["a.txt"]; !ok {
+		t.Error("Expected a.txt to stay tracked")
+	}
+	if _, ok := reopened.State.WorkTree["a.txt"]; ok {
+		t.Error("Expected stat data without a hash to be dropped")
+	}
+	if _, ok := reopened.State.WorkTree["untracked.txt"]; ok {
+		t.Error("Expected an entry with only unhashed stat data to be dropped")
+	}
+}
+
+func TestIndexChecksumMismatch(t *testing.T) {
+	repo := newTestRepository(t)
+	commitTestFiles(t, repo, "Initial commit", map[string]string{"a.txt": "alpha\n"})
+
+	indexPath := filepath.Join(repo.Path, DefaultKitDir, DefaultKitIndexFile)
+	data, err := os.ReadFile(indexPath)
+	if err != nil {
+		t.Fatalf("Failed to read index: %v", err)
+	}
+
+	// Flip a bit in the middle of the entries
+	data[len(data)/2] ^= 0x01
+	if err := os.WriteFile(indexPath, data, 0644); err != nil {
+		t.Fatalf("Failed to write index: %v", err)
+	}
+
+	if _, err := NewRepository(repo.Path); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("Expected checksum error, got %v", err)
+	}
+}
+
+func TestIndexUpgradeFromJSON(t *testing.T) {
+	repo := newTestRepository(t)
+	writeTestFile(t, repo, "a.txt", "alpha\n")
+	objID := hashContent([]byte("alpha\n"))
+
+	legacy := map[string]interface{}{
+		"stage":    map[string]string{},
+		"tracked":  map[string]string{"a.txt": objID},
+		"worktree": map[string]WorkTreeEntry{},
+		"head":     "refs/heads/main",
+	}
+	data, err := json.MarshalIndent(legacy, "", "  ")
+	if err != nil {
+		t.Fatalf("Failed to marshal legacy index: %v", err)
+	}
+	indexPath := filepath.Join(repo.Path, DefaultKitDir, DefaultKitIndexFile)
+	if err := os.WriteFile(indexPath, data, 0644); err != nil {
+		t.Fatalf("Failed to write legacy index: %v", err)
+	}
+
+	reopened, err := NewRepository(repo.Path)
+	if err != nil {
+		t.Fatalf("Failed to read legacy index: %v", err)
+	}
+	if reopened.State.Tracked["a.txt"] != objID {
+		t.Errorf("Expected tracked ID %s, got %s", objID, reopened.State.Tracked["a.txt"])
+	}
+
+	upgraded, err := reopened.UpgradeIndex()
+	if err != nil {
+		t.Fatalf("Failed to upgrade index: %v", err)
+	}
+	if !upgraded {
+		t.Error("Expected legacy index to be upgraded")
+	}
+
+	data, err = os.ReadFile(indexPath)
+	if err != nil {
+		t.Fatalf("Failed to read index: %v", err)
+	}
+	if !strings.HasPrefix(string(data), indexSignature) {
+		t.Error("Expected upgraded index to use the binary format")
+	}
+
+	if upgraded, _ := reopened.UpgradeIndex(); upgraded {
+		t.Error("Binary index should not need upgrading")
+	}
+}
+
+func TestStatCacheSkipsHashing(t *testing.T) {
+	repo := newTestRepository(t)
+	writeTestFile(t, repo, "a.txt", "alpha\n")
+
+	// Backdate the file so it is clearly older than the index
+	past := time.Now().Add(-time.Hour)
+	filePath := filepath.Join(repo.Path, "a.txt")
+	if err := os.Chtimes(filePath, past, past); err != nil {
+		t.Fatalf("Failed to set file times: %v", err)
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		t.Fatalf("Failed to stat file: %v", err)
+	}
+
+	// A cached hash that differs from the content shows whether the file was read
+	fakeID := hashContent([]byte("cached\n"))
+	repo.State.WorkTree["a.txt"] = newWorkTreeEntry("a.txt", fakeID, info)
+	repo.indexTime = time.Now()
+
+	objID, refreshed, err := repo.cachedWorkingFileID("a.txt", info)
+	if err != nil {
+		t.Fatalf("Failed to get file ID: %v", err)
+	}
+	if objID != fakeID || refreshed {
+		t.Errorf("Expected cached ID %s without refresh, got %s (refreshed %v)", fakeID, objID, refreshed)
+	}
+
+	// An entry not older than the index is racily clean and must be rehashed
+	repo.indexTime = info.ModTime()
+	objID, refreshed, err = repo.cachedWorkingFileID("a.txt", info)
+	if err != nil {
+		t.Fatalf("Failed to get file ID: %v", err)
+	}
+	if objID != hashContent([]byte("alpha\n")) || !refreshed {
+		t.Errorf("Expected racy entry to be rehashed, got %s (refreshed %v)", objID, refreshed)
+	}
+}
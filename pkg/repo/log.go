@@ -1,9 +1,9 @@
 package repo
 
 import (
-	"encoding/json"
 	"fmt"
-	"os"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -11,76 +11,286 @@ import (
 // CommitLog represents a commit in the log output
 type CommitLog struct {
 	ID        string    // Commit ID
+	Parents   []string  // Parent commit IDs followed by the walk
 	Author    string    // Author name and email
 	Timestamp time.Time // Commit timestamp
 	Message   string    // Commit message
 }
 
+// LogOptions represents options for listing commit history
+type LogOptions struct {
+	RevWalkOptions
+	Author string    // Only list commits whose author matches this regular expression
+	Grep   string    // Only list commits whose message matches this regular expression
+	Since  time.Time // Only list commits made at or after this time, if set
+	Until  time.Time // Only list commits made at or before this time, if set
+}
+
 // Log returns the commit history of the repository
 func (r *Repository) Log() ([]*CommitLog, error) {
-	// Get current commit ID from HEAD
-	commitID, err := r.resolveReference(r.State.HEAD)
+	return r.LogRevisions(nil, nil)
+}
+
+// CommitInfo describes a single commit as the log does, with all of its
+// parents, reading only that commit
+func (r *Repository) CommitInfo(commitID string) (*CommitLog, error) {
+	commit, err := r.readCommit(commitID)
 	if err != nil {
-		if os.IsNotExist(err) {
-			// No commits yet
-			return []*CommitLog{}, nil
+		return nil, err
+	}
+	return newCommitLog(commitID, commit), nil
+}
+
+// newCommitLog describes a commit object as the log does
+func newCommitLog(commitID string, commit *CommitObject) *CommitLog {
+	log := &CommitLog{ID: commitID, Parents: []string{}, Author: commit.Author, Timestamp: commit.Timestamp, Message: commit.Message}
+	for _, parent := range []string{commit.Parent, commit.Parent2} {
+		if parent != "" {
+			log.Parents = append(log.Parents, parent)
 		}
-		return nil, fmt.Errorf("failed to resolve HEAD: %w", err)
 	}
+	return log
+}
 
-	// Traverse commit history
-	var log []*CommitLog
-	for commitID != "" {
-		// Read commit object
-		commitData, err := r.readObject(commitID)
-		if err != nil {
-			// If we can't read the commit, stop the traversal
-			break
+// LogRevisions returns the history selected by revision arguments, as
+// parsed by ParseRevisionRange: HEAD's history without arguments
+func (r *Repository) LogRevisions(revisions []string, options *LogOptions) ([]*CommitLog, error) {
+	if options == nil {
+		options = &LogOptions{}
+	}
+
+	include, exclude, err := r.ParseRevisionRange(revisions)
+	if err != nil {
+		return nil, err
+	}
+
+	walkOptions := options.RevWalkOptions
+	filter, err := options.filter()
+	if err != nil {
+		return nil, err
+	}
+	if filter != nil {
+		walkFilter := walkOptions.Filter
+		walkOptions.Filter = func(commit *CommitLog) bool {
+			return filter(commit) && (walkFilter == nil || walkFilter(commit))
 		}
+	}
 
-		// Unmarshal commit object
-		var commit CommitObject
-		if err := json.Unmarshal(commitData, &commit); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal commit %s: %w", commitID, err)
+	return r.RevWalk(include, exclude, &walkOptions)
+}
+
+// filter returns a function accepting the commits matching the author,
+// message and date options, or nil if none are set
+func (o *LogOptions) filter() (func(*CommitLog) bool, error) {
+	if o.Author == "" && o.Grep == "" && o.Since.IsZero() && o.Until.IsZero() {
+		return nil, nil
+	}
+
+	var author, grep *regexp.Regexp
+	var err error
+	if o.Author != "" {
+		if author, err = regexp.Compile(o.Author); err != nil {
+			return nil, fmt.Errorf("invalid author pattern: %w", err)
+		}
+	}
+	if o.Grep != "" {
+		if grep, err = regexp.Compile(o.Grep); err != nil {
+			return nil, fmt.Errorf("invalid message pattern: %w", err)
+		}
+	}
+
+	since, until := o.Since, o.Until
+	return func(commit *CommitLog) bool {
+		switch {
+		case author != nil && !author.MatchString(commit.Author):
+			return false
+		case grep != nil && !grep.MatchString(commit.Message):
+			return false
+		case !since.IsZero() && commit.Timestamp.Before(since):
+			return false
+		case !until.IsZero() && commit.Timestamp.After(until):
+			return false
 		}
+		return true
+	}, nil
+}
+
+// ParseLogDate parses a date given to --since or --until: an RFC 3339
+// time, "YYYY-MM-DD" optionally followed by "HH:MM:SS", "now",
+// "yesterday", or a relative date such as "2 weeks ago" or "3.days.ago"
+func ParseLogDate(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, now.Location()); err == nil {
+			return t, nil
+		}
+	}
 
-		// Add commit to log
-		log = append(log, &CommitLog{
-			ID:        commitID,
-			Author:    commit.Author,
-			Timestamp: commit.Timestamp,
-			Message:   commit.Message,
-		})
+	switch strings.ToLower(s) {
+	case "now":
+		return now, nil
+	case "yesterday":
+		return now.AddDate(0, 0, -1), nil
+	}
 
-		// Move to parent commit
-		commitID = commit.Parent
+	fields := strings.Fields(strings.ReplaceAll(strings.ToLower(s), ".", " "))
+	if len(fields) == 3 && fields[2] == "ago" {
+		n, err := strconv.Atoi(fields[0])
+		if err == nil && n >= 0 {
+			switch strings.TrimSuffix(fields[1], "s") {
+			case "second":
+				return now.Add(-time.Duration(n) * time.Second), nil
+			case "minute":
+				return now.Add(-time.Duration(n) * time.Minute), nil
+			case "hour":
+				return now.Add(-time.Duration(n) * time.Hour), nil
+			case "day":
+				return now.AddDate(0, 0, -n), nil
+			case "week":
+				return now.AddDate(0, 0, -7*n), nil
+			case "month":
+				return now.AddDate(0, -n, 0), nil
+			case "year":
+				return now.AddDate(-n, 0, 0), nil
+			}
+		}
 	}
 
-	return log, nil
+	return time.Time{}, fmt.Errorf("invalid date: %s", s)
 }
 
-// FormatLog formats a commit log for display
-func FormatLog(log []*CommitLog) string {
+// FormatLog formats a commit log for display. The format is "medium" (or
+// empty) for the full description of each commit, "oneline" for the
+// abbreviated ID and subject, or a template as described by formatTemplate,
+// optionally prefixed by "format:" or "tformat:".
+func FormatLog(log []*CommitLog, format string) string {
 	var sb strings.Builder
 
 	for i, commit := range log {
 		// Add separator except for the first line
-		if i > 0 {
+		if i > 0 && isMediumFormat(format) {
 			sb.WriteString("\n")
 		}
+		for _, line := range formatCommit(commit, format) {
+			sb.WriteString(line + "\n")
+		}
+	}
 
-		// Format commit ID (use full ID for now as per standard git)
-		sb.WriteString(fmt.Sprintf("commit %s\n", commit.ID))
+	return sb.String()
+}
+
+// FormatLogGraph formats a commit log for display next to an ASCII graph
+// of the history. The log must list children before their parents.
+func FormatLogGraph(log []*CommitLog, format string) string {
+	var sb strings.Builder
+
+	graph := newCommitGraph(log)
+	for i, commit := range log {
+		lines := formatCommit(commit, format)
+		if i < len(log)-1 && isMediumFormat(format) {
+			lines = append(lines, "")
+		}
+
+		rows := graph.next(commit)
+		for _, row := range rows.before {
+			sb.WriteString(row + "\n")
+		}
+		for j, line := range lines {
+			prefix := rows.padding
+			if j == 0 {
+				prefix = rows.commit
+			}
+			sb.WriteString(strings.TrimRight(prefix+" "+line, " ") + "\n")
+		}
+		for _, row := range rows.after {
+			sb.WriteString(row + "\n")
+		}
+	}
+
+	return sb.String()
+}
+
+// isMediumFormat reports whether a log format is the default, multi-line one
+func isMediumFormat(format string) bool {
+	return format == "" || format == "medium"
+}
 
-		// Format author and timestamp
-		sb.WriteString(fmt.Sprintf("Author: %s\n", commit.Author))
-		sb.WriteString(fmt.Sprintf("Date:   %s\n\n", commit.Timestamp.Format(time.RFC1123)))
+// formatCommit returns the lines describing a commit in the log
+func formatCommit(commit *CommitLog, format string) []string {
+	if !isMediumFormat(format) {
+		if format == "oneline" {
+			format = "%h %s"
+		}
+		format = strings.TrimPrefix(strings.TrimPrefix(format, "format:"), "tformat:")
+		return strings.Split(formatTemplate(commit, format), "\n")
+	}
 
-		// Format message with 4-space indent
-		for _, line := range strings.Split(commit.Message, "\n") {
-			sb.WriteString(fmt.Sprintf("    %s\n", line))
+	// Format commit ID (use full ID for now as per standard git)
+	lines := []string{fmt.Sprintf("commit %s", commit.ID)}
+	if len(commit.Parents) > 1 {
+		parents := make([]string, len(commit.Parents))
+		for i, parent := range commit.Parents {
+			parents[i] = shortID(parent)
 		}
+		lines = append(lines, "Merge: "+strings.Join(parents, " "))
+	}
+
+	// Format author and timestamp
+	lines = append(lines, fmt.Sprintf("Author: %s", commit.Author))
+	lines = append(lines, fmt.Sprintf("Date:   %s", commit.Timestamp.Format(time.RFC1123)), "")
+
+	// Format message with 4-space indent
+	for _, line := range strings.Split(commit.Message, "\n") {
+		lines = append(lines, "    "+line)
+	}
+
+	return lines
+}
+
+// formatTemplate expands the placeholders of a log format template:
+// %H and %h for the full and abbreviated commit ID, %P and %p for the
+// parent IDs, %an, %ae and %ad for the author name, email and date, %s and
+// %b for the message subject and body, %n for a newline and %% for "%".
+// Unknown placeholders are kept as they are.
+func formatTemplate(commit *CommitLog, template string) string {
+	name, email := splitAuthor(commit.Author)
+	subject, body, _ := strings.Cut(commit.Message, "\n")
+	shortParents := make([]string, len(commit.Parents))
+	for i, parent := range commit.Parents {
+		shortParents[i] = shortID(parent)
+	}
+
+	placeholders := []struct{ key, value string }{
+		{"%H", commit.ID},
+		{"%h", shortID(commit.ID)},
+		{"%P", strings.Join(commit.Parents, " ")},
+		{"%p", strings.Join(shortParents, " ")},
+		{"%an", name},
+		{"%ae", email},
+		{"%ad", commit.Timestamp.Format(time.RFC1123)},
+		{"%s", subject},
+		{"%b", strings.TrimLeft(body, "\n")},
+		{"%n", "\n"},
+		{"%%", "%"},
 	}
 
+	var sb strings.Builder
+	for i := 0; i < len(template); {
+		matched := false
+		if template[i] == '%' {
+			for _, p := range placeholders {
+				if strings.HasPrefix(template[i:], p.key) {
+					sb.WriteString(p.value)
+					i += len(p.key)
+					matched = true
+					break
+				}
+			}
+		}
+		if !matched {
+			sb.WriteByte(template[i])
+			i++
+		}
+	}
 	return sb.String()
 }
@@ -4,9 +4,12 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 )
@@ -21,19 +24,34 @@ type MergeResult struct {
 
 // MergeConflict represents a conflict during merge
 type MergeConflict struct {
-	Path         string // File path with conflict
-	OurContent   string // Content from our branch
-	TheirContent string // Content from their branch
-	BaseContent  string // Common ancestor content
-	Resolution   string // Resolved content (if any)
+	Path          string // File path with conflict
+	OurContent    string // Content from our branch
+	TheirContent  string // Content from their branch
+	BaseContent   string // Common ancestor content
+	Resolution    string // Resolved content (if any)
+	OursDeleted   bool   // We deleted the file they changed
+	TheirsDeleted bool   // They deleted the file we changed
+}
+
+// Summary describes a conflict in a line of merge output
+func (c MergeConflict) Summary() string {
+	switch {
+	case c.OursDeleted:
+		return fmt.Sprintf("CONFLICT (modify/delete): %s deleted in ours and modified in theirs", c.Path)
+	case c.TheirsDeleted:
+		return fmt.Sprintf("CONFLICT (modify/delete): %s deleted in theirs and modified in ours", c.Path)
+	default:
+		return fmt.Sprintf("CONFLICT (content): Merge conflict in %s", c.Path)
+	}
 }
 
 // MergeOptions represents options for merge operations
 type MergeOptions struct {
-	Strategy    MergeStrategy // Merge strategy to use
-	NoCommit    bool          // Don't auto-commit after merge
-	Message     string        // Custom commit message
-	UseSemantic bool          // Use semantic kernel for resolution
+	Strategy                MergeStrategy // Merge strategy to use
+	NoCommit                bool          // Don't auto-commit after merge
+	Message                 string        // Custom commit message
+	UseSemantic             bool          // Use semantic kernel for resolution
+	AllowUnrelatedHistories bool          // Merge branches without a common ancestor
 }
 
 // DefaultMergeOptions provides default merge options
@@ -52,6 +70,7 @@ const (
 	Ours                           // Always prefer our version in conflicts
 	Theirs                         // Always prefer their version in conflicts
 	Manual                         // Require manual resolution
+	Union                          // Keep the lines of both versions in conflicts
 )
 
 // Merge merges a branch into the current branch
@@ -79,15 +98,21 @@ func (r *Repository) Merge(branchName string, options *MergeOptions) (*MergeResu
 	}
 
 	// 4. Check for uncommitted changes
-	if len(r.State.Stage) > 0 {
+	if len(r.State.Conflicts) > 0 {
+		return nil, fmt.Errorf("cannot merge with unresolved conflicts, please stage the resolved files and commit first")
+	}
+	if r.hasStagedChanges() {
 		return nil, fmt.Errorf("cannot merge with uncommitted changes, please commit or stash them first")
 	}
 
-	// 5. Find merge base (common ancestor)
-	baseCommitID, err := r.FindMergeBase(currentCommitID, targetCommitID)
+	// 5. Find the best common ancestors
+	bases, err := r.MergeBases(currentCommitID, targetCommitID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to find merge base: %w", err)
 	}
+	if len(bases) == 0 && !options.AllowUnrelatedHistories {
+		return nil, fmt.Errorf("refusing to merge unrelated histories")
+	}
 
 	// Create result
 	result := &MergeResult{
@@ -98,7 +123,7 @@ func (r *Repository) Merge(branchName string, options *MergeOptions) (*MergeResu
 	}
 
 	// 6. Check for fast-forward merge
-	if baseCommitID == currentCommitID {
+	if len(bases) == 1 && bases[0] == currentCommitID {
 		// Current branch is an ancestor of target branch, we can fast-forward
 		result.FastForward = true
 
@@ -121,9 +146,9 @@ func (r *Repository) Merge(branchName string, options *MergeOptions) (*MergeResu
 
 	// 7. Not a fast-forward, perform 3-way merge
 	// Get trees for base, ours, and theirs
-	baseTree, err := r.getTreeFromCommit(baseCommitID)
+	baseTree, err := r.mergeBaseTree(bases, options)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get base tree: %w", err)
+		return nil, err
 	}
 
 	ourTree, err := r.getTreeFromCommit(currentCommitID)
@@ -152,6 +177,18 @@ func (r *Repository) Merge(branchName string, options *MergeOptions) (*MergeResu
 		if err != nil {
 			return nil, fmt.Errorf("failed to write conflict markers: %w", err)
 		}
+
+		// Remember the conflicting versions until the files are staged
+		for _, conflict := range conflicts {
+			r.State.Conflicts[conflict.Path] = ConflictEntry{
+				Base:   baseTree.Entries[conflict.Path].ObjID,
+				Ours:   ourTree.Entries[conflict.Path].ObjID,
+				Theirs: theirTree.Entries[conflict.Path].ObjID,
+			}
+		}
+		if err := r.SaveIndex(); err != nil {
+			return nil, fmt.Errorf("failed to save index after merge: %w", err)
+		}
 		return result, nil
 	}
 
@@ -192,40 +229,12 @@ func (r *Repository) Merge(branchName string, options *MergeOptions) (*MergeResu
 	}
 
 	// Update tracked files and working tree to match merged tree
-	for path, entry := range mergedTree.Entries {
-		// Update tracked files
-		r.State.Tracked[path] = entry.ObjID
-
-		// Update working tree
-		objectData, err := r.readObject(entry.ObjID)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read object %s: %w", entry.ObjID, err)
-		}
-
-		// Write file to working directory
-		filePath := filepath.Join(r.Path, path)
-		err = os.MkdirAll(filepath.Dir(filePath), 0755)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create directory for %s: %w", path, err)
-		}
-
-		err = os.WriteFile(filePath, objectData, 0644)
-		if err != nil {
-			return nil, fmt.Errorf("failed to write file %s: %w", path, err)
-		}
-
-		// Update working tree state
-		fileInfo, err := os.Stat(filePath)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get file info for %s: %w", path, err)
-		}
-
-		r.State.WorkTree[path] = WorkTreeEntry{
-			Path:    path,
-			Size:    fileInfo.Size(),
-			ModTime: fileInfo.ModTime(),
-			Hash:    entry.ObjID,
-		}
+	mergedFiles := treeBlobs(mergedTree)
+	if err := r.materializeTree(nil, mergedFiles); err != nil {
+		return nil, fmt.Errorf("failed to update working tree: %w", err)
+	}
+	for path, objID := range mergedFiles {
+		r.State.Tracked[path] = objID
 	}
 
 	// Save index
@@ -238,88 +247,110 @@ func (r *Repository) Merge(branchName string, options *MergeOptions) (*MergeResu
 	return result, nil
 }
 
-// FindMergeBase finds the common ancestor of two commits
+// FindMergeBase finds the common ancestor of two commits, following both
+// parents of merges. If there are several, it returns the newest one.
 func (r *Repository) FindMergeBase(commitA, commitB string) (string, error) {
-	// Implementation of finding the lowest common ancestor in the commit graph
-	// For simplicity, we'll use a breadth-first search approach
-
-	// Get the history of commit A
-	historyA := make(map[string]bool)
-	queue := []string{commitA}
+	bases, err := r.MergeBases(commitA, commitB)
+	if err != nil {
+		return "", err
+	}
+	if len(bases) == 0 {
+		return "", fmt.Errorf("no common ancestor found")
+	}
+	return bases[0], nil
+}
 
-	for len(queue) > 0 {
-		commit := queue[0]
-		queue = queue[1:]
+// MergeBases returns the best common ancestors of two commits: those that
+// aren't an ancestor of another common ancestor. There are several after
+// criss-cross merges, and none for unrelated histories.
+func (r *Repository) MergeBases(commitA, commitB string) ([]string, error) {
+	candidates, err := r.paintDownToCommon([]string{commitA}, commitB)
+	if err != nil {
+		return nil, err
+	}
+	return r.reduceMergeBases(candidates)
+}
 
-		// Check if we've already processed this commit
-		if historyA[commit] {
-			continue
+// reduceMergeBases drops the candidates that are ancestors of other ones
+func (r *Repository) reduceMergeBases(candidates []string) ([]string, error) {
+	bases := []string{}
+	for i, candidate := range candidates {
+		redundant := false
+		for j, other := range candidates {
+			if i == j || other == candidate {
+				continue
+			}
+			ok, err := r.IsAncestor(candidate, other)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				redundant = true
+				break
+			}
+		}
+		if !redundant {
+			bases = append(bases, candidate)
 		}
+	}
+	return bases, nil
+}
 
-		// Mark this commit as part of history A
-		historyA[commit] = true
+// mergeBaseTree returns the tree a merge compares both sides with. It is
+// empty for unrelated histories. Several best common ancestors are merged
+// one by one into a virtual base first, using their own merge bases in turn
+// (the recursive strategy); conflicts between them stay in the virtual base
+// with markers, so they come up again in the real merge.
+func (r *Repository) mergeBaseTree(bases []string, options *MergeOptions) (*TreeObject, error) {
+	if len(bases) == 0 {
+		return &TreeObject{Entries: make(map[string]TreeEntry)}, nil
+	}
 
-		// Get the commit object
-		commitData, err := r.readObject(commit)
+	tree, err := r.getTreeFromCommit(bases[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to get base tree: %w", err)
+	}
+	merged := []string{bases[0]}
+	for _, base := range bases[1:] {
+		candidates, err := r.paintDownToCommon(merged, base)
 		if err != nil {
-			// Skip if we can't read the commit
-			continue
+			return nil, err
 		}
-
-		// Unmarshal commit
-		var commitObj CommitObject
-		if err := json.Unmarshal(commitData, &commitObj); err != nil {
-			continue
-		}
-
-		// Add parent to the queue
-		if commitObj.Parent != "" {
-			queue = append(queue, commitObj.Parent)
+		innerBases, err := r.reduceMergeBases(candidates)
+		if err != nil {
+			return nil, err
 		}
-	}
-
-	// Now traverse commit B's history, stopping when we find a commit in A's history
-	queue = []string{commitB}
-	visited := make(map[string]bool)
-
-	for len(queue) > 0 {
-		commit := queue[0]
-		queue = queue[1:]
-
-		// Check if we've already processed this commit
-		if visited[commit] {
-			continue
+		innerTree, err := r.mergeBaseTree(innerBases, options)
+		if err != nil {
+			return nil, err
 		}
-
-		// Mark this commit as visited
-		visited[commit] = true
-
-		// Check if this commit is in A's history
-		if historyA[commit] {
-			return commit, nil
+		baseTree, err := r.getTreeFromCommit(base)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get base tree: %w", err)
 		}
 
-		// Get the commit object
-		commitData, err := r.readObject(commit)
+		virtualOptions := *options
+		virtualOptions.Strategy = Manual
+		virtualTree, conflicts, err := r.MergeTrees(innerTree, tree, baseTree, &virtualOptions)
 		if err != nil {
-			// Skip if we can't read the commit
-			continue
+			return nil, fmt.Errorf("failed to merge bases: %w", err)
 		}
-
-		// Unmarshal commit
-		var commitObj CommitObject
-		if err := json.Unmarshal(commitData, &commitObj); err != nil {
-			continue
+		for _, conflict := range conflicts {
+			content, _, err := r.MergeFiles(conflict.BaseContent, conflict.OurContent, conflict.TheirContent, Manual)
+			if err != nil {
+				return nil, fmt.Errorf("failed to merge bases: %w", err)
+			}
+			contentID := hashContent([]byte(content))
+			if err := r.storeObject(contentID, []byte(content)); err != nil {
+				return nil, fmt.Errorf("failed to store merged content for %s: %w", conflict.Path, err)
+			}
+			virtualTree.Entries[conflict.Path] = TreeEntry{Path: conflict.Path, Mode: "100644", Type: "blob", ObjID: contentID}
 		}
 
-		// Add parent to the queue
-		if commitObj.Parent != "" {
-			queue = append(queue, commitObj.Parent)
-		}
+		tree = virtualTree
+		merged = append(merged, base)
 	}
-
-	// If we get here, there's no common ancestor (shouldn't happen in a proper repository)
-	return "", fmt.Errorf("no common ancestor found")
+	return tree, nil
 }
 
 // MergeTrees performs a 3-way merge of trees
@@ -386,27 +417,8 @@ func (r *Repository) MergeTrees(baseTree, ourTree, theirTree *TreeObject, option
 				return nil, nil, fmt.Errorf("failed to read their content for %s: %w", path, err)
 			}
 
-			// Try to merge file contents
-			var mergedContent string
-			var hasConflict bool
-
-			if options.UseSemantic && isCodeFile(path) {
-				// Use semantic merge for code files
-				mergedContent, hasConflict, err = r.SemanticMergeFiles(
-					string(baseContent),
-					string(ourContent),
-					string(theirContent),
-				)
-			} else {
-				// Use regular 3-way merge
-				mergedContent, hasConflict, err = r.MergeFiles(
-					string(baseContent),
-					string(ourContent),
-					string(theirContent),
-					options.Strategy,
-				)
-			}
-
+			// Try to merge file contents with the file's merge driver
+			mergedContent, hasConflict, err := r.mergeBlobs(path, baseContent, ourContent, theirContent, options)
 			if err != nil {
 				return nil, nil, fmt.Errorf("failed to merge file %s: %w", path, err)
 			}
@@ -474,7 +486,11 @@ func (r *Repository) MergeTrees(baseTree, ourTree, theirTree *TreeObject, option
 			var mergedContent string
 			var hasConflict bool
 
-			if options.UseSemantic && isCodeFile(path) {
+			attrs, err := r.Attributes(path)
+			if err != nil {
+				return nil, nil, err
+			}
+			if options.UseSemantic && attrs.semantic(path) && attrs.mergeDriver() == "text" {
 				// For semantic merge of new files, we can try with empty base
 				var err error
 				mergedContent, hasConflict, err = r.SemanticMergeFiles(
@@ -528,12 +544,32 @@ func (r *Repository) MergeTrees(baseTree, ourTree, theirTree *TreeObject, option
 			}
 		} else if baseExists && ourExists && !theirExists {
 			// Case 3: File deleted in theirs but kept in ours
-			// Keep our version
-			mergedTree.Entries[path] = ourEntry
+			// Delete it unless we changed it, which conflicts
+			if ourEntry.ObjID == baseEntry.ObjID {
+				continue
+			}
+			conflict, err := r.modifyDeleteConflict(path, baseEntry.ObjID, ourEntry.ObjID, "")
+			if err != nil {
+				return nil, nil, err
+			}
+			conflicts = append(conflicts, conflict)
+			if options.Strategy == Ours {
+				mergedTree.Entries[path] = ourEntry
+			}
 		} else if baseExists && !ourExists && theirExists {
 			// Case 4: File deleted in ours but kept in theirs
-			// Keep their version
-			mergedTree.Entries[path] = theirEntry
+			// Leave it deleted unless they changed it, which conflicts
+			if theirEntry.ObjID == baseEntry.ObjID {
+				continue
+			}
+			conflict, err := r.modifyDeleteConflict(path, baseEntry.ObjID, "", theirEntry.ObjID)
+			if err != nil {
+				return nil, nil, err
+			}
+			conflicts = append(conflicts, conflict)
+			if options.Strategy == Theirs {
+				mergedTree.Entries[path] = theirEntry
+			}
 		} else if !baseExists && ourExists && !theirExists {
 			// Case 5: File added in ours only
 			// Keep our version
@@ -549,123 +585,248 @@ func (r *Repository) MergeTrees(baseTree, ourTree, theirTree *TreeObject, option
 	return mergedTree, conflicts, nil
 }
 
-// MergeFiles performs a 3-way merge of file contents
+// modifyDeleteConflict describes a file deleted on one side, its ID
+// empty, and changed on the other
+func (r *Repository) modifyDeleteConflict(path, baseID, ourID, theirID string) (MergeConflict, error) {
+	conflict := MergeConflict{Path: path, OursDeleted: ourID == "", TheirsDeleted: theirID == ""}
+	for _, side := range []struct {
+		objID   string
+		content *string
+	}{{baseID, &conflict.BaseContent}, {ourID, &conflict.OurContent}, {theirID, &conflict.TheirContent}} {
+		if side.objID == "" {
+			continue
+		}
+		content, err := r.readObject(side.objID)
+		if err != nil {
+			return conflict, fmt.Errorf("failed to read content for %s: %w", path, err)
+		}
+		*side.content = string(content)
+	}
+	return conflict, nil
+}
+
+// MergeFiles performs a line-based 3-way merge of file contents. Changes
+// made on only one side are taken as they are; overlapping changes conflict
+// and are either resolved by the strategy or marked in the result.
 func (r *Repository) MergeFiles(baseContent, ourContent, theirContent string, strategy MergeStrategy) (string, bool, error) {
-	// Simple line-based 3-way merge
-	baseLines := strings.Split(baseContent, "\n")
-	ourLines := strings.Split(ourContent, "\n")
-	theirLines := strings.Split(theirContent, "\n")
+	baseLines := splitMergeLines(baseContent)
+	ourLines := splitMergeLines(ourContent)
+	theirLines := splitMergeLines(theirContent)
+
+	// Collect the changes of each side relative to base, ordered by where
+	// they start in base
+	hunks := append(mergeHunks(baseLines, ourLines, false), mergeHunks(baseLines, theirLines, true)...)
+	sort.SliceStable(hunks, func(i, j int) bool {
+		return hunks[i].baseStart < hunks[j].baseStart
+	})
+
+	var sb strings.Builder
+	hasConflict := false
+	basePos := 0
+	for i := 0; i < len(hunks); {
+		// Group changes that overlap or touch in base
+		start, end := hunks[i].baseStart, hunks[i].baseEnd
+		j := i + 1
+		for j < len(hunks) && hunks[j].baseStart <= end {
+			if hunks[j].baseEnd > end {
+				end = hunks[j].baseEnd
+			}
+			j++
+		}
+		group := hunks[i:j]
+		i = j
+
+		writeMergeLines(&sb, baseLines[basePos:start])
+		basePos = end
+
+		ours := mergeSide(baseLines, ourLines, group, start, end, false)
+		theirs := mergeSide(baseLines, theirLines, group, start, end, true)
+		switch {
+		case !hunksFrom(group, true):
+			writeMergeLines(&sb, ours)
+		case !hunksFrom(group, false), equalLines(ours, theirs):
+			writeMergeLines(&sb, theirs)
+		case strategy == Ours:
+			writeMergeLines(&sb, ours)
+		case strategy == Theirs:
+			writeMergeLines(&sb, theirs)
+		case strategy == Union:
+			writeMergeLines(&sb, ours)
+			writeMergeLines(&sb, theirs)
+		default:
+			hasConflict = true
+			sb.WriteString("<<<<<<< OURS\n")
+			writeConflictLines(&sb, ours)
+			sb.WriteString("=======\n")
+			writeConflictLines(&sb, theirs)
+			sb.WriteString(">>>>>>> THEIRS\n")
+		}
+	}
+	writeMergeLines(&sb, baseLines[basePos:])
+
+	return sb.String(), hasConflict, nil
+}
 
-	// Remove trailing empty lines
-	if len(baseLines) > 0 && baseLines[len(baseLines)-1] == "" {
-		baseLines = baseLines[:len(baseLines)-1]
-	}
-	if len(ourLines) > 0 && ourLines[len(ourLines)-1] == "" {
-		ourLines = ourLines[:len(ourLines)-1]
+// mergeHunk is a change of one side of a merge relative to base
+type mergeHunk struct {
+	baseStart, baseEnd int  // Replaced lines in base
+	sideStart, sideEnd int  // Replacement lines in the side
+	theirs             bool // Whether the change was made by theirs
+}
+
+// splitMergeLines splits content into lines that keep their line endings,
+// so a missing final newline is a change like any other
+func splitMergeLines(content string) []string {
+	if content == "" {
+		return nil
 	}
-	if len(theirLines) > 0 && theirLines[len(theirLines)-1] == "" {
-		theirLines = theirLines[:len(theirLines)-1]
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
 	}
+	return lines
+}
 
-	// Build maps for faster lookup
-	baseMap := make(map[string]int)
-	for i, line := range baseLines {
-		baseMap[line] = i
+// mergeHunks returns the changes between base and side
+func mergeHunks(base, side []string, theirs bool) []mergeHunk {
+	var hunks []mergeHunk
+	basePos, sidePos := 0, 0
+	lcs := append(longestCommonSubsequence(base, side), []int{len(base), len(side)})
+	for _, pair := range lcs {
+		if pair[0] > basePos || pair[1] > sidePos {
+			hunks = append(hunks, mergeHunk{basePos, pair[0], sidePos, pair[1], theirs})
+		}
+		basePos, sidePos = pair[0]+1, pair[1]+1
 	}
+	return hunks
+}
 
-	// Track which lines have been processed
-	ourProcessed := make([]bool, len(ourLines))
-	theirProcessed := make([]bool, len(theirLines))
-
-	// Result lines
-	var resultLines []string
-	hasConflict := false
-
-	// First pass: find unchanged and non-conflicting lines
-	for i, ourLine := range ourLines {
-		if ourProcessed[i] {
-			continue
+// hunksFrom reports whether any change in the group was made by the given side
+func hunksFrom(group []mergeHunk, theirs bool) bool {
+	for _, hunk := range group {
+		if hunk.theirs == theirs {
+			return true
 		}
+	}
+	return false
+}
 
-		// Look for the same line in theirs
-		found := false
-		for j, theirLine := range theirLines {
-			if theirProcessed[j] {
-				continue
+// mergeSide returns the lines of one side that replace base[start:end].
+// Outside its own changes a side matches base line for line.
+func mergeSide(base, side []string, group []mergeHunk, start, end int, theirs bool) []string {
+	var first, last *mergeHunk
+	for i := range group {
+		if group[i].theirs == theirs {
+			if first == nil {
+				first = &group[i]
 			}
+			last = &group[i]
+		}
+	}
+	if first == nil {
+		return base[start:end]
+	}
+	return side[first.sideStart-(first.baseStart-start) : last.sideEnd+(end-last.baseEnd)]
+}
 
-			if ourLine == theirLine {
-				// Line unchanged or changed identically
-				resultLines = append(resultLines, ourLine)
-				ourProcessed[i] = true
-				theirProcessed[j] = true
-				found = true
-				break
-			}
+// equalLines reports whether two line slices are identical
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
 		}
+	}
+	return true
+}
 
-		if !found {
-			// Check if the line exists in base
-			if baseIdx, exists := baseMap[ourLine]; exists {
-				// Line unchanged in ours but changed or deleted in theirs
-				// Need to check if there's a conflicting edit
+// writeMergeLines writes lines to a merge result
+func writeMergeLines(sb *strings.Builder, lines []string) {
+	for _, line := range lines {
+		sb.WriteString(line)
+	}
+}
 
-				// Find closest match in their changes
-				theirIdx := -1
-				for j, processed := range theirProcessed {
-					if !processed && j < len(theirLines) {
-						if baseIdx-1 <= j && j <= baseIdx+1 {
-							theirIdx = j
-							break
-						}
-					}
-				}
+// writeConflictLines writes one side of a conflict, ending the last line
+// so the following marker starts on a line of its own
+func writeConflictLines(sb *strings.Builder, lines []string) {
+	writeMergeLines(sb, lines)
+	if len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+		sb.WriteString("\n")
+	}
+}
 
-				if theirIdx != -1 && theirLines[theirIdx] != baseLines[baseIdx] {
-					// Conflicting change
-					hasConflict = true
-				} else {
-					// Non-conflicting, keep our change
-					resultLines = append(resultLines, ourLine)
-					ourProcessed[i] = true
-				}
-			} else {
-				// Line added in ours
-				resultLines = append(resultLines, ourLine)
-				ourProcessed[i] = true
-			}
-		}
+// mergeBlobs merges a file changed on both sides with the merge driver its
+// attributes name: "text" (the default) merges lines, or uses the semantic
+// merge where semantic merging applies; "binary" always conflicts, keeping
+// our version; "union" keeps the lines of both sides in conflicts. Other
+// drivers run the command configured as merge.<driver>.driver, falling
+// back to "text" if there is none.
+func (r *Repository) mergeBlobs(path string, base, ours, theirs []byte, options *MergeOptions) (string, bool, error) {
+	attrs, err := r.Attributes(path)
+	if err != nil {
+		return "", false, err
 	}
 
-	// Second pass: add any remaining their lines
-	for j, theirLine := range theirLines {
-		if !theirProcessed[j] {
-			// Line unique to theirs
-			resultLines = append(resultLines, theirLine)
+	switch driver := attrs.mergeDriver(); driver {
+	case "binary":
+		return string(ours), true, nil
+	case "union":
+		return r.MergeFiles(string(base), string(ours), string(theirs), Union)
+	case "text":
+	default:
+		if command, ok := r.ConfigValue(fmt.Sprintf("merge \"%s\"", driver), "driver"); ok && command != "" {
+			return runMergeDriver(command, path, base, ours, theirs)
 		}
 	}
 
-	// If we detected conflicts, return a conflict marker string
-	if hasConflict {
-		// This is a simplified version, a real implementation would show
-		// the exact conflicting sections with markers
-		if strategy == Ours {
-			return ourContent, false, nil
-		} else if strategy == Theirs {
-			return theirContent, false, nil
+	if options.UseSemantic && attrs.semantic(path) {
+		return r.SemanticMergeFiles(string(base), string(ours), string(theirs))
+	}
+	return r.MergeFiles(string(base), string(ours), string(theirs), options.Strategy)
+}
+
+// runMergeDriver runs a custom merge driver. In the command %O, %A and %B
+// are replaced by temporary files holding the base, our and their version
+// and %P by the path. The driver leaves its result in %A and exits with a
+// non-zero status if it left conflicts.
+func runMergeDriver(command, path string, base, ours, theirs []byte) (string, bool, error) {
+	dir, err := os.MkdirTemp("", "kit-merge-")
+	if err != nil {
+		return "", false, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string][]byte{"%O": base, "%A": ours, "%B": theirs}
+	replacements := []string{"%P", shellQuote(path)}
+	for placeholder, content := range files {
+		file := filepath.Join(dir, strings.TrimPrefix(placeholder, "%"))
+		if err := os.WriteFile(file, content, 0644); err != nil {
+			return "", false, fmt.Errorf("failed to write temporary file: %w", err)
 		}
+		replacements = append(replacements, placeholder, shellQuote(file))
+	}
 
-		var sb strings.Builder
-		sb.WriteString("<<<<<<< OURS\n")
-		sb.WriteString(ourContent)
-		sb.WriteString("\n=======\n")
-		sb.WriteString(theirContent)
-		sb.WriteString("\n>>>>>>> THEIRS\n")
-		return sb.String(), true, nil
+	cmd := exec.Command("sh", "-c", strings.NewReplacer(replacements...).Replace(command))
+	cmd.Stderr = os.Stderr
+	runErr := cmd.Run()
+	var exitErr *exec.ExitError
+	if runErr != nil && !errors.As(runErr, &exitErr) {
+		return "", false, fmt.Errorf("failed to run merge driver for %s: %w", path, runErr)
 	}
 
-	// Join result lines
-	return strings.Join(resultLines, "\n"), false, nil
+	result, err := os.ReadFile(filepath.Join(dir, "A"))
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read merge driver result: %w", err)
+	}
+	return string(result), runErr != nil, nil
+}
+
+// shellQuote quotes a string for use as a single shell word
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
 }
 
 // SemanticMergeFiles uses semantic understanding to perform smart merges
@@ -760,6 +921,34 @@ func (r *Repository) CreateMergeCommit(message string, parent1, parent2, treeID
 // WriteConflictMarkers writes conflicts to files in standard format
 func (r *Repository) WriteConflictMarkers(conflicts []MergeConflict) error {
 	for _, conflict := range conflicts {
+		// A file deleted on one side is left as the other side changed it
+		if conflict.OursDeleted || conflict.TheirsDeleted {
+			content := conflict.OurContent
+			if conflict.OursDeleted {
+				content = conflict.TheirContent
+			}
+			filePath := filepath.Join(r.Path, conflict.Path)
+			if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+				return fmt.Errorf("failed to create directory for %s: %w", conflict.Path, err)
+			}
+			if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+				return fmt.Errorf("failed to write %s: %w", conflict.Path, err)
+			}
+			continue
+		}
+
+		// Binary files can't hold markers, keep our version
+		attrs, err := r.Attributes(conflict.Path)
+		if err != nil {
+			return err
+		}
+		if attrs.IsBinary() || isBinaryContent([]byte(conflict.OurContent)) || isBinaryContent([]byte(conflict.TheirContent)) {
+			if err := os.WriteFile(filepath.Join(r.Path, conflict.Path), []byte(conflict.OurContent), 0644); err != nil {
+				return fmt.Errorf("failed to write %s: %w", conflict.Path, err)
+			}
+			continue
+		}
+
 		// Create conflict marker content
 		var content strings.Builder
 		content.WriteString("<<<<<<< HEAD\n")
@@ -770,7 +959,7 @@ func (r *Repository) WriteConflictMarkers(conflicts []MergeConflict) error {
 
 		// Write to file
 		filePath := filepath.Join(r.Path, conflict.Path)
-		err := os.MkdirAll(filepath.Dir(filePath), 0755)
+		err = os.MkdirAll(filepath.Dir(filePath), 0755)
 		if err != nil {
 			return fmt.Errorf("failed to create directory for %s: %w", conflict.Path, err)
 		}
@@ -0,0 +1,224 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMergeFiles(t *testing.T) {
+	repo := newTestRepository(t)
+
+	base := "one\ntwo\nthree\nfour\n"
+	tests := []struct {
+		name     string
+		ours     string
+		theirs   string
+		strategy MergeStrategy
+		want     string
+		conflict bool
+	}{
+		{"separate edits", "ONE\ntwo\nthree\nfour\n", "one\ntwo\nthree\nFOUR\n", Manual, "ONE\ntwo\nthree\nFOUR\n", false},
+		{"same edit", "one\nTWO\nthree\nfour\n", "one\nTWO\nthree\nfour\n", Manual, "one\nTWO\nthree\nfour\n", false},
+		{"insert and delete", "zero\none\ntwo\nthree\nfour\n", "one\ntwo\nfour\n", Manual, "zero\none\ntwo\nfour\n", false},
+		{"conflict", "one\nTWO\nthree\nfour\n", "one\n2\nthree\nfour\n", Manual, "one\n<<<<<<< OURS\nTWO\n=======\n2\n>>>>>>> THEIRS\nthree\nfour\n", true},
+		{"conflict ours", "one\nTWO\nthree\nfour\n", "one\n2\nthree\nFOUR\n", Ours, "one\nTWO\nthree\nFOUR\n", false},
+		{"conflict theirs", "one\nTWO\nthree\nfour\n", "one\n2\nthree\nfour\n", Theirs, "one\n2\nthree\nfour\n", false},
+		{"missing newline", "one\ntwo\nthree\nfour", "ONE\ntwo\nthree\nfour\n", Manual, "ONE\ntwo\nthree\nfour", false},
+		{"adjacent edits", "ONE\ntwo\nthree\nfour\n", "one\nTWO\nthree\nfour\n", Manual, "<<<<<<< OURS\nONE\ntwo\n=======\none\nTWO\n>>>>>>> THEIRS\nthree\nfour\n", true},
+		{"both append", "one\ntwo\nthree\nfour\nfive\n", "one\ntwo\nthree\nfour\n5\n", Manual, "one\ntwo\nthree\nfour\n<<<<<<< OURS\nfive\n=======\n5\n>>>>>>> THEIRS\n", true},
+		{"delete everything", "", "one\ntwo\nthree\nfour\n", Manual, "", false},
+		{"conflict keeps trailing newline", "one\nTWO\nthree\nfour\n", "one\n2\nthree\nfour\n", Ours, "one\nTWO\nthree\nfour\n", false},
+	}
+
+	for _, test := range tests {
+		got, conflict, err := repo.MergeFiles(base, test.ours, test.theirs, test.strategy)
+		if err != nil {
+			t.Fatalf("%s: failed to merge: %v", test.name, err)
+		}
+		if got != test.want || conflict != test.conflict {
+			t.Errorf("%s: expected %q (conflict %v), got %q (conflict %v)", test.name, test.want, test.conflict, got, conflict)
+		}
+	}
+}
+
+func TestMergeFilesRepeatedLines(t *testing.T) {
+	repo := newTestRepository(t)
+
+	// Changes are placed by position, not by matching line content, so
+	// repeated lines are neither dropped nor duplicated
+	got, conflict, err := repo.MergeFiles("a\nx\nb\nx\nc\n", "a\nX\nb\nx\nc\n", "a\nx\nb\nx\nC\n", Manual)
+	if err != nil {
+		t.Fatalf("Failed to merge: %v", err)
+	}
+	if conflict || got != "a\nX\nb\nx\nC\n" {
+		t.Errorf("Expected both edits of repeated lines, got %q (conflict %v)", got, conflict)
+	}
+
+	// A strategy only settles the overlapping change; the other side's
+	// separate changes are still merged in
+	got, conflict, err = repo.MergeFiles("a\nb\nc\n", "A\nb\nc\n", "1\nb\nc\nd\n", Ours)
+	if err != nil {
+		t.Fatalf("Failed to merge: %v", err)
+	}
+	if conflict || got != "A\nb\nc\nd\n" {
+		t.Errorf("Expected ours for the conflict and theirs elsewhere, got %q (conflict %v)", got, conflict)
+	}
+}
+
+func TestMergeRecursive(t *testing.T) {
+	repo := newTestRepository(t)
+	lines := []string{"1", "2", "3", "4", "5", "6", "7", "8", "9"}
+	content := func(changes map[int]string) string {
+		changed := append([]string{}, lines...)
+		for i, line := range changes {
+			changed[i-1] = line
+		}
+		return strings.Join(changed, "\n") + "\n"
+	}
+	checkout := func(branch string) {
+		t.Helper()
+		if err := repo.CheckoutBranch(branch); err != nil {
+			t.Fatalf("Failed to checkout %s: %v", branch, err)
+		}
+	}
+	merge := func(branch string) *MergeResult {
+		t.Helper()
+		result, err := repo.Merge(branch, &MergeOptions{Strategy: Manual})
+		if err != nil || !result.Success {
+			t.Fatalf("Failed to merge %s: %+v (%v)", branch, result, err)
+		}
+		return result
+	}
+
+	// Criss-cross: main and feature each merge the other's first change
+	commitTestFiles(t, repo, "A", map[string]string{"a.txt": content(nil)})
+	if err := repo.CreateBranch("feature"); err != nil {
+		t.Fatalf("Failed to create branch: %v", err)
+	}
+	b := commitTestFiles(t, repo, "B", map[string]string{"a.txt": content(map[int]string{1: "1 main"})})
+	if err := repo.CreateBranch("side"); err != nil {
+		t.Fatalf("Failed to create branch: %v", err)
+	}
+	checkout("feature")
+	c := commitTestFiles(t, repo, "C", map[string]string{"a.txt": content(map[int]string{9: "9 feature"})})
+	checkout("main")
+	merge("feature")
+	checkout("feature")
+	theirs := merge("side").MergedCommit
+	checkout("main")
+	ours := commitTestFiles(t, repo, "X", map[string]string{"a.txt": content(map[int]string{1: "1 main 2", 9: "9 main"})})
+
+	bases, err := repo.MergeBases(ours, theirs)
+	if err != nil {
+		t.Fatalf("Failed to find merge bases: %v", err)
+	}
+	sort.Strings(bases)
+	want := []string{b, c}
+	sort.Strings(want)
+	if strings.Join(bases, " ") != strings.Join(want, " ") {
+		t.Errorf("Expected merge bases B and C, got %v", bases)
+	}
+
+	// Against either base alone, main and feature both changed line 1 or
+	// line 9; against the virtual base of B and C, only main changed them
+	merge("feature")
+	data, err := os.ReadFile(filepath.Join(repo.Path, "a.txt"))
+	if err != nil {
+		t.Fatalf("Failed to read a.txt: %v", err)
+	}
+	if string(data) != content(map[int]string{1: "1 main 2", 9: "9 main"}) {
+		t.Errorf("Unexpected merge result %q", data)
+	}
+}
+
+func TestMergeUnrelatedHistories(t *testing.T) {
+	repo := newTestRepository(t)
+	head := commitTestFiles(t, repo, "A", map[string]string{"a.txt": "a\n"})
+
+	// A root commit on another branch
+	blobID := hashContent([]byte("b\n"))
+	if err := repo.storeObject(blobID, []byte("b\n")); err != nil {
+		t.Fatalf("Failed to store blob: %v", err)
+	}
+	treeID, err := repo.storeTree(&TreeObject{Entries: map[string]TreeEntry{
+		"b.txt": {Path: "b.txt", Mode: "100644", Type: "blob", ObjID: blobID},
+	}})
+	if err != nil {
+		t.Fatalf("Failed to store tree: %v", err)
+	}
+	other, err := repo.storeCommit(&CommitObject{Tree: treeID, Author: "Other <other@example.com>", Message: "B", Timestamp: time.Now()})
+	if err != nil {
+		t.Fatalf("Failed to store commit: %v", err)
+	}
+	if err := repo.updateReference("refs/heads/other", other); err != nil {
+		t.Fatalf("Failed to create branch: %v", err)
+	}
+
+	bases, err := repo.MergeBases(head, other)
+	if err != nil || len(bases) != 0 {
+		t.Errorf("Expected no merge bases, got %v (%v)", bases, err)
+	}
+	if _, err := repo.FindMergeBase(head, other); err == nil {
+		t.Errorf("Expected FindMergeBase to fail for unrelated histories")
+	}
+
+	if _, err := repo.Merge("other", nil); err == nil || !strings.Contains(err.Error(), "unrelated histories") {
+		t.Errorf("Expected the merge to be refused, got %v", err)
+	}
+	result, err := repo.Merge("other", &MergeOptions{Strategy: Manual, AllowUnrelatedHistories: true})
+	if err != nil || !result.Success {
+		t.Fatalf("Failed to merge unrelated histories: %+v (%v)", result, err)
+	}
+	for _, path := range []string{"a.txt", "b.txt"} {
+		if _, err := os.Stat(filepath.Join(repo.Path, path)); err != nil {
+			t.Errorf("Expected %s after the merge: %v", path, err)
+		}
+	}
+}
+
+func TestMergeTreesModifyDelete(t *testing.T) {
+	repo := newTestRepository(t)
+	blobs := make(map[string]string)
+	for _, content := range []string{"a\n", "changed\n", "b\n"} {
+		blobs[content] = hashContent([]byte(content))
+		if err := repo.storeObject(blobs[content], []byte(content)); err != nil {
+			t.Fatalf("Failed to store blob: %v", err)
+		}
+	}
+	base := buildTree(map[string]string{"a.txt": blobs["a\n"], "b.txt": blobs["b\n"]})
+	ours := buildTree(map[string]string{"b.txt": blobs["b\n"]})
+	theirs := buildTree(map[string]string{"a.txt": blobs["changed\n"]})
+
+	// We deleted a.txt, which they changed; they deleted b.txt, which we didn't touch
+	merged, conflicts, err := repo.MergeTrees(base, ours, theirs, &MergeOptions{Strategy: Manual})
+	if err != nil {
+		t.Fatalf("Failed to merge trees: %v", err)
+	}
+	if len(merged.Entries) != 0 {
+		t.Errorf("Expected neither file in the merged tree, got %+v", merged.Entries)
+	}
+	if len(conflicts) != 1 || !conflicts[0].OursDeleted || conflicts[0].TheirContent != "changed\n" ||
+		conflicts[0].Summary() != "CONFLICT (modify/delete): a.txt deleted in ours and modified in theirs" {
+		t.Fatalf("Expected a modify/delete conflict for a.txt, got %+v", conflicts)
+	}
+	if err := repo.WriteConflictMarkers(conflicts); err != nil {
+		t.Fatalf("Failed to write conflicts: %v", err)
+	}
+	if content := readTestFile(t, repo, "a.txt"); content != "changed\n" {
+		t.Errorf("Expected their version of a.txt without markers, got %q", content)
+	}
+
+	// The strategies resolve it by taking a side
+	merged, _, err = repo.MergeTrees(base, ours, theirs, &MergeOptions{Strategy: Theirs})
+	if err != nil || merged.Entries["a.txt"].ObjID != blobs["changed\n"] {
+		t.Errorf("Expected theirs to keep the changed a.txt, got %+v (%v)", merged.Entries, err)
+	}
+	merged, conflicts, err = repo.MergeTrees(base, theirs, ours, &MergeOptions{Strategy: Theirs})
+	if err != nil || len(merged.Entries) != 0 || len(conflicts) != 1 || !conflicts[0].TheirsDeleted {
+		t.Errorf("Expected theirs to delete the a.txt we changed, got %+v %+v (%v)", merged.Entries, conflicts, err)
+	}
+}
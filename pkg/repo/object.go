@@ -0,0 +1,244 @@
+package repo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ObjectType is the kind of an object in the object database
+type ObjectType string
+
+const (
+	ObjectCommit ObjectType = "commit" // A commit
+	ObjectTree   ObjectType = "tree"   // A tree listing the files of a commit
+	ObjectBlob   ObjectType = "blob"   // The contents of a file
+)
+
+// ObjectType returns the type of an object. Objects are stored without a
+// type, so commits and trees are recognised by their JSON content and
+// anything else is a blob. Where the object was named, ResolveObjectType
+// knows its type without guessing.
+func (r *Repository) ObjectType(objID string) (ObjectType, error) {
+	content, err := r.readObject(objID)
+	if err != nil {
+		return "", err
+	}
+	return objectType(content), nil
+}
+
+// objectType returns the type of an object's content. Content is only a
+// commit or tree when it parses as one in full, with no fields or data
+// beyond those of the kind.
+func objectType(content []byte) ObjectType {
+	var commit CommitObject
+	if decodeObject(content, &commit) == nil && commit.Tree != "" {
+		return ObjectCommit
+	}
+	var tree TreeObject
+	if decodeObject(content, &tree) == nil && tree.Entries != nil {
+		return ObjectTree
+	}
+	return ObjectBlob
+}
+
+// decodeObject decodes JSON content into v, failing on unknown fields and
+// on anything after the value
+func decodeObject(content []byte, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return fmt.Errorf("unexpected data after object")
+	}
+	return nil
+}
+
+// ResolveObject resolves an object name to an object ID. A name is a
+// revision as accepted by ResolveRevision, a full or abbreviated object ID
+// of any type, "<rev>:<path>" for a file in a commit, or "<rev>:" for a
+// commit's tree.
+func (r *Repository) ResolveObject(name string) (string, error) {
+	objID, _, err := r.ResolveObjectType(name)
+	return objID, err
+}
+
+// ResolveObjectType resolves an object name like ResolveObject and returns
+// the object's type along with its ID. The type comes from how the object
+// was named: a revision is a commit, "<rev>:" a tree and "<rev>:<path>"
+// whatever its tree entry says. Only an object ID has its type guessed
+// from its content, as by ObjectType.
+func (r *Repository) ResolveObjectType(name string) (string, ObjectType, error) {
+	if rev, path, ok := strings.Cut(name, ":"); ok {
+		commitID, err := r.ResolveRevision(rev)
+		if err != nil {
+			return "", "", err
+		}
+		commit, err := r.readCommit(commitID)
+		if err != nil {
+			return "", "", err
+		}
+		if path == "" {
+			return commit.Tree, ObjectTree, nil
+		}
+		tree, err := r.readTree(commit.Tree)
+		if err != nil {
+			return "", "", err
+		}
+		entry, ok := tree.Entries[cleanPathspec(path)]
+		if !ok {
+			return "", "", fmt.Errorf("path '%s' does not exist in '%s'", path, rev)
+		}
+		return entry.ObjID, ObjectType(entry.Type), nil
+	}
+
+	if commitID, err := r.ResolveRevision(name); err == nil {
+		return commitID, ObjectCommit, nil
+	}
+	if len(name) >= minAbbrevLength && isHexString(name) {
+		objID, err := r.expandObjectID(strings.ToLower(name))
+		if err != nil {
+			return "", "", err
+		}
+		objType, err := r.ObjectType(objID)
+		if err != nil {
+			return "", "", err
+		}
+		return objID, objType, nil
+	}
+	return "", "", fmt.Errorf("unknown object '%s'", name)
+}
+
+// expandObjectID finds the unique object whose ID starts with prefix
+func (r *Repository) expandObjectID(prefix string) (string, error) {
+	entries, err := os.ReadDir(filepath.Join(r.Path, DefaultKitDir, DefaultKitObjectsDir, prefix[:2]))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("no object %s", prefix)
+		}
+		return "", fmt.Errorf("failed to read objects: %w", err)
+	}
+
+	match := ""
+	for _, entry := range entries {
+		objID := prefix[:2] + entry.Name()
+		if !strings.HasPrefix(objID, prefix) {
+			continue
+		}
+		if match != "" {
+			return "", fmt.Errorf("short object ID %s is ambiguous", prefix)
+		}
+		match = objID
+	}
+	if match == "" {
+		return "", fmt.Errorf("no object %s", prefix)
+	}
+	return match, nil
+}
+
+// isHexString reports whether s only has hexadecimal digits
+func isHexString(s string) bool {
+	for _, c := range strings.ToLower(s) {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// CatObject returns the raw content of an object
+func (r *Repository) CatObject(objID string) ([]byte, error) {
+	return r.readObject(objID)
+}
+
+// PrettyObject returns an object of the given type in a readable form:
+// commits as their header fields and message, trees as by FormatTree,
+// blobs as they are
+func (r *Repository) PrettyObject(objID string, objType ObjectType) ([]byte, error) {
+	content, err := r.readObject(objID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch objType {
+	case ObjectCommit:
+		commit, err := r.readCommit(objID)
+		if err != nil {
+			return nil, err
+		}
+		var sb strings.Builder
+		sb.WriteString("tree " + commit.Tree + "\n")
+		for _, parent := range []string{commit.Parent, commit.Parent2} {
+			if parent != "" {
+				sb.WriteString("parent " + parent + "\n")
+			}
+		}
+		timestamp := fmt.Sprintf("%d %s", commit.Timestamp.Unix(), commit.Timestamp.Format("-0700"))
+		sb.WriteString("author " + commit.Author + " " + timestamp + "\n")
+		sb.WriteString("committer " + commit.Committer + " " + timestamp + "\n")
+		sb.WriteString("\n" + commit.Message + "\n")
+		return []byte(sb.String()), nil
+	case ObjectTree:
+		tree, err := r.readTree(objID)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(FormatTree(sortedTreeEntries(tree, nil))), nil
+	default:
+		return content, nil
+	}
+}
+
+// LsTree returns the entries of the tree of a commit, or of a tree, whose
+// paths match the pathspecs (all entries without pathspecs), sorted by path
+func (r *Repository) LsTree(name string, paths []string) ([]TreeEntry, error) {
+	objID, objType, err := r.ResolveObjectType(name)
+	if err != nil {
+		return nil, err
+	}
+
+	switch objType {
+	case ObjectCommit:
+		commit, err := r.readCommit(objID)
+		if err != nil {
+			return nil, err
+		}
+		objID = commit.Tree
+	case ObjectBlob:
+		return nil, fmt.Errorf("%s is not a tree", name)
+	}
+
+	tree, err := r.readTree(objID)
+	if err != nil {
+		return nil, err
+	}
+	return sortedTreeEntries(tree, paths), nil
+}
+
+// sortedTreeEntries returns the entries of a tree matching the pathspecs, sorted by path
+func sortedTreeEntries(tree *TreeObject, paths []string) []TreeEntry {
+	entries := []TreeEntry{}
+	for path, entry := range tree.Entries {
+		if matchPathspec(path, paths) {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries
+}
+
+// FormatTree formats tree entries as "<mode> <type> <id>\t<path>" lines
+func FormatTree(entries []TreeEntry) string {
+	var sb strings.Builder
+	for _, entry := range entries {
+		sb.WriteString(fmt.Sprintf("%s %s %s\t%s\n", entry.Mode, entry.Type, entry.ObjID, entry.Path))
+	}
+	return sb.String()
+}
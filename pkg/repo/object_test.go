@@ -0,0 +1,99 @@
+package repo
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestObjects(t *testing.T) {
+	repo := newTestRepository(t)
+	commitID := commitTestFiles(t, repo, "Initial commit", map[string]string{
+		"a.txt":     "a\n",
+		"dir/b.txt": "b\n",
+	})
+
+	commit, err := repo.readCommit(commitID)
+	if err != nil {
+		t.Fatalf("Failed to read commit: %v", err)
+	}
+	blobID, err := repo.ResolveObject("HEAD:dir/b.txt")
+	if err != nil {
+		t.Fatalf("Failed to resolve file: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		objID    string
+		objType  ObjectType
+		contains string
+	}{
+		{"HEAD", commitID, ObjectCommit, "tree " + commit.Tree + "\nauthor Kit User <kit@example.com> "},
+		{"main:", commit.Tree, ObjectTree, "100644 blob " + blobID + "\tdir/b.txt\n"},
+		{blobID[:10], blobID, ObjectBlob, "b\n"},
+	}
+	for _, test := range tests {
+		objID, err := repo.ResolveObject(test.name)
+		if err != nil {
+			t.Fatalf("Failed to resolve %s: %v", test.name, err)
+		}
+		if objID != test.objID {
+			t.Errorf("Resolve %s: expected %s, got %s", test.name, test.objID, objID)
+		}
+		objType, err := repo.ObjectType(objID)
+		if err != nil || objType != test.objType {
+			t.Errorf("Expected %s to be a %s, got %s (%v)", test.name, test.objType, objType, err)
+		}
+		pretty, err := repo.PrettyObject(objID, objType)
+		if err != nil {
+			t.Fatalf("Failed to print %s: %v", test.name, err)
+		}
+		if !strings.Contains(string(pretty), test.contains) {
+			t.Errorf("Expected %s to contain %q, got:\n%s", test.name, test.contains, pretty)
+		}
+	}
+
+	if _, err := repo.ResolveObject("HEAD:missing.txt"); err == nil {
+		t.Error("Expected a missing path to fail")
+	}
+
+	entries, err := repo.LsTree("HEAD", []string{"dir"})
+	if err != nil {
+		t.Fatalf("Failed to list tree: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Path != "dir/b.txt" {
+		t.Errorf("Expected only dir/b.txt, got %+v", entries)
+	}
+	if _, err := repo.LsTree("HEAD:a.txt", nil); err == nil {
+		t.Error("Expected listing a blob to fail")
+	}
+}
+
+func TestObjectTypeOfJSONFiles(t *testing.T) {
+	repo := newTestRepository(t)
+	files := map[string]string{
+		"tree.json":   `{"entries":{}}`,
+		"commit.json": `{"tree":"abc","author":"someone","note":"not a commit field"}`,
+		"extra.json":  `{"entries":{}} {"entries":{}}`,
+	}
+	commitTestFiles(t, repo, "JSON files", files)
+
+	// Files named through a commit are blobs whatever they contain
+	for path, content := range files {
+		objID, objType, err := repo.ResolveObjectType("HEAD:" + path)
+		if err != nil || objType != ObjectBlob {
+			t.Errorf("Expected HEAD:%s to be a blob, got %s (%v)", path, objType, err)
+		}
+		if shown, err := repo.Show("HEAD:"+path, nil); err != nil || shown != content {
+			t.Errorf("Expected show of %s to print its content, got %q (%v)", path, shown, err)
+		}
+
+		// Named by ID, only content that fully parses is taken for a commit or tree
+		want := ObjectBlob
+		if path == "tree.json" {
+			want = ObjectTree
+		}
+		if _, objType, err := repo.ResolveObjectType(objID); err != nil || objType != want {
+			t.Errorf("Expected the ID of %s to be a %s, got %s (%v)", path, want, objType, err)
+		}
+	}
+}
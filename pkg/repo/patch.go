@@ -0,0 +1,387 @@
+package repo
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// StageHunks stages a subset of the changes to a file. The hunks, as
+// produced by diffContent against the index version, are applied to the
+// index version and the result is staged; the working file is left
+// untouched. Hunks are located by their context and removed lines, starting
+// at their old line number, so they may be given in any subset but must be
+// in file order. A file not in the index is treated as empty.
+func (r *Repository) StageHunks(path string, hunks []DiffChunk) error {
+	var base []byte
+	if objID, ok := r.indexEntries()[path]; ok {
+		content, err := r.readObject(objID)
+		if err != nil {
+			return fmt.Errorf("failed to read index version of %s: %w", path, err)
+		}
+		base = content
+	}
+
+	content, err := applyHunks(string(base), hunks)
+	if err != nil {
+		return fmt.Errorf("failed to apply hunks to %s: %w", path, err)
+	}
+
+	return r.stageContent(path, []byte(content))
+}
+
+// StageBinaryPatch stages the result of a binary patch. The index version
+// of the file must be oldID, or absent if oldID is empty; the patch's
+// forward hunk is applied to it and the result must be newID. The working
+// file is left untouched.
+func (r *Repository) StageBinaryPatch(path string, patch *BinaryPatch, oldID, newID string) error {
+	indexID := r.indexEntries()[path]
+	if indexID != oldID {
+		return fmt.Errorf("binary patch does not apply to the index version of %s", path)
+	}
+	var base []byte
+	if indexID != "" {
+		content, err := r.readObject(indexID)
+		if err != nil {
+			return fmt.Errorf("failed to read index version of %s: %w", path, err)
+		}
+		base = content
+	}
+
+	content, err := patch.Forward.Apply(base)
+	if err != nil {
+		return fmt.Errorf("failed to apply binary patch to %s: %w", path, err)
+	}
+	if hashContent(content) != newID {
+		return fmt.Errorf("binary patch for %s does not produce %s", path, newID)
+	}
+
+	return r.stageContent(path, content)
+}
+
+// stageContent stores content and stages it as path
+func (r *Repository) stageContent(path string, content []byte) error {
+	objID := hashContent(content)
+	if err := r.storeObject(objID, content); err != nil {
+		return fmt.Errorf("failed to store object: %w", err)
+	}
+
+	if trackedID, tracked := r.State.Tracked[path]; tracked && trackedID == objID {
+		delete(r.State.Stage, path)
+	} else {
+		r.State.Stage[path] = objID
+	}
+	delete(r.State.Removed, path)
+	delete(r.State.Conflicts, path)
+
+	if err := r.SaveIndex(); err != nil {
+		return fmt.Errorf("failed to save index: %w", err)
+	}
+
+	return nil
+}
+
+// applyHunks applies hunks to content. Each hunk's context and removed lines
+// must appear in content after the previous hunk; the occurrence closest to
+// the hunk's old line number is used. A "\ No newline at end of file" marker
+// after a line says that side of the hunk ends the file without a newline,
+// so the hunk must end where content does, and decides whether the result
+// ends in one.
+func applyHunks(content string, hunks []DiffChunk) (string, error) {
+	lines := strings.Split(content, "\n")
+	hadNewline := true
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	} else if content != "" {
+		hadNewline = false
+	}
+	newline := hadNewline
+
+	result := []string{}
+	next := 0
+	for n, hunk := range hunks {
+		var oldLines, newLines []string
+		oldNoNewline, newNoNewline := false, false
+		last := byte(0)
+		for _, line := range hunk.Lines {
+			if line == "" {
+				return "", fmt.Errorf("hunk %d has a line without a prefix", n+1)
+			}
+			switch line[0] {
+			case ' ':
+				oldLines = append(oldLines, line[1:])
+				newLines = append(newLines, line[1:])
+			case '-':
+				oldLines = append(oldLines, line[1:])
+			case '+':
+				newLines = append(newLines, line[1:])
+			case '\\':
+				if last == 0 {
+					return "", fmt.Errorf("hunk %d starts with a no newline marker", n+1)
+				}
+				oldNoNewline = oldNoNewline || last == ' ' || last == '-'
+				newNoNewline = newNoNewline || last == ' ' || last == '+'
+				continue
+			default:
+				return "", fmt.Errorf("hunk %d has a line with unknown prefix %q", n+1, line[0])
+			}
+			last = line[0]
+		}
+
+		// A hunk without old lines goes after its start line rather than at it
+		hint := hunk.OldStart - 1
+		if len(oldLines) == 0 {
+			hint = hunk.OldStart
+		}
+		pos := findHunk(lines, oldLines, next, hint)
+		if pos < 0 {
+			return "", fmt.Errorf("hunk %d does not apply", n+1)
+		}
+		// Whether the old side ends the file without a newline must match
+		atEnd := pos+len(oldLines) == len(lines)
+		if oldNoNewline && !atEnd || atEnd && len(oldLines) > 0 && oldNoNewline == hadNewline {
+			return "", fmt.Errorf("hunk %d does not apply", n+1)
+		}
+		if atEnd {
+			newline = !newNoNewline
+		}
+
+		result = append(result, lines[next:pos]...)
+		result = append(result, newLines...)
+		next = pos + len(oldLines)
+	}
+	result = append(result, lines[next:]...)
+
+	if len(result) == 0 {
+		return "", nil
+	}
+	joined := strings.Join(result, "\n")
+	if newline {
+		joined += "\n"
+	}
+	return joined, nil
+}
+
+// findHunk returns the position at or after from where lines contains
+// match, choosing the one closest to hint, or -1 if there is none
+func findHunk(lines, match []string, from, hint int) int {
+	best := -1
+	for pos := from; pos+len(match) <= len(lines); pos++ {
+		if !equalLines(lines[pos:pos+len(match)], match) {
+			continue
+		}
+		if best < 0 || abs(pos-hint) < abs(best-hint) {
+			best = pos
+		}
+	}
+	return best
+}
+
+// abs returns the absolute value of an integer
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// ParsePatch parses a unified diff, such as the output of FormatDiff, into
+// diff results. Binary patches are read along with the object IDs on the
+// "index" line before them; a "Binary files ... differ" line gives a binary
+// result without a patch. "\ No newline at end of file" markers are kept
+// in the hunk lines, after the line they follow. Other lines outside of
+// file headers and hunks are ignored.
+func ParsePatch(patch string) ([]DiffResult, error) {
+	results := []DiffResult{}
+	var current *DiffResult
+	var oldID, newID string
+
+	scanner := bufio.NewScanner(strings.NewReader(patch))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<30)
+	lineNo := 0
+	held, holding := "", false
+	next := func() (string, bool) {
+		if holding {
+			holding = false
+			lineNo++
+			return held, true
+		}
+		if !scanner.Scan() {
+			return "", false
+		}
+		lineNo++
+		return scanner.Text(), true
+	}
+	unread := func(line string) {
+		held, holding = line, true
+		lineNo--
+	}
+
+	for {
+		line, ok := next()
+		if !ok {
+			break
+		}
+
+		switch {
+		case strings.HasPrefix(line, "index "):
+			if fields := strings.Fields(line); len(fields) > 1 {
+				oldID, newID, _ = strings.Cut(fields[1], "..")
+			}
+
+		case strings.HasPrefix(line, "--- "):
+			header, ok := next()
+			if !ok || !strings.HasPrefix(header, "+++ ") {
+				return nil, fmt.Errorf("line %d: expected +++ header after ---", lineNo)
+			}
+			results = append(results, DiffResult{
+				OldPath: patchPath(line[4:], "a/"),
+				NewPath: patchPath(header[4:], "b/"),
+			})
+			current = &results[len(results)-1]
+			if strings.Trim(oldID, "0") != "" {
+				current.OldID = oldID
+			}
+			if strings.Trim(newID, "0") != "" {
+				current.NewID = newID
+			}
+			oldID, newID = "", ""
+
+		case strings.HasPrefix(line, "Binary files ") && strings.HasSuffix(line, " differ"):
+			paths := strings.TrimSuffix(strings.TrimPrefix(line, "Binary files "), " differ")
+			oldPath, newPath, found := strings.Cut(paths, " and ")
+			if !found {
+				return nil, fmt.Errorf("line %d: invalid binary files line: %s", lineNo, line)
+			}
+			results = append(results, DiffResult{
+				OldPath: patchPath(oldPath, "a/"),
+				NewPath: patchPath(newPath, "b/"),
+				Binary:  true,
+			})
+			current = nil
+			oldID, newID = "", ""
+
+		case line == "GIT binary patch":
+			if current == nil {
+				return nil, fmt.Errorf("line %d: binary patch without file header", lineNo)
+			}
+			header, _ := next()
+			forward, err := parseBinaryHunk(header, next)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			current.Binary = true
+			current.Patch = &BinaryPatch{Forward: forward}
+			if header, ok := next(); ok && header != "" {
+				if current.Patch.Reverse, err = parseBinaryHunk(header, next); err != nil {
+					return nil, fmt.Errorf("line %d: %w", lineNo, err)
+				}
+			}
+
+		case strings.HasPrefix(line, "@@ "):
+			if current == nil {
+				return nil, fmt.Errorf("line %d: hunk without file header", lineNo)
+			}
+			chunk, err := parseHunkHeader(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+
+			// The header's line counts say how far the hunk extends
+			oldLeft, newLeft := chunk.OldLength, chunk.NewLength
+			for oldLeft > 0 || newLeft > 0 {
+				body, ok := next()
+				if !ok {
+					return nil, fmt.Errorf("line %d: hunk ends early", lineNo)
+				}
+				if strings.HasPrefix(body, "\\") {
+					chunk.Lines = append(chunk.Lines, body)
+					continue
+				}
+				if body == "" {
+					body = " "
+				}
+				switch body[0] {
+				case ' ':
+					oldLeft--
+					newLeft--
+				case '-':
+					oldLeft--
+				case '+':
+					newLeft--
+				default:
+					return nil, fmt.Errorf("line %d: unexpected line in hunk: %s", lineNo, body)
+				}
+				if oldLeft < 0 || newLeft < 0 {
+					return nil, fmt.Errorf("line %d: hunk is longer than its header says", lineNo)
+				}
+				chunk.Lines = append(chunk.Lines, body)
+			}
+			// A marker may follow the hunk's last line
+			if body, ok := next(); ok {
+				if strings.HasPrefix(body, "\\") {
+					chunk.Lines = append(chunk.Lines, body)
+				} else {
+					unread(body)
+				}
+			}
+			current.Chunks = append(current.Chunks, chunk)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read patch: %w", err)
+	}
+
+	return results, nil
+}
+
+// patchPath strips the a/ or b/ prefix from a path in a file header
+func patchPath(name, prefix string) string {
+	if i := strings.IndexByte(name, '\t'); i >= 0 {
+		name = name[:i]
+	}
+	return strings.TrimPrefix(name, prefix)
+}
+
+// parseHunkHeader parses a "@@ -start,length +start,length @@" line
+func parseHunkHeader(line string) (DiffChunk, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 || fields[3] != "@@" || !strings.HasPrefix(fields[1], "-") || !strings.HasPrefix(fields[2], "+") {
+		return DiffChunk{}, fmt.Errorf("invalid hunk header: %s", line)
+	}
+
+	oldStart, oldLength, err := parseHunkRange(fields[1][1:])
+	if err != nil {
+		return DiffChunk{}, fmt.Errorf("invalid hunk header: %s", line)
+	}
+	newStart, newLength, err := parseHunkRange(fields[2][1:])
+	if err != nil {
+		return DiffChunk{}, fmt.Errorf("invalid hunk header: %s", line)
+	}
+
+	return DiffChunk{
+		OldStart:  oldStart,
+		OldLength: oldLength,
+		NewStart:  newStart,
+		NewLength: newLength,
+		Lines:     []string{},
+	}, nil
+}
+
+// parseHunkRange parses "start,length", where the length defaults to 1
+func parseHunkRange(value string) (int, int, error) {
+	startText, lengthText, found := strings.Cut(value, ",")
+	start, err := strconv.Atoi(startText)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !found {
+		return start, 1, nil
+	}
+	length, err := strconv.Atoi(lengthText)
+	if err != nil {
+		return 0, 0, err
+	}
+	return start, length, nil
+}
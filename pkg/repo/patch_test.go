@@ -0,0 +1,119 @@
+package repo
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStageHunks(t *testing.T) {
+	repo := newTestRepository(t)
+
+	lines := []string{}
+	for i := 1; i <= 12; i++ {
+		lines = append(lines, "line "+string(rune('a'+i-1)))
+	}
+	original := strings.Join(lines, "\n") + "\n"
+	commitTestFiles(t, repo, "Initial commit", map[string]string{"file.txt": original})
+
+	changed := append([]string{}, lines...)
+	changed[1] = "line B"
+	changed[10] = "line K"
+	working := strings.Join(changed, "\n") + "\n"
+	writeTestFile(t, repo, "file.txt", working)
+
+	chunks := diffContent(original, working, &DiffOptions{ContextLines: 1})
+	if len(chunks) != 2 {
+		t.Fatalf("Expected 2 hunks, got %d", len(chunks))
+	}
+
+	// Stage only the second hunk, through a patch round trip
+	patch := FormatDiff([]DiffResult{{OldPath: "file.txt", NewPath: "file.txt", Chunks: chunks[1:]}})
+	results, err := ParsePatch(patch)
+	if err != nil {
+		t.Fatalf("Failed to parse patch: %v", err)
+	}
+	if len(results) != 1 || results[0].NewPath != "file.txt" || len(results[0].Chunks) != 1 {
+		t.Fatalf("Expected one file with one hunk, got %+v", results)
+	}
+	if err := repo.StageHunks("file.txt", results[0].Chunks); err != nil {
+		t.Fatalf("Failed to stage hunks: %v", err)
+	}
+
+	staged, err := repo.readObject(repo.State.Stage["file.txt"])
+	if err != nil {
+		t.Fatalf("Failed to read staged blob: %v", err)
+	}
+	expected := append([]string{}, lines...)
+	expected[10] = "line K"
+	if string(staged) != strings.Join(expected, "\n")+"\n" {
+		t.Errorf("Expected only the second hunk to be staged, got %q", staged)
+	}
+	if content := readTestFile(t, repo, "file.txt"); content != working {
+		t.Errorf("Expected working file to be untouched, got %q", content)
+	}
+
+	// The first hunk still applies to the new index version
+	if err := repo.StageHunks("file.txt", chunks[:1]); err != nil {
+		t.Fatalf("Failed to stage remaining hunk: %v", err)
+	}
+	if repo.State.Stage["file.txt"] != hashContent([]byte(working)) {
+		t.Error("Expected staged version to match the working file")
+	}
+
+	// A hunk whose lines aren't in the index version is refused
+	if err := repo.StageHunks("file.txt", chunks[:1]); err == nil {
+		t.Error("Expected an already applied hunk not to apply")
+	}
+}
+
+func TestStageHunksMissingNewline(t *testing.T) {
+	repo := newTestRepository(t)
+
+	// stage round trips the diff from original to content through a patch
+	// and stages it, with a missing original for an added file
+	stage := func(path, original, content string) string {
+		t.Helper()
+		oldPath := path
+		if original == "" {
+			oldPath = "/dev/null"
+		}
+		result, err := repo.diffBlobs(oldPath, path, []byte(original), []byte(content), &DefaultDiffOptions)
+		if err != nil {
+			t.Fatalf("Failed to diff: %v", err)
+		}
+		parsed, err := ParsePatch(FormatDiff([]DiffResult{result}))
+		if err != nil || len(parsed) != 1 {
+			t.Fatalf("Failed to parse patch: %v", err)
+		}
+		if err := repo.StageHunks(path, parsed[0].Chunks); err != nil {
+			t.Fatalf("Failed to stage %q over %q: %v", content, original, err)
+		}
+		staged, _ := repo.readObject(repo.State.Stage[path])
+		return string(staged)
+	}
+
+	commitTestFiles(t, repo, "Initial commit", map[string]string{
+		"ends.txt":  "one\ntwo\n",
+		"short.txt": "one\ntwo",
+		"last.txt":  "one\ntwo",
+	})
+	if got := stage("ends.txt", "one\ntwo\n", "one\ntwo"); got != "one\ntwo" {
+		t.Errorf("Expected the final newline to be removed, got %q", got)
+	}
+	if got := stage("short.txt", "one\ntwo", "one\ntwo\n"); got != "one\ntwo\n" {
+		t.Errorf("Expected the final newline to be added, got %q", got)
+	}
+	if got := stage("last.txt", "one\ntwo", "one\nthree"); got != "one\nthree" {
+		t.Errorf("Expected the last line to change without a newline, got %q", got)
+	}
+	if got := stage("added.txt", "", "new"); got != "new" {
+		t.Errorf("Expected an added file without a newline, got %q", got)
+	}
+
+	// A hunk claiming the file has no final newline doesn't apply to one with it
+	hunk := DiffChunk{OldStart: 2, OldLength: 1, NewStart: 2, NewLength: 1,
+		Lines: []string{"-two", noNewlineMarker, "+two"}}
+	if _, err := applyHunks("one\ntwo\n", []DiffChunk{hunk}); err == nil {
+		t.Error("Expected a no newline marker not to apply to a file with one")
+	}
+}
@@ -0,0 +1,113 @@
+package repo
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// matchPathspec reports whether a repository path is selected by any of the
+// given pathspecs. An empty list selects every path. A pathspec selects the
+// path itself, everything below it when it names a directory, and any path
+// matching it as a glob. As in git, "*" in a pathspec also matches "/".
+func matchPathspec(filePath string, specs []string) bool {
+	if len(specs) == 0 {
+		return true
+	}
+
+	for _, spec := range specs {
+		if pathspecMatches(cleanPathspec(spec), filePath) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pathspecMatches matches a single cleaned pathspec against a path
+func pathspecMatches(spec, filePath string) bool {
+	if spec == "" || spec == "." {
+		return true
+	}
+	if filePath == spec || strings.HasPrefix(filePath, spec+"/") {
+		return true
+	}
+	if !isGlobPathspec(spec) {
+		return false
+	}
+
+	regex, err := regexp.Compile("^" + pathspecToRegexp(spec) + "(?:/.*)?$")
+	return err == nil && regex.MatchString(filePath)
+}
+
+// pathspecCouldMatchBelow reports whether any file below dir might be
+// selected by the pathspecs, so that unrelated directories can be skipped
+func pathspecCouldMatchBelow(dir string, specs []string) bool {
+	if len(specs) == 0 {
+		return true
+	}
+
+	for _, spec := range specs {
+		spec = cleanPathspec(spec)
+		if spec == "" || spec == "." {
+			return true
+		}
+
+		// Only the literal leading directories of a glob constrain the walk
+		if isGlobPathspec(spec) {
+			prefix := spec[:strings.IndexAny(spec, "*?[")]
+			if slash := strings.LastIndex(prefix, "/"); slash >= 0 {
+				spec = prefix[:slash]
+			} else {
+				return true
+			}
+		}
+
+		if dir == spec || strings.HasPrefix(dir, spec+"/") || strings.HasPrefix(spec, dir+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isGlobPathspec reports whether a pathspec contains glob characters
+func isGlobPathspec(spec string) bool {
+	return strings.ContainsAny(spec, "*?[")
+}
+
+// pathspecToRegexp translates a pathspec glob into a regular expression
+func pathspecToRegexp(spec string) string {
+	var sb strings.Builder
+	for i := 0; i < len(spec); i++ {
+		switch c := spec[i]; c {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		case '[':
+			end := strings.IndexByte(spec[i+1:], ']')
+			if end < 0 {
+				sb.WriteString(regexp.QuoteMeta("["))
+				continue
+			}
+			class := spec[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + strings.ReplaceAll(class, "\\", "\\\\") + "]")
+			i += end + 1
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return sb.String()
+}
+
+// cleanPathspec normalises a user supplied pathspec to a slash separated
+// repository relative form
+func cleanPathspec(spec string) string {
+	spec = strings.ReplaceAll(spec, "\\", "/")
+	spec = path.Clean(spec)
+	return strings.TrimPrefix(strings.TrimSuffix(spec, "/"), "./")
+}
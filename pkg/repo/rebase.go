@@ -0,0 +1,521 @@
+package repo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RebaseAction is the command of a line of a rebase todo list
+type RebaseAction string
+
+const (
+	RebasePick   RebaseAction = "pick"   // Replay the commit
+	RebaseReword RebaseAction = "reword" // Replay the commit with a new subject
+	RebaseEdit   RebaseAction = "edit"   // Replay the commit and stop to amend it
+	RebaseSquash RebaseAction = "squash" // Meld into the previous commit, joining the messages
+	RebaseFixup  RebaseAction = "fixup"  // Meld into the previous commit, keeping its message
+	RebaseDrop   RebaseAction = "drop"   // Leave the commit out
+)
+
+// rebaseAbbreviations maps the one-letter forms of todo commands to the commands
+var rebaseAbbreviations = map[string]RebaseAction{
+	"p": RebasePick,
+	"r": RebaseReword,
+	"e": RebaseEdit,
+	"s": RebaseSquash,
+	"f": RebaseFixup,
+	"d": RebaseDrop,
+}
+
+// RebaseStep is a line of a rebase todo list: "<action> <commit> <text>"
+type RebaseStep struct {
+	Action RebaseAction // What to do with the commit
+	Commit string       // The commit
+	Text   string       // The commit's subject, or the new subject for reword
+}
+
+// RebaseOptions represents options for rebasing
+type RebaseOptions struct {
+	Todo []RebaseStep // Steps to run instead of picking every commit of the branch; must not be empty if set
+}
+
+// RebaseResult represents where a rebase stands
+type RebaseResult struct {
+	Head      string          // Commit the branch points at once the rebase is done
+	UpToDate  bool            // The branch was already based on the upstream
+	Stopped   *RebaseStep     // Step the rebase stopped at, for conflicts or an edit
+	Conflicts []MergeConflict // Conflicts to resolve before continuing
+}
+
+// Files holding an unfinished rebase under .kit/rebase-merge
+const (
+	rebaseDir          = "rebase-merge"
+	rebaseHeadName     = "head-name" // Branch being rebased
+	rebaseOnto         = "onto"      // Commit the branch is replayed onto
+	rebaseOrigHead     = "orig-head" // Branch tip before the rebase
+	rebaseTodo         = "todo"      // Steps left to do
+	rebaseStopped      = "stopped"   // "conflict" or "edit" and the step stopped at
+	rebaseStopConflict = "conflict"
+	rebaseStopEdit     = "edit"
+)
+
+// ParseRebaseTodo parses a todo list. Each line is an action (or its first
+// letter), a commit and optional text; blank lines and lines starting with
+// "#" are ignored.
+func ParseRebaseTodo(content string) ([]RebaseStep, error) {
+	steps := []RebaseStep{}
+	for i, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d of the todo list has no commit: %q", i+1, line)
+		}
+		action := RebaseAction(fields[0])
+		if full, ok := rebaseAbbreviations[fields[0]]; ok {
+			action = full
+		}
+		switch action {
+		case RebasePick, RebaseReword, RebaseEdit, RebaseSquash, RebaseFixup, RebaseDrop:
+		default:
+			return nil, fmt.Errorf("line %d of the todo list has an unknown command %q", i+1, fields[0])
+		}
+		step := RebaseStep{Action: action, Commit: fields[1]}
+		if len(fields) == 3 {
+			step.Text = strings.TrimSpace(fields[2])
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// FormatRebaseTodo formats steps as a todo list, one step per line
+func FormatRebaseTodo(steps []RebaseStep) string {
+	var sb strings.Builder
+	for _, step := range steps {
+		line := fmt.Sprintf("%s %s", step.Action, step.Commit)
+		if step.Text != "" {
+			line += " " + step.Text
+		}
+		sb.WriteString(line + "\n")
+	}
+	return sb.String()
+}
+
+// RebaseInProgress reports whether a rebase is unfinished
+func (r *Repository) RebaseInProgress() bool {
+	_, err := os.Stat(filepath.Join(r.Path, DefaultKitDir, rebaseDir))
+	return err == nil
+}
+
+// Rebase replays the commits of the current branch that aren't in upstream
+// on top of it, oldest first, with the cherry-pick machinery; merge commits
+// are left out, giving a linear history. options.Todo replaces the list of
+// commits to replay, with each step's action; an empty list, such as a todo
+// file with nothing but comments, is refused rather than dropping every
+// commit, which takes "drop" steps. The rebase stops on conflicts
+// and at edit steps; ContinueRebase, SkipRebase and AbortRebase pick up
+// from there, even in another process. Every step is recorded in the
+// reflog of HEAD and the result in the reflog of the branch.
+func (r *Repository) Rebase(upstream string, options *RebaseOptions) (*RebaseResult, error) {
+	if options == nil {
+		options = &RebaseOptions{}
+	}
+	if r.RebaseInProgress() {
+		return nil, fmt.Errorf("a rebase is already in progress, use --continue, --skip or --abort")
+	}
+	if r.SequenceInProgress() {
+		return nil, fmt.Errorf("a cherry-pick or revert is in progress")
+	}
+	if len(r.State.Conflicts) > 0 {
+		return nil, fmt.Errorf("cannot rebase with unresolved conflicts in %s", strings.Join(r.conflictPaths(), ", "))
+	}
+	if r.hasStagedChanges() {
+		return nil, fmt.Errorf("cannot rebase with uncommitted changes, please commit or stash them first")
+	}
+	if changed, err := r.unstagedChanges(); err != nil {
+		return nil, err
+	} else if len(changed) > 0 {
+		return nil, fmt.Errorf("cannot rebase with unstaged changes in %s, please commit or stash them first", strings.Join(changed, ", "))
+	}
+	if options.Todo != nil && len(options.Todo) == 0 {
+		return nil, fmt.Errorf("nothing to do")
+	}
+
+	branch, err := r.GetCurrentBranch()
+	if err != nil {
+		return nil, fmt.Errorf("cannot rebase: %w", err)
+	}
+	headName := fmt.Sprintf("refs/heads/%s", branch)
+	head, err := r.resolveReference(headName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve branch: %w", err)
+	}
+	onto, err := r.ResolveRevision(upstream)
+	if err != nil {
+		return nil, err
+	}
+
+	todo := options.Todo
+	if todo == nil {
+		if ok, err := r.IsAncestor(onto, head); err != nil {
+			return nil, err
+		} else if ok {
+			return &RebaseResult{Head: head, UpToDate: true}, nil
+		}
+		log, err := r.RevWalk([]string{head}, []string{onto}, &RevWalkOptions{Order: OrderTopo, Reverse: true})
+		if err != nil {
+			return nil, err
+		}
+		for _, commit := range log {
+			if len(commit.Parents) > 1 {
+				continue
+			}
+			subject, _, _ := strings.Cut(commit.Message, "\n")
+			todo = append(todo, RebaseStep{Action: RebasePick, Commit: commit.ID, Text: subject})
+		}
+	}
+
+	// Resolve the todo list's commits before anything changes
+	resolved := make([]RebaseStep, len(todo))
+	for i, step := range todo {
+		commitID, err := r.ResolveRevision(step.Commit)
+		if err != nil {
+			return nil, err
+		}
+		step.Commit = commitID
+		if i == 0 && (step.Action == RebaseSquash || step.Action == RebaseFixup) {
+			return nil, fmt.Errorf("cannot %s without a previous commit", step.Action)
+		}
+		resolved[i] = step
+	}
+
+	dir := filepath.Join(r.Path, DefaultKitDir, rebaseDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create rebase directory: %w", err)
+	}
+	for name, value := range map[string]string{rebaseHeadName: headName, rebaseOnto: onto, rebaseOrigHead: head} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(value+"\n"), 0644); err != nil {
+			return nil, fmt.Errorf("failed to write rebase state: %w", err)
+		}
+	}
+	if err := r.writeRebaseTodo(resolved); err != nil {
+		return nil, err
+	}
+
+	// Replay on a detached HEAD, moving the branch at the end
+	if _, err := r.checkoutCommit(onto, "HEAD", upstream, fmt.Sprintf("rebase (start): checkout %s", upstream), &CheckoutOptions{}); err != nil {
+		r.clearRebase()
+		return nil, err
+	}
+	return r.runRebase()
+}
+
+// ContinueRebase goes on with a rebase that stopped. After conflicts, the
+// resolved and staged changes complete the step; after an edit, staged
+// changes are amended into the commit.
+func (r *Repository) ContinueRebase() (*RebaseResult, error) {
+	if !r.RebaseInProgress() {
+		return nil, fmt.Errorf("no rebase in progress")
+	}
+	if len(r.State.Conflicts) > 0 {
+		return nil, fmt.Errorf("unresolved conflicts in %s, stage the resolved files first", strings.Join(r.conflictPaths(), ", "))
+	}
+
+	reason, step, err := r.readRebaseStopped()
+	if err != nil {
+		return nil, err
+	}
+	switch reason {
+	case rebaseStopConflict:
+		if err := r.finishRebaseStep(*step); err != nil {
+			return nil, err
+		}
+		if step.Action == RebaseEdit {
+			return r.stopRebase(rebaseStopEdit, *step, nil)
+		}
+	case rebaseStopEdit:
+		if r.hasStagedChanges() {
+			headID, _ := r.resolveReference("HEAD")
+			head, err := r.readCommit(headID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read HEAD commit: %w", err)
+			}
+			commitID, err := r.amendHead(head, head.Message)
+			if err != nil {
+				return nil, err
+			}
+			subject, _, _ := strings.Cut(head.Message, "\n")
+			if err := r.appendReflog("HEAD", headID, commitID, fmt.Sprintf("rebase (amend): %s", subject)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := r.clearRebaseStopped(); err != nil {
+		return nil, err
+	}
+	return r.runRebase()
+}
+
+// SkipRebase drops the changes of the commit a rebase stopped at on
+// conflicts and goes on with the remaining steps
+func (r *Repository) SkipRebase() (*RebaseResult, error) {
+	if !r.RebaseInProgress() {
+		return nil, fmt.Errorf("no rebase in progress")
+	}
+	reason, _, err := r.readRebaseStopped()
+	if err != nil {
+		return nil, err
+	}
+	if reason != rebaseStopConflict {
+		return nil, fmt.Errorf("no conflicting commit to skip, use --continue")
+	}
+	if _, err := r.Reset("HEAD", ResetMerge); err != nil {
+		return nil, err
+	}
+	if err := r.clearRebaseStopped(); err != nil {
+		return nil, err
+	}
+	return r.runRebase()
+}
+
+// AbortRebase gives up on a rebase, checking the branch out as it was
+// before. Unstaged edits to files the rebase didn't change are kept.
+func (r *Repository) AbortRebase() error {
+	if !r.RebaseInProgress() {
+		return fmt.Errorf("no rebase in progress")
+	}
+	headName, err := r.readRebaseFile(rebaseHeadName)
+	if err != nil {
+		return err
+	}
+	origHead, err := r.readRebaseFile(rebaseOrigHead)
+	if err != nil {
+		return err
+	}
+
+	// Undo what the rebase wrote, keeping other local edits, then go back
+	// to the branch
+	tree, err := r.getTreeFromCommit(origHead)
+	if err != nil {
+		return fmt.Errorf("failed to read tree of %s: %w", shortID(origHead), err)
+	}
+	if err := r.resetFiles(treeBlobs(tree), ResetMerge); err != nil {
+		return err
+	}
+	message := fmt.Sprintf("rebase (abort): returning to %s", headName)
+	if _, err := r.checkoutCommit(origHead, headName, strings.TrimPrefix(headName, "refs/heads/"), message, &CheckoutOptions{}); err != nil {
+		return err
+	}
+	return r.clearRebase()
+}
+
+// runRebase runs the steps left in the todo list until one stops, then
+// moves the branch to the result
+func (r *Repository) runRebase() (*RebaseResult, error) {
+	for {
+		todo, err := r.readRebaseTodo()
+		if err != nil {
+			return nil, err
+		}
+		if len(todo) == 0 {
+			return r.finishRebase()
+		}
+		step := todo[0]
+		if err := r.writeRebaseTodo(todo[1:]); err != nil {
+			return nil, err
+		}
+		if step.Action == RebaseDrop {
+			continue
+		}
+
+		// A commit already on top of HEAD is kept as it is
+		headID, _ := r.resolveReference("HEAD")
+		commit, err := r.readCommit(step.Commit)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read commit %s: %w", shortID(step.Commit), err)
+		}
+		if (step.Action == RebasePick || step.Action == RebaseEdit) && commit.Parent == headID && commit.Parent2 == "" {
+			subject, _, _ := strings.Cut(commit.Message, "\n")
+			message := fmt.Sprintf("rebase (%s): %s", step.Action, subject)
+			if _, err := r.checkoutCommit(step.Commit, "HEAD", step.Commit, message, &CheckoutOptions{}); err != nil {
+				return nil, err
+			}
+		} else {
+			conflicts, err := r.applyStep(sequenceStep{action: ActionPick, commitID: step.Commit}, 0)
+			if err != nil {
+				return nil, err
+			}
+			if len(conflicts) > 0 {
+				return r.stopRebase(rebaseStopConflict, step, conflicts)
+			}
+			if err := r.finishRebaseStep(step); err != nil {
+				return nil, err
+			}
+		}
+
+		if step.Action == RebaseEdit {
+			return r.stopRebase(rebaseStopEdit, step, nil)
+		}
+	}
+}
+
+// finishRebaseStep commits the applied changes of a step: a new commit
+// keeping the original author, or the previous commit amended for squash
+// and fixup. A pick whose changes are already there is dropped.
+func (r *Repository) finishRebaseStep(step RebaseStep) error {
+	commit, err := r.readCommit(step.Commit)
+	if err != nil {
+		return fmt.Errorf("failed to read commit %s: %w", shortID(step.Commit), err)
+	}
+	headID, _ := r.resolveReference("HEAD")
+
+	var commitID string
+	switch step.Action {
+	case RebaseSquash, RebaseFixup:
+		head, err := r.readCommit(headID)
+		if err != nil {
+			return fmt.Errorf("failed to read HEAD commit: %w", err)
+		}
+		message := head.Message
+		if step.Action == RebaseSquash {
+			message += "\n\n" + commit.Message
+		}
+		if commitID, err = r.amendHead(head, message); err != nil {
+			return err
+		}
+	default:
+		if !r.hasStagedChanges() {
+			return nil
+		}
+		message := commit.Message
+		if step.Action == RebaseReword && step.Text != "" {
+			_, body, hasBody := strings.Cut(commit.Message, "\n")
+			message = step.Text
+			if hasBody {
+				message += "\n" + body
+			}
+		}
+		if commitID, err = r.commitIndex(message, commit.Author); err != nil {
+			return err
+		}
+	}
+
+	subject, _, _ := strings.Cut(commit.Message, "\n")
+	return r.appendReflog("HEAD", headID, commitID, fmt.Sprintf("rebase (%s): %s", step.Action, subject))
+}
+
+// stopRebase records why and where a rebase stopped
+func (r *Repository) stopRebase(reason string, step RebaseStep, conflicts []MergeConflict) (*RebaseResult, error) {
+	content := reason + "\n" + FormatRebaseTodo([]RebaseStep{step})
+	if err := os.WriteFile(filepath.Join(r.Path, DefaultKitDir, rebaseDir, rebaseStopped), []byte(content), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write rebase state: %w", err)
+	}
+	return &RebaseResult{Stopped: &step, Conflicts: conflicts}, nil
+}
+
+// finishRebase points the branch at the rebased commits and checks it out
+func (r *Repository) finishRebase() (*RebaseResult, error) {
+	headName, err := r.readRebaseFile(rebaseHeadName)
+	if err != nil {
+		return nil, err
+	}
+	onto, err := r.readRebaseFile(rebaseOnto)
+	if err != nil {
+		return nil, err
+	}
+	origHead, err := r.readRebaseFile(rebaseOrigHead)
+	if err != nil {
+		return nil, err
+	}
+	headID, err := r.resolveReference("HEAD")
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve HEAD: %w", err)
+	}
+
+	if err := r.updateReference(headName, headID); err != nil {
+		return nil, fmt.Errorf("failed to update %s: %w", headName, err)
+	}
+	if err := r.appendReflog(headName, origHead, headID, fmt.Sprintf("rebase (finish): %s onto %s", headName, onto)); err != nil {
+		return nil, err
+	}
+	headPath := filepath.Join(r.Path, DefaultKitDir, DefaultKitHeadFile)
+	if err := os.WriteFile(headPath, []byte(fmt.Sprintf("ref: %s\n", headName)), 0644); err != nil {
+		return nil, fmt.Errorf("failed to update HEAD reference: %w", err)
+	}
+	r.State.HEAD = headName
+	if err := r.appendReflog("HEAD", headID, headID, fmt.Sprintf("rebase (finish): returning to %s", headName)); err != nil {
+		return nil, err
+	}
+	if err := r.SaveIndex(); err != nil {
+		return nil, fmt.Errorf("failed to save index: %w", err)
+	}
+
+	if err := r.clearRebase(); err != nil {
+		return nil, err
+	}
+	return &RebaseResult{Head: headID}, nil
+}
+
+// readRebaseFile reads a single value of the rebase state
+func (r *Repository) readRebaseFile(name string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(r.Path, DefaultKitDir, rebaseDir, name))
+	if err != nil {
+		return "", fmt.Errorf("failed to read rebase state: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// readRebaseTodo reads the steps left to do
+func (r *Repository) readRebaseTodo() ([]RebaseStep, error) {
+	data, err := os.ReadFile(filepath.Join(r.Path, DefaultKitDir, rebaseDir, rebaseTodo))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rebase todo: %w", err)
+	}
+	return ParseRebaseTodo(string(data))
+}
+
+// writeRebaseTodo writes the steps left to do
+func (r *Repository) writeRebaseTodo(todo []RebaseStep) error {
+	if err := os.WriteFile(filepath.Join(r.Path, DefaultKitDir, rebaseDir, rebaseTodo), []byte(FormatRebaseTodo(todo)), 0644); err != nil {
+		return fmt.Errorf("failed to write rebase todo: %w", err)
+	}
+	return nil
+}
+
+// readRebaseStopped returns why and at which step the rebase stopped
+func (r *Repository) readRebaseStopped() (string, *RebaseStep, error) {
+	data, err := os.ReadFile(filepath.Join(r.Path, DefaultKitDir, rebaseDir, rebaseStopped))
+	if os.IsNotExist(err) {
+		return "", nil, nil
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read rebase state: %w", err)
+	}
+	reason, line, _ := strings.Cut(string(data), "\n")
+	steps, err := ParseRebaseTodo(line)
+	if err != nil || len(steps) != 1 {
+		return "", nil, fmt.Errorf("corrupt rebase state in %s", rebaseStopped)
+	}
+	return reason, &steps[0], nil
+}
+
+// clearRebaseStopped removes the record of where the rebase stopped
+func (r *Repository) clearRebaseStopped() error {
+	if err := os.Remove(filepath.Join(r.Path, DefaultKitDir, rebaseDir, rebaseStopped)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove rebase state: %w", err)
+	}
+	return nil
+}
+
+// clearRebase removes the rebase state once a rebase is over
+func (r *Repository) clearRebase() error {
+	if err := os.RemoveAll(filepath.Join(r.Path, DefaultKitDir, rebaseDir)); err != nil {
+		return fmt.Errorf("failed to remove rebase state: %w", err)
+	}
+	return nil
+}
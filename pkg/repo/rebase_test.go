@@ -0,0 +1,279 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// logMessages returns the subjects of the log of HEAD, newest first
+func logMessages(t *testing.T, repo *Repository) string {
+	t.Helper()
+	log, err := repo.Log()
+	if err != nil {
+		t.Fatalf("Failed to get log: %v", err)
+	}
+	subjects := []string{}
+	for _, commit := range log {
+		subject, _, _ := strings.Cut(commit.Message, "\n")
+		subjects = append(subjects, subject)
+	}
+	return strings.Join(subjects, " ")
+}
+
+func TestRebase(t *testing.T) {
+	repo := newTestRepository(t)
+	commitTestFiles(t, repo, "A", map[string]string{"a.txt": "1\n2\n3\n"})
+	if err := repo.CreateBranch("feature"); err != nil {
+		t.Fatalf("Failed to create branch: %v", err)
+	}
+	commitTestFiles(t, repo, "D", map[string]string{"a.txt": "one\n2\n3\n"})
+	if err := repo.CheckoutBranch("feature"); err != nil {
+		t.Fatalf("Failed to checkout: %v", err)
+	}
+	commitTestFiles(t, repo, "B", map[string]string{"b.txt": "b\n"})
+	commitTestFiles(t, repo, "C", map[string]string{"a.txt": "1\n2\nthree\n"})
+
+	result, err := repo.Rebase("main", nil)
+	if err != nil || result.Stopped != nil || result.UpToDate {
+		t.Fatalf("Failed to rebase: %+v (%v)", result, err)
+	}
+	if branch, err := repo.GetCurrentBranch(); err != nil || branch != "feature" {
+		t.Errorf("Expected to be on feature, got %q (%v)", branch, err)
+	}
+	if got := logMessages(t, repo); got != "C B D A" {
+		t.Errorf("Expected C B D A, got %s", got)
+	}
+	if data, _ := os.ReadFile(filepath.Join(repo.Path, "a.txt")); string(data) != "one\n2\nthree\n" {
+		t.Errorf("Expected both changes to a.txt, got %q", data)
+	}
+	if repo.RebaseInProgress() {
+		t.Errorf("Expected the rebase state to be removed")
+	}
+
+	// Every step is in the reflog
+	entries, err := repo.ReadReflog("HEAD")
+	if err != nil {
+		t.Fatalf("Failed to read reflog: %v", err)
+	}
+	messages := []string{}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Message, "rebase") {
+			messages = append(messages, entry.Message)
+		}
+	}
+	want := "rebase (start): checkout main|rebase (pick): B|rebase (pick): C|rebase (finish): returning to refs/heads/feature"
+	if strings.Join(messages, "|") != want {
+		t.Errorf("Unexpected reflog %q", messages)
+	}
+	entries, err = repo.ReadReflog("refs/heads/feature")
+	if err != nil || len(entries) == 0 || entries[len(entries)-1].NewID != result.Head {
+		t.Errorf("Expected the branch reflog to record the rebase, got %+v (%v)", entries, err)
+	}
+
+	result, err = repo.Rebase("main", nil)
+	if err != nil || !result.UpToDate {
+		t.Errorf("Expected the branch to be up to date, got %+v (%v)", result, err)
+	}
+}
+
+func TestRebaseTodo(t *testing.T) {
+	repo := newTestRepository(t)
+	a := commitTestFiles(t, repo, "A", map[string]string{"a.txt": "a\n"})
+	b := commitTestFiles(t, repo, "B", map[string]string{"b.txt": "b\n"})
+	c := commitTestFiles(t, repo, "C", map[string]string{"c.txt": "c\n"})
+	writeTestFile(t, repo, "d.txt", "d\n")
+	if err := repo.Add("d.txt"); err != nil {
+		t.Fatalf("Failed to add: %v", err)
+	}
+	d, err := repo.Commit("D\n\nThe body of D")
+	if err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	e := commitTestFiles(t, repo, "E", map[string]string{"e.txt": "e\n"})
+	f := commitTestFiles(t, repo, "F", map[string]string{"f.txt": "f\n"})
+
+	todo, err := ParseRebaseTodo("# Rebase\npick " + b[:8] + " B\nf " + c + "\n\nreword " + d + " D reworded\nd " + e + "\nsquash " + f + " F\n")
+	if err != nil {
+		t.Fatalf("Failed to parse todo: %v", err)
+	}
+	if _, err := ParseRebaseTodo("bogus " + b); err == nil {
+		t.Errorf("Expected an unknown command to fail")
+	}
+
+	result, err := repo.Rebase(a, &RebaseOptions{Todo: todo})
+	if err != nil || result.Stopped != nil {
+		t.Fatalf("Failed to rebase: %+v (%v)", result, err)
+	}
+	if got := logMessages(t, repo); got != "D reworded B A" {
+		t.Errorf("Expected D reworded B A, got %s", got)
+	}
+	head, err := repo.readCommit(result.Head)
+	if err != nil || head.Message != "D reworded\n\nThe body of D\n\nF" {
+		t.Errorf("Expected the reworded D with F squashed in, got %q (%v)", head.Message, err)
+	}
+	for path, want := range map[string]bool{"b.txt": true, "c.txt": true, "d.txt": true, "e.txt": false, "f.txt": true} {
+		if _, err := os.Stat(filepath.Join(repo.Path, path)); (err == nil) != want {
+			t.Errorf("Expected %s to exist: %v", path, want)
+		}
+	}
+
+	if _, err := repo.Rebase(a, &RebaseOptions{Todo: []RebaseStep{{Action: RebaseFixup, Commit: b}}}); err == nil {
+		t.Errorf("Expected a leading fixup to fail")
+	}
+
+	// A todo list without steps leaves the branch alone
+	empty, err := ParseRebaseTodo("# pick " + b + " B\n\n")
+	if err != nil {
+		t.Fatalf("Failed to parse todo: %v", err)
+	}
+	if _, err := repo.Rebase(a, &RebaseOptions{Todo: empty}); err == nil || err.Error() != "nothing to do" {
+		t.Errorf("Expected an empty todo list to be refused, got %v", err)
+	}
+	if now, _ := repo.resolveReference("HEAD"); now != result.Head || repo.RebaseInProgress() {
+		t.Errorf("Expected the branch to stay at %s, got %s", result.Head, now)
+	}
+}
+
+func TestRebaseKeepsLocalEdits(t *testing.T) {
+	repo := newTestRepository(t)
+	commitTestFiles(t, repo, "A", map[string]string{"a.txt": "a\n", "x.txt": "x\n"})
+	if err := repo.CreateBranch("feature"); err != nil {
+		t.Fatalf("Failed to create branch: %v", err)
+	}
+	commitTestFiles(t, repo, "M", map[string]string{"a.txt": "main\n"})
+	if err := repo.CheckoutBranch("feature"); err != nil {
+		t.Fatalf("Failed to checkout: %v", err)
+	}
+	f1 := commitTestFiles(t, repo, "F1", map[string]string{"a.txt": "feature\n"})
+	f2 := commitTestFiles(t, repo, "F2", map[string]string{"f.txt": "f\n"})
+
+	// Unstaged changes keep a rebase from starting
+	writeTestFile(t, repo, "x.txt", "local\n")
+	if _, err := repo.Rebase("main", nil); err == nil || !strings.Contains(err.Error(), "x.txt") {
+		t.Errorf("Expected the rebase to be refused for x.txt, got %v", err)
+	}
+	if repo.RebaseInProgress() || mustResolve(t, repo, "HEAD") != f2 || readTestFile(t, repo, "x.txt") != "local\n" {
+		t.Errorf("Expected a refused rebase to change nothing")
+	}
+
+	// Edits made while a rebase is stopped survive skip and abort
+	for _, finish := range []string{"abort", "skip"} {
+		writeTestFile(t, repo, "x.txt", "x\n")
+		result, err := repo.Rebase("main", nil)
+		if err != nil || result.Stopped == nil || result.Stopped.Commit != f1 {
+			t.Fatalf("Expected the rebase to stop on F1, got %+v (%v)", result, err)
+		}
+		writeTestFile(t, repo, "x.txt", "local\n")
+		if finish == "abort" {
+			err = repo.AbortRebase()
+		} else {
+			_, err = repo.SkipRebase()
+		}
+		if err != nil {
+			t.Fatalf("Failed to %s: %v", finish, err)
+		}
+		if content := readTestFile(t, repo, "x.txt"); content != "local\n" {
+			t.Errorf("Expected %s to keep the edit to x.txt, got %q", finish, content)
+		}
+		if branch, err := repo.GetCurrentBranch(); err != nil || branch != "feature" || repo.RebaseInProgress() {
+			t.Errorf("Expected %s to end on feature, got %q (%v)", finish, branch, err)
+		}
+	}
+	if got := logMessages(t, repo); got != "F2 M A" {
+		t.Errorf("Expected skip to drop F1, got %s", got)
+	}
+	if content := readTestFile(t, repo, "a.txt"); content != "main\n" {
+		t.Errorf("Expected a.txt from M after the skip, got %q", content)
+	}
+}
+
+func TestRebaseStops(t *testing.T) {
+	repo := newTestRepository(t)
+	commitTestFiles(t, repo, "A", map[string]string{"a.txt": "a\n"})
+	if err := repo.CreateBranch("feature"); err != nil {
+		t.Fatalf("Failed to create branch: %v", err)
+	}
+	commitTestFiles(t, repo, "M", map[string]string{"a.txt": "main\n"})
+	if err := repo.CheckoutBranch("feature"); err != nil {
+		t.Fatalf("Failed to checkout: %v", err)
+	}
+	f1 := commitTestFiles(t, repo, "F1", map[string]string{"a.txt": "feature\n"})
+	f2 := commitTestFiles(t, repo, "F2", map[string]string{"f.txt": "f\n"})
+
+	// Abort goes back to the branch as it was
+	result, err := repo.Rebase("main", nil)
+	if err != nil || result.Stopped == nil || result.Stopped.Commit != f1 || len(result.Conflicts) != 1 {
+		t.Fatalf("Expected the rebase to stop on F1, got %+v (%v)", result, err)
+	}
+	if _, err := repo.Rebase("main", nil); err == nil {
+		t.Errorf("Expected a second rebase to be refused")
+	}
+	if err := repo.AbortRebase(); err != nil {
+		t.Fatalf("Failed to abort: %v", err)
+	}
+	if head, _ := repo.resolveReference("HEAD"); head != f2 || repo.RebaseInProgress() {
+		t.Errorf("Expected abort to go back to F2")
+	}
+	if branch, err := repo.GetCurrentBranch(); err != nil || branch != "feature" {
+		t.Errorf("Expected to be on feature, got %q (%v)", branch, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(repo.Path, "a.txt")); string(data) != "feature\n" {
+		t.Errorf("Expected a.txt to be restored, got %q", data)
+	}
+
+	// Skip drops the conflicting commit
+	if _, err := repo.Rebase("main", nil); err != nil {
+		t.Fatalf("Failed to rebase: %v", err)
+	}
+	result, err = repo.SkipRebase()
+	if err != nil || result.Stopped != nil {
+		t.Fatalf("Failed to skip: %+v (%v)", result, err)
+	}
+	if got := logMessages(t, repo); got != "F2 M A" {
+		t.Errorf("Expected F2 M A, got %s", got)
+	}
+	if err := repo.CheckoutBranch("main"); err != nil {
+		t.Fatalf("Failed to checkout: %v", err)
+	}
+	if err := repo.CreateBranch("other"); err != nil {
+		t.Fatalf("Failed to create branch: %v", err)
+	}
+	if _, err := repo.Reset(f2, ResetHard); err != nil {
+		t.Fatalf("Failed to reset: %v", err)
+	}
+	// (main now holds F1 and F2 again, with other at M)
+
+	// The rebase state survives the process: continue from a new instance
+	if _, err := repo.Rebase("other", &RebaseOptions{Todo: []RebaseStep{{Action: RebasePick, Commit: f1}, {Action: RebaseEdit, Commit: f2}}}); err != nil {
+		t.Fatalf("Failed to rebase: %v", err)
+	}
+	reopened, err := NewRepository(repo.Path)
+	if err != nil {
+		t.Fatalf("Failed to reopen repository: %v", err)
+	}
+	writeTestFile(t, reopened, "a.txt", "both\n")
+	if err := reopened.Add("a.txt"); err != nil {
+		t.Fatalf("Failed to add: %v", err)
+	}
+	result, err = reopened.ContinueRebase()
+	if err != nil || result.Stopped == nil || result.Stopped.Action != RebaseEdit || len(result.Conflicts) != 0 {
+		t.Fatalf("Expected the rebase to stop to edit F2, got %+v (%v)", result, err)
+	}
+	writeTestFile(t, reopened, "g.txt", "g\n")
+	if err := reopened.Add("g.txt"); err != nil {
+		t.Fatalf("Failed to add: %v", err)
+	}
+	result, err = reopened.ContinueRebase()
+	if err != nil || result.Stopped != nil {
+		t.Fatalf("Failed to continue: %+v (%v)", result, err)
+	}
+	if got := logMessages(t, reopened); got != "F2 F1 M A" {
+		t.Errorf("Expected F2 F1 M A, got %s", got)
+	}
+	tree, err := reopened.getTreeFromCommit(result.Head)
+	if err != nil || len(tree.Entries) != 3 {
+		t.Errorf("Expected a.txt, f.txt and g.txt in the edited commit, got %+v (%v)", tree, err)
+	}
+}
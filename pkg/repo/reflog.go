@@ -0,0 +1,152 @@
+package repo
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultKitLogsDir is the directory holding reference logs
+const DefaultKitLogsDir = "logs"
+
+// zeroID is the placeholder object ID used for missing reflog values
+var zeroID = strings.Repeat("0", 64)
+
+// ReflogEntry represents a single update of a reference
+type ReflogEntry struct {
+	OldID     string    // Commit ID before the update
+	NewID     string    // Commit ID after the update
+	Committer string    // Who made the update
+	Timestamp time.Time // When the update was made
+	Message   string    // Description of the update
+}
+
+// reflogPath returns the path of the log file for a reference
+func (r *Repository) reflogPath(ref string) string {
+	return filepath.Join(r.Path, DefaultKitDir, DefaultKitLogsDir, ref)
+}
+
+// appendReflog records an update of a reference in its log
+func (r *Repository) appendReflog(ref, oldID, newID, message string) error {
+	if oldID == "" {
+		oldID = zeroID
+	}
+	if newID == "" {
+		newID = zeroID
+	}
+
+	logPath := r.reflogPath(ref)
+	if err := os.MkdirAll(filepath.Dir(logPath), 0755); err != nil {
+		return fmt.Errorf("failed to create reflog directory: %w", err)
+	}
+
+	f, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open reflog for %s: %w", ref, err)
+	}
+	defer f.Close()
+
+	entry := ReflogEntry{
+		OldID:     oldID,
+		NewID:     newID,
+		Committer: defaultSignature,
+		Timestamp: time.Now(),
+		Message:   message,
+	}
+	if _, err := f.WriteString(formatReflogEntry(entry)); err != nil {
+		return fmt.Errorf("failed to write reflog for %s: %w", ref, err)
+	}
+
+	return nil
+}
+
+// ReadReflog returns the log of a reference, oldest entry first
+func (r *Repository) ReadReflog(ref string) ([]ReflogEntry, error) {
+	f, err := os.Open(r.reflogPath(ref))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []ReflogEntry{}, nil
+		}
+		return nil, fmt.Errorf("failed to open reflog for %s: %w", ref, err)
+	}
+	defer f.Close()
+
+	var entries []ReflogEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		entry, err := parseReflogEntry(line)
+		if err != nil {
+			return nil, fmt.Errorf("corrupt reflog for %s: %w", ref, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read reflog for %s: %w", ref, err)
+	}
+
+	return entries, nil
+}
+
+// writeReflog replaces the log of a reference with the given entries
+func (r *Repository) writeReflog(ref string, entries []ReflogEntry) error {
+	logPath := r.reflogPath(ref)
+	if len(entries) == 0 {
+		if err := os.Remove(logPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove reflog for %s: %w", ref, err)
+		}
+		return nil
+	}
+
+	var sb strings.Builder
+	for _, entry := range entries {
+		sb.WriteString(formatReflogEntry(entry))
+	}
+
+	if err := os.MkdirAll(filepath.Dir(logPath), 0755); err != nil {
+		return fmt.Errorf("failed to create reflog directory: %w", err)
+	}
+	if err := os.WriteFile(logPath, []byte(sb.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write reflog for %s: %w", ref, err)
+	}
+
+	return nil
+}
+
+// formatReflogEntry renders an entry as "<old> <new> <committer> <unix-time>\t<message>"
+func formatReflogEntry(entry ReflogEntry) string {
+	// Keep every entry on a single line
+	message := strings.ReplaceAll(entry.Message, "\n", " ")
+	return fmt.Sprintf("%s %s %s %d\t%s\n",
+		entry.OldID, entry.NewID, entry.Committer, entry.Timestamp.Unix(), message)
+}
+
+// parseReflogEntry parses a line written by formatReflogEntry
+func parseReflogEntry(line string) (ReflogEntry, error) {
+	header, message, _ := strings.Cut(line, "\t")
+
+	fields := strings.Fields(header)
+	if len(fields) < 4 {
+		return ReflogEntry{}, fmt.Errorf("malformed entry %q", line)
+	}
+
+	unix, err := strconv.ParseInt(fields[len(fields)-1], 10, 64)
+	if err != nil {
+		return ReflogEntry{}, fmt.Errorf("malformed timestamp in %q", line)
+	}
+
+	return ReflogEntry{
+		OldID:     fields[0],
+		NewID:     fields[1],
+		Committer: strings.Join(fields[2:len(fields)-1], " "),
+		Timestamp: time.Unix(unix, 0),
+		Message:   message,
+	}, nil
+}
@@ -0,0 +1,183 @@
+package repo
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/systemshift/kit/pkg/kernel"
+)
+
+// DefaultRenameThreshold is how similar a removed and an added file must be
+// to be reported as a rename when no threshold is given
+const DefaultRenameThreshold = 0.5
+
+// renameBandRows is the number of MinHash values in each LSH band when
+// looking for rename candidates. The retrieval kernel's wider bands only
+// bring together files that are about three quarters alike; bands of four
+// values catch most pairs from about 40%.
+const renameBandRows = 4
+
+// renamePair is an added file matched with the file it came from
+type renamePair struct {
+	source     string  // Path in the old tree
+	dest       string  // Path in the new tree
+	similarity float64 // How alike the contents are, from 0 to 1
+	copied     bool    // The source is kept, or already used by another rename
+}
+
+// ParseSimilarityThreshold parses a rename or copy threshold as given to
+// -M or -C: a percentage such as "50%", or digits read as a fraction after
+// a decimal point, so "5" and "50" both mean 0.5. An empty value gives
+// DefaultRenameThreshold.
+func ParseSimilarityThreshold(value string) (float64, error) {
+	if value == "" {
+		return DefaultRenameThreshold, nil
+	}
+	if percent, ok := strings.CutSuffix(value, "%"); ok {
+		n, err := strconv.ParseFloat(percent, 64)
+		if err != nil || n < 0 || n > 100 {
+			return 0, fmt.Errorf("invalid similarity threshold %q", value)
+		}
+		return n / 100, nil
+	}
+	for _, c := range value {
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("invalid similarity threshold %q", value)
+		}
+	}
+	fraction, _ := strconv.ParseFloat("0."+value, 64)
+	return fraction, nil
+}
+
+// findRenames pairs added files with the deleted files they were moved
+// from: first files with identical content, then the pairs whose MinHash
+// similarity reaches the threshold, most similar first. Candidates come
+// from LSH buckets, so files are only compared with ones likely to be
+// alike. With copies, modified files and deleted files already taken by a
+// rename are sources too, and the files they pair with are copies.
+func (r *Repository) findRenames(treeA, treeB *TreeObject, deleted, modified, added []string, options *DiffOptions) (map[string]renamePair, error) {
+	pairs := make(map[string]renamePair)
+	used := make(map[string]bool)
+	isDeleted := make(map[string]bool, len(deleted))
+	for _, path := range deleted {
+		isDeleted[path] = true
+	}
+	sources := append([]string{}, deleted...)
+	if options.DetectCopies {
+		sources = append(sources, modified...)
+	}
+	sort.Strings(sources)
+	sort.Strings(added)
+
+	// pair records a match, as a rename unless the source has to stay
+	pair := func(source, dest string, similarity float64) bool {
+		if isDeleted[source] && !used[source] {
+			used[source] = true
+			pairs[dest] = renamePair{source: source, dest: dest, similarity: similarity}
+			return true
+		}
+		if options.DetectCopies {
+			pairs[dest] = renamePair{source: source, dest: dest, similarity: similarity, copied: true}
+			return true
+		}
+		return false
+	}
+
+	// Identical content. Empty files are alike without being related.
+	emptyID := hashContent(nil)
+	byID := make(map[string][]string)
+	for _, source := range sources {
+		if objID := treeA.Entries[source].ObjID; objID != emptyID {
+			byID[objID] = append(byID[objID], source)
+		}
+	}
+	remaining := []string{}
+	for _, dest := range added {
+		paired := false
+		for _, source := range byID[treeB.Entries[dest].ObjID] {
+			if paired = pair(source, dest, 1); paired {
+				break
+			}
+		}
+		if !paired && treeB.Entries[dest].ObjID != emptyID {
+			remaining = append(remaining, dest)
+		}
+	}
+	if len(remaining) == 0 {
+		return pairs, nil
+	}
+
+	// Similar content, with candidates from shared LSH bands
+	retrieval := r.RetrievalKernel
+	if retrieval == nil {
+		retrieval = kernel.NewRetrievalKernel(200, 1000000, 20, 42)
+	}
+	retrieval = kernel.NewRetrievalKernel(retrieval.NumPermutations, 1000000, retrieval.NumPermutations/renameBandRows, retrieval.Seed)
+
+	signatures := make(map[string][]int)
+	buckets := make(map[string][]string)
+	for _, source := range sources {
+		if (!options.DetectCopies && used[source]) || treeA.Entries[source].ObjID == emptyID {
+			continue
+		}
+		content, err := r.readObject(treeA.Entries[source].ObjID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read blob %s: %w", treeA.Entries[source].ObjID, err)
+		}
+		signatures[source] = retrieval.MinHash(string(content))
+		for _, band := range retrieval.LSHSignature(signatures[source]) {
+			buckets[band] = append(buckets[band], source)
+		}
+	}
+
+	candidates := []renamePair{}
+	for _, dest := range remaining {
+		content, err := r.readObject(treeB.Entries[dest].ObjID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read blob %s: %w", treeB.Entries[dest].ObjID, err)
+		}
+		signature := retrieval.MinHash(string(content))
+		seen := make(map[string]bool)
+		for _, band := range retrieval.LSHSignature(signature) {
+			for _, source := range buckets[band] {
+				if seen[source] {
+					continue
+				}
+				seen[source] = true
+				// Only identical files are 100% alike, whatever the estimate
+				score := math.Min(retrieval.ComputeJaccardSimilarity(signature, signatures[source]), 0.99)
+				if score >= options.renameThreshold() {
+					candidates = append(candidates, renamePair{source: source, dest: dest, similarity: score})
+				}
+			}
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.similarity != b.similarity {
+			return a.similarity > b.similarity
+		}
+		if a.dest != b.dest {
+			return a.dest < b.dest
+		}
+		return a.source < b.source
+	})
+	for _, candidate := range candidates {
+		if _, done := pairs[candidate.dest]; !done {
+			pair(candidate.source, candidate.dest, candidate.similarity)
+		}
+	}
+
+	return pairs, nil
+}
+
+// renameThreshold returns the similarity threshold for renames and copies
+func (o *DiffOptions) renameThreshold() float64 {
+	if o.RenameThreshold <= 0 {
+		return DefaultRenameThreshold
+	}
+	return o.RenameThreshold
+}
@@ -0,0 +1,135 @@
+package repo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// sourceFile returns n lines of made-up Go code
+func sourceFile(name string, n int) string {
+	var sb strings.Builder
+	for i := 0; i < n; i++ {
+		sb.WriteString(fmt.Sprintf("func %s%d(x int) int { return x * %d }\n", name, i, i))
+	}
+	return sb.String()
+}
+
+// diffByPath indexes diff results by the path they are about
+func diffByPath(results []DiffResult) map[string]DiffResult {
+	byPath := make(map[string]DiffResult)
+	for _, result := range results {
+		byPath[diffResultPath(result)] = result
+	}
+	return byPath
+}
+
+func TestDiffRenames(t *testing.T) {
+	repo := newTestRepository(t)
+	oldCode := sourceFile("parse", 40)
+	a := commitTestFiles(t, repo, "A", map[string]string{
+		"old.go":   oldCode,
+		"same.txt": "unchanged content\n",
+		"gone.txt": "deleted for good\n",
+		"keep.go":  sourceFile("keep", 20),
+	})
+	newCode := strings.Replace(oldCode, "parse3(x int) int { return x * 3 }", "parse3(x int) int { return x * 33 }", 1)
+	for _, path := range []string{"old.go", "same.txt", "gone.txt"} {
+		if err := os.Remove(filepath.Join(repo.Path, path)); err != nil {
+			t.Fatalf("Failed to remove %s: %v", path, err)
+		}
+	}
+	if _, err := repo.AddPaths(nil, &AddOptions{Update: true}); err != nil {
+		t.Fatalf("Failed to stage deletions: %v", err)
+	}
+	b := commitTestFiles(t, repo, "B", map[string]string{
+		"new.go":    newCode,
+		"moved.txt": "unchanged content\n",
+		"fresh.txt": sourceFile("fresh", 10),
+		"keep.go":   sourceFile("keep", 21),
+		"copy.go":   sourceFile("keep", 20),
+	})
+
+	// Without detection a move is a deletion and an addition
+	results, err := repo.Diff(a, b, nil)
+	if err != nil {
+		t.Fatalf("Failed to diff: %v", err)
+	}
+	if len(results) != 8 {
+		t.Errorf("Expected 8 results without rename detection, got %d", len(results))
+	}
+
+	results, err = repo.Diff(a, b, &DiffOptions{ContextLines: 3, DetectRenames: true})
+	if err != nil {
+		t.Fatalf("Failed to diff: %v", err)
+	}
+	byPath := diffByPath(results)
+	if len(results) != 6 {
+		t.Errorf("Expected 6 results with rename detection, got %+v", results)
+	}
+	if moved := byPath["moved.txt"]; !moved.Renamed || moved.OldPath != "same.txt" || moved.Similarity != 1 || len(moved.Chunks) != 0 {
+		t.Errorf("Expected same.txt to be moved unchanged, got %+v", moved)
+	}
+	renamed := byPath["new.go"]
+	if !renamed.Renamed || renamed.OldPath != "old.go" || renamed.Similarity < 0.5 || renamed.Similarity >= 1 ||
+		len(renamed.Chunks) == 0 || !containsString(renamed.Chunks[0].Lines, "+func parse3(x int) int { return x * 33 }") {
+		t.Errorf("Expected old.go to be renamed with its change, got %+v", renamed)
+	}
+	if gone := byPath["gone.txt"]; gone.NewPath != "/dev/null" {
+		t.Errorf("Expected gone.txt to be deleted, got %+v", gone)
+	}
+	if fresh := byPath["fresh.txt"]; fresh.OldPath != "/dev/null" {
+		t.Errorf("Expected fresh.txt to be added, got %+v", fresh)
+	}
+	if copied := byPath["copy.go"]; copied.OldPath != "/dev/null" {
+		t.Errorf("Expected copy.go to be added without copy detection, got %+v", copied)
+	}
+
+	formatted := FormatDiff([]DiffResult{byPath["moved.txt"], renamed})
+	if !strings.HasPrefix(formatted, "similarity index 100%\nrename from same.txt\nrename to moved.txt\n\nsimilarity index ") ||
+		!strings.Contains(formatted, "rename from old.go\nrename to new.go\n--- a/old.go\n+++ b/new.go\n@@ ") {
+		t.Errorf("Unexpected rename headers:\n%s", formatted)
+	}
+	// 0.58*100 is just under 58 in floating point
+	if formatted := FormatDiff([]DiffResult{{OldPath: "a", NewPath: "b", Renamed: true, Similarity: 0.58}}); !strings.HasPrefix(formatted, "similarity index 58%\n") {
+		t.Errorf("Expected the similarity to be rounded, got:\n%s", formatted)
+	}
+
+	// Copies come from modified files too
+	results, err = repo.Diff(a, b, &DiffOptions{ContextLines: 3, DetectCopies: true})
+	if err != nil {
+		t.Fatalf("Failed to diff: %v", err)
+	}
+	if copied := diffByPath(results)["copy.go"]; !copied.Copied || copied.OldPath != "keep.go" || copied.Similarity != 1 {
+		t.Errorf("Expected copy.go to be copied from keep.go, got %+v", copied)
+	}
+	if !strings.Contains(FormatDiff(results), "copy from keep.go\ncopy to copy.go\n") {
+		t.Errorf("Expected copy headers")
+	}
+
+	// Only identical files reach 100%
+	results, err = repo.Diff(a, b, &DiffOptions{ContextLines: 3, DetectRenames: true, RenameThreshold: 1})
+	if err != nil {
+		t.Fatalf("Failed to diff: %v", err)
+	}
+	byPath = diffByPath(results)
+	if !byPath["moved.txt"].Renamed || byPath["new.go"].Renamed {
+		t.Errorf("Expected only the exact rename, got %+v", results)
+	}
+}
+
+func TestParseSimilarityThreshold(t *testing.T) {
+	tests := map[string]float64{"": 0.5, "50%": 0.5, "5": 0.5, "50": 0.5, "05": 0.05, "90%": 0.9, "100%": 1}
+	for value, want := range tests {
+		if got, err := ParseSimilarityThreshold(value); err != nil || got != want {
+			t.Errorf("ParseSimilarityThreshold(%q) = %v (%v), want %v", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"abc", "150%", "-5"} {
+		if _, err := ParseSimilarityThreshold(value); err == nil {
+			t.Errorf("Expected %q to be refused", value)
+		}
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"strings"
 	"time"
 
 	"github.com/systemshift/kit/pkg/kernel"
@@ -30,10 +29,21 @@ const (
 
 // RepositoryState represents the state of a repository
 type RepositoryState struct {
-	HEAD     string                   // Current HEAD reference
-	Stage    map[string]string        // Staged files (path -> object ID)
-	Tracked  map[string]string        // Tracked files (path -> object ID from latest commit)
-	WorkTree map[string]WorkTreeEntry // Working tree files
+	HEAD         string                   // Current HEAD reference
+	Stage        map[string]string        // Staged files (path -> object ID)
+	Removed      map[string]bool          // Staged deletions of tracked files
+	Tracked      map[string]string        // Tracked files (path -> object ID from latest commit)
+	WorkTree     map[string]WorkTreeEntry // Working tree files
+	SkipWorktree map[string]bool          // Indexed files left out of the working tree by sparse checkout
+	Conflicts    map[string]ConflictEntry // Files left unmerged by a merge
+}
+
+// ConflictEntry records the versions of a file a merge couldn't combine.
+// A version missing on one side has an empty object ID.
+type ConflictEntry struct {
+	Base   string // Object ID in the merge base
+	Ours   string // Object ID on the current branch
+	Theirs string // Object ID on the merged branch
 }
 
 // WorkTreeEntry represents a file in the working tree
@@ -42,6 +52,9 @@ type WorkTreeEntry struct {
 	Size    int64     // File size
 	ModTime time.Time // Last modification time
 	Hash    string    // Hash of the file content
+	CTime   time.Time // Last status change time
+	Inode   uint64    // Inode number
+	Mode    uint32    // File mode bits
 }
 
 // Repository represents a Kit repository
@@ -51,6 +64,13 @@ type Repository struct {
 	SemanticKernel  *kernel.SemanticKernel   // For semantic diffing and merging
 	RetrievalKernel *kernel.RetrievalKernel  // For efficient content search
 	State           *RepositoryState         // Current repository state
+	indexTime       time.Time                // Modification time of the index file when last read or written
+	indexVersion    int                      // Format version of the index file when last read
+	fsmonitor       *fsmonitorState          // State of the last complete fsmonitor walk
+	fsmonitorDirty  bool                     // Whether fsmonitor state needs saving
+	attributes      *AttributeMatcher        // Attribute rules, loaded on first use
+	attrStamp       string                   // Attribute files the stat cache's hashes were made under
+	commitGraph     *commitGraphData         // Commit-graph file, loaded on first use
 }
 
 // NewRepository creates a new repository instance
@@ -62,10 +82,13 @@ func NewRepository(path string) (*Repository, error) {
 
 	// Initialize repository state
 	state := &RepositoryState{
-		HEAD:     "refs/heads/main",
-		Stage:    make(map[string]string),
-		Tracked:  make(map[string]string),
-		WorkTree: make(map[string]WorkTreeEntry),
+		HEAD:         "refs/heads/main",
+		Stage:        make(map[string]string),
+		Removed:      make(map[string]bool),
+		Tracked:      make(map[string]string),
+		WorkTree:     make(map[string]WorkTreeEntry),
+		SkipWorktree: make(map[string]bool),
+		Conflicts:    make(map[string]ConflictEntry),
 	}
 
 	// Create the repository
@@ -224,186 +247,38 @@ func (r *Repository) Initialize() error {
 	return nil
 }
 
-// Add stages a file for commit
-func (r *Repository) Add(path string) error {
-	// Get absolute path
-	absPath := filepath.Join(r.Path, path)
-
-	// Read file content
-	content, err := os.ReadFile(absPath)
-	if err != nil {
-		return fmt.Errorf("failed to read file %s: %w", path, err)
-	}
-
-	// Generate object ID
-	hash := sha256.Sum256(content)
-	objID := hex.EncodeToString(hash[:])
-
-	// Store the object
-	err = r.storeObject(objID, content)
-	if err != nil {
-		return fmt.Errorf("failed to store object: %w", err)
-	}
-
-	// Update stage
-	r.State.Stage[path] = objID
-
-	// Update working tree
-	fileInfo, err := os.Stat(absPath)
-	if err != nil {
-		return fmt.Errorf("failed to get file info: %w", err)
-	}
-
-	r.State.WorkTree[path] = WorkTreeEntry{
-		Path:    path,
-		Size:    fileInfo.Size(),
-		ModTime: fileInfo.ModTime(),
-		Hash:    objID,
-	}
+// AddOptions represents options for staging files
+type AddOptions struct {
+	Force  bool // Stage files even if they are ignored
+	All    bool // Also stage deletions of tracked files
+	Update bool // Only stage tracked files, including their deletions
+	DryRun bool // Report what would be staged without changing the index
+}
 
-	// Save index
-	if err := r.SaveIndex(); err != nil {
-		return fmt.Errorf("failed to save index: %w", err)
-	}
+// Add stages a file or directory for commit
+func (r *Repository) Add(path string) error {
+	return r.AddWithOptions(path, nil)
+}
 
-	return nil
+// AddWithOptions stages the files selected by a single pathspec
+func (r *Repository) AddWithOptions(path string, options *AddOptions) error {
+	_, err := r.AddPaths([]string{path}, options)
+	return err
 }
 
-// Status shows the status of the repository
+// Status describes the status of the repository for people, see StatusReport
 func (r *Repository) Status() (string, error) {
-	// Get current branch name
-	branchName, err := r.GetCurrentBranch()
+	report, err := r.StatusReport()
 	if err != nil {
-		branchName = "main" // Default to main if we can't determine branch
-	}
-
-	// Check for different file states
-	modified := []string{}         // Modified but not staged
-	staged := []string{}           // Staged for commit
-	untracked := []string{}        // Not tracked by Git
-	modified_tracked := []string{} // Modified since last commit (tracked files)
-
-	// Get all files in working directory
-	err = filepath.Walk(r.Path, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		// Skip .kit directory and subdirectories
-		if strings.Contains(path, DefaultKitDir) {
-			if info.IsDir() {
-				return filepath.SkipDir
-			}
-			return nil
-		}
-
-		// Skip directories
-		if info.IsDir() {
-			return nil
-		}
-
-		// Get relative path
-		relPath, err := filepath.Rel(r.Path, path)
-		if err != nil {
-			return err
-		}
-
-		// Check the file's status
-		isStaged := false
-		isTracked := false
-
-		// Check if file is in staging area
-		if _, ok := r.State.Stage[relPath]; ok {
-			isStaged = true
-			staged = append(staged, relPath)
-
-			// Check if it's also modified since staging
-			if entry, ok := r.State.WorkTree[relPath]; ok {
-				fileInfo := info
-				if entry.ModTime != fileInfo.ModTime() || entry.Size != fileInfo.Size() {
-					modified = append(modified, relPath)
-				}
-			}
-		}
-
-		// Check if file is tracked (committed)
-		if _, ok := r.State.Tracked[relPath]; ok {
-			isTracked = true
-
-			// If not staged but tracked, check if modified since last commit
-			if !isStaged {
-				// Get file hash
-				content, err := os.ReadFile(path)
-				if err == nil {
-					hash := sha256.Sum256(content)
-					objID := hex.EncodeToString(hash[:])
-
-					// Compare with tracked version
-					if objID != r.State.Tracked[relPath] {
-						modified_tracked = append(modified_tracked, relPath)
-					}
-				}
-			}
-		}
-
-		// If neither staged nor tracked, it's untracked
-		if !isStaged && !isTracked {
-			untracked = append(untracked, relPath)
-		}
-
-		return nil
-	})
-
-	if err != nil {
-		return "", fmt.Errorf("failed to walk directory: %w", err)
-	}
-
-	// Build status message
-	var sb strings.Builder
-	sb.WriteString(fmt.Sprintf("On branch %s\n\n", branchName))
-
-	if len(staged) > 0 {
-		sb.WriteString("Changes to be committed:\n")
-		for _, file := range staged {
-			// Check if this is a new file or modified file
-			if _, ok := r.State.Tracked[file]; ok {
-				sb.WriteString(fmt.Sprintf("  modified: %s\n", file))
-			} else {
-				sb.WriteString(fmt.Sprintf("  new file: %s\n", file))
-			}
-		}
-		sb.WriteString("\n")
-	}
-
-	if len(modified) > 0 {
-		sb.WriteString("Changes not staged for commit:\n")
-		for _, file := range modified {
-			sb.WriteString(fmt.Sprintf("  modified: %s\n", file))
-		}
-		sb.WriteString("\n")
-	}
-
-	if len(modified_tracked) > 0 {
-		sb.WriteString("Changes not staged for commit:\n")
-		for _, file := range modified_tracked {
-			sb.WriteString(fmt.Sprintf("  modified: %s\n", file))
-		}
-		sb.WriteString("\n")
-	}
-
-	if len(untracked) > 0 {
-		sb.WriteString("Untracked files:\n")
-		for _, file := range untracked {
-			sb.WriteString(fmt.Sprintf("  %s\n", file))
-		}
-		sb.WriteString("\n")
-	}
-
-	if len(staged) == 0 && len(modified) == 0 && len(modified_tracked) == 0 && len(untracked) == 0 {
-		sb.WriteString("nothing to commit, working tree clean\n")
+		return "", err
 	}
+	return report.String(), nil
+}
 
-	return sb.String(), nil
+// hashContent computes the object ID for the given content
+func hashContent(content []byte) string {
+	hash := sha256.Sum256(content)
+	return hex.EncodeToString(hash[:])
 }
 
 // storeObject stores an object in the object database
@@ -411,6 +286,11 @@ func (r *Repository) storeObject(objID string, content []byte) error {
 	objDir := filepath.Join(r.Path, DefaultKitDir, DefaultKitObjectsDir)
 	objPath := filepath.Join(objDir, objID[:2], objID[2:])
 
+	// Objects are content addressed, so an existing object never changes
+	if _, err := os.Stat(objPath); err == nil {
+		return nil
+	}
+
 	// Create subdirectory if it doesn't exist
 	if err := os.MkdirAll(filepath.Join(objDir, objID[:2]), 0755); err != nil {
 		return fmt.Errorf("failed to create object directory: %w", err)
@@ -434,6 +314,15 @@ func (r *Repository) readObject(objID string) ([]byte, error) {
 	return content, nil
 }
 
+// hasObject checks whether an object exists in the object store
+func (r *Repository) hasObject(objID string) bool {
+	if len(objID) < 3 {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(r.Path, DefaultKitDir, DefaultKitObjectsDir, objID[:2], objID[2:]))
+	return err == nil
+}
+
 // IsRepository checks if the given path is a Kit repository
 func IsRepository(path string) bool {
 	kitDir := filepath.Join(path, DefaultKitDir)
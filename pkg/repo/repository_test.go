@@ -643,4 +643,64 @@ func BenchmarkCommit(b *testing.B) {
 			b.Fatalf("Failed to commit: %v", err)
 		}
 	}
-}
\ No newline at end of file
+}
+
+// newTestRepository creates and initializes a repository in a temporary directory
+func newTestRepository(t *testing.T) *Repository {
+	t.Helper()
+
+	// Keep the developer's global ignore file out of the tests
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	repo, err := NewRepository(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create repository: %v", err)
+	}
+	if err := repo.Initialize(); err != nil {
+		t.Fatalf("Failed to initialize repository: %v", err)
+	}
+
+	return repo
+}
+
+// writeTestFile writes a file relative to the repository root
+func writeTestFile(t *testing.T, repo *Repository, path, content string) {
+	t.Helper()
+
+	fullPath := filepath.Join(repo.Path, path)
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		t.Fatalf("Failed to create directory for %s: %v", path, err)
+	}
+	if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+}
+
+// readTestFile reads a file relative to the repository root
+func readTestFile(t *testing.T, repo *Repository, path string) string {
+	t.Helper()
+
+	content, err := os.ReadFile(filepath.Join(repo.Path, path))
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", path, err)
+	}
+	return string(content)
+}
+
+// commitTestFiles writes, stages and commits the given files
+func commitTestFiles(t *testing.T, repo *Repository, message string, files map[string]string) string {
+	t.Helper()
+
+	for path, content := range files {
+		writeTestFile(t, repo, path, content)
+		if err := repo.Add(path); err != nil {
+			t.Fatalf("Failed to add %s: %v", path, err)
+		}
+	}
+
+	commitID, err := repo.Commit(message)
+	if err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	return commitID
+}
@@ -0,0 +1,285 @@
+package repo
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ResetMode selects what Reset rewrites besides the current branch
+type ResetMode int
+
+const (
+	ResetSoft  ResetMode = iota // Only move the branch; the index and working tree are kept
+	ResetMixed                  // Also reset the index to the target commit
+	ResetHard                   // Also reset the index and working tree to the target commit
+	ResetMerge                  // Like ResetHard, but keep local edits to files that aren't staged and don't change
+)
+
+// String returns the name of the mode as used on the command line
+func (mode ResetMode) String() string {
+	switch mode {
+	case ResetSoft:
+		return "soft"
+	case ResetHard:
+		return "hard"
+	case ResetMerge:
+		return "merge"
+	default:
+		return "mixed"
+	}
+}
+
+// RestoreOptions represents options for restoring files
+type RestoreOptions struct {
+	Source   string // Revision to restore from; defaults to the index, or HEAD with Staged
+	Staged   bool   // Restore the index
+	Worktree bool   // Restore the working tree; the default when Staged is not set
+}
+
+// Reset moves the current branch (or a detached HEAD) to a revision and,
+// depending on the mode, rewrites the index and working tree to match it.
+// Untracked files are never touched. It returns the new commit ID.
+func (r *Repository) Reset(rev string, mode ResetMode) (string, error) {
+	if rev == "" {
+		rev = "HEAD"
+	}
+
+	targetID, err := r.ResolveRevision(rev)
+	if err != nil {
+		return "", err
+	}
+	commit, err := r.readCommit(targetID)
+	if err != nil {
+		return "", fmt.Errorf("failed to read commit %s: %w", targetID, err)
+	}
+	tree, err := r.readTree(commit.Tree)
+	if err != nil {
+		return "", fmt.Errorf("failed to read tree %s: %w", commit.Tree, err)
+	}
+	if err := r.resetFiles(treeBlobs(tree), mode); err != nil {
+		return "", err
+	}
+
+	oldID, _ := r.resolveReference("HEAD")
+	if err := r.updateReference("HEAD", targetID); err != nil {
+		return "", fmt.Errorf("failed to update HEAD: %w", err)
+	}
+	refs := []string{"HEAD"}
+	if r.State.HEAD != "HEAD" {
+		refs = append(refs, r.State.HEAD)
+	}
+	for _, ref := range refs {
+		if err := r.appendReflog(ref, oldID, targetID, fmt.Sprintf("reset: moving to %s", rev)); err != nil {
+			return "", err
+		}
+	}
+
+	if err := r.SaveIndex(); err != nil {
+		return "", fmt.Errorf("failed to save index: %w", err)
+	}
+
+	return targetID, nil
+}
+
+// resetFiles rewrites the tracked files and, depending on the mode, the
+// index and working tree to match target. HEAD is left alone.
+func (r *Repository) resetFiles(target map[string]string, mode ResetMode) error {
+	// Remember the index before it is rewritten
+	oldEntries := r.indexEntries()
+	oldFiles := make(map[string]string, len(oldEntries)+len(r.State.Tracked))
+	for path, objID := range r.State.Tracked {
+		oldFiles[path] = objID
+	}
+	for path, objID := range oldEntries {
+		oldFiles[path] = objID
+	}
+
+	if mode == ResetHard {
+		if err := r.materializeTree(oldFiles, target); err != nil {
+			return fmt.Errorf("failed to update working tree: %w", err)
+		}
+	}
+	if mode == ResetMerge {
+		from, to, err := r.planResetMerge(oldEntries, target)
+		if err != nil {
+			return err
+		}
+		if err := r.materializeTree(from, to); err != nil {
+			return fmt.Errorf("failed to update working tree: %w", err)
+		}
+	}
+
+	// The tracked files always follow HEAD; what is staged depends on the mode
+	r.State.Tracked = target
+	if mode == ResetSoft {
+		r.setIndexEntries(oldEntries)
+	} else {
+		r.setIndexEntries(target)
+		r.State.Conflicts = make(map[string]ConflictEntry)
+	}
+
+	return nil
+}
+
+// planResetMerge picks the files a merge reset rewrites: those that are
+// staged or unmerged, and those that differ between HEAD and the target.
+// It returns the files to remove and the files to write. Local edits to
+// any other file are kept; an edit that would be lost, to a file that
+// changes or on top of a staged change, refuses the reset.
+func (r *Repository) planResetMerge(index, target map[string]string) (map[string]string, map[string]string, error) {
+	head := r.State.Tracked
+	paths := make(map[string]bool, len(head)+len(target))
+	for _, files := range []map[string]string{head, index, target} {
+		for path := range files {
+			paths[path] = true
+		}
+	}
+	for path := range r.State.Conflicts {
+		paths[path] = true
+	}
+
+	from := make(map[string]string)
+	to := make(map[string]string)
+	dirty := []string{}
+	for path := range paths {
+		h, inHead := head[path]
+		i, inIndex := index[path]
+		m, inTarget := target[path]
+		_, unmerged := r.State.Conflicts[path]
+		staged := i != h || inIndex != inHead
+		if !unmerged && !staged && h == m && inHead == inTarget {
+			continue
+		}
+
+		// Files outside a sparse checkout stand for their index entry
+		if !unmerged {
+			w := i
+			if !r.State.SkipWorktree[path] {
+				var err error
+				if w, err = r.workingFileID(path); err != nil {
+					return nil, nil, err
+				}
+			}
+			if w != i {
+				dirty = append(dirty, path)
+				continue
+			}
+		}
+
+		if inTarget {
+			to[path] = m
+		} else {
+			from[path] = i
+		}
+	}
+	if len(dirty) > 0 {
+		sort.Strings(dirty)
+		return nil, nil, fmt.Errorf("your local changes to the following files would be overwritten by reset:\n\t%s",
+			strings.Join(dirty, "\n\t"))
+	}
+
+	return from, to, nil
+}
+
+// Restore restores the files selected by pathspecs in the index and/or the
+// working tree. Selected files that are missing from the source are removed.
+// By default the working tree is restored from the index; with Staged the
+// index is restored from HEAD. A Source revision overrides either.
+func (r *Repository) Restore(pathspecs []string, options *RestoreOptions) ([]string, error) {
+	if options == nil {
+		options = &RestoreOptions{}
+	}
+	if len(pathspecs) == 0 {
+		return nil, fmt.Errorf("you must specify path(s) to restore")
+	}
+	worktree := options.Worktree || !options.Staged
+
+	specs := make([]string, len(pathspecs))
+	for i, spec := range pathspecs {
+		specs[i] = cleanPathspec(spec)
+	}
+
+	// Work out where the files come from
+	var source map[string]string
+	switch {
+	case options.Source != "":
+		commitID, err := r.ResolveRevision(options.Source)
+		if err != nil {
+			return nil, err
+		}
+		tree, err := r.getTreeFromCommit(commitID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get tree for %s: %w", options.Source, err)
+		}
+		source = treeBlobs(tree)
+	case options.Staged:
+		source = make(map[string]string, len(r.State.Tracked))
+		for path, objID := range r.State.Tracked {
+			source[path] = objID
+		}
+	default:
+		source = r.indexEntries()
+	}
+
+	// Select paths known to the source or the index
+	index := r.indexEntries()
+	selected := make(map[string]bool)
+	matched := make(map[string]bool, len(specs))
+	for _, files := range []map[string]string{source, index, r.State.Tracked} {
+		for path := range files {
+			for _, spec := range specs {
+				if pathspecMatches(spec, filepath.ToSlash(path)) {
+					matched[spec] = true
+					selected[path] = true
+				}
+			}
+		}
+	}
+	for _, spec := range specs {
+		if !matched[spec] {
+			return nil, fmt.Errorf("pathspec '%s' did not match any file(s) known to kit", spec)
+		}
+	}
+
+	paths := make([]string, 0, len(selected))
+	for path := range selected {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	if options.Staged {
+		for _, path := range paths {
+			if objID, ok := source[path]; ok {
+				index[path] = objID
+			} else {
+				delete(index, path)
+			}
+		}
+		r.setIndexEntries(index)
+	}
+
+	if worktree {
+		from := make(map[string]string)
+		to := make(map[string]string)
+		for _, path := range paths {
+			if objID, ok := source[path]; ok {
+				to[path] = objID
+			} else if objID, ok := index[path]; ok {
+				from[path] = objID
+			} else if objID, ok := r.State.Tracked[path]; ok {
+				from[path] = objID
+			}
+		}
+		if err := r.materializeTree(from, to); err != nil {
+			return nil, fmt.Errorf("failed to update working tree: %w", err)
+		}
+	}
+
+	if err := r.SaveIndex(); err != nil {
+		return nil, fmt.Errorf("failed to save index: %w", err)
+	}
+
+	return paths, nil
+}
@@ -0,0 +1,163 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResetModes(t *testing.T) {
+	for _, mode := range []ResetMode{ResetSoft, ResetMixed, ResetHard} {
+		t.Run(mode.String(), func(t *testing.T) {
+			repo := newTestRepository(t)
+			first := commitTestFiles(t, repo, "First", map[string]string{"a.txt": "one\n"})
+			commitTestFiles(t, repo, "Second", map[string]string{"a.txt": "two\n", "b.txt": "new\n"})
+
+			commitID, err := repo.Reset("HEAD~1", mode)
+			if err != nil {
+				t.Fatalf("Failed to reset: %v", err)
+			}
+			if commitID != first {
+				t.Errorf("Expected reset to %s, got %s", first, commitID)
+			}
+			if head := mustResolve(t, repo, "HEAD"); head != first {
+				t.Errorf("Expected HEAD at %s, got %s", first, head)
+			}
+			for _, ref := range []string{"HEAD", "refs/heads/main"} {
+				entries, err := repo.ReadReflog(ref)
+				if err != nil || len(entries) == 0 || entries[len(entries)-1].Message != "reset: moving to HEAD~1" || entries[len(entries)-1].NewID != first {
+					t.Errorf("Expected the reset in the reflog of %s, got %+v (%v)", ref, entries, err)
+				}
+			}
+
+			index := repo.indexEntries()
+			switch mode {
+			case ResetSoft:
+				// The index still holds the second commit
+				if index["a.txt"] != hashContent([]byte("two\n")) || index["b.txt"] == "" {
+					t.Errorf("Expected soft reset to keep the index, got %v", index)
+				}
+			default:
+				if index["a.txt"] != hashContent([]byte("one\n")) || index["b.txt"] != "" {
+					t.Errorf("Expected index to match the first commit, got %v", index)
+				}
+			}
+
+			_, err = os.Stat(filepath.Join(repo.Path, "b.txt"))
+			if mode == ResetHard {
+				if !os.IsNotExist(err) {
+					t.Error("Expected hard reset to remove b.txt")
+				}
+				if content := readTestFile(t, repo, "a.txt"); content != "one\n" {
+					t.Errorf("Expected a.txt to be reset, got %q", content)
+				}
+			} else if content := readTestFile(t, repo, "a.txt"); content != "two\n" {
+				t.Errorf("Expected working tree to be kept, got %q", content)
+			}
+		})
+	}
+}
+
+func TestResetHardKeepsUntrackedFiles(t *testing.T) {
+	repo := newTestRepository(t)
+	commitTestFiles(t, repo, "First", map[string]string{"a.txt": "one\n"})
+	writeTestFile(t, repo, "a.txt", "edited\n")
+	writeTestFile(t, repo, "untracked.txt", "keep me\n")
+
+	if _, err := repo.Reset("HEAD", ResetHard); err != nil {
+		t.Fatalf("Failed to reset: %v", err)
+	}
+	if content := readTestFile(t, repo, "a.txt"); content != "one\n" {
+		t.Errorf("Expected edit to be discarded, got %q", content)
+	}
+	if content := readTestFile(t, repo, "untracked.txt"); content != "keep me\n" {
+		t.Errorf("Expected untracked file to be kept, got %q", content)
+	}
+}
+
+func TestResetMerge(t *testing.T) {
+	repo := newTestRepository(t)
+	first := commitTestFiles(t, repo, "First", map[string]string{"a.txt": "one\n", "x.txt": "x\n", "y.txt": "y\n"})
+	second := commitTestFiles(t, repo, "Second", map[string]string{"a.txt": "two\n", "b.txt": "new\n"})
+
+	// Staged changes and files that differ from the target are reset,
+	// unstaged edits to other files are kept
+	writeTestFile(t, repo, "y.txt", "staged\n")
+	if err := repo.Add("y.txt"); err != nil {
+		t.Fatalf("Failed to add: %v", err)
+	}
+	writeTestFile(t, repo, "x.txt", "local\n")
+	if _, err := repo.Reset(first, ResetMerge); err != nil {
+		t.Fatalf("Failed to reset: %v", err)
+	}
+	expected := map[string]string{"a.txt": "one\n", "x.txt": "local\n", "y.txt": "y\n"}
+	for path, want := range expected {
+		if content := readTestFile(t, repo, path); content != want {
+			t.Errorf("Expected %s to be %q, got %q", path, want, content)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(repo.Path, "b.txt")); !os.IsNotExist(err) {
+		t.Error("Expected b.txt to be removed")
+	}
+	if index := repo.indexEntries(); index["x.txt"] != hashContent([]byte("x\n")) || len(index) != 3 {
+		t.Errorf("Expected the index to match the first commit, got %v", index)
+	}
+
+	// An edit to a file the reset changes would be lost, so it is refused
+	writeTestFile(t, repo, "a.txt", "edited\n")
+	if _, err := repo.Reset(second, ResetMerge); err == nil || !strings.Contains(err.Error(), "a.txt") {
+		t.Errorf("Expected the reset to be refused for a.txt, got %v", err)
+	}
+	if head := mustResolve(t, repo, "HEAD"); head != first || readTestFile(t, repo, "a.txt") != "edited\n" {
+		t.Errorf("Expected a refused reset to change nothing")
+	}
+}
+
+func TestRestore(t *testing.T) {
+	repo := newTestRepository(t)
+	first := commitTestFiles(t, repo, "First", map[string]string{"a.txt": "one\n", "dir/b.txt": "b\n"})
+	commitTestFiles(t, repo, "Second", map[string]string{"a.txt": "two\n"})
+
+	// Unstage a change but keep it in the working tree
+	writeTestFile(t, repo, "a.txt", "three\n")
+	if err := repo.Add("a.txt"); err != nil {
+		t.Fatalf("Failed to add: %v", err)
+	}
+	if _, err := repo.Restore([]string{"a.txt"}, &RestoreOptions{Staged: true}); err != nil {
+		t.Fatalf("Failed to restore staged file: %v", err)
+	}
+	if _, staged := repo.State.Stage["a.txt"]; staged {
+		t.Error("Expected a.txt to be unstaged")
+	}
+	if content := readTestFile(t, repo, "a.txt"); content != "three\n" {
+		t.Errorf("Expected working tree to be kept, got %q", content)
+	}
+
+	// Discard the working tree change
+	if _, err := repo.Restore([]string{"a.txt"}, nil); err != nil {
+		t.Fatalf("Failed to restore file: %v", err)
+	}
+	if content := readTestFile(t, repo, "a.txt"); content != "two\n" {
+		t.Errorf("Expected a.txt to be restored from the index, got %q", content)
+	}
+
+	// Recover a directory from an older commit into both index and working tree
+	writeTestFile(t, repo, "dir/b.txt", "changed\n")
+	if _, err := repo.Restore([]string{"."}, &RestoreOptions{Source: first[:8], Staged: true, Worktree: true}); err != nil {
+		t.Fatalf("Failed to restore from source: %v", err)
+	}
+	if content := readTestFile(t, repo, "a.txt"); content != "one\n" {
+		t.Errorf("Expected a.txt from the first commit, got %q", content)
+	}
+	if content := readTestFile(t, repo, "dir/b.txt"); content != "b\n" {
+		t.Errorf("Expected dir/b.txt from the first commit, got %q", content)
+	}
+	if repo.State.Stage["a.txt"] != hashContent([]byte("one\n")) {
+		t.Error("Expected a.txt from the first commit to be staged")
+	}
+
+	if _, err := repo.Restore([]string{"missing.txt"}, nil); err == nil {
+		t.Error("Expected unknown path to fail")
+	}
+}
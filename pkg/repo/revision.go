@@ -0,0 +1,170 @@
+package repo
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// minAbbrevLength is the shortest accepted abbreviated commit ID
+const minAbbrevLength = 4
+
+// ResolveRevision resolves a revision to a commit ID. A revision is a full
+// or abbreviated commit ID, HEAD, a branch or tag name or a full reference
+// name, followed by any number of "~<n>" (n-th first-parent ancestor) and
+// "^<n>" (n-th parent) suffixes.
+func (r *Repository) ResolveRevision(rev string) (string, error) {
+	if rev == "" {
+		return "", fmt.Errorf("empty revision")
+	}
+
+	base := rev
+	suffix := ""
+	if i := strings.IndexAny(rev, "~^"); i >= 0 {
+		base, suffix = rev[:i], rev[i:]
+	}
+
+	commitID, err := r.resolveRevisionBase(base)
+	if err != nil {
+		return "", fmt.Errorf("unknown revision '%s': %w", rev, err)
+	}
+
+	for suffix != "" {
+		op := suffix[0]
+		digits := 0
+		for 1+digits < len(suffix) && suffix[1+digits] >= '0' && suffix[1+digits] <= '9' {
+			digits++
+		}
+		n := 1
+		if digits > 0 {
+			n, _ = strconv.Atoi(suffix[1 : 1+digits])
+		}
+		suffix = suffix[1+digits:]
+
+		switch op {
+		case '~':
+			for i := 0; i < n; i++ {
+				commitID, err = r.nthParent(commitID, 1)
+				if err != nil {
+					return "", fmt.Errorf("invalid revision '%s': %w", rev, err)
+				}
+			}
+		case '^':
+			commitID, err = r.nthParent(commitID, n)
+			if err != nil {
+				return "", fmt.Errorf("invalid revision '%s': %w", rev, err)
+			}
+		default:
+			return "", fmt.Errorf("invalid revision '%s'", rev)
+		}
+	}
+
+	return commitID, nil
+}
+
+// resolveRevisionBase resolves a revision without ancestry suffixes
+func (r *Repository) resolveRevisionBase(name string) (string, error) {
+	if name == "HEAD" || name == "@" {
+		commitID, err := r.resolveReference("HEAD")
+		if err != nil || commitID == "" {
+			return "", fmt.Errorf("HEAD does not point to a commit")
+		}
+		return commitID, nil
+	}
+
+	if !validRefName(name) {
+		return "", fmt.Errorf("invalid reference name '%s'", name)
+	}
+
+	// References take precedence over abbreviated IDs
+	candidates := []string{name, "refs/" + name, "refs/heads/" + name, "refs/tags/" + name}
+	if !strings.HasPrefix(name, "refs/") {
+		candidates = candidates[1:]
+	}
+	for _, ref := range candidates {
+		if commitID, err := r.resolveReference(ref); err == nil && commitID != "" {
+			return commitID, nil
+		}
+	}
+
+	if len(name) >= minAbbrevLength {
+		if _, err := hex.DecodeString(name + strings.Repeat("0", len(name)%2)); err == nil {
+			return r.expandCommitID(strings.ToLower(name))
+		}
+	}
+
+	return "", fmt.Errorf("no such reference or commit")
+}
+
+// validRefName reports whether name can be looked up under refs/: it has
+// no empty, "." or ".." components that could lead out of that directory
+func validRefName(name string) bool {
+	if strings.ContainsRune(name, '\\') {
+		return false
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// expandCommitID finds the unique commit whose ID starts with prefix
+func (r *Repository) expandCommitID(prefix string) (string, error) {
+	objDir := filepath.Join(r.Path, DefaultKitDir, DefaultKitObjectsDir, prefix[:2])
+	entries, err := os.ReadDir(objDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("no such reference or commit")
+		}
+		return "", fmt.Errorf("failed to read objects: %w", err)
+	}
+
+	match := ""
+	for _, entry := range entries {
+		objID := prefix[:2] + entry.Name()
+		if !strings.HasPrefix(objID, prefix) {
+			continue
+		}
+		if objType, err := r.ObjectType(objID); err != nil || objType != ObjectCommit {
+			continue
+		}
+		if match != "" {
+			return "", fmt.Errorf("short commit ID %s is ambiguous", prefix)
+		}
+		match = objID
+	}
+
+	if match == "" {
+		return "", fmt.Errorf("no such reference or commit")
+	}
+	return match, nil
+}
+
+// nthParent returns the n-th parent of a commit; the 0th is the commit itself
+func (r *Repository) nthParent(commitID string, n int) (string, error) {
+	if n == 0 {
+		return commitID, nil
+	}
+
+	commit, err := r.readCommit(commitID)
+	if err != nil {
+		return "", err
+	}
+
+	var parent string
+	switch n {
+	case 1:
+		parent = commit.Parent
+	case 2:
+		parent = commit.Parent2
+	}
+	if parent == "" {
+		return "", fmt.Errorf("commit %s has no parent %d", shortID(commitID), n)
+	}
+	return parent, nil
+}
@@ -0,0 +1,43 @@
+package repo
+
+import "testing"
+
+func TestResolveRevision(t *testing.T) {
+	repo := newTestRepository(t)
+	first := commitTestFiles(t, repo, "First", map[string]string{"a.txt": "one\n"})
+	second := commitTestFiles(t, repo, "Second", map[string]string{"a.txt": "two\n"})
+	third := commitTestFiles(t, repo, "Third", map[string]string{"a.txt": "three\n"})
+
+	tests := map[string]string{
+		"HEAD":            third,
+		"main":            third,
+		"refs/heads/main": third,
+		"HEAD~1":          second,
+		"HEAD^":           second,
+		"main~2":          first,
+		"HEAD^^":          first,
+		"HEAD~1^1":        first,
+		"HEAD^0":          third,
+		second[:10]:       second,
+		first:             first,
+	}
+	for rev, expected := range tests {
+		got, err := repo.ResolveRevision(rev)
+		if err != nil {
+			t.Errorf("Failed to resolve %s: %v", rev, err)
+			continue
+		}
+		if got != expected {
+			t.Errorf("Expected %s to resolve to %s, got %s", rev, expected, got)
+		}
+	}
+
+	// A file outside refs/ is not a reference, even when it holds a commit ID
+	writeTestFile(t, repo, ".kit/outside", first)
+	for _, rev := range []string{"HEAD~3", "HEAD^2", "nosuchbranch", "zzzz",
+		"refs/heads/../../outside", "heads/../../outside", "../outside", "/refs/heads/main", "refs//heads/main"} {
+		if _, err := repo.ResolveRevision(rev); err == nil {
+			t.Errorf("Expected %s not to resolve", rev)
+		}
+	}
+}
@@ -0,0 +1,604 @@
+package repo
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// RevOrder selects the order in which a revision walk returns commits
+type RevOrder int
+
+const (
+	OrderDefault RevOrder = iota // Newest commit first, by commit timestamp
+	OrderDate                    // No parent before all of its children, otherwise newest first
+	OrderTopo                    // No parent before all of its children, without interleaving lines of history
+)
+
+// RevWalkOptions represents options for walking the commit graph
+type RevWalkOptions struct {
+	Order       RevOrder              // Order of the returned commits
+	FirstParent bool                  // Only follow the first parent of merge commits
+	Reverse     bool                  // Return the commits in reverse order
+	Paths       []string              // Only return commits that change these pathspecs
+	Follow      bool                  // Follow the single path in Paths across renames
+	MaxCount    int                   // Return at most this many commits (before reversing); 0 for all
+	Filter      func(*CommitLog) bool // Only return commits it accepts, if set
+}
+
+// ParseRevisionRange splits revision arguments into commits to include and
+// commits whose ancestors are excluded. "A..B" includes B and excludes A,
+// with HEAD standing in for an omitted side; "^A" excludes A; anything else
+// is included. Without included revisions HEAD is included.
+func (r *Repository) ParseRevisionRange(revisions []string) ([]string, []string, error) {
+	include, exclude := []string{}, []string{}
+	resolve := func(rev string) (string, error) {
+		if rev == "" {
+			rev = "HEAD"
+		}
+		return r.ResolveRevision(rev)
+	}
+
+	for _, rev := range revisions {
+		switch {
+		case strings.Contains(rev, ".."):
+			from, to, _ := strings.Cut(rev, "..")
+			fromID, err := resolve(from)
+			if err != nil {
+				return nil, nil, err
+			}
+			toID, err := resolve(to)
+			if err != nil {
+				return nil, nil, err
+			}
+			exclude = append(exclude, fromID)
+			include = append(include, toID)
+		case strings.HasPrefix(rev, "^"):
+			commitID, err := resolve(rev[1:])
+			if err != nil {
+				return nil, nil, err
+			}
+			exclude = append(exclude, commitID)
+		default:
+			commitID, err := resolve(rev)
+			if err != nil {
+				return nil, nil, err
+			}
+			include = append(include, commitID)
+		}
+	}
+
+	if len(include) == 0 {
+		commitID, err := r.resolveReference("HEAD")
+		if err == nil && commitID != "" {
+			include = append(include, commitID)
+		}
+	}
+
+	return include, exclude, nil
+}
+
+// RevWalk returns the commits reachable from include but not from exclude,
+// following every parent of merge commits unless options.FirstParent is set.
+//
+// With options.Paths history is simplified: a commit is left out when its
+// tree matches its parent's for the paths (it is TREESAME), and a merge
+// that is TREESAME to one of its parents is left out and only that parent
+// is followed. With options.Follow the single path is tracked across the
+// commits that renamed it. Commits left out by simplification or by
+// options.Filter are skipped when listing parents, so the parents of the
+// returned commits are their nearest returned ancestors.
+func (r *Repository) RevWalk(include, exclude []string, options *RevWalkOptions) ([]*CommitLog, error) {
+	if options == nil {
+		options = &RevWalkOptions{}
+	}
+	if options.Follow && len(options.Paths) != 1 {
+		return nil, fmt.Errorf("--follow requires exactly one path")
+	}
+
+	walk := &revWalk{
+		repo:        r,
+		commits:     make(map[string]*CommitLog),
+		firstParent: options.FirstParent,
+		paths:       options.Paths,
+		follow:      options.Follow,
+		pathAt:      make(map[string]string),
+		files:       make(map[string]map[string]string),
+		hidden:      make(map[string]bool),
+	}
+	if options.Follow {
+		for _, commitID := range include {
+			walk.pathAt[commitID] = cleanPathspec(options.Paths[0])
+		}
+	}
+
+	// Everything reachable from an excluded commit is left out
+	excluded := make(map[string]bool)
+	for _, commitID := range exclude {
+		ancestors, err := r.ancestry(commitID)
+		if err != nil {
+			return nil, err
+		}
+		for id := range ancestors {
+			excluded[id] = true
+		}
+	}
+
+	// Collect the commits to show
+	selected := make(map[string]bool)
+	queue := append([]string{}, include...)
+	for len(queue) > 0 {
+		commitID := queue[0]
+		queue = queue[1:]
+		if selected[commitID] || excluded[commitID] {
+			continue
+		}
+		commit, err := walk.commit(commitID)
+		if err != nil {
+			return nil, err
+		}
+		selected[commitID] = true
+		queue = append(queue, commit.Parents...)
+	}
+
+	var result []*CommitLog
+	switch options.Order {
+	case OrderTopo, OrderDate:
+		result = walk.sortTopological(include, selected, options.Order == OrderTopo)
+	default:
+		result = walk.sortByDate(include, selected)
+	}
+
+	// Leave out simplified and filtered commits, linking the rest to their
+	// nearest remaining ancestors. Whether a commit is kept is only worked
+	// out when the walk reaches it, so that the details the filter needs
+	// are read for no more commits than MaxCount calls for.
+	keep := make(map[string]bool, len(result))
+	kept := func(commit *CommitLog) (bool, error) {
+		if ok, decided := keep[commit.ID]; decided {
+			return ok, nil
+		}
+		ok := !walk.hidden[commit.ID]
+		if ok && options.Filter != nil {
+			if err := walk.details(commit); err != nil {
+				return false, err
+			}
+			ok = options.Filter(commit)
+		}
+		keep[commit.ID] = ok
+		return ok, nil
+	}
+	rewritten := make(map[string][]string)
+	var rewrite func(commitID string) ([]string, error)
+	rewrite = func(commitID string) ([]string, error) {
+		commit := walk.commits[commitID]
+		if commit == nil {
+			return []string{commitID}, nil
+		}
+		if ok, err := kept(commit); err != nil || ok {
+			return []string{commitID}, err
+		}
+		if parents, ok := rewritten[commitID]; ok {
+			return parents, nil
+		}
+		rewritten[commitID] = nil // Guards against revisiting while in progress
+		parents := []string{}
+		for _, parent := range commit.Parents {
+			ancestors, err := rewrite(parent)
+			if err != nil {
+				return nil, err
+			}
+			for _, ancestor := range ancestors {
+				if !containsString(parents, ancestor) {
+					parents = append(parents, ancestor)
+				}
+			}
+		}
+		rewritten[commitID] = parents
+		return parents, nil
+	}
+
+	shown := []*CommitLog{}
+	parents := make(map[*CommitLog][]string)
+	for _, commit := range result {
+		if options.MaxCount > 0 && len(shown) == options.MaxCount {
+			break
+		}
+		if ok, err := kept(commit); err != nil {
+			return nil, err
+		} else if !ok {
+			continue
+		}
+		if err := walk.details(commit); err != nil {
+			return nil, err
+		}
+		list := []string{}
+		for _, parent := range commit.Parents {
+			ancestors, err := rewrite(parent)
+			if err != nil {
+				return nil, err
+			}
+			for _, ancestor := range ancestors {
+				if !containsString(list, ancestor) {
+					list = append(list, ancestor)
+				}
+			}
+		}
+		parents[commit] = list
+		shown = append(shown, commit)
+	}
+	for commit, list := range parents {
+		commit.Parents = list
+	}
+	result = shown
+
+	if options.Reverse {
+		for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+			result[i], result[j] = result[j], result[i]
+		}
+	}
+
+	return result, nil
+}
+
+// revWalk caches the commits read during a revision walk
+type revWalk struct {
+	repo        *Repository
+	commits     map[string]*CommitLog
+	firstParent bool
+	paths       []string                     // Pathspecs limiting the history
+	follow      bool                         // Whether the single path is followed across renames
+	pathAt      map[string]string            // Name of the followed path at each commit
+	files       map[string]map[string]string // Files of commits, by commit
+	hidden      map[string]bool              // Commits TREESAME to a parent
+	trees       map[string]string            // Tree IDs of commits, by commit
+	detailed    map[string]bool              // Commits whose author and message have been read
+}
+
+// commit reads a commit, keeping only its first parent if the walk follows first parents
+func (w *revWalk) commit(commitID string) (*CommitLog, error) {
+	if commit, ok := w.commits[commitID]; ok {
+		return commit, nil
+	}
+
+	node, err := w.repo.commitNode(commitID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The author and message are read by details, once the commit is shown
+	commit := &CommitLog{
+		ID:        commitID,
+		Timestamp: node.Time,
+		Parents:   []string{},
+	}
+	for _, parent := range node.Parents {
+		if !(w.firstParent && len(commit.Parents) == 1) {
+			commit.Parents = append(commit.Parents, parent)
+		}
+	}
+
+	if w.trees == nil {
+		w.trees = make(map[string]string)
+	}
+	w.trees[commitID] = node.Tree
+	if len(w.paths) > 0 {
+		if err := w.simplify(commit, node.Tree); err != nil {
+			return nil, err
+		}
+	}
+
+	w.commits[commitID] = commit
+	return commit, nil
+}
+
+// details reads the author, message and timestamp of a commit from its object
+func (w *revWalk) details(commit *CommitLog) error {
+	if w.detailed == nil {
+		w.detailed = make(map[string]bool)
+	}
+	if w.detailed[commit.ID] {
+		return nil
+	}
+	object, err := w.repo.readCommit(commit.ID)
+	if err != nil {
+		return fmt.Errorf("failed to read commit %s: %w", commit.ID, err)
+	}
+	commit.Author = object.Author
+	commit.Message = object.Message
+	commit.Timestamp = object.Timestamp
+	w.detailed[commit.ID] = true
+	return nil
+}
+
+// simplify hides a commit that doesn't change the limiting paths, and
+// follows only a parent of a merge that it takes the paths from unchanged
+func (w *revWalk) simplify(commit *CommitLog, treeID string) error {
+	files, err := w.treeFiles(commit.ID, treeID)
+	if err != nil {
+		return err
+	}
+
+	if len(commit.Parents) == 0 {
+		w.hidden[commit.ID] = len(w.selectFiles(commit.ID, files)) == 0
+		return nil
+	}
+
+	sameParent := ""
+	for _, parent := range commit.Parents {
+		parentFiles, err := w.commitFiles(parent)
+		if err != nil {
+			return err
+		}
+
+		// A followed path missing from the parent may have been renamed
+		if w.follow {
+			if _, ok := w.pathAt[parent]; !ok {
+				path := w.pathAt[commit.ID]
+				w.pathAt[parent] = path
+				if _, inParent := parentFiles[path]; !inParent {
+					if _, inCommit := files[path]; inCommit {
+						if source := followRenameSource(w.repo, parentFiles, files, path); source != "" {
+							w.pathAt[parent] = source
+						}
+					}
+				}
+			}
+		}
+
+		if sameParent == "" && w.treesame(commit.ID, files, parent, parentFiles) {
+			sameParent = parent
+		}
+	}
+
+	if sameParent != "" {
+		w.hidden[commit.ID] = true
+		commit.Parents = []string{sameParent}
+	}
+	return nil
+}
+
+// treesame reports whether two commits have the same content for the limiting paths
+func (w *revWalk) treesame(commitID string, files map[string]string, parentID string, parentFiles map[string]string) bool {
+	if w.follow && w.pathAt[commitID] != w.pathAt[parentID] {
+		return false
+	}
+	ours, theirs := w.selectFiles(commitID, files), w.selectFiles(parentID, parentFiles)
+	if len(ours) != len(theirs) {
+		return false
+	}
+	for path, objID := range ours {
+		if theirs[path] != objID {
+			return false
+		}
+	}
+	return true
+}
+
+// selectFiles returns the files of a commit selected by the limiting paths
+func (w *revWalk) selectFiles(commitID string, files map[string]string) map[string]string {
+	specs := w.paths
+	if w.follow {
+		specs = []string{w.pathAt[commitID]}
+	}
+	selected := make(map[string]string)
+	for path, objID := range files {
+		if matchPathspec(path, specs) {
+			selected[path] = objID
+		}
+	}
+	return selected
+}
+
+// commitFiles returns the files of a commit
+func (w *revWalk) commitFiles(commitID string) (map[string]string, error) {
+	if files, ok := w.files[commitID]; ok {
+		return files, nil
+	}
+	treeID, ok := w.trees[commitID]
+	if !ok {
+		node, err := w.repo.commitNode(commitID)
+		if err != nil {
+			return nil, err
+		}
+		treeID = node.Tree
+	}
+	return w.treeFiles(commitID, treeID)
+}
+
+// treeFiles returns the files of a commit's tree
+func (w *revWalk) treeFiles(commitID, treeID string) (map[string]string, error) {
+	if files, ok := w.files[commitID]; ok {
+		return files, nil
+	}
+	tree, err := w.repo.readTree(treeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tree %s: %w", treeID, err)
+	}
+	files := treeBlobs(tree)
+	w.files[commitID] = files
+	return files, nil
+}
+
+// followRenameSource finds the file a path was renamed from: a file of the
+// parent that is gone from the commit, with the same content or, failing
+// that, the most lines in common (at least half)
+func followRenameSource(r *Repository, parentFiles, files map[string]string, path string) string {
+	objID := files[path]
+	candidates := []string{}
+	for candidate, candidateID := range parentFiles {
+		if _, kept := files[candidate]; kept {
+			continue
+		}
+		if candidateID == objID {
+			return candidate
+		}
+		candidates = append(candidates, candidate)
+	}
+	sort.Strings(candidates)
+
+	content, err := r.readObject(objID)
+	if err != nil {
+		return ""
+	}
+	best, bestScore := "", 0.5
+	for _, candidate := range candidates {
+		candidateContent, err := r.readObject(parentFiles[candidate])
+		if err != nil {
+			continue
+		}
+		if score := lineSimilarity(content, candidateContent); score >= bestScore && best == "" || score > bestScore {
+			best, bestScore = candidate, score
+		}
+	}
+	return best
+}
+
+// lineSimilarity returns the share of lines two contents have in common,
+// relative to the longer of them
+func lineSimilarity(a, b []byte) float64 {
+	linesA := strings.Split(string(a), "\n")
+	linesB := strings.Split(string(b), "\n")
+	counts := make(map[string]int, len(linesA))
+	for _, line := range linesA {
+		counts[line]++
+	}
+	common := 0
+	for _, line := range linesB {
+		if counts[line] > 0 {
+			counts[line]--
+			common++
+		}
+	}
+	longest := len(linesA)
+	if len(linesB) > longest {
+		longest = len(linesB)
+	}
+	return float64(common) / float64(longest)
+}
+
+// containsString reports whether a list contains a string
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// sortByDate orders the selected commits newest first, starting from the tips
+// and following parents
+func (w *revWalk) sortByDate(tips []string, selected map[string]bool) []*CommitLog {
+	result := []*CommitLog{}
+	queue := &commitQueue{}
+	queued := make(map[string]bool)
+	push := func(commitID string) {
+		if selected[commitID] && !queued[commitID] {
+			queued[commitID] = true
+			heap.Push(queue, w.commits[commitID])
+		}
+	}
+
+	for _, tip := range tips {
+		push(tip)
+	}
+	for queue.Len() > 0 {
+		commit := heap.Pop(queue).(*CommitLog)
+		result = append(result, commit)
+		for _, parent := range commit.Parents {
+			push(parent)
+		}
+	}
+	return result
+}
+
+// sortTopological orders the selected commits so that no commit comes
+// before its children. Among commits whose children have all been shown,
+// date order takes the newest first; topo order continues with the last
+// parent of the commit just shown, so a merged line of history is shown
+// in full before the line it was merged into.
+func (w *revWalk) sortTopological(tips []string, selected map[string]bool, topo bool) []*CommitLog {
+	children := make(map[string]int, len(selected))
+	for commitID := range selected {
+		for _, parent := range w.commits[commitID].Parents {
+			if selected[parent] {
+				children[parent]++
+			}
+		}
+	}
+
+	result := make([]*CommitLog, 0, len(selected))
+	queue := &commitQueue{}
+	stack := []*CommitLog{}
+	ready := func(commit *CommitLog) {
+		if topo {
+			stack = append(stack, commit)
+		} else {
+			heap.Push(queue, commit)
+		}
+	}
+
+	// Start with the tips that no other selected commit descends to, newest on top
+	starts := &commitQueue{}
+	for _, tip := range tips {
+		if selected[tip] && children[tip] == 0 {
+			heap.Push(starts, w.commits[tip])
+			children[tip] = -1 // Don't start from the same tip twice
+		}
+	}
+	ordered := make([]*CommitLog, 0, starts.Len())
+	for starts.Len() > 0 {
+		ordered = append(ordered, heap.Pop(starts).(*CommitLog))
+	}
+	for i := len(ordered) - 1; i >= 0; i-- {
+		ready(ordered[i])
+	}
+
+	for len(stack) > 0 || queue.Len() > 0 {
+		var commit *CommitLog
+		if topo {
+			commit = stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+		} else {
+			commit = heap.Pop(queue).(*CommitLog)
+		}
+		result = append(result, commit)
+
+		for _, parent := range commit.Parents {
+			if !selected[parent] {
+				continue
+			}
+			children[parent]--
+			if children[parent] == 0 {
+				ready(w.commits[parent])
+			}
+		}
+	}
+
+	return result
+}
+
+// commitQueue is a priority queue of commits, newest first
+type commitQueue []*CommitLog
+
+func (q commitQueue) Len() int { return len(q) }
+
+func (q commitQueue) Less(i, j int) bool {
+	if !q[i].Timestamp.Equal(q[j].Timestamp) {
+		return q[i].Timestamp.After(q[j].Timestamp)
+	}
+	return q[i].ID < q[j].ID
+}
+
+func (q commitQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *commitQueue) Push(x any) { *q = append(*q, x.(*CommitLog)) }
+
+func (q *commitQueue) Pop() any {
+	old := *q
+	commit := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return commit
+}
@@ -0,0 +1,312 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mergeHistory creates the history
+//
+//	A - B - D - M (main)
+//	     \     /
+//	      C - E (feature)
+//
+// with commits made in the order A B C D E M, and returns their IDs by name
+func mergeHistory(t *testing.T, repo *Repository) map[string]string {
+	t.Helper()
+	ids := make(map[string]string)
+	ids["A"] = commitTestFiles(t, repo, "A", map[string]string{"a.txt": "a\n"})
+	ids["B"] = commitTestFiles(t, repo, "B", map[string]string{"a.txt": "b\n"})
+	if err := repo.CreateBranch("feature"); err != nil {
+		t.Fatalf("Failed to create branch: %v", err)
+	}
+	if err := repo.CheckoutBranch("feature"); err != nil {
+		t.Fatalf("Failed to checkout: %v", err)
+	}
+	ids["C"] = commitTestFiles(t, repo, "C", map[string]string{"c.txt": "c\n"})
+	if err := repo.CheckoutBranch("main"); err != nil {
+		t.Fatalf("Failed to checkout: %v", err)
+	}
+	ids["D"] = commitTestFiles(t, repo, "D", map[string]string{"d.txt": "d\n"})
+	if err := repo.CheckoutBranch("feature"); err != nil {
+		t.Fatalf("Failed to checkout: %v", err)
+	}
+	ids["E"] = commitTestFiles(t, repo, "E", map[string]string{"c.txt": "e\n"})
+	if err := repo.CheckoutBranch("main"); err != nil {
+		t.Fatalf("Failed to checkout: %v", err)
+	}
+	result, err := repo.Merge("feature", &MergeOptions{Strategy: Manual, Message: "M"})
+	if err != nil || !result.Success {
+		t.Fatalf("Failed to merge: %v", err)
+	}
+	ids["M"] = result.MergedCommit
+	return ids
+}
+
+func TestRevWalk(t *testing.T) {
+	repo := newTestRepository(t)
+	ids := mergeHistory(t, repo)
+	names := make(map[string]string)
+	for name, id := range ids {
+		names[id] = name
+	}
+
+	tests := []struct {
+		revisions []string
+		options   LogOptions
+		want      string
+	}{
+		{nil, LogOptions{}, "M E D C B A"},
+		{nil, LogOptions{RevWalkOptions: RevWalkOptions{Order: OrderDate}}, "M E D C B A"},
+		{nil, LogOptions{RevWalkOptions: RevWalkOptions{Order: OrderTopo}}, "M E C D B A"},
+		{nil, LogOptions{RevWalkOptions: RevWalkOptions{FirstParent: true}}, "M D B A"},
+		{nil, LogOptions{RevWalkOptions: RevWalkOptions{Reverse: true}}, "A B C D E M"},
+		{[]string{"feature..main"}, LogOptions{}, "M D"},
+		{[]string{"main", "^feature"}, LogOptions{}, "M D"},
+		{[]string{"main..feature"}, LogOptions{}, ""},
+		{[]string{"feature"}, LogOptions{}, "E C B A"},
+	}
+
+	for _, test := range tests {
+		log, err := repo.LogRevisions(test.revisions, &test.options)
+		if err != nil {
+			t.Fatalf("Failed to walk %v: %v", test.revisions, err)
+		}
+		got := []string{}
+		for _, commit := range log {
+			got = append(got, names[commit.ID])
+		}
+		if strings.Join(got, " ") != test.want {
+			t.Errorf("Walk %v with %+v: expected %q, got %q", test.revisions, test.options, test.want, strings.Join(got, " "))
+		}
+	}
+}
+
+func TestRevWalkMaxCount(t *testing.T) {
+	repo := newTestRepository(t)
+	ids := mergeHistory(t, repo)
+
+	// With the graph in the commit graph, only the commits shown are read,
+	// so the walk doesn't need the object of an old commit
+	if _, err := repo.WriteCommitGraph(); err != nil {
+		t.Fatalf("Failed to write commit graph: %v", err)
+	}
+	if err := os.Remove(filepath.Join(repo.Path, DefaultKitDir, DefaultKitObjectsDir, ids["A"][:2], ids["A"][2:])); err != nil {
+		t.Fatalf("Failed to remove commit: %v", err)
+	}
+	log, err := repo.RevWalk([]string{ids["M"]}, nil, &RevWalkOptions{MaxCount: 2})
+	if err != nil {
+		t.Fatalf("Failed to walk: %v", err)
+	}
+	if len(log) != 2 || log[0].ID != ids["M"] || log[0].Message != "M" || log[1].ID != ids["E"] {
+		t.Errorf("Expected M and E, got %+v", log)
+	}
+
+	// The filter only sees the commits shown and the ancestors their
+	// parents are rewritten to
+	filtered := []string{}
+	filter := func(commit *CommitLog) bool {
+		filtered = append(filtered, commit.Message)
+		return commit.Message != "E"
+	}
+	log, err = repo.RevWalk([]string{ids["M"]}, nil, &RevWalkOptions{MaxCount: 2, Filter: filter})
+	if err != nil {
+		t.Fatalf("Failed to walk: %v", err)
+	}
+	if len(log) != 2 || log[1].ID != ids["D"] || strings.Join(log[0].Parents, " ") != ids["D"]+" "+ids["C"] {
+		t.Errorf("Expected M with parents D and C, then D, got %+v", log)
+	}
+	if containsString(filtered, "A") {
+		t.Errorf("Expected A not to be filtered, got %v", filtered)
+	}
+}
+
+func TestFormatLogGraph(t *testing.T) {
+	repo := newTestRepository(t)
+	mergeHistory(t, repo)
+
+	log, err := repo.LogRevisions(nil, &LogOptions{RevWalkOptions: RevWalkOptions{Order: OrderTopo}})
+	if err != nil {
+		t.Fatalf("Failed to walk: %v", err)
+	}
+
+	expected := []string{
+		"* M",
+		"|\\",
+		"| * E",
+		"| * C",
+		"* | D",
+		"|/",
+		"* B",
+		"* A",
+	}
+	// Keep the node rows, labelled with the commit message, and the rows between commits
+	got := []string{}
+	messages := make(map[string]string)
+	for _, commit := range log {
+		messages[commit.ID] = commit.Message
+	}
+	for _, line := range strings.Split(FormatLogGraph(log, ""), "\n") {
+		if i := strings.Index(line, "commit "); i >= 0 {
+			got = append(got, strings.TrimRight(line[:i], " ")+" "+messages[strings.TrimSpace(line[i+len("commit "):])])
+		} else if trimmed := strings.Trim(line, " |"); trimmed == "\\" || trimmed == "/" {
+			got = append(got, line)
+		}
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected graph:\n%s\ngot:\n%s\nfrom:\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"), FormatLogGraph(log, ""))
+	}
+}
+
+func TestRevWalkPaths(t *testing.T) {
+	repo := newTestRepository(t)
+	ids := mergeHistory(t, repo)
+	names := make(map[string]string)
+	for name, id := range ids {
+		names[id] = name
+	}
+
+	tests := []struct {
+		options LogOptions
+		want    string
+	}{
+		// M takes c.txt unchanged from E, so D's side is dropped
+		{LogOptions{RevWalkOptions: RevWalkOptions{Paths: []string{"c.txt"}}}, "E C"},
+		// M takes a.txt unchanged from D, so the feature branch is dropped
+		{LogOptions{RevWalkOptions: RevWalkOptions{Paths: []string{"a.txt"}}}, "B A"},
+		// M differs from both parents in one of the paths
+		{LogOptions{RevWalkOptions: RevWalkOptions{Paths: []string{"d.txt", "c.txt"}}}, "M E D C"},
+		{LogOptions{Grep: "^[CE]$"}, "E C"},
+		{LogOptions{Author: "nobody"}, ""},
+		{LogOptions{RevWalkOptions: RevWalkOptions{MaxCount: 2}}, "M E"},
+		{LogOptions{Since: time.Now().Add(time.Hour)}, ""},
+		{LogOptions{Until: time.Now().Add(time.Hour), RevWalkOptions: RevWalkOptions{MaxCount: 1, Reverse: true}}, "M"},
+	}
+
+	for _, test := range tests {
+		log, err := repo.LogRevisions(nil, &test.options)
+		if err != nil {
+			t.Fatalf("Failed to walk with %+v: %v", test.options, err)
+		}
+		got := []string{}
+		for _, commit := range log {
+			got = append(got, names[commit.ID])
+		}
+		if strings.Join(got, " ") != test.want {
+			t.Errorf("Walk with %+v: expected %q, got %q", test.options, test.want, strings.Join(got, " "))
+		}
+	}
+
+	// Parents are rewritten to the nearest listed ancestors
+	log, err := repo.LogRevisions(nil, &LogOptions{RevWalkOptions: RevWalkOptions{Paths: []string{"d.txt", "c.txt"}}})
+	if err != nil {
+		t.Fatalf("Failed to walk: %v", err)
+	}
+	parents := make(map[string]string)
+	for _, commit := range log {
+		list := []string{}
+		for _, parent := range commit.Parents {
+			list = append(list, names[parent])
+		}
+		parents[names[commit.ID]] = strings.Join(list, " ")
+	}
+	if parents["M"] != "D E" || parents["E"] != "C" || parents["D"] != "" || parents["C"] != "" {
+		t.Errorf("Expected parents M: D E, E: C and none for D and C, got %v", parents)
+	}
+}
+
+func TestRevWalkFollow(t *testing.T) {
+	repo := newTestRepository(t)
+	commitTestFiles(t, repo, "Add f", map[string]string{"f.txt": "1\n2\n3\n4\n"})
+	commitTestFiles(t, repo, "Edit f", map[string]string{"f.txt": "1\n2\n3\n4\n5\n"})
+	commitTestFiles(t, repo, "Unrelated", map[string]string{"other.txt": "x\n"})
+
+	if err := os.Remove(filepath.Join(repo.Path, "f.txt")); err != nil {
+		t.Fatalf("Failed to remove: %v", err)
+	}
+	writeTestFile(t, repo, "g.txt", "1\n2\n3\n4\n5\n6\n")
+	if _, err := repo.AddPaths([]string{"f.txt", "g.txt"}, &AddOptions{All: true}); err != nil {
+		t.Fatalf("Failed to add: %v", err)
+	}
+	if _, err := repo.Commit("Rename f to g"); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	commitTestFiles(t, repo, "Edit g", map[string]string{"g.txt": "0\n1\n2\n3\n4\n5\n6\n"})
+
+	messages := func(log []*CommitLog) string {
+		list := []string{}
+		for _, commit := range log {
+			list = append(list, commit.Message)
+		}
+		return strings.Join(list, ", ")
+	}
+
+	log, err := repo.LogRevisions(nil, &LogOptions{RevWalkOptions: RevWalkOptions{Paths: []string{"g.txt"}}})
+	if err != nil {
+		t.Fatalf("Failed to walk: %v", err)
+	}
+	if got := messages(log); got != "Edit g, Rename f to g" {
+		t.Errorf("Expected history of g.txt only, got %q", got)
+	}
+
+	log, err = repo.LogRevisions(nil, &LogOptions{RevWalkOptions: RevWalkOptions{Paths: []string{"g.txt"}, Follow: true}})
+	if err != nil {
+		t.Fatalf("Failed to walk: %v", err)
+	}
+	if got := messages(log); got != "Edit g, Rename f to g, Edit f, Add f" {
+		t.Errorf("Expected history to follow the rename, got %q", got)
+	}
+
+	if _, err := repo.LogRevisions(nil, &LogOptions{RevWalkOptions: RevWalkOptions{Paths: []string{"f.txt", "g.txt"}, Follow: true}}); err == nil {
+		t.Error("Expected --follow with two paths to fail")
+	}
+}
+
+func TestFormatLog(t *testing.T) {
+	repo := newTestRepository(t)
+	first := commitTestFiles(t, repo, "First", map[string]string{"a.txt": "1\n"})
+	second := commitTestFiles(t, repo, "Second\n\nWith a body", map[string]string{"a.txt": "2\n"})
+
+	log, err := repo.Log()
+	if err != nil {
+		t.Fatalf("Failed to get log: %v", err)
+	}
+
+	expected := shortID(second) + " Second\n" + shortID(first) + " First\n"
+	if got := FormatLog(log, "oneline"); got != expected {
+		t.Errorf("Expected oneline log %q, got %q", expected, got)
+	}
+
+	expected = "Kit User <kit@example.com> " + shortID(first) + "%\nWith a body\n" +
+		"Kit User <kit@example.com> %\n\n"
+	if got := FormatLog(log, "format:%an <%ae> %p%%%n%b"); got != expected {
+		t.Errorf("Expected templated log %q, got %q", expected, got)
+	}
+}
+
+func TestParseLogDate(t *testing.T) {
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	tests := map[string]time.Time{
+		"2024-01-31":           time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC),
+		"2024-01-31 08:30:00":  time.Date(2024, 1, 31, 8, 30, 0, 0, time.UTC),
+		"2024-01-31T08:30:00Z": time.Date(2024, 1, 31, 8, 30, 0, 0, time.UTC),
+		"yesterday":            time.Date(2024, 3, 14, 12, 0, 0, 0, time.UTC),
+		"2 weeks ago":          time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		"3.hours.ago":          time.Date(2024, 3, 15, 9, 0, 0, 0, time.UTC),
+		"1 month ago":          time.Date(2024, 2, 15, 12, 0, 0, 0, time.UTC),
+	}
+	for input, want := range tests {
+		got, err := ParseLogDate(input, now)
+		if err != nil {
+			t.Errorf("Failed to parse %q: %v", input, err)
+		} else if !got.Equal(want) {
+			t.Errorf("Parse %q: expected %v, got %v", input, want, got)
+		}
+	}
+	if _, err := ParseLogDate("next tuesday", now); err == nil {
+		t.Error("Expected an invalid date to fail")
+	}
+}
@@ -0,0 +1,511 @@
+package repo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SequenceAction is what a cherry-pick or revert does with a commit
+type SequenceAction string
+
+const (
+	ActionPick   SequenceAction = "pick"   // Apply the commit's changes
+	ActionRevert SequenceAction = "revert" // Undo the commit's changes
+)
+
+// SequenceOptions represents options for cherry-pick and revert
+type SequenceOptions struct {
+	Mainline int // Parent (1 or 2) of merge commits whose changes are taken relative to it
+}
+
+// SequenceResult represents the outcome of a cherry-pick or revert
+type SequenceResult struct {
+	Commits   []string        // Commits created, in order
+	Empty     []string        // Commits dropped because their changes were already there
+	Stopped   string          // Commit whose changes conflicted, if the sequence stopped
+	Conflicts []MergeConflict // Conflicts to resolve before continuing
+}
+
+// Files holding an unfinished cherry-pick or revert in the .kit directory
+const (
+	sequencerDir       = "sequencer"        // Todo list, original HEAD and options
+	cherryPickHeadFile = "CHERRY_PICK_HEAD" // Commit being picked when stopped on conflicts
+	revertHeadFile     = "REVERT_HEAD"      // Commit being reverted when stopped on conflicts
+)
+
+// sequenceStep is a line of the todo list
+type sequenceStep struct {
+	action   SequenceAction
+	commitID string
+}
+
+// CherryPick applies the changes of existing commits on top of HEAD, one
+// new commit each, keeping their authors and messages. Revisions can be
+// commits or ranges like "A..B", picked oldest first. It stops when a
+// commit's changes conflict; resolve them, stage the files and call
+// ContinueSequence, or use SkipSequence or AbortSequence.
+func (r *Repository) CherryPick(revisions []string, options *SequenceOptions) (*SequenceResult, error) {
+	return r.startSequence(ActionPick, revisions, options)
+}
+
+// Revert records new commits undoing the changes of existing ones, like
+// CherryPick with each commit as the base of the merge and its parent as
+// the side whose changes are taken
+func (r *Repository) Revert(revisions []string, options *SequenceOptions) (*SequenceResult, error) {
+	return r.startSequence(ActionRevert, revisions, options)
+}
+
+// startSequence records the todo list and works through it
+func (r *Repository) startSequence(action SequenceAction, revisions []string, options *SequenceOptions) (*SequenceResult, error) {
+	if options == nil {
+		options = &SequenceOptions{}
+	}
+	if r.SequenceInProgress() {
+		return nil, fmt.Errorf("a cherry-pick or revert is already in progress, use --continue, --skip or --abort")
+	}
+	if len(r.State.Conflicts) > 0 {
+		return nil, fmt.Errorf("cannot %s with unresolved conflicts in %s", action, strings.Join(r.conflictPaths(), ", "))
+	}
+	if r.hasStagedChanges() {
+		return nil, fmt.Errorf("cannot %s with uncommitted changes, please commit or stash them first", action)
+	}
+
+	commits, err := r.sequenceCommits(revisions)
+	if err != nil {
+		return nil, err
+	}
+	if len(commits) == 0 {
+		return nil, fmt.Errorf("no commits to %s", action)
+	}
+	for _, commitID := range commits {
+		commit, err := r.readCommit(commitID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read commit %s: %w", shortID(commitID), err)
+		}
+		if _, err := sequenceParent(commit, commitID, options.Mainline); err != nil {
+			return nil, err
+		}
+	}
+	head, err := r.resolveReference("HEAD")
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve HEAD: %w", err)
+	}
+
+	todo := make([]sequenceStep, len(commits))
+	for i, commitID := range commits {
+		todo[i] = sequenceStep{action: action, commitID: commitID}
+	}
+	dir := filepath.Join(r.Path, DefaultKitDir, sequencerDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create sequencer directory: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "head"), []byte(head+"\n"), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write sequencer state: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "opts"), []byte(fmt.Sprintf("mainline=%d\n", options.Mainline)), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write sequencer state: %w", err)
+	}
+	if err := r.writeSequenceTodo(todo); err != nil {
+		return nil, err
+	}
+
+	return r.runSequence(&SequenceResult{}, options)
+}
+
+// sequenceCommits resolves revisions to commits in the order to apply them
+func (r *Repository) sequenceCommits(revisions []string) ([]string, error) {
+	commits := []string{}
+	for _, rev := range revisions {
+		if !strings.Contains(rev, "..") {
+			commitID, err := r.ResolveRevision(rev)
+			if err != nil {
+				return nil, err
+			}
+			commits = append(commits, commitID)
+			continue
+		}
+		include, exclude, err := r.ParseRevisionRange([]string{rev})
+		if err != nil {
+			return nil, err
+		}
+		log, err := r.RevWalk(include, exclude, &RevWalkOptions{Order: OrderTopo, Reverse: true})
+		if err != nil {
+			return nil, err
+		}
+		for _, commit := range log {
+			commits = append(commits, commit.ID)
+		}
+	}
+	return commits, nil
+}
+
+// SequenceInProgress reports whether a cherry-pick or revert is unfinished
+func (r *Repository) SequenceInProgress() bool {
+	_, err := os.Stat(filepath.Join(r.Path, DefaultKitDir, sequencerDir))
+	return err == nil
+}
+
+// ContinueSequence commits the resolved changes of the commit a cherry-pick
+// or revert stopped at and goes on with the remaining commits
+func (r *Repository) ContinueSequence() (*SequenceResult, error) {
+	options, err := r.readSequenceOptions()
+	if err != nil {
+		return nil, err
+	}
+	if len(r.State.Conflicts) > 0 {
+		return nil, fmt.Errorf("unresolved conflicts in %s, stage the resolved files first", strings.Join(r.conflictPaths(), ", "))
+	}
+
+	result := &SequenceResult{}
+	step, err := r.stoppedStep()
+	if err != nil {
+		return nil, err
+	}
+	if step != nil {
+		if r.hasStagedChanges() {
+			commitID, err := r.commitStep(*step, options.Mainline)
+			if err != nil {
+				return nil, err
+			}
+			result.Commits = append(result.Commits, commitID)
+		} else {
+			result.Empty = append(result.Empty, step.commitID)
+		}
+		if err := r.clearStoppedStep(); err != nil {
+			return nil, err
+		}
+	}
+	return r.runSequence(result, options)
+}
+
+// SkipSequence drops the changes of the commit a cherry-pick or revert
+// stopped at and goes on with the remaining commits
+func (r *Repository) SkipSequence() (*SequenceResult, error) {
+	options, err := r.readSequenceOptions()
+	if err != nil {
+		return nil, err
+	}
+	step, err := r.stoppedStep()
+	if err != nil {
+		return nil, err
+	}
+	if step == nil {
+		return nil, fmt.Errorf("no commit to skip")
+	}
+	if _, err := r.Reset("HEAD", ResetMerge); err != nil {
+		return nil, err
+	}
+	if err := r.clearStoppedStep(); err != nil {
+		return nil, err
+	}
+	return r.runSequence(&SequenceResult{}, options)
+}
+
+// AbortSequence gives up on a cherry-pick or revert, resetting the branch,
+// index and working tree to where they were before it started. Unstaged
+// edits to files the sequence didn't change are kept.
+func (r *Repository) AbortSequence() error {
+	if !r.SequenceInProgress() {
+		return fmt.Errorf("no cherry-pick or revert in progress")
+	}
+	data, err := os.ReadFile(filepath.Join(r.Path, DefaultKitDir, sequencerDir, "head"))
+	if err != nil {
+		return fmt.Errorf("failed to read sequencer state: %w", err)
+	}
+	if _, err := r.Reset(strings.TrimSpace(string(data)), ResetMerge); err != nil {
+		return err
+	}
+	if err := r.clearStoppedStep(); err != nil {
+		return err
+	}
+	return r.clearSequence()
+}
+
+// runSequence applies the commits left in the todo list until one conflicts
+func (r *Repository) runSequence(result *SequenceResult, options *SequenceOptions) (*SequenceResult, error) {
+	for {
+		todo, err := r.readSequenceTodo()
+		if err != nil {
+			return nil, err
+		}
+		if len(todo) == 0 {
+			return result, r.clearSequence()
+		}
+		step := todo[0]
+		if err := r.writeSequenceTodo(todo[1:]); err != nil {
+			return nil, err
+		}
+
+		conflicts, err := r.applyStep(step, options.Mainline)
+		if err != nil {
+			return nil, err
+		}
+		if len(conflicts) > 0 {
+			name := cherryPickHeadFile
+			if step.action == ActionRevert {
+				name = revertHeadFile
+			}
+			if err := os.WriteFile(filepath.Join(r.Path, DefaultKitDir, name), []byte(step.commitID+"\n"), 0644); err != nil {
+				return nil, fmt.Errorf("failed to write %s: %w", name, err)
+			}
+			result.Stopped = step.commitID
+			result.Conflicts = conflicts
+			return result, nil
+		}
+
+		if !r.hasStagedChanges() {
+			result.Empty = append(result.Empty, step.commitID)
+			continue
+		}
+		commitID, err := r.commitStep(step, options.Mainline)
+		if err != nil {
+			return nil, err
+		}
+		result.Commits = append(result.Commits, commitID)
+	}
+}
+
+// applyStep merges the changes of a step into the index and working tree.
+// Conflicting files get conflict markers and are left unmerged.
+func (r *Repository) applyStep(step sequenceStep, mainline int) ([]MergeConflict, error) {
+	commit, err := r.readCommit(step.commitID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commit %s: %w", shortID(step.commitID), err)
+	}
+	parent, err := sequenceParent(commit, step.commitID, mainline)
+	if err != nil {
+		return nil, err
+	}
+
+	baseTree := &TreeObject{Entries: make(map[string]TreeEntry)}
+	if parent != "" {
+		if baseTree, err = r.getTreeFromCommit(parent); err != nil {
+			return nil, err
+		}
+	}
+	theirTree, err := r.readTree(commit.Tree)
+	if err != nil {
+		return nil, err
+	}
+	if step.action == ActionRevert {
+		baseTree, theirTree = theirTree, baseTree
+	}
+
+	ours := r.State.Tracked
+	mergedTree, conflicts, err := r.MergeTrees(baseTree, buildTree(ours), theirTree, &MergeOptions{
+		Strategy:    Manual,
+		UseSemantic: false,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to %s %s: %w", step.action, shortID(step.commitID), err)
+	}
+
+	// Unmerged files keep our version in the index
+	merged := treeBlobs(mergedTree)
+	conflicted := make(map[string]bool, len(conflicts))
+	for _, conflict := range conflicts {
+		conflicted[conflict.Path] = true
+		if objID, ok := ours[conflict.Path]; ok {
+			merged[conflict.Path] = objID
+		}
+	}
+
+	// Refuse to overwrite local modifications
+	changed := make(map[string]bool)
+	for path, objID := range merged {
+		if ours[path] != objID {
+			changed[path] = true
+		}
+	}
+	for path := range ours {
+		if _, ok := merged[path]; !ok {
+			changed[path] = true
+		}
+	}
+	for path := range conflicted {
+		changed[path] = true
+	}
+	dirty := []string{}
+	for path := range changed {
+		workID, err := r.workingFileID(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file %s: %w", path, err)
+		}
+		if workID != ours[path] {
+			dirty = append(dirty, path)
+		}
+	}
+	if len(dirty) > 0 {
+		sort.Strings(dirty)
+		return nil, fmt.Errorf("your local changes to the following files would be overwritten by %s:\n\t%s",
+			step.action, strings.Join(dirty, "\n\t"))
+	}
+
+	// Only the paths the step changes are written; edits to others stay
+	remove := make(map[string]string)
+	write := make(map[string]string)
+	for path := range changed {
+		if objID, ok := merged[path]; ok {
+			write[path] = objID
+		} else {
+			remove[path] = ours[path]
+		}
+	}
+	if err := r.materializeTree(remove, write); err != nil {
+		return nil, fmt.Errorf("failed to update working tree: %w", err)
+	}
+	if err := r.WriteConflictMarkers(conflicts); err != nil {
+		return nil, fmt.Errorf("failed to write conflict markers: %w", err)
+	}
+	r.setIndexEntries(merged)
+	for _, conflict := range conflicts {
+		r.State.Conflicts[conflict.Path] = ConflictEntry{
+			Base:   baseTree.Entries[conflict.Path].ObjID,
+			Ours:   ours[conflict.Path],
+			Theirs: theirTree.Entries[conflict.Path].ObjID,
+		}
+	}
+	if err := r.SaveIndex(); err != nil {
+		return nil, fmt.Errorf("failed to save index: %w", err)
+	}
+	return conflicts, nil
+}
+
+// sequenceParent returns the parent a commit's changes are relative to:
+// its only parent, or the mainline parent of a merge
+func sequenceParent(commit *CommitObject, commitID string, mainline int) (string, error) {
+	if commit.Parent2 == "" {
+		if mainline != 0 {
+			return "", fmt.Errorf("mainline was specified but commit %s is not a merge", shortID(commitID))
+		}
+		return commit.Parent, nil
+	}
+	switch mainline {
+	case 1:
+		return commit.Parent, nil
+	case 2:
+		return commit.Parent2, nil
+	case 0:
+		return "", fmt.Errorf("commit %s is a merge but no mainline parent was given", shortID(commitID))
+	default:
+		return "", fmt.Errorf("commit %s does not have parent %d", shortID(commitID), mainline)
+	}
+}
+
+// commitStep commits the index for a step: a picked commit keeps its
+// author and message, a revert says what it reverts
+func (r *Repository) commitStep(step sequenceStep, mainline int) (string, error) {
+	commit, err := r.readCommit(step.commitID)
+	if err != nil {
+		return "", fmt.Errorf("failed to read commit %s: %w", shortID(step.commitID), err)
+	}
+	if step.action == ActionPick {
+		return r.commitIndex(commit.Message, commit.Author)
+	}
+
+	subject, _, _ := strings.Cut(commit.Message, "\n")
+	message := fmt.Sprintf("Revert \"%s\"\n\nThis reverts commit %s", subject, step.commitID)
+	if commit.Parent2 != "" {
+		parent, err := sequenceParent(commit, step.commitID, mainline)
+		if err != nil {
+			return "", err
+		}
+		message += fmt.Sprintf(", reversing\nchanges made to %s", parent)
+	}
+	return r.commitIndex(message+".", defaultSignature)
+}
+
+// readSequenceOptions reads the options of the sequence in progress
+func (r *Repository) readSequenceOptions() (*SequenceOptions, error) {
+	if !r.SequenceInProgress() {
+		return nil, fmt.Errorf("no cherry-pick or revert in progress")
+	}
+	data, err := os.ReadFile(filepath.Join(r.Path, DefaultKitDir, sequencerDir, "opts"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sequencer state: %w", err)
+	}
+	options := &SequenceOptions{}
+	for _, line := range strings.Split(string(data), "\n") {
+		if value, ok := strings.CutPrefix(line, "mainline="); ok {
+			if options.Mainline, err = strconv.Atoi(value); err != nil {
+				return nil, fmt.Errorf("corrupt sequencer options: %w", err)
+			}
+		}
+	}
+	return options, nil
+}
+
+// readSequenceTodo reads the steps left to do
+func (r *Repository) readSequenceTodo() ([]sequenceStep, error) {
+	data, err := os.ReadFile(filepath.Join(r.Path, DefaultKitDir, sequencerDir, "todo"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sequencer todo: %w", err)
+	}
+	todo := []sequenceStep{}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 || (fields[0] != string(ActionPick) && fields[0] != string(ActionRevert)) {
+			return nil, fmt.Errorf("corrupt sequencer todo line %q", line)
+		}
+		todo = append(todo, sequenceStep{action: SequenceAction(fields[0]), commitID: fields[1]})
+	}
+	return todo, nil
+}
+
+// writeSequenceTodo writes the steps left to do, one "<action> <commit>" per line
+func (r *Repository) writeSequenceTodo(todo []sequenceStep) error {
+	var sb strings.Builder
+	for _, step := range todo {
+		sb.WriteString(fmt.Sprintf("%s %s\n", step.action, step.commitID))
+	}
+	if err := os.WriteFile(filepath.Join(r.Path, DefaultKitDir, sequencerDir, "todo"), []byte(sb.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write sequencer todo: %w", err)
+	}
+	return nil
+}
+
+// stoppedStep returns the step the sequence stopped at on conflicts, if any
+func (r *Repository) stoppedStep() (*sequenceStep, error) {
+	for _, file := range []struct {
+		name   string
+		action SequenceAction
+	}{
+		{cherryPickHeadFile, ActionPick},
+		{revertHeadFile, ActionRevert},
+	} {
+		data, err := os.ReadFile(filepath.Join(r.Path, DefaultKitDir, file.name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", file.name, err)
+		}
+		return &sequenceStep{action: file.action, commitID: strings.TrimSpace(string(data))}, nil
+	}
+	return nil, nil
+}
+
+// clearStoppedStep removes the record of the step the sequence stopped at
+func (r *Repository) clearStoppedStep() error {
+	for _, name := range []string{cherryPickHeadFile, revertHeadFile} {
+		if err := os.Remove(filepath.Join(r.Path, DefaultKitDir, name)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// clearSequence removes the sequencer state once a sequence is over
+func (r *Repository) clearSequence() error {
+	if err := os.RemoveAll(filepath.Join(r.Path, DefaultKitDir, sequencerDir)); err != nil {
+		return fmt.Errorf("failed to remove sequencer state: %w", err)
+	}
+	return nil
+}
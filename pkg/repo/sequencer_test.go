@@ -0,0 +1,205 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCherryPick(t *testing.T) {
+	repo := newTestRepository(t)
+	commitTestFiles(t, repo, "A", map[string]string{"a.txt": "1\n2\n3\n"})
+	if err := repo.CreateBranch("feature"); err != nil {
+		t.Fatalf("Failed to create branch: %v", err)
+	}
+	if err := repo.CheckoutBranch("feature"); err != nil {
+		t.Fatalf("Failed to checkout: %v", err)
+	}
+	b := commitTestFiles(t, repo, "B", map[string]string{"a.txt": "1\n2\nthree\n"})
+	writeTestFile(t, repo, "c.txt", "c\n")
+	if err := repo.Add("c.txt"); err != nil {
+		t.Fatalf("Failed to add: %v", err)
+	}
+	c, err := repo.commitIndex("C", "Jane Doe <jane@example.com>")
+	if err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	conflicting := commitTestFiles(t, repo, "F", map[string]string{"a.txt": "feature\n2\nthree\n"})
+	if err := repo.CheckoutBranch("main"); err != nil {
+		t.Fatalf("Failed to checkout: %v", err)
+	}
+	commitTestFiles(t, repo, "D", map[string]string{"a.txt": "one\n2\n3\n"})
+
+	// Picked commits keep their message and author
+	result, err := repo.CherryPick([]string{c, b}, nil)
+	if err != nil || len(result.Commits) != 2 || result.Stopped != "" {
+		t.Fatalf("Failed to cherry-pick: %+v (%v)", result, err)
+	}
+	picked, err := repo.readCommit(result.Commits[0])
+	if err != nil || picked.Message != "C" || picked.Author != "Jane Doe <jane@example.com>" {
+		t.Errorf("Expected C with its author, got %+v (%v)", picked, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(repo.Path, "a.txt")); string(data) != "one\n2\nthree\n" {
+		t.Errorf("Expected both changes to a.txt, got %q", data)
+	}
+	if repo.SequenceInProgress() {
+		t.Errorf("Expected the sequencer state to be removed")
+	}
+
+	// Picking changes that are already there gives no commit
+	result, err = repo.CherryPick([]string{b}, nil)
+	if err != nil || len(result.Commits) != 0 || len(result.Empty) != 1 {
+		t.Errorf("Expected an empty pick, got %+v (%v)", result, err)
+	}
+
+	// A conflict stops the pick until it is resolved
+	head, _ := repo.resolveReference("HEAD")
+	result, err = repo.CherryPick([]string{conflicting}, nil)
+	if err != nil || result.Stopped != conflicting || len(result.Conflicts) != 1 {
+		t.Fatalf("Expected the pick to stop on a conflict, got %+v (%v)", result, err)
+	}
+	if _, err := repo.CherryPick([]string{b}, nil); err == nil {
+		t.Errorf("Expected a second cherry-pick to be refused")
+	}
+	if _, err := repo.ContinueSequence(); err == nil {
+		t.Errorf("Expected continue to fail with unresolved conflicts")
+	}
+	if err := repo.AbortSequence(); err != nil {
+		t.Fatalf("Failed to abort: %v", err)
+	}
+	if now, _ := repo.resolveReference("HEAD"); now != head || repo.SequenceInProgress() || len(repo.State.Conflicts) != 0 {
+		t.Errorf("Expected abort to restore HEAD and clear the state")
+	}
+	if data, _ := os.ReadFile(filepath.Join(repo.Path, "a.txt")); string(data) != "one\n2\nthree\n" {
+		t.Errorf("Expected a.txt to be restored, got %q", data)
+	}
+
+	result, err = repo.CherryPick([]string{conflicting}, nil)
+	if err != nil || result.Stopped != conflicting {
+		t.Fatalf("Expected the pick to stop on a conflict, got %+v (%v)", result, err)
+	}
+	writeTestFile(t, repo, "a.txt", "both\n2\nthree\n")
+	if err := repo.Add("a.txt"); err != nil {
+		t.Fatalf("Failed to add: %v", err)
+	}
+	result, err = repo.ContinueSequence()
+	if err != nil || len(result.Commits) != 1 {
+		t.Fatalf("Failed to continue: %+v (%v)", result, err)
+	}
+	if commit, err := repo.readCommit(result.Commits[0]); err != nil || commit.Message != "F" || commit.Parent != head {
+		t.Errorf("Expected F on top of HEAD, got %+v (%v)", commit, err)
+	}
+
+	// Skipping drops the conflicting commit
+	head, _ = repo.resolveReference("HEAD")
+	writeTestFile(t, repo, "a.txt", "other\n2\nthree\n")
+	if err := repo.Add("a.txt"); err != nil {
+		t.Fatalf("Failed to add: %v", err)
+	}
+	if _, err := repo.Commit("G"); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	result, err = repo.CherryPick([]string{conflicting}, nil)
+	if err != nil || result.Stopped != conflicting {
+		t.Fatalf("Expected the pick to stop on a conflict, got %+v (%v)", result, err)
+	}
+	if _, err := repo.SkipSequence(); err != nil {
+		t.Fatalf("Failed to skip: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(repo.Path, "a.txt")); string(data) != "other\n2\nthree\n" || repo.SequenceInProgress() {
+		t.Errorf("Expected skip to restore a.txt and end the pick, got %q", data)
+	}
+}
+
+func TestCherryPickKeepsLocalEdits(t *testing.T) {
+	repo := newTestRepository(t)
+	commitTestFiles(t, repo, "A", map[string]string{"x.txt": "x\n", "a.txt": "a\n"})
+	if err := repo.CreateBranch("feature"); err != nil {
+		t.Fatalf("Failed to create branch: %v", err)
+	}
+	if err := repo.CheckoutBranch("feature"); err != nil {
+		t.Fatalf("Failed to checkout: %v", err)
+	}
+	b := commitTestFiles(t, repo, "B", map[string]string{"b.txt": "b\n"})
+	conflict := commitTestFiles(t, repo, "D", map[string]string{"a.txt": "feature\n", "d.txt": "d\n"})
+	if err := repo.CheckoutBranch("main"); err != nil {
+		t.Fatalf("Failed to checkout: %v", err)
+	}
+
+	// An unstaged edit to a file the pick doesn't touch survives it
+	writeTestFile(t, repo, "x.txt", "local\n")
+	result, err := repo.CherryPick([]string{b}, nil)
+	if err != nil || len(result.Commits) != 1 {
+		t.Fatalf("Failed to cherry-pick: %+v (%v)", result, err)
+	}
+	if content := readTestFile(t, repo, "x.txt"); content != "local\n" {
+		t.Errorf("Expected the edit to x.txt to be kept, got %q", content)
+	}
+	if content := readTestFile(t, repo, "b.txt"); content != "b\n" {
+		t.Errorf("Expected b.txt to be picked, got %q", content)
+	}
+
+	// Skipping or aborting a conflicting pick only undoes what the pick wrote
+	commitTestFiles(t, repo, "C", map[string]string{"a.txt": "main\n"})
+	head := mustResolve(t, repo, "HEAD")
+	for _, finish := range []string{"skip", "abort"} {
+		result, err = repo.CherryPick([]string{conflict}, nil)
+		if err != nil || result.Stopped != conflict {
+			t.Fatalf("Expected the pick to stop on a conflict, got %+v (%v)", result, err)
+		}
+		if finish == "skip" {
+			_, err = repo.SkipSequence()
+		} else {
+			err = repo.AbortSequence()
+		}
+		if err != nil {
+			t.Fatalf("Failed to %s: %v", finish, err)
+		}
+		if content := readTestFile(t, repo, "x.txt"); content != "local\n" {
+			t.Errorf("Expected %s to keep the edit to x.txt, got %q", finish, content)
+		}
+		if content := readTestFile(t, repo, "a.txt"); content != "main\n" || mustResolve(t, repo, "HEAD") != head {
+			t.Errorf("Expected %s to restore a.txt and HEAD, got %q", finish, content)
+		}
+		if _, err := os.Stat(filepath.Join(repo.Path, "d.txt")); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to remove the pick's d.txt", finish)
+		}
+	}
+}
+
+func TestRevert(t *testing.T) {
+	repo := newTestRepository(t)
+	ids := mergeHistory(t, repo)
+
+	result, err := repo.Revert([]string{ids["D"]}, nil)
+	if err != nil || len(result.Commits) != 1 {
+		t.Fatalf("Failed to revert: %+v (%v)", result, err)
+	}
+	commit, err := repo.readCommit(result.Commits[0])
+	if err != nil || !strings.HasPrefix(commit.Message, "Revert \"D\"\n\nThis reverts commit "+ids["D"]) {
+		t.Errorf("Unexpected revert message %q (%v)", commit.Message, err)
+	}
+	if _, err := os.Stat(filepath.Join(repo.Path, "d.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected d.txt to be removed")
+	}
+
+	// A merge needs the parent whose side is kept
+	if _, err := repo.Revert([]string{ids["M"]}, nil); err == nil {
+		t.Errorf("Expected reverting a merge without a mainline to fail")
+	}
+	if repo.SequenceInProgress() {
+		t.Errorf("Expected no sequencer state after a refused revert")
+	}
+	result, err = repo.Revert([]string{ids["M"]}, &SequenceOptions{Mainline: 1})
+	if err != nil || len(result.Commits) != 1 {
+		t.Fatalf("Failed to revert merge: %+v (%v)", result, err)
+	}
+	if _, err := os.Stat(filepath.Join(repo.Path, "c.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected the feature's c.txt to be removed")
+	}
+	commit, _ = repo.readCommit(result.Commits[0])
+	if !strings.Contains(commit.Message, "changes made to "+ids["D"]) {
+		t.Errorf("Expected the message to name the mainline parent, got %q", commit.Message)
+	}
+}
@@ -0,0 +1,374 @@
+package repo
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// CombinedDiffResult represents the changes a merge made to a file,
+// compared with each of its parents at once
+type CombinedDiffResult struct {
+	Path    string          // Path of the file
+	Parents []string        // Object IDs of the file in each parent ("" if missing)
+	Result  string          // Object ID of the file in the merge ("" if missing)
+	Chunks  []CombinedChunk // Chunks of changes
+	Binary  bool            // The file is binary and its changes are not shown
+}
+
+// CombinedChunk represents a chunk of a combined diff
+type CombinedChunk struct {
+	OldStarts  []int    // Starting line in each parent
+	OldLengths []int    // Number of lines in each parent
+	NewStart   int      // Starting line in the merge
+	NewLength  int      // Number of lines in the merge
+	Lines      []string // Lines with a column of prefixes (+, -, ' ') per parent
+}
+
+// Show describes an object: a commit with its header and changes, a tree
+// with the paths it lists, a blob with its contents. name is anything
+// ResolveObject accepts, such as "HEAD" or "HEAD:path/to/file".
+func (r *Repository) Show(name string, options *DiffOptions) (string, error) {
+	if options == nil {
+		options = &DefaultDiffOptions
+	}
+	objID, objType, err := r.ResolveObjectType(name)
+	if err != nil {
+		return "", err
+	}
+
+	switch objType {
+	case ObjectCommit:
+		return r.showCommit(objID, options)
+	case ObjectTree:
+		tree, err := r.readTree(objID)
+		if err != nil {
+			return "", err
+		}
+		var sb strings.Builder
+		sb.WriteString(fmt.Sprintf("tree %s\n\n", name))
+		for _, entry := range sortedTreeEntries(tree, nil) {
+			sb.WriteString(entry.Path + "\n")
+		}
+		return sb.String(), nil
+	default:
+		content, err := r.readObject(objID)
+		if err != nil {
+			return "", err
+		}
+		return string(content), nil
+	}
+}
+
+// showCommit describes a commit like the log does, followed by its changes:
+// a diff against its parent, or a combined diff for a merge
+func (r *Repository) showCommit(commitID string, options *DiffOptions) (string, error) {
+	commit, err := r.readCommit(commitID)
+	if err != nil {
+		return "", err
+	}
+	log := newCommitLog(commitID, commit)
+
+	var sb strings.Builder
+	for _, line := range formatCommit(log, "") {
+		sb.WriteString(line + "\n")
+	}
+
+	if len(log.Parents) > 1 {
+		results, err := r.CombinedDiff(commitID, options)
+		if err != nil {
+			return "", err
+		}
+		if len(results) > 0 {
+			sb.WriteString("\n" + FormatCombinedDiff(results))
+		}
+		return sb.String(), nil
+	}
+
+	tree, err := r.readTree(commit.Tree)
+	if err != nil {
+		return "", err
+	}
+	parentTree := &TreeObject{Entries: make(map[string]TreeEntry)}
+	if commit.Parent != "" {
+		if parentTree, err = r.getTreeFromCommit(commit.Parent); err != nil {
+			return "", err
+		}
+	}
+	results, err := r.diffTrees(parentTree, tree, options)
+	if err != nil {
+		return "", err
+	}
+	sort.Slice(results, func(i, j int) bool { return diffResultPath(results[i]) < diffResultPath(results[j]) })
+	if len(results) > 0 {
+		sb.WriteString("\n" + FormatDiff(results))
+	}
+	return sb.String(), nil
+}
+
+// diffResultPath returns the path a diff result is about
+func diffResultPath(result DiffResult) string {
+	if result.NewPath == "/dev/null" {
+		return result.OldPath
+	}
+	return result.NewPath
+}
+
+// CombinedDiff compares a merge commit with all of its parents at once.
+// Only files that differ from every parent are included: a file taken
+// unchanged from one side of the merge is not interesting.
+func (r *Repository) CombinedDiff(commitID string, options *DiffOptions) ([]CombinedDiffResult, error) {
+	if options == nil {
+		options = &DefaultDiffOptions
+	}
+	commit, err := r.readCommit(commitID)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := r.readTree(commit.Tree)
+	if err != nil {
+		return nil, err
+	}
+	files := treeBlobs(tree)
+
+	parentFiles := []map[string]string{}
+	paths := make(map[string]bool)
+	for path := range files {
+		paths[path] = true
+	}
+	for _, parent := range []string{commit.Parent, commit.Parent2} {
+		if parent == "" {
+			continue
+		}
+		parentTree, err := r.getTreeFromCommit(parent)
+		if err != nil {
+			return nil, err
+		}
+		blobs := treeBlobs(parentTree)
+		parentFiles = append(parentFiles, blobs)
+		for path := range blobs {
+			paths[path] = true
+		}
+	}
+
+	sorted := make([]string, 0, len(paths))
+	for path := range paths {
+		sorted = append(sorted, path)
+	}
+	sort.Strings(sorted)
+
+	results := []CombinedDiffResult{}
+	for _, path := range sorted {
+		result := CombinedDiffResult{Path: path, Result: files[path]}
+		interesting := true
+		for _, blobs := range parentFiles {
+			result.Parents = append(result.Parents, blobs[path])
+			if blobs[path] == files[path] {
+				interesting = false
+			}
+		}
+		if !interesting {
+			continue
+		}
+
+		attrs, err := r.Attributes(path)
+		if err != nil {
+			return nil, err
+		}
+		content, err := r.blobContent(result.Result)
+		if err != nil {
+			return nil, err
+		}
+		binary := attrs.binaryDiff() || isBinaryContent(content)
+		parentLines := make([][]string, len(result.Parents))
+		for i, objID := range result.Parents {
+			parentContent, err := r.blobContent(objID)
+			if err != nil {
+				return nil, err
+			}
+			binary = binary || isBinaryContent(parentContent)
+			parentLines[i] = splitDiffLines(string(parentContent))
+		}
+		if binary {
+			result.Binary = true
+		} else {
+			result.Chunks = combinedDiffContent(parentLines, splitDiffLines(string(content)), options)
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// blobContent reads a blob, with no content for a missing file ("")
+func (r *Repository) blobContent(objID string) ([]byte, error) {
+	if objID == "" {
+		return nil, nil
+	}
+	return r.readObject(objID)
+}
+
+// splitDiffLines splits content into lines without the final empty line
+func splitDiffLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
+
+// combinedLine is a line of a combined diff
+type combinedLine struct {
+	text     string
+	marks    []byte // Prefix for each parent
+	inParent []bool // Whether the line counts towards each parent's side
+	inResult bool   // Whether the line counts towards the merge's side
+}
+
+// combinedDiffContent lines the merge's lines up with each parent's and
+// groups the lines that differ from any parent into chunks with context
+func combinedDiffContent(parents [][]string, result []string, options *DiffOptions) []CombinedChunk {
+	// For each parent, which merge lines it has, and which of its lines
+	// were removed before each merge line
+	present := make([][]bool, len(parents))
+	removed := make([][][]string, len(parents))
+	for i, parent := range parents {
+		present[i] = make([]bool, len(result))
+		removed[i] = make([][]string, len(result)+1)
+		parentPos, resultPos := 0, 0
+		lcs := append(matchLines(parent, result, options.Algorithm), []int{len(parent), len(result)})
+		for _, pair := range lcs {
+			removed[i][resultPos] = append(removed[i][resultPos], parent[parentPos:pair[0]]...)
+			if pair[1] < len(result) {
+				present[i][pair[1]] = true
+			}
+			parentPos, resultPos = pair[0]+1, pair[1]+1
+		}
+	}
+
+	lines := []combinedLine{}
+	for j := 0; j <= len(result); j++ {
+		for i := range parents {
+			for _, text := range removed[i][j] {
+				line := combinedLine{text: text, marks: []byte(strings.Repeat(" ", len(parents))), inParent: make([]bool, len(parents))}
+				line.marks[i] = '-'
+				line.inParent[i] = true
+				lines = append(lines, line)
+			}
+		}
+		if j == len(result) {
+			break
+		}
+		line := combinedLine{text: result[j], marks: make([]byte, len(parents)), inParent: make([]bool, len(parents)), inResult: true}
+		for i := range parents {
+			line.marks[i] = '+'
+			if present[i][j] {
+				line.marks[i] = ' '
+				line.inParent[i] = true
+			}
+		}
+		lines = append(lines, line)
+	}
+
+	// Keep the changed lines and their context
+	keep := make([]bool, len(lines))
+	for k, line := range lines {
+		if strings.Trim(string(line.marks), " ") == "" {
+			continue
+		}
+		for c := max(0, k-options.ContextLines); c < min(len(lines), k+options.ContextLines+1); c++ {
+			keep[c] = true
+		}
+	}
+
+	chunks := []CombinedChunk{}
+	oldCounts := make([]int, len(parents))
+	newCount := 0
+	var chunk *CombinedChunk
+	for k, line := range lines {
+		if keep[k] && chunk == nil {
+			chunk = &CombinedChunk{OldStarts: append([]int{}, oldCounts...), OldLengths: make([]int, len(parents)), NewStart: newCount}
+		}
+		if keep[k] {
+			chunk.Lines = append(chunk.Lines, string(line.marks)+line.text)
+			for i := range parents {
+				if line.inParent[i] {
+					chunk.OldLengths[i]++
+				}
+			}
+			if line.inResult {
+				chunk.NewLength++
+			}
+		}
+		for i := range parents {
+			if line.inParent[i] {
+				oldCounts[i]++
+			}
+		}
+		if line.inResult {
+			newCount++
+		}
+		if chunk != nil && (!keep[k] || k == len(lines)-1) {
+			// Starts are 1-based, except for an empty side
+			for i := range parents {
+				if chunk.OldLengths[i] > 0 {
+					chunk.OldStarts[i]++
+				}
+			}
+			if chunk.NewLength > 0 {
+				chunk.NewStart++
+			}
+			chunks = append(chunks, *chunk)
+			chunk = nil
+		}
+	}
+	return chunks
+}
+
+// FormatCombinedDiff formats combined diff results into a string
+func FormatCombinedDiff(results []CombinedDiffResult) string {
+	var buf strings.Builder
+
+	for _, result := range results {
+		buf.WriteString(fmt.Sprintf("diff --combined %s\n", result.Path))
+		parents := make([]string, len(result.Parents))
+		for i, objID := range result.Parents {
+			parents[i] = shortID(objID)
+			if objID == "" {
+				parents[i] = strings.Repeat("0", 8)
+			}
+		}
+		resultID := shortID(result.Result)
+		if result.Result == "" {
+			resultID = strings.Repeat("0", 8)
+		}
+		buf.WriteString(fmt.Sprintf("index %s..%s\n", strings.Join(parents, ","), resultID))
+
+		if result.Binary {
+			buf.WriteString("Binary files differ\n\n")
+			continue
+		}
+
+		buf.WriteString(fmt.Sprintf("--- a/%s\n", result.Path))
+		if result.Result == "" {
+			buf.WriteString("+++ /dev/null\n")
+		} else {
+			buf.WriteString(fmt.Sprintf("+++ b/%s\n", result.Path))
+		}
+
+		marker := strings.Repeat("@", len(result.Parents)+1)
+		for _, chunk := range result.Chunks {
+			buf.WriteString(marker)
+			for i := range chunk.OldStarts {
+				buf.WriteString(fmt.Sprintf(" -%d,%d", chunk.OldStarts[i], chunk.OldLengths[i]))
+			}
+			buf.WriteString(fmt.Sprintf(" +%d,%d %s\n", chunk.NewStart, chunk.NewLength, marker))
+			for _, line := range chunk.Lines {
+				buf.WriteString(line + "\n")
+			}
+		}
+
+		buf.WriteString("\n")
+	}
+
+	return buf.String()
+}
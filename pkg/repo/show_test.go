@@ -0,0 +1,76 @@
+package repo
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestShow(t *testing.T) {
+	repo := newTestRepository(t)
+	commitTestFiles(t, repo, "First", map[string]string{"a.txt": "1\n", "b.txt": "b\n"})
+	commitID := commitTestFiles(t, repo, "Second", map[string]string{"a.txt": "2\n"})
+
+	output, err := repo.Show("HEAD", nil)
+	if err != nil {
+		t.Fatalf("Failed to show: %v", err)
+	}
+	expected := "\n    Second\n\n--- a/a.txt\n+++ b/a.txt\n@@ -1,1 +1,1 @@\n-1\n+2\n"
+	if !strings.HasPrefix(output, "commit "+commitID+"\n") || !strings.Contains(output, expected) {
+		t.Errorf("Expected commit header and patch, got:\n%s", output)
+	}
+
+	output, err = repo.Show("HEAD~1:a.txt", nil)
+	if err != nil || output != "1\n" {
+		t.Errorf("Expected old file contents, got %q (%v)", output, err)
+	}
+}
+
+func TestShowMerge(t *testing.T) {
+	repo := newTestRepository(t)
+	commitTestFiles(t, repo, "Base", map[string]string{"a.txt": "1\n2\n3\n4\n5\n6\n7\n8\n", "b.txt": "b\n"})
+	if err := repo.CreateBranch("other"); err != nil {
+		t.Fatalf("Failed to create branch: %v", err)
+	}
+	commitTestFiles(t, repo, "Ours", map[string]string{"a.txt": "one\n2\n3\n4\n5\n6\n7\n8\n"})
+	if err := repo.CheckoutBranch("other"); err != nil {
+		t.Fatalf("Failed to checkout: %v", err)
+	}
+	commitTestFiles(t, repo, "Theirs", map[string]string{"a.txt": "1\n2\n3\n4\n5\n6\n7\neight\n", "b.txt": "theirs\n"})
+	if err := repo.CheckoutBranch("main"); err != nil {
+		t.Fatalf("Failed to checkout: %v", err)
+	}
+	result, err := repo.Merge("other", &MergeOptions{Strategy: AutoMerge, Message: "Merge"})
+	if err != nil || !result.Success {
+		t.Fatalf("Failed to merge: %v", err)
+	}
+
+	results, err := repo.CombinedDiff(result.MergedCommit, &DiffOptions{ContextLines: 1})
+	if err != nil {
+		t.Fatalf("Failed to diff merge: %v", err)
+	}
+	// b.txt was taken unchanged from their side
+	if len(results) != 1 || results[0].Path != "a.txt" {
+		t.Fatalf("Expected only a.txt in the combined diff, got %+v", results)
+	}
+
+	expected := "--- a/a.txt\n+++ b/a.txt\n" +
+		"@@@ -1,2 -1,2 +1,2 @@@\n" +
+		" -1\n" +
+		" +one\n" +
+		"  2\n" +
+		"@@@ -7,2 -7,2 +7,2 @@@\n" +
+		"  7\n" +
+		"- 8\n" +
+		"+ eight\n"
+	if diff := FormatCombinedDiff(results); !strings.Contains(diff, expected) {
+		t.Errorf("Expected combined diff:\n%s\ngot:\n%s", expected, diff)
+	}
+
+	output, err := repo.Show(result.MergedCommit, nil)
+	if err != nil {
+		t.Fatalf("Failed to show merge: %v", err)
+	}
+	if !strings.Contains(output, "Merge: ") || !strings.Contains(output, "diff --combined a.txt\n") || strings.Contains(output, "b.txt") {
+		t.Errorf("Expected merge header and combined diff of a.txt, got:\n%s", output)
+	}
+}
@@ -0,0 +1,211 @@
+package repo
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultKitSparseFile lists what a sparse checkout includes, relative to the Kit directory
+const DefaultKitSparseFile = "info/sparse-checkout"
+
+// SparseCheckout decides which indexed files are present in the working
+// tree. In cone mode it holds directories: files at the top level, files
+// directly inside the parents of a listed directory and everything below a
+// listed directory are included. Otherwise it holds patterns in ignore file
+// syntax, where the last pattern matching a file or one of its directories
+// decides whether it is included.
+type SparseCheckout struct {
+	Cone     bool     // Whether Patterns are cone mode directories
+	Patterns []string // Directories or patterns, as stored in the sparse-checkout file
+	rules    []*IgnoreRule
+}
+
+// SparseCheckout returns the repository's sparse checkout, or nil if the
+// whole tree is checked out
+func (r *Repository) SparseCheckout() (*SparseCheckout, error) {
+	if !r.configBool("core", "sparsecheckout") {
+		return nil, nil
+	}
+
+	file := filepath.Join(r.Path, DefaultKitDir, DefaultKitSparseFile)
+	f, err := os.Open(file)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read sparse-checkout file: %w", err)
+	}
+
+	var patterns []string
+	if f != nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" && !strings.HasPrefix(line, "#") {
+				patterns = append(patterns, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read sparse-checkout file: %w", err)
+		}
+	}
+
+	return newSparseCheckout(patterns, r.configBool("core", "sparsecheckoutcone"))
+}
+
+// newSparseCheckout validates and compiles directories or patterns
+func newSparseCheckout(patterns []string, cone bool) (*SparseCheckout, error) {
+	s := &SparseCheckout{Cone: cone}
+	for _, pattern := range patterns {
+		if !cone {
+			if rule := parseIgnoreRule(pattern, ""); rule != nil {
+				s.rules = append(s.rules, rule)
+			}
+			s.Patterns = append(s.Patterns, pattern)
+			continue
+		}
+
+		dir := path.Clean("/" + filepath.ToSlash(pattern))[1:]
+		if dir == "" || strings.ContainsAny(dir, "*?[") {
+			return nil, fmt.Errorf("'%s' is not a directory; cone mode only accepts directories", pattern)
+		}
+		s.Patterns = append(s.Patterns, dir)
+	}
+
+	if cone {
+		sort.Strings(s.Patterns)
+	}
+
+	return s, nil
+}
+
+// Includes reports whether a file belongs in the working tree
+func (s *SparseCheckout) Includes(filePath string) bool {
+	filePath = filepath.ToSlash(filePath)
+
+	if s.Cone {
+		dir := path.Dir(filePath)
+		if dir == "." {
+			return true
+		}
+		for _, included := range s.Patterns {
+			if strings.HasPrefix(filePath, included+"/") || strings.HasPrefix(included, dir+"/") || included == dir {
+				return true
+			}
+		}
+		return false
+	}
+
+	isDir := false
+	for p := filePath; p != "."; p = path.Dir(p) {
+		for i := len(s.rules) - 1; i >= 0; i-- {
+			if s.rules[i].matches(p, isDir) {
+				return !s.rules[i].Negate
+			}
+		}
+		isDir = true
+	}
+	return false
+}
+
+// SetSparseCheckout enables sparse checkout with the given directories (cone
+// mode) or patterns and updates the working tree to match. Files that would
+// be removed but have local changes are kept and returned.
+func (r *Repository) SetSparseCheckout(patterns []string, cone bool) ([]string, error) {
+	sparse, err := newSparseCheckout(patterns, cone)
+	if err != nil {
+		return nil, err
+	}
+
+	file := filepath.Join(r.Path, DefaultKitDir, DefaultKitSparseFile)
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+	var sb strings.Builder
+	for _, pattern := range sparse.Patterns {
+		sb.WriteString(pattern + "\n")
+	}
+	if err := os.WriteFile(file, []byte(sb.String()), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write sparse-checkout file: %w", err)
+	}
+
+	if err := r.SetConfigValue("core", "sparsecheckout", "true"); err != nil {
+		return nil, err
+	}
+	if err := r.SetConfigValue("core", "sparsecheckoutcone", fmt.Sprint(cone)); err != nil {
+		return nil, err
+	}
+
+	return r.applySparseCheckout(sparse)
+}
+
+// DisableSparseCheckout turns sparse checkout off and restores every
+// indexed file to the working tree
+func (r *Repository) DisableSparseCheckout() error {
+	if err := r.SetConfigValue("core", "sparsecheckout", "false"); err != nil {
+		return err
+	}
+
+	_, err := r.applySparseCheckout(nil)
+	return err
+}
+
+// applySparseCheckout writes indexed files the sparse checkout includes
+// and removes the ones it excludes, marking them skip-worktree. Excluded
+// files with local changes are kept and returned.
+func (r *Repository) applySparseCheckout(sparse *SparseCheckout) ([]string, error) {
+	index := r.indexEntries()
+	paths := make([]string, 0, len(index))
+	for path := range index {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	kept := []string{}
+	for _, path := range paths {
+		objID := index[path]
+
+		if sparse == nil || sparse.Includes(path) {
+			if !r.State.SkipWorktree[path] {
+				continue
+			}
+			// Files left in place while skipped may have changed, keep them
+			current, err := r.workingFileID(path)
+			if err != nil {
+				return nil, err
+			}
+			if current == "" {
+				if err := r.writeWorkingFile(path, objID); err != nil {
+					return nil, err
+				}
+			}
+			delete(r.State.SkipWorktree, path)
+			continue
+		}
+
+		if r.State.SkipWorktree[path] {
+			continue
+		}
+		current, err := r.workingFileID(path)
+		if err != nil {
+			return nil, err
+		}
+		if current != "" && current != objID {
+			kept = append(kept, path)
+			continue
+		}
+		if err := r.removeWorkingFile(path); err != nil {
+			return nil, err
+		}
+		r.State.SkipWorktree[path] = true
+	}
+
+	if err := r.SaveIndex(); err != nil {
+		return nil, fmt.Errorf("failed to save index: %w", err)
+	}
+
+	return kept, nil
+}
@@ -0,0 +1,123 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fileExists reports whether a working tree file exists
+func fileExists(repo *Repository, path string) bool {
+	_, err := os.Lstat(filepath.Join(repo.Path, path))
+	return err == nil
+}
+
+func TestSparseCheckoutIncludes(t *testing.T) {
+	cone, err := newSparseCheckout([]string{"a/b/"}, true)
+	if err != nil {
+		t.Fatalf("Failed to create sparse checkout: %v", err)
+	}
+	patterns, err := newSparseCheckout([]string{"/*", "!/*/", "/docs/", "*.md", "!docs/drafts/"}, false)
+	if err != nil {
+		t.Fatalf("Failed to create sparse checkout: %v", err)
+	}
+
+	tests := []struct {
+		sparse *SparseCheckout
+		path   string
+		want   bool
+	}{
+		{cone, "top.txt", true},
+		{cone, "a/x.txt", true},
+		{cone, "a/b/y.txt", true},
+		{cone, "a/b/c/deep.txt", true},
+		{cone, "a/c/z.txt", false},
+		{cone, "ab/z.txt", false},
+		{patterns, "top.txt", true},
+		{patterns, "src/main.go", false},
+		{patterns, "src/README.md", true},
+		{patterns, "docs/guide/intro.txt", true},
+		{patterns, "docs/drafts/next.txt", false},
+	}
+
+	for _, test := range tests {
+		if got := test.sparse.Includes(test.path); got != test.want {
+			t.Errorf("Includes(%s) in cone mode %v: expected %v, got %v", test.path, test.sparse.Cone, test.want, got)
+		}
+	}
+
+	if _, err := newSparseCheckout([]string{"src/*.go"}, true); err == nil {
+		t.Error("Expected cone mode to reject patterns")
+	}
+}
+
+func TestSparseCheckout(t *testing.T) {
+	repo := newTestRepository(t)
+	commitTestFiles(t, repo, "Initial commit", map[string]string{
+		"top.txt":   "top\n",
+		"app/a.txt": "app\n",
+		"lib/b.txt": "lib\n",
+		"lib/c.txt": "lib\n",
+	})
+
+	// Excluded files with local changes stay
+	writeTestFile(t, repo, "lib/c.txt", "local\n")
+	kept, err := repo.SetSparseCheckout([]string{"app"}, true)
+	if err != nil {
+		t.Fatalf("Failed to set sparse checkout: %v", err)
+	}
+	if strings.Join(kept, ",") != "lib/c.txt" {
+		t.Errorf("Expected lib/c.txt to be kept, got %v", kept)
+	}
+	if fileExists(repo, "lib/b.txt") || !fileExists(repo, "app/a.txt") || !fileExists(repo, "top.txt") {
+		t.Error("Expected only files outside the cone to be removed")
+	}
+
+	// Skipped files survive reloading the index and aren't reported as deleted
+	repo, err = NewRepository(repo.Path)
+	if err != nil {
+		t.Fatalf("Failed to open repository: %v", err)
+	}
+	if !repo.State.SkipWorktree["lib/b.txt"] || repo.State.SkipWorktree["lib/c.txt"] {
+		t.Errorf("Expected only lib/b.txt to be skip-worktree, got %v", repo.State.SkipWorktree)
+	}
+	status, err := repo.Status()
+	if err != nil {
+		t.Fatalf("Failed to get status: %v", err)
+	}
+	if strings.Contains(status, "lib/b.txt") {
+		t.Errorf("Expected skip-worktree file not to be reported, got:\n%s", status)
+	}
+
+	// Committing keeps skipped files in the tree
+	commitTestFiles(t, repo, "Change app", map[string]string{"app/a.txt": "app 2\n"})
+	tree, err := repo.getTreeFromCommit(mustResolve(t, repo, "HEAD"))
+	if err != nil {
+		t.Fatalf("Failed to read tree: %v", err)
+	}
+	if _, ok := tree.Entries["lib/b.txt"]; !ok {
+		t.Error("Expected skip-worktree file to stay in the committed tree")
+	}
+
+	// Resetting only materialises files inside the sparse checkout
+	if _, err := repo.Reset("HEAD~1", ResetHard); err != nil {
+		t.Fatalf("Failed to reset: %v", err)
+	}
+	if content := readTestFile(t, repo, "app/a.txt"); content != "app\n" {
+		t.Errorf("Expected app/a.txt to be reset, got %q", content)
+	}
+	if fileExists(repo, "lib/b.txt") || fileExists(repo, "lib/c.txt") {
+		t.Error("Expected reset not to write files outside the sparse checkout")
+	}
+
+	if err := repo.DisableSparseCheckout(); err != nil {
+		t.Fatalf("Failed to disable sparse checkout: %v", err)
+	}
+	if content := readTestFile(t, repo, "lib/b.txt"); content != "lib\n" {
+		t.Errorf("Expected lib/b.txt to be restored, got %q", content)
+	}
+	if len(repo.State.SkipWorktree) != 0 {
+		t.Errorf("Expected no skip-worktree entries, got %v", repo.State.SkipWorktree)
+	}
+}
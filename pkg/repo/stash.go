@@ -0,0 +1,451 @@
+package repo
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stashRef is the reference holding the most recent stash
+const stashRef = "refs/stash"
+
+// StashEntry represents a shelved set of changes
+type StashEntry struct {
+	Index     int       // Position in the stash stack (0 is the most recent)
+	ID        string    // Commit ID of the stash
+	Message   string    // Stash description
+	Timestamp time.Time // When the stash was created
+}
+
+// StashApplyOptions represents options for applying a stash
+type StashApplyOptions struct {
+	RestoreIndex bool // Also restore the staged state recorded in the stash
+}
+
+// StashPush saves the staged and unstaged changes of tracked files as a new
+// stash and reverts them in the working tree. When paths are given only the
+// matching files are stashed.
+//
+// Each stash is a commit whose first parent is HEAD and whose second parent is
+// a commit recording the index. The commit tree records the working tree.
+func (r *Repository) StashPush(message string, paths []string) (string, error) {
+	headID, err := r.resolveReference(r.State.HEAD)
+	if err != nil || headID == "" {
+		return "", fmt.Errorf("cannot stash: no commit history")
+	}
+
+	headCommit, err := r.readCommit(headID)
+	if err != nil {
+		return "", fmt.Errorf("failed to read HEAD commit: %w", err)
+	}
+
+	headTree, err := r.readTree(headCommit.Tree)
+	if err != nil {
+		return "", fmt.Errorf("failed to read HEAD tree: %w", err)
+	}
+
+	// Record the index: HEAD with the selected staged changes applied
+	indexBlobs := treeBlobs(headTree)
+	for path, objID := range r.State.Stage {
+		if matchPathspec(path, paths) {
+			indexBlobs[path] = objID
+		}
+	}
+	removed := []string{}
+	for path := range r.State.Removed {
+		if matchPathspec(path, paths) {
+			delete(indexBlobs, path)
+			removed = append(removed, path)
+		}
+	}
+
+	// Record the working tree: the index with the selected files as they are on disk
+	workBlobs := make(map[string]string, len(indexBlobs))
+	for path, objID := range indexBlobs {
+		workBlobs[path] = objID
+	}
+	stashed := removed
+	for path := range indexBlobs {
+		if matchPathspec(path, paths) {
+			stashed = append(stashed, path)
+		}
+	}
+	for _, path := range stashed {
+
+		content, err := r.readWorkingFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				delete(workBlobs, path)
+				continue
+			}
+			return "", fmt.Errorf("failed to read file %s: %w", path, err)
+		}
+
+		objID := hashContent(content)
+		if err := r.storeObject(objID, content); err != nil {
+			return "", fmt.Errorf("failed to store object: %w", err)
+		}
+		workBlobs[path] = objID
+	}
+
+	indexTreeID, err := r.storeTree(buildTree(indexBlobs))
+	if err != nil {
+		return "", err
+	}
+	workTreeID, err := r.storeTree(buildTree(workBlobs))
+	if err != nil {
+		return "", err
+	}
+
+	if indexTreeID == headCommit.Tree && workTreeID == headCommit.Tree {
+		return "", fmt.Errorf("no local changes to save")
+	}
+
+	// Describe the stash relative to the current branch and commit
+	branchName, err := r.GetCurrentBranch()
+	if err != nil {
+		branchName = "(no branch)"
+	}
+	subject, _, _ := strings.Cut(headCommit.Message, "\n")
+	description := fmt.Sprintf("%s: %s %s", branchName, shortID(headID), subject)
+
+	indexCommitID, err := r.storeCommit(&CommitObject{
+		Tree:      indexTreeID,
+		Parent:    headID,
+		Author:    defaultSignature,
+		Committer: defaultSignature,
+		Message:   "index on " + description,
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	stashMessage := "WIP on " + description
+	if message != "" {
+		stashMessage = fmt.Sprintf("On %s: %s", branchName, message)
+	}
+
+	stashID, err := r.storeCommit(&CommitObject{
+		Tree:      workTreeID,
+		Parent:    headID,
+		Parent2:   indexCommitID,
+		Author:    defaultSignature,
+		Committer: defaultSignature,
+		Message:   stashMessage,
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// Push the stash onto the stack
+	oldStashID, _ := r.resolveReference(stashRef)
+	if err := r.updateReference(stashRef, stashID); err != nil {
+		return "", fmt.Errorf("failed to update stash reference: %w", err)
+	}
+	if err := r.appendReflog(stashRef, oldStashID, stashID, stashMessage); err != nil {
+		return "", err
+	}
+
+	// Revert the stashed files to HEAD
+	for _, path := range stashed {
+		delete(r.State.Stage, path)
+		delete(r.State.Removed, path)
+
+		headEntry, inHead := headTree.Entries[path]
+		if !inHead {
+			if err := r.removeWorkingFile(path); err != nil {
+				return "", err
+			}
+			continue
+		}
+
+		if workBlobs[path] != headEntry.ObjID {
+			if err := r.writeWorkingFile(path, headEntry.ObjID); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	if err := r.SaveIndex(); err != nil {
+		return "", fmt.Errorf("failed to save index: %w", err)
+	}
+
+	return stashID, nil
+}
+
+// StashList returns the stash stack, most recent first
+func (r *Repository) StashList() ([]StashEntry, error) {
+	entries, err := r.ReadReflog(stashRef)
+	if err != nil {
+		return nil, err
+	}
+
+	stashes := make([]StashEntry, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		stashes = append(stashes, StashEntry{
+			Index:     len(stashes),
+			ID:        entries[i].NewID,
+			Message:   entries[i].Message,
+			Timestamp: entries[i].Timestamp,
+		})
+	}
+
+	return stashes, nil
+}
+
+// StashShow returns the changes recorded in a stash relative to the commit it
+// was created on
+func (r *Repository) StashShow(index int, options *DiffOptions) ([]DiffResult, error) {
+	if options == nil {
+		options = &DefaultDiffOptions
+	}
+
+	entry, err := r.stashEntry(index)
+	if err != nil {
+		return nil, err
+	}
+
+	stash, err := r.readCommit(entry.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stash commit: %w", err)
+	}
+
+	baseTree, err := r.getTreeFromCommit(stash.Parent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stash base: %w", err)
+	}
+
+	stashTree, err := r.readTree(stash.Tree)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stash tree: %w", err)
+	}
+
+	return r.diffTrees(baseTree, stashTree, options)
+}
+
+// StashApply applies a stash on top of the current index and working tree.
+// The stash is merged with MergeTrees using the commit it was created on as
+// the base, so it can be applied after HEAD has moved. Conflicting files are
+// written with conflict markers and returned.
+func (r *Repository) StashApply(index int, options *StashApplyOptions) ([]MergeConflict, error) {
+	if options == nil {
+		options = &StashApplyOptions{}
+	}
+
+	entry, err := r.stashEntry(index)
+	if err != nil {
+		return nil, err
+	}
+
+	stash, err := r.readCommit(entry.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stash commit: %w", err)
+	}
+
+	baseTree, err := r.getTreeFromCommit(stash.Parent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stash base: %w", err)
+	}
+
+	theirTree, err := r.readTree(stash.Tree)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stash tree: %w", err)
+	}
+
+	ourBlobs := r.indexEntries()
+	ourTree := buildTree(ourBlobs)
+
+	mergedTree, conflicts, err := r.MergeTrees(baseTree, ourTree, theirTree, &MergeOptions{
+		Strategy:    Manual,
+		UseSemantic: false,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to merge stash: %w", err)
+	}
+
+	if options.RestoreIndex && len(conflicts) > 0 {
+		return nil, fmt.Errorf("conflicts in index, try without restoring the index")
+	}
+
+	conflicted := make(map[string]bool, len(conflicts))
+	for _, conflict := range conflicts {
+		conflicted[conflict.Path] = true
+	}
+
+	// Collect the paths the merge would touch
+	changed := []string{}
+	for path, entry := range mergedTree.Entries {
+		if ourBlobs[path] != entry.ObjID {
+			changed = append(changed, path)
+		}
+	}
+	for path := range ourBlobs {
+		if _, ok := mergedTree.Entries[path]; !ok && !conflicted[path] {
+			changed = append(changed, path)
+		}
+	}
+	for path := range conflicted {
+		changed = append(changed, path)
+	}
+	sort.Strings(changed)
+
+	// Refuse to overwrite local modifications
+	dirty := []string{}
+	for _, path := range changed {
+		workID, err := r.workingFileID(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file %s: %w", path, err)
+		}
+		if workID != ourBlobs[path] {
+			dirty = append(dirty, path)
+		}
+	}
+	if len(dirty) > 0 {
+		return nil, fmt.Errorf("your local changes to the following files would be overwritten by stash apply:\n\t%s",
+			strings.Join(dirty, "\n\t"))
+	}
+
+	// Update the working tree
+	for _, path := range changed {
+		if conflicted[path] {
+			continue
+		}
+		entry, ok := mergedTree.Entries[path]
+		if !ok {
+			if err := r.removeWorkingFile(path); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := r.writeWorkingFile(path, entry.ObjID); err != nil {
+			return nil, err
+		}
+
+		// Keep files added by the stash in the index so they don't become untracked
+		if _, tracked := r.State.Tracked[path]; !tracked {
+			r.State.Stage[path] = entry.ObjID
+		}
+	}
+
+	if err := r.WriteConflictMarkers(conflicts); err != nil {
+		return nil, fmt.Errorf("failed to write conflict markers: %w", err)
+	}
+
+	// Restore the staged state recorded in the stash
+	if options.RestoreIndex && stash.Parent2 != "" {
+		indexTree, err := r.getTreeFromCommit(stash.Parent2)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read stash index: %w", err)
+		}
+		for path, entry := range indexTree.Entries {
+			if baseEntry, ok := baseTree.Entries[path]; !ok || baseEntry.ObjID != entry.ObjID {
+				r.State.Stage[path] = entry.ObjID
+			}
+		}
+	}
+
+	if err := r.SaveIndex(); err != nil {
+		return nil, fmt.Errorf("failed to save index: %w", err)
+	}
+
+	return conflicts, nil
+}
+
+// StashPop applies a stash and drops it if it applied without conflicts
+func (r *Repository) StashPop(index int, options *StashApplyOptions) ([]MergeConflict, error) {
+	conflicts, err := r.StashApply(index, options)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(conflicts) > 0 {
+		// Keep the stash around so the changes are not lost
+		return conflicts, nil
+	}
+
+	if err := r.StashDrop(index); err != nil {
+		return nil, err
+	}
+
+	return conflicts, nil
+}
+
+// StashDrop removes a stash from the stack
+func (r *Repository) StashDrop(index int) error {
+	entries, err := r.ReadReflog(stashRef)
+	if err != nil {
+		return err
+	}
+
+	if index < 0 || index >= len(entries) {
+		return fmt.Errorf("stash@{%d} does not exist", index)
+	}
+
+	// The stack is stored oldest first
+	pos := len(entries) - 1 - index
+	entries = append(entries[:pos], entries[pos+1:]...)
+
+	if err := r.writeReflog(stashRef, entries); err != nil {
+		return err
+	}
+
+	if len(entries) == 0 {
+		refPath := r.refPath(stashRef)
+		if err := os.Remove(refPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove stash reference: %w", err)
+		}
+		return nil
+	}
+
+	return r.updateReference(stashRef, entries[len(entries)-1].NewID)
+}
+
+// stashEntry looks up a stash by its position in the stack
+func (r *Repository) stashEntry(index int) (StashEntry, error) {
+	stashes, err := r.StashList()
+	if err != nil {
+		return StashEntry{}, err
+	}
+
+	if len(stashes) == 0 {
+		return StashEntry{}, fmt.Errorf("no stash entries found")
+	}
+	if index < 0 || index >= len(stashes) {
+		return StashEntry{}, fmt.Errorf("stash@{%d} does not exist", index)
+	}
+
+	return stashes[index], nil
+}
+
+// ParseStashIndex parses a stash reference of the form "stash@{n}" or "n".
+// An empty string refers to the most recent stash.
+func ParseStashIndex(ref string) (int, error) {
+	if ref == "" {
+		return 0, nil
+	}
+
+	spec := ref
+	if strings.HasPrefix(spec, "stash@{") && strings.HasSuffix(spec, "}") {
+		spec = spec[len("stash@{") : len(spec)-1]
+	}
+
+	index, err := strconv.Atoi(spec)
+	if err != nil || index < 0 {
+		return 0, fmt.Errorf("invalid stash reference '%s'", ref)
+	}
+
+	return index, nil
+}
+
+// shortID abbreviates an object ID for display
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
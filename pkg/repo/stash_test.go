@@ -0,0 +1,155 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStashPushAndPop(t *testing.T) {
+	repo := newTestRepository(t)
+	commitTestFiles(t, repo, "Initial commit", map[string]string{
+		"a.txt": "one\ntwo\nthree\n",
+		"b.txt": "unchanged\n",
+	})
+
+	// Modify one file, stage a new one
+	writeTestFile(t, repo, "a.txt", "one\nTWO\nthree\n")
+	writeTestFile(t, repo, "c.txt", "new file\n")
+	if err := repo.Add("c.txt"); err != nil {
+		t.Fatalf("Failed to add c.txt: %v", err)
+	}
+
+	if _, err := repo.StashPush("work in progress", nil); err != nil {
+		t.Fatalf("Failed to stash: %v", err)
+	}
+
+	// The working tree and index should be back at HEAD
+	if got := readTestFile(t, repo, "a.txt"); got != "one\ntwo\nthree\n" {
+		t.Errorf("a.txt should be reverted, got %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(repo.Path, "c.txt")); !os.IsNotExist(err) {
+		t.Error("c.txt should be removed from the working tree")
+	}
+	if len(repo.State.Stage) != 0 {
+		t.Errorf("Stage should be empty after stash, got %v", repo.State.Stage)
+	}
+
+	stashes, err := repo.StashList()
+	if err != nil {
+		t.Fatalf("Failed to list stashes: %v", err)
+	}
+	if len(stashes) != 1 || stashes[0].Message != "On main: work in progress" {
+		t.Fatalf("Unexpected stash list: %+v", stashes)
+	}
+
+	conflicts, err := repo.StashPop(0, nil)
+	if err != nil {
+		t.Fatalf("Failed to pop stash: %v", err)
+	}
+	if len(conflicts) != 0 {
+		t.Fatalf("Expected no conflicts, got %d", len(conflicts))
+	}
+
+	if got := readTestFile(t, repo, "a.txt"); got != "one\nTWO\nthree\n" {
+		t.Errorf("a.txt should be restored, got %q", got)
+	}
+	if got := readTestFile(t, repo, "c.txt"); got != "new file\n" {
+		t.Errorf("c.txt should be restored, got %q", got)
+	}
+	if _, ok := repo.State.Stage["c.txt"]; !ok {
+		t.Error("c.txt should be staged again after pop")
+	}
+
+	stashes, _ = repo.StashList()
+	if len(stashes) != 0 {
+		t.Errorf("Stash should be dropped after pop, got %d entries", len(stashes))
+	}
+}
+
+func TestStashApplyOnChangedBase(t *testing.T) {
+	repo := newTestRepository(t)
+	commitTestFiles(t, repo, "Initial commit", map[string]string{
+		"a.txt": "one\n",
+		"b.txt": "two\n",
+	})
+
+	writeTestFile(t, repo, "a.txt", "one changed\n")
+	if _, err := repo.StashPush("", nil); err != nil {
+		t.Fatalf("Failed to stash: %v", err)
+	}
+
+	// Move HEAD forward with an unrelated change
+	commitTestFiles(t, repo, "Change b", map[string]string{"b.txt": "two changed\n"})
+
+	conflicts, err := repo.StashApply(0, nil)
+	if err != nil {
+		t.Fatalf("Failed to apply stash: %v", err)
+	}
+	if len(conflicts) != 0 {
+		t.Fatalf("Expected no conflicts, got %d", len(conflicts))
+	}
+
+	if got := readTestFile(t, repo, "a.txt"); got != "one changed\n" {
+		t.Errorf("a.txt should carry the stashed change, got %q", got)
+	}
+	if got := readTestFile(t, repo, "b.txt"); got != "two changed\n" {
+		t.Errorf("b.txt should keep the committed change, got %q", got)
+	}
+
+	// Apply keeps the entry
+	stashes, _ := repo.StashList()
+	if len(stashes) != 1 {
+		t.Errorf("Apply should keep the stash, got %d entries", len(stashes))
+	}
+}
+
+func TestStashPathsAndDrop(t *testing.T) {
+	repo := newTestRepository(t)
+	commitTestFiles(t, repo, "Initial commit", map[string]string{
+		"src/a.txt": "a\n",
+		"doc/b.txt": "b\n",
+	})
+
+	writeTestFile(t, repo, "src/a.txt", "a changed\n")
+	writeTestFile(t, repo, "doc/b.txt", "b changed\n")
+
+	if _, err := repo.StashPush("", []string{"src"}); err != nil {
+		t.Fatalf("Failed to stash: %v", err)
+	}
+
+	if got := readTestFile(t, repo, "src/a.txt"); got != "a\n" {
+		t.Errorf("src/a.txt should be stashed, got %q", got)
+	}
+	if got := readTestFile(t, repo, "doc/b.txt"); got != "b changed\n" {
+		t.Errorf("doc/b.txt should be left alone, got %q", got)
+	}
+
+	if _, err := repo.StashPush("", []string{"src"}); err == nil {
+		t.Error("Stashing without changes should fail")
+	}
+
+	if err := repo.StashDrop(0); err != nil {
+		t.Fatalf("Failed to drop stash: %v", err)
+	}
+	if err := repo.StashDrop(0); err == nil {
+		t.Error("Dropping a missing stash should fail")
+	}
+	if _, err := os.Stat(repo.refPath(stashRef)); !os.IsNotExist(err) {
+		t.Error("refs/stash should be removed once the stack is empty")
+	}
+}
+
+func TestParseStashIndex(t *testing.T) {
+	tests := map[string]int{"": 0, "2": 2, "stash@{3}": 3}
+	for ref, expected := range tests {
+		index, err := ParseStashIndex(ref)
+		if err != nil || index != expected {
+			t.Errorf("ParseStashIndex(%q) = %d, %v; expected %d", ref, index, err, expected)
+		}
+	}
+
+	if _, err := ParseStashIndex("stash@{x}"); err == nil {
+		t.Error("Expected error for invalid stash reference")
+	}
+}
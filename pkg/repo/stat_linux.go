@@ -0,0 +1,17 @@
+//go:build linux
+
+package repo
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// fileStat extracts the change time and inode number from file info
+func fileStat(info os.FileInfo) (ctime time.Time, inode uint64) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(st.Ctim.Sec, st.Ctim.Nsec), st.Ino
+	}
+	return info.ModTime(), 0
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package repo
+
+import (
+	"os"
+	"time"
+)
+
+// fileStat extracts the change time and inode number from file info. Only
+// the modification time is portable, so it stands in for the change time.
+func fileStat(info os.FileInfo) (ctime time.Time, inode uint64) {
+	return info.ModTime(), 0
+}
@@ -0,0 +1,521 @@
+package repo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// StatusCode describes how a file differs in one column of a status entry:
+// the index compared to HEAD, or the working tree compared to the index
+type StatusCode byte
+
+// Status codes, as shown in porcelain output
+const (
+	StatusUnmodified StatusCode = ' ' // No change
+	StatusModified   StatusCode = 'M' // Content changed
+	StatusAdded      StatusCode = 'A' // New file
+	StatusDeleted    StatusCode = 'D' // File removed
+	StatusRenamed    StatusCode = 'R' // File moved from OrigPath
+	StatusUnmerged   StatusCode = 'U' // Changed by a side of an unresolved merge
+	StatusUntracked  StatusCode = '?' // Not in the index
+)
+
+// String returns the name of a status code
+func (c StatusCode) String() string {
+	switch c {
+	case StatusUnmodified:
+		return "unmodified"
+	case StatusModified:
+		return "modified"
+	case StatusAdded:
+		return "added"
+	case StatusDeleted:
+		return "deleted"
+	case StatusRenamed:
+		return "renamed"
+	case StatusUnmerged:
+		return "unmerged"
+	case StatusUntracked:
+		return "untracked"
+	default:
+		return fmt.Sprintf("StatusCode(%q)", byte(c))
+	}
+}
+
+// MarshalText encodes a status code by its name
+func (c StatusCode) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// ConflictStages records which versions of an unmerged file exist, using
+// the stage numbers of a three-way merge
+type ConflictStages uint8
+
+// Conflict stages
+const (
+	ConflictBase   ConflictStages = 1 << iota // Stage 1: the merge base version
+	ConflictOurs                              // Stage 2: the current branch's version
+	ConflictTheirs                            // Stage 3: the merged branch's version
+)
+
+// Stages returns the stage numbers present, in order
+func (s ConflictStages) Stages() []int {
+	stages := []int{}
+	for stage := 1; stage <= 3; stage++ {
+		if s&(1<<(stage-1)) != 0 {
+			stages = append(stages, stage)
+		}
+	}
+	return stages
+}
+
+// MarshalJSON encodes conflict stages as a list of stage numbers
+func (s ConflictStages) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Stages())
+}
+
+// StatusEntry is a file that differs between HEAD, the index and the working tree
+type StatusEntry struct {
+	Path     string         `json:"path"`                // File path
+	OrigPath string         `json:"orig_path,omitempty"` // Source of a rename
+	Index    StatusCode     `json:"index"`               // Index compared to HEAD
+	WorkTree StatusCode     `json:"worktree"`            // Working tree compared to the index
+	Conflict ConflictStages `json:"conflict,omitempty"`  // Versions of an unmerged file
+}
+
+// StatusReport is the state of the working tree and index
+type StatusReport struct {
+	Branch       string        `json:"branch,omitempty"`        // Current branch, empty when HEAD is detached
+	Head         string        `json:"head,omitempty"`          // Commit HEAD points to, empty before the first commit
+	Upstream     string        `json:"upstream,omitempty"`      // Branch the current branch tracks, if configured
+	UpstreamGone bool          `json:"upstream_gone,omitempty"` // The upstream branch doesn't exist
+	Ahead        int           `json:"ahead"`                   // Commits on the branch but not on its upstream
+	Behind       int           `json:"behind"`                  // Commits on the upstream but not on the branch
+	Entries      []StatusEntry `json:"entries"`                 // Changed files by path, untracked files last
+}
+
+// StatusReport compares HEAD, the index and the working tree. Staged files
+// whose content matches a staged deletion are reported as renames, and
+// files left unmerged by a merge are reported with their conflict stages.
+// The upstream of a branch is set with "merge" in its [branch "<name>"]
+// configuration section.
+func (r *Repository) StatusReport() (*StatusReport, error) {
+	report := &StatusReport{Entries: []StatusEntry{}}
+	if err := r.statusBranch(report); err != nil {
+		return nil, err
+	}
+
+	index := r.indexEntries()
+	entries := make(map[string]*StatusEntry)
+	entry := func(path string) *StatusEntry {
+		if e, ok := entries[path]; ok {
+			return e
+		}
+		e := &StatusEntry{Path: path, Index: StatusUnmodified, WorkTree: StatusUnmodified}
+		entries[path] = e
+		return e
+	}
+	untracked := []string{}
+	seen := make(map[string]bool) // Files found in the working tree
+	refreshed := false            // Whether any stat cache entry was updated
+
+	// Compare working tree files with the index, skipping ignored paths
+	err := r.walkWorkTree(walkOptions{}, func(relPath string, d fs.DirEntry) error {
+		seen[relPath] = true
+
+		// Files outside a sparse checkout aren't compared, unmerged ones are reported below
+		if r.State.SkipWorktree[relPath] {
+			return nil
+		}
+		if _, ok := r.State.Conflicts[relPath]; ok {
+			return nil
+		}
+
+		indexID, ok := index[relPath]
+		if !ok {
+			untracked = append(untracked, relPath)
+			return nil
+		}
+
+		// Hash files only when the stat cache can't vouch for them
+		fileInfo, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objID, updated, err := r.cachedWorkingFileID(relPath, fileInfo)
+		if err != nil {
+			return err
+		}
+		refreshed = refreshed || updated
+
+		if objID != indexID {
+			entry(relPath).WorkTree = StatusModified
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk directory: %w", err)
+	}
+
+	// Keep refreshed stat data and fsmonitor state so the next status
+	// doesn't redo the same work; failing to save only costs speed
+	if refreshed || r.fsmonitorDirty {
+		_ = r.SaveIndex()
+	}
+
+	// Indexed files that weren't found have been deleted
+	for path := range index {
+		if _, conflicted := r.State.Conflicts[path]; !seen[path] && !r.State.SkipWorktree[path] && !conflicted {
+			entry(path).WorkTree = StatusDeleted
+		}
+	}
+
+	// Staged changes
+	for path, objID := range r.State.Stage {
+		if _, conflicted := r.State.Conflicts[path]; conflicted {
+			continue
+		}
+		if trackedID, tracked := r.State.Tracked[path]; !tracked {
+			entry(path).Index = StatusAdded
+		} else if trackedID != objID {
+			entry(path).Index = StatusModified
+		}
+	}
+	removed := []string{}
+	for path := range r.State.Removed {
+		if _, conflicted := r.State.Conflicts[path]; !conflicted {
+			entry(path).Index = StatusDeleted
+			removed = append(removed, path)
+		}
+	}
+	sort.Strings(removed)
+
+	// A new file with the content of a deleted one is a rename
+	added := []string{}
+	for path, e := range entries {
+		if e.Index == StatusAdded {
+			added = append(added, path)
+		}
+	}
+	sort.Strings(added)
+	for _, path := range added {
+		for i, source := range removed {
+			if r.State.Tracked[source] != index[path] {
+				continue
+			}
+			entries[path].Index = StatusRenamed
+			entries[path].OrigPath = source
+			delete(entries, source)
+			removed = append(removed[:i], removed[i+1:]...)
+			break
+		}
+	}
+
+	// Unmerged files
+	for path, conflict := range r.State.Conflicts {
+		var stages ConflictStages
+		if conflict.Base != "" {
+			stages |= ConflictBase
+		}
+		if conflict.Ours != "" {
+			stages |= ConflictOurs
+		}
+		if conflict.Theirs != "" {
+			stages |= ConflictTheirs
+		}
+		e := entry(path)
+		e.Index, e.WorkTree = conflictCodes(stages)
+		e.Conflict = stages
+	}
+
+	paths := make([]string, 0, len(entries))
+	for path, e := range entries {
+		if e.Index != StatusUnmodified || e.WorkTree != StatusUnmodified {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		report.Entries = append(report.Entries, *entries[path])
+	}
+
+	sort.Strings(untracked)
+	for _, path := range untracked {
+		report.Entries = append(report.Entries, StatusEntry{Path: path, Index: StatusUntracked, WorkTree: StatusUntracked})
+	}
+
+	return report, nil
+}
+
+// statusBranch fills in the branch, HEAD and upstream of a status report
+func (r *Repository) statusBranch(report *StatusReport) error {
+	branch, err := r.GetCurrentBranch()
+	if err != nil {
+		// Detached HEAD
+		report.Head, _ = r.ResolveRevision("HEAD")
+		return nil
+	}
+	report.Branch = branch
+	if head, err := r.resolveReference("refs/heads/" + branch); err == nil {
+		report.Head = head
+	}
+
+	upstream, ok := r.ConfigValue(fmt.Sprintf("branch \"%s\"", branch), "merge")
+	upstream = strings.TrimPrefix(upstream, "refs/heads/")
+	if !ok || upstream == "" {
+		return nil
+	}
+	report.Upstream = upstream
+
+	upstreamID, err := r.resolveReference("refs/heads/" + upstream)
+	if err != nil || upstreamID == "" {
+		report.UpstreamGone = true
+		return nil
+	}
+	if report.Head == "" {
+		return nil
+	}
+
+	ours, err := r.ancestry(report.Head)
+	if err != nil {
+		return err
+	}
+	theirs, err := r.ancestry(upstreamID)
+	if err != nil {
+		return err
+	}
+	for commitID := range ours {
+		if !theirs[commitID] {
+			report.Ahead++
+		}
+	}
+	for commitID := range theirs {
+		if !ours[commitID] {
+			report.Behind++
+		}
+	}
+	return nil
+}
+
+// ancestry returns a commit and all of its ancestors
+func (r *Repository) ancestry(commitID string) (map[string]bool, error) {
+	commits := make(map[string]bool)
+	queue := []string{commitID}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if id == "" || commits[id] {
+			continue
+		}
+		commits[id] = true
+
+		node, err := r.commitNode(id)
+		if err != nil {
+			return nil, err
+		}
+		queue = append(queue, node.Parents...)
+	}
+	return commits, nil
+}
+
+// conflictPaths returns the unmerged files, sorted
+func (r *Repository) conflictPaths() []string {
+	paths := make([]string, 0, len(r.State.Conflicts))
+	for path := range r.State.Conflicts {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// conflictCodes returns the status codes of an unmerged file, which tell
+// how each side changed it
+func conflictCodes(stages ConflictStages) (StatusCode, StatusCode) {
+	switch stages {
+	case ConflictBase:
+		return StatusDeleted, StatusDeleted
+	case ConflictOurs:
+		return StatusAdded, StatusUnmerged
+	case ConflictTheirs:
+		return StatusUnmerged, StatusAdded
+	case ConflictBase | ConflictOurs:
+		return StatusUnmerged, StatusDeleted
+	case ConflictBase | ConflictTheirs:
+		return StatusDeleted, StatusUnmerged
+	case ConflictOurs | ConflictTheirs:
+		return StatusAdded, StatusAdded
+	default:
+		return StatusUnmerged, StatusUnmerged
+	}
+}
+
+// conflictDescription describes how the sides of a merge changed an unmerged file
+func conflictDescription(stages ConflictStages) string {
+	switch stages {
+	case ConflictBase:
+		return "both deleted"
+	case ConflictOurs:
+		return "added by us"
+	case ConflictTheirs:
+		return "added by them"
+	case ConflictBase | ConflictOurs:
+		return "deleted by them"
+	case ConflictBase | ConflictTheirs:
+		return "deleted by us"
+	case ConflictOurs | ConflictTheirs:
+		return "both added"
+	default:
+		return "both modified"
+	}
+}
+
+// Clean reports whether there is nothing to commit and no untracked files
+func (s *StatusReport) Clean() bool {
+	return len(s.Entries) == 0
+}
+
+// String formats the report for people
+func (s *StatusReport) String() string {
+	var sb strings.Builder
+	if s.Branch != "" {
+		sb.WriteString(fmt.Sprintf("On branch %s\n", s.Branch))
+	} else {
+		sb.WriteString(fmt.Sprintf("HEAD detached at %s\n", shortID(s.Head)))
+	}
+
+	if s.Upstream != "" {
+		commits := func(n int) string {
+			if n == 1 {
+				return "1 commit"
+			}
+			return fmt.Sprintf("%d commits", n)
+		}
+		switch {
+		case s.UpstreamGone:
+			sb.WriteString(fmt.Sprintf("Your branch is based on '%s', but the upstream is gone.\n", s.Upstream))
+		case s.Ahead > 0 && s.Behind > 0:
+			sb.WriteString(fmt.Sprintf("Your branch and '%s' have diverged,\nand have %d and %d different commits each, respectively.\n", s.Upstream, s.Ahead, s.Behind))
+		case s.Ahead > 0:
+			sb.WriteString(fmt.Sprintf("Your branch is ahead of '%s' by %s.\n", s.Upstream, commits(s.Ahead)))
+		case s.Behind > 0:
+			sb.WriteString(fmt.Sprintf("Your branch is behind '%s' by %s, and can be fast-forwarded.\n", s.Upstream, commits(s.Behind)))
+		default:
+			sb.WriteString(fmt.Sprintf("Your branch is up to date with '%s'.\n", s.Upstream))
+		}
+	}
+	sb.WriteString("\n")
+
+	var staged, unmerged, unstaged, untracked []string
+	for _, e := range s.Entries {
+		switch {
+		case e.Index == StatusUntracked:
+			untracked = append(untracked, e.Path)
+		case e.Conflict != 0:
+			unmerged = append(unmerged, fmt.Sprintf("%s: %s", conflictDescription(e.Conflict), e.Path))
+		default:
+			switch e.Index {
+			case StatusModified:
+				staged = append(staged, "modified: "+e.Path)
+			case StatusAdded:
+				staged = append(staged, "new file: "+e.Path)
+			case StatusDeleted:
+				staged = append(staged, "deleted:  "+e.Path)
+			case StatusRenamed:
+				staged = append(staged, fmt.Sprintf("renamed:  %s -> %s", e.OrigPath, e.Path))
+			}
+			switch e.WorkTree {
+			case StatusModified:
+				unstaged = append(unstaged, "modified: "+e.Path)
+			case StatusDeleted:
+				unstaged = append(unstaged, "deleted:  "+e.Path)
+			}
+		}
+	}
+
+	sections := []struct {
+		title string
+		lines []string
+	}{
+		{"Changes to be committed:", staged},
+		{"Unmerged paths:", unmerged},
+		{"Changes not staged for commit:", unstaged},
+		{"Untracked files:", untracked},
+	}
+	for _, section := range sections {
+		if len(section.lines) == 0 {
+			continue
+		}
+		sb.WriteString(section.title + "\n")
+		for _, line := range section.lines {
+			sb.WriteString("  " + line + "\n")
+		}
+		sb.WriteString("\n")
+	}
+
+	if s.Clean() {
+		sb.WriteString("nothing to commit, working tree clean\n")
+	}
+
+	return sb.String()
+}
+
+// Porcelain formats the report in a stable format for scripts. The first
+// line describes the branch:
+//
+//	## <branch>[...<upstream> [ahead <n>, behind <m>]]
+//	## HEAD (no branch)
+//	## No commits yet on <branch>
+//
+// followed by a line per entry: the index and working tree status codes,
+// a space and the path, as "<orig path> -> <path>" for renames. Paths
+// containing quotes, backslashes or control characters are quoted in Go
+// syntax.
+func (s *StatusReport) Porcelain() string {
+	var sb strings.Builder
+
+	switch {
+	case s.Branch == "":
+		sb.WriteString("## HEAD (no branch)\n")
+	case s.Head == "":
+		sb.WriteString(fmt.Sprintf("## No commits yet on %s\n", s.Branch))
+	default:
+		sb.WriteString("## " + s.Branch)
+		if s.Upstream != "" {
+			sb.WriteString("..." + s.Upstream)
+			switch {
+			case s.UpstreamGone:
+				sb.WriteString(" [gone]")
+			case s.Ahead > 0 && s.Behind > 0:
+				sb.WriteString(fmt.Sprintf(" [ahead %d, behind %d]", s.Ahead, s.Behind))
+			case s.Ahead > 0:
+				sb.WriteString(fmt.Sprintf(" [ahead %d]", s.Ahead))
+			case s.Behind > 0:
+				sb.WriteString(fmt.Sprintf(" [behind %d]", s.Behind))
+			}
+		}
+		sb.WriteString("\n")
+	}
+
+	for _, e := range s.Entries {
+		sb.WriteString(fmt.Sprintf("%c%c ", e.Index, e.WorkTree))
+		if e.OrigPath != "" {
+			sb.WriteString(porcelainPath(e.OrigPath) + " -> ")
+		}
+		sb.WriteString(porcelainPath(e.Path) + "\n")
+	}
+
+	return sb.String()
+}
+
+// porcelainPath quotes a path if it contains characters that would make
+// porcelain output ambiguous
+func porcelainPath(path string) string {
+	if strings.ContainsAny(path, "\"\\") || strings.IndexFunc(path, func(c rune) bool { return c < ' ' || c == 0x7f }) >= 0 {
+		return strconv.Quote(path)
+	}
+	return path
+}
@@ -0,0 +1,164 @@
+package repo
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStatusReport(t *testing.T) {
+	repo := newTestRepository(t)
+	commitTestFiles(t, repo, "Initial commit", map[string]string{
+		"a.txt":    "a\n",
+		"b.txt":    "b\n",
+		"old.txt":  "moved\n",
+		"gone.txt": "gone\n",
+	})
+
+	// Staged and then modified again, modified only, renamed, deleted and untracked
+	writeTestFile(t, repo, "a.txt", "a 2\n")
+	if err := repo.Add("a.txt"); err != nil {
+		t.Fatalf("Failed to add: %v", err)
+	}
+	writeTestFile(t, repo, "a.txt", "a 3\n")
+	writeTestFile(t, repo, "b.txt", "b 2\n")
+	if err := os.Rename(filepath.Join(repo.Path, "old.txt"), filepath.Join(repo.Path, "new.txt")); err != nil {
+		t.Fatalf("Failed to rename: %v", err)
+	}
+	if _, err := repo.AddPaths([]string{"old.txt", "new.txt"}, &AddOptions{All: true}); err != nil {
+		t.Fatalf("Failed to add: %v", err)
+	}
+	if err := os.Remove(filepath.Join(repo.Path, "gone.txt")); err != nil {
+		t.Fatalf("Failed to remove: %v", err)
+	}
+	writeTestFile(t, repo, "untracked.txt", "?\n")
+
+	report, err := repo.StatusReport()
+	if err != nil {
+		t.Fatalf("Failed to get status: %v", err)
+	}
+
+	expected := "## main\n" +
+		"MM a.txt\n" +
+		" M b.txt\n" +
+		" D gone.txt\n" +
+		"R  old.txt -> new.txt\n" +
+		"?? untracked.txt\n"
+	if porcelain := report.Porcelain(); porcelain != expected {
+		t.Errorf("Expected porcelain status:\n%s\ngot:\n%s", expected, porcelain)
+	}
+
+	text := report.String()
+	if strings.Count(text, "Changes not staged for commit:") != 1 {
+		t.Errorf("Expected a single unstaged changes section, got:\n%s", text)
+	}
+	for _, line := range []string{"  modified: a.txt\n", "  renamed:  old.txt -> new.txt\n", "  deleted:  gone.txt\n"} {
+		if !strings.Contains(text, line) {
+			t.Errorf("Expected status to contain %q, got:\n%s", line, text)
+		}
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatalf("Failed to encode status: %v", err)
+	}
+	if !strings.Contains(string(data), `{"path":"new.txt","orig_path":"old.txt","index":"renamed","worktree":"unmodified"}`) {
+		t.Errorf("Expected rename entry in JSON, got %s", data)
+	}
+}
+
+func TestStatusReportUpstream(t *testing.T) {
+	repo := newTestRepository(t)
+	commitTestFiles(t, repo, "Initial commit", map[string]string{"a.txt": "a\n"})
+
+	if err := repo.CreateBranch("feature"); err != nil {
+		t.Fatalf("Failed to create branch: %v", err)
+	}
+	commitTestFiles(t, repo, "Main change", map[string]string{"a.txt": "main\n"})
+	if err := repo.CheckoutBranch("feature"); err != nil {
+		t.Fatalf("Failed to checkout: %v", err)
+	}
+	commitTestFiles(t, repo, "Feature 1", map[string]string{"b.txt": "1\n"})
+	commitTestFiles(t, repo, "Feature 2", map[string]string{"b.txt": "2\n"})
+
+	if err := repo.SetConfigValue(`branch "feature"`, "merge", "refs/heads/main"); err != nil {
+		t.Fatalf("Failed to set config: %v", err)
+	}
+
+	report, err := repo.StatusReport()
+	if err != nil {
+		t.Fatalf("Failed to get status: %v", err)
+	}
+	if report.Branch != "feature" || report.Upstream != "main" || report.Ahead != 2 || report.Behind != 1 {
+		t.Errorf("Expected feature 2 ahead and 1 behind main, got %+v", report)
+	}
+	if porcelain := report.Porcelain(); porcelain != "## feature...main [ahead 2, behind 1]\n" {
+		t.Errorf("Expected branch header, got %q", porcelain)
+	}
+	if !strings.Contains(report.String(), "have 2 and 1 different commits each") {
+		t.Errorf("Expected diverged message, got:\n%s", report.String())
+	}
+}
+
+func TestStatusReportConflicts(t *testing.T) {
+	repo := newTestRepository(t)
+	commitTestFiles(t, repo, "Initial commit", map[string]string{"a.txt": "base\n"})
+
+	if err := repo.CreateBranch("other"); err != nil {
+		t.Fatalf("Failed to create branch: %v", err)
+	}
+	commitTestFiles(t, repo, "Ours", map[string]string{"a.txt": "ours\n"})
+	if err := repo.CheckoutBranch("other"); err != nil {
+		t.Fatalf("Failed to checkout: %v", err)
+	}
+	commitTestFiles(t, repo, "Theirs", map[string]string{"a.txt": "theirs\n"})
+	if err := repo.CheckoutBranch("main"); err != nil {
+		t.Fatalf("Failed to checkout: %v", err)
+	}
+
+	result, err := repo.Merge("other", &MergeOptions{Strategy: Manual})
+	if err != nil {
+		t.Fatalf("Failed to merge: %v", err)
+	}
+	if len(result.Conflicts) != 1 {
+		t.Fatalf("Expected a conflict, got %v", result.Conflicts)
+	}
+
+	// Conflicts survive reloading the index
+	repo, err = NewRepository(repo.Path)
+	if err != nil {
+		t.Fatalf("Failed to open repository: %v", err)
+	}
+	report, err := repo.StatusReport()
+	if err != nil {
+		t.Fatalf("Failed to get status: %v", err)
+	}
+	if len(report.Entries) != 1 || report.Entries[0].Conflict != ConflictBase|ConflictOurs|ConflictTheirs {
+		t.Fatalf("Expected a conflict with all stages, got %+v", report.Entries)
+	}
+	if !strings.Contains(report.Porcelain(), "UU a.txt\n") {
+		t.Errorf("Expected unmerged entry, got:\n%s", report.Porcelain())
+	}
+	if !strings.Contains(report.String(), "Unmerged paths:\n  both modified: a.txt\n") {
+		t.Errorf("Expected unmerged section, got:\n%s", report.String())
+	}
+
+	if _, err := repo.Commit("Merge"); err == nil {
+		t.Error("Expected commit to be refused with unresolved conflicts")
+	}
+
+	// Staging the file resolves the conflict
+	writeTestFile(t, repo, "a.txt", "resolved\n")
+	if err := repo.Add("a.txt"); err != nil {
+		t.Fatalf("Failed to add: %v", err)
+	}
+	report, err = repo.StatusReport()
+	if err != nil {
+		t.Fatalf("Failed to get status: %v", err)
+	}
+	if porcelain := report.Porcelain(); porcelain != "## main\nM  a.txt\n" {
+		t.Errorf("Expected resolved file to be staged, got:\n%s", porcelain)
+	}
+}
@@ -1,7 +1,6 @@
 package repo
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -239,13 +238,13 @@ func (r *Repository) verifyIndex(result *VerificationResult) error {
 		return nil
 	}
 
-	var index struct {
-		Stage    map[string]string        `json:"stage"`
-		Tracked  map[string]string        `json:"tracked"`
-		WorkTree map[string]WorkTreeEntry `json:"worktree"`
+	// An empty index is valid for a fresh repository
+	if len(data) == 0 {
+		return nil
 	}
 
-	if err := json.Unmarshal(data, &index); err != nil {
+	index, err := decodeIndex(data)
+	if err != nil {
 		result.Status = false
 		return nil
 	}
@@ -0,0 +1,253 @@
+package repo
+
+import (
+	"bytes"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxSimilarityFileSize is the largest file compared for similarity
+const maxSimilarityFileSize = 1 << 20
+
+// ExpandOptions represents options for expanding a sparse checkout
+type ExpandOptions struct {
+	Semantic  bool    // Also pull in files similar to the working set
+	Threshold float64 // Minimum semantic similarity; defaults to the semantic kernel's minimum score
+	DryRun    bool    // Only report what would be added
+}
+
+// SparseExpansion explains why a directory or pattern was added to a sparse checkout
+type SparseExpansion struct {
+	Pattern string // Directory (cone mode) or pattern added to the sparse checkout
+	Path    string // File that made it relevant
+	Reason  string // How the file relates to the working set
+}
+
+// ExpandSparseCheckout grows a sparse checkout to the working set its files
+// need. Go packages imported from the working set are added, following
+// imports transitively; module paths are taken from the go.mod files in the
+// index. With Semantic, files whose content is similar to a file of the
+// original working set are added too: the retrieval kernel's LSH bands pick
+// candidates, and the semantic kernel's similarity must reach the threshold.
+func (r *Repository) ExpandSparseCheckout(options *ExpandOptions) ([]SparseExpansion, error) {
+	if options == nil {
+		options = &ExpandOptions{}
+	}
+
+	sparse, err := r.SparseCheckout()
+	if err != nil {
+		return nil, err
+	}
+	if sparse == nil {
+		return nil, fmt.Errorf("sparse checkout is not enabled")
+	}
+
+	index := r.indexEntries()
+	paths := make([]string, 0, len(index))
+	for file := range index {
+		paths = append(paths, file)
+	}
+	sort.Strings(paths)
+
+	original := []string{}
+	for _, file := range paths {
+		if sparse.Includes(file) {
+			original = append(original, file)
+		}
+	}
+
+	patterns := append([]string{}, sparse.Patterns...)
+	expansions := []SparseExpansion{}
+	include := func(file, reason string) error {
+		pattern := "/" + file
+		if sparse.Cone {
+			pattern = path.Dir(file)
+		}
+		patterns = append(patterns, pattern)
+		expansions = append(expansions, SparseExpansion{Pattern: pattern, Path: file, Reason: reason})
+
+		sparse, err = newSparseCheckout(patterns, sparse.Cone)
+		return err
+	}
+
+	// Follow imports until every imported package is in the working set
+	modules := r.goModules(index)
+	packages := make(map[string][]string)
+	for _, file := range paths {
+		if strings.HasSuffix(file, ".go") && !strings.HasSuffix(file, "_test.go") {
+			dir := path.Dir(file)
+			packages[dir] = append(packages[dir], file)
+		}
+	}
+
+	visited := make(map[string]bool)
+	for progress := true; progress; {
+		progress = false
+		for _, file := range paths {
+			if visited[file] || !strings.HasSuffix(file, ".go") || !sparse.Includes(file) {
+				continue
+			}
+			visited[file] = true
+			progress = true
+
+			for _, imported := range r.goImports(file, index[file]) {
+				dir, ok := importDir(modules, imported)
+				if !ok {
+					continue
+				}
+				for _, pkgFile := range packages[dir] {
+					if sparse.Includes(pkgFile) {
+						continue
+					}
+					if err := include(pkgFile, fmt.Sprintf("package %s is imported by %s", imported, file)); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+	}
+
+	if options.Semantic {
+		threshold := options.Threshold
+		if threshold <= 0 {
+			threshold = r.SemanticKernel.MinimumScore
+		}
+
+		// Index the original working set by LSH band
+		bands := make(map[string][]string)
+		embeddings := make(map[string][]float64)
+		for _, file := range original {
+			content, ok := r.similarityContent(index[file])
+			if !ok {
+				continue
+			}
+			for _, band := range r.RetrievalKernel.LSHSignature(r.RetrievalKernel.MinHash(content)) {
+				bands[band] = append(bands[band], file)
+			}
+			embeddings[file] = r.SemanticKernel.CodeToEmbedding(content)
+		}
+
+		for _, file := range paths {
+			if sparse.Includes(file) {
+				continue
+			}
+			content, ok := r.similarityContent(index[file])
+			if !ok {
+				continue
+			}
+
+			candidates := make(map[string]bool)
+			for _, band := range r.RetrievalKernel.LSHSignature(r.RetrievalKernel.MinHash(content)) {
+				for _, related := range bands[band] {
+					candidates[related] = true
+				}
+			}
+			if len(candidates) == 0 {
+				continue
+			}
+
+			// Explain the addition with the most similar candidate
+			embedding := r.SemanticKernel.CodeToEmbedding(content)
+			best, bestScore := "", 0.0
+			for related := range candidates {
+				score := r.SemanticKernel.CosineSimilarity(embedding, embeddings[related])
+				if score > bestScore || (score == bestScore && related < best) {
+					best, bestScore = related, score
+				}
+			}
+			if bestScore < threshold {
+				continue
+			}
+			if err := include(file, fmt.Sprintf("similar to %s (similarity %.2f)", best, bestScore)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if options.DryRun || len(expansions) == 0 {
+		return expansions, nil
+	}
+
+	if _, err := r.SetSparseCheckout(patterns, sparse.Cone); err != nil {
+		return nil, err
+	}
+
+	return expansions, nil
+}
+
+// goModules maps the directories of the go.mod files in the index to their module paths
+func (r *Repository) goModules(index map[string]string) map[string]string {
+	modules := make(map[string]string)
+	for file, objID := range index {
+		if path.Base(file) != "go.mod" {
+			continue
+		}
+		content, err := r.readObject(objID)
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(content), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 2 && fields[0] == "module" {
+				if module, err := strconv.Unquote(fields[1]); err == nil {
+					fields[1] = module
+				}
+				modules[path.Dir(file)] = fields[1]
+				break
+			}
+		}
+	}
+	return modules
+}
+
+// goImports returns the import paths of a Go file, or nil if it can't be parsed
+func (r *Repository) goImports(file, objID string) []string {
+	content, err := r.readObject(objID)
+	if err != nil {
+		return nil
+	}
+	parsed, err := parser.ParseFile(token.NewFileSet(), file, content, parser.ImportsOnly)
+	if err != nil {
+		return nil
+	}
+
+	imports := make([]string, 0, len(parsed.Imports))
+	for _, spec := range parsed.Imports {
+		if imported, err := strconv.Unquote(spec.Path.Value); err == nil {
+			imports = append(imports, imported)
+		}
+	}
+	return imports
+}
+
+// similarityContent returns a blob's content if it is text small enough to compare
+func (r *Repository) similarityContent(objID string) (string, bool) {
+	content, err := r.readObject(objID)
+	if err != nil || len(content) == 0 || len(content) > maxSimilarityFileSize || bytes.IndexByte(content, 0) >= 0 {
+		return "", false
+	}
+	return string(content), true
+}
+
+// importDir returns the repository directory of an imported package, using
+// the module with the longest matching path
+func importDir(modules map[string]string, imported string) (string, bool) {
+	dir, longest := "", -1
+	for moduleDir, module := range modules {
+		if len(module) <= longest {
+			continue
+		}
+		switch {
+		case imported == module:
+			dir, longest = moduleDir, len(module)
+		case strings.HasPrefix(imported, module+"/"):
+			dir, longest = path.Join(moduleDir, imported[len(module)+1:]), len(module)
+		}
+	}
+	return dir, longest >= 0
+}
@@ -0,0 +1,73 @@
+package repo
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExpandSparseCheckout(t *testing.T) {
+	repo := newTestRepository(t)
+
+	handler := `package app
+
+import "strings"
+
+// Normalize cleans up a user name before it is stored
+func Normalize(name string) string {
+	name = strings.TrimSpace(name)
+	name = strings.ToLower(name)
+	return strings.ReplaceAll(name, " ", "_")
+}
+`
+	commitTestFiles(t, repo, "Initial commit", map[string]string{
+		"go.mod":        "module example.com/mono\n\ngo 1.21\n",
+		"app/main.go":   "package main\n\nimport \"example.com/mono/lib\"\n\nfunc main() { lib.Run() }\n",
+		"app/names.go":  handler,
+		"lib/lib.go":    "package lib\n\nimport (\n\t\"fmt\"\n\n\t\"example.com/mono/util\"\n)\n\nfunc Run() { fmt.Println(util.Name()) }\n",
+		"util/util.go":  "package util\n\nfunc Name() string { return \"util\" }\n",
+		"legacy/old.go": strings.Replace(handler, "package app", "package legacy", 1),
+		"other/x.txt":   "Unrelated release notes for the storage team.\n",
+	})
+
+	if _, err := repo.SetSparseCheckout([]string{"app"}, true); err != nil {
+		t.Fatalf("Failed to set sparse checkout: %v", err)
+	}
+
+	// Without --semantic only imports are followed, transitively
+	expansions, err := repo.ExpandSparseCheckout(&ExpandOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("Failed to expand sparse checkout: %v", err)
+	}
+	if len(expansions) != 2 {
+		t.Fatalf("Expected 2 expansions, got %v", expansions)
+	}
+	if expansions[0].Pattern != "lib" || expansions[0].Reason != "package example.com/mono/lib is imported by app/main.go" {
+		t.Errorf("Unexpected first expansion: %+v", expansions[0])
+	}
+	if expansions[1].Pattern != "util" || expansions[1].Reason != "package example.com/mono/util is imported by lib/lib.go" {
+		t.Errorf("Unexpected second expansion: %+v", expansions[1])
+	}
+	if fileExists(repo, "lib/lib.go") {
+		t.Error("Expected dry run not to change the working tree")
+	}
+
+	expansions, err = repo.ExpandSparseCheckout(&ExpandOptions{Semantic: true})
+	if err != nil {
+		t.Fatalf("Failed to expand sparse checkout: %v", err)
+	}
+	if len(expansions) != 3 {
+		t.Fatalf("Expected 3 expansions, got %v", expansions)
+	}
+	if expansions[2].Path != "legacy/old.go" || !strings.HasPrefix(expansions[2].Reason, "similar to app/names.go") {
+		t.Errorf("Expected legacy/old.go to be similar to app/names.go, got %+v", expansions[2])
+	}
+
+	for _, file := range []string{"lib/lib.go", "util/util.go", "legacy/old.go"} {
+		if !fileExists(repo, file) {
+			t.Errorf("Expected %s to be checked out", file)
+		}
+	}
+	if fileExists(repo, "other/x.txt") {
+		t.Error("Expected unrelated files to stay out of the working tree")
+	}
+}
@@ -0,0 +1,308 @@
+package repo
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// writeWorkingFile writes the content of a blob to the working tree and
+// refreshes the cached working tree entry for it
+func (r *Repository) writeWorkingFile(path, objID string) error {
+	objectData, err := r.readObject(objID)
+	if err != nil {
+		return fmt.Errorf("failed to read object %s: %w", objID, err)
+	}
+
+	// Ensure parent directories exist
+	filePath := filepath.Join(r.Path, path)
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", filepath.Dir(filePath), err)
+	}
+
+	attrs, err := r.Attributes(path)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filePath, attrs.smudge(objectData), 0644); err != nil {
+		return fmt.Errorf("failed to write file %s: %w", filePath, err)
+	}
+	r.forgetAttributes(path)
+
+	fileInfo, err := os.Stat(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to get file info for %s: %w", filePath, err)
+	}
+
+	r.State.WorkTree[path] = newWorkTreeEntry(path, objID, fileInfo)
+
+	return nil
+}
+
+// removeWorkingFile deletes a file from the working tree, along with any
+// parent directories left empty, and forgets its cached entry
+func (r *Repository) removeWorkingFile(path string) error {
+	filePath := filepath.Join(r.Path, path)
+	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove file %s: %w", path, err)
+	}
+	delete(r.State.WorkTree, path)
+	r.forgetAttributes(path)
+
+	// Prune empty parent directories up to the repository root
+	for dir := filepath.Dir(filePath); dir != r.Path && len(dir) > len(r.Path); dir = filepath.Dir(dir) {
+		if err := os.Remove(dir); err != nil {
+			break
+		}
+	}
+
+	return nil
+}
+
+// workingFileID returns the object ID the working tree file would have,
+// or an empty string if the file does not exist
+func (r *Repository) workingFileID(path string) (string, error) {
+	info, err := os.Lstat(filepath.Join(r.Path, path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to get file info for %s: %w", path, err)
+	}
+	if info.IsDir() {
+		return "", nil
+	}
+
+	objID, _, err := r.cachedWorkingFileID(path, info)
+	return objID, err
+}
+
+// materializeTree updates the working tree from one snapshot of files
+// (path -> blob object ID) to another. Files of the target snapshot are
+// written unless their content already matches, and files only in the
+// source snapshot are deleted. Other files are left alone. Target files
+// outside a sparse checkout are not written but marked skip-worktree.
+func (r *Repository) materializeTree(from, to map[string]string) error {
+	sparse, err := r.SparseCheckout()
+	if err != nil {
+		return err
+	}
+
+	// Remove files first, so a directory can replace a file of the same name
+	removed := make([]string, 0)
+	for path := range from {
+		_, ok := to[path]
+		if !ok || (sparse != nil && !sparse.Includes(path)) {
+			removed = append(removed, path)
+		}
+	}
+	sort.Strings(removed)
+	for _, path := range removed {
+		if err := r.removeWorkingFile(path); err != nil {
+			return err
+		}
+		delete(r.State.SkipWorktree, path)
+	}
+
+	paths := make([]string, 0, len(to))
+	for path := range to {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		if sparse != nil && !sparse.Includes(path) {
+			r.State.SkipWorktree[path] = true
+			continue
+		}
+		delete(r.State.SkipWorktree, path)
+
+		current, err := r.workingFileID(path)
+		if err != nil {
+			return err
+		}
+		if current == to[path] {
+			continue
+		}
+		if err := r.writeWorkingFile(path, to[path]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// newWorkTreeEntry records the stat data of a working tree file whose
+// content hashes to objID
+func newWorkTreeEntry(path, objID string, info os.FileInfo) WorkTreeEntry {
+	ctime, inode := fileStat(info)
+	return WorkTreeEntry{
+		Path:    path,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		Hash:    objID,
+		CTime:   ctime,
+		Inode:   inode,
+		Mode:    uint32(info.Mode()),
+	}
+}
+
+// matchesStat reports whether file info agrees with the cached stat data
+func (entry WorkTreeEntry) matchesStat(info os.FileInfo) bool {
+	ctime, inode := fileStat(info)
+	return entry.Hash != "" &&
+		entry.Size == info.Size() &&
+		entry.ModTime.Equal(info.ModTime()) &&
+		entry.CTime.Equal(ctime) &&
+		entry.Inode == inode &&
+		entry.Mode == uint32(info.Mode())
+}
+
+// cachedHash returns the cached object ID of a working tree file if its stat
+// data is unchanged. Entries modified no earlier than the index was written
+// are racily clean: the file may have changed again within the timestamp
+// resolution, so they are never trusted. Info served by the fsmonitor path
+// for files without changes is trusted as it is.
+func (r *Repository) cachedHash(path string, info os.FileInfo) (string, bool) {
+	// The fsmonitor daemon vouches for files it has seen no change to
+	if monitored, ok := info.(monitoredFileInfo); ok {
+		return monitored.entry.Hash, true
+	}
+	entry, ok := r.State.WorkTree[path]
+	if !ok || !entry.matchesStat(info) {
+		return "", false
+	}
+	if r.indexTime.IsZero() || !entry.ModTime.Before(r.indexTime) {
+		return "", false
+	}
+	return entry.Hash, true
+}
+
+// cachedWorkingFileID returns the object ID of a working tree file, hashing
+// it only when the stat cache can't vouch for it. It reports whether the
+// cached entry was refreshed, in which case the index is worth saving.
+func (r *Repository) cachedWorkingFileID(path string, info os.FileInfo) (string, bool, error) {
+	if objID, ok := r.cachedHash(path, info); ok {
+		return objID, false, nil
+	}
+
+	content, err := r.readWorkingFile(path)
+	if err != nil {
+		return "", false, err
+	}
+	objID := hashContent(content)
+	r.State.WorkTree[path] = newWorkTreeEntry(path, objID, info)
+
+	return objID, true, nil
+}
+
+// walkOptions controls which parts of the working tree walkWorkTree visits
+type walkOptions struct {
+	includeIgnored bool     // Visit ignored files too
+	pathspecs      []string // Skip directories that cannot contain matching files
+}
+
+// walkWorkTree calls fn for every file in the working tree that is either in
+// the index or not ignored. Ignored directories that contain no indexed
+// files are pruned without being read. When an fsmonitor daemon is running
+// only the paths it reports as changed are read from disk.
+func (r *Repository) walkWorkTree(options walkOptions, fn func(path string, d fs.DirEntry) error) error {
+	if !options.includeIgnored {
+		if response := r.queryFSMonitor(); response != nil {
+			return r.walkMonitoredWorkTree(response, options, fn)
+		}
+	}
+	return r.walkFullWorkTree(options, fn)
+}
+
+// walkFullWorkTree is walkWorkTree reading every directory from disk
+func (r *Repository) walkFullWorkTree(options walkOptions, fn func(path string, d fs.DirEntry) error) error {
+	matcher, err := r.NewIgnoreMatcher()
+	if err != nil {
+		return err
+	}
+
+	// Directories holding indexed files must always be descended into
+	indexedDirs := r.indexedDirs()
+
+	return filepath.WalkDir(r.Path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(r.Path, path)
+		if err != nil {
+			return err
+		}
+		if relPath == "." {
+			return nil
+		}
+
+		if d.IsDir() {
+			// Skip the .kit directory
+			if relPath == DefaultKitDir {
+				return filepath.SkipDir
+			}
+			if !pathspecCouldMatchBelow(filepath.ToSlash(relPath), options.pathspecs) {
+				return filepath.SkipDir
+			}
+			if indexedDirs[relPath] || options.includeIgnored {
+				return nil
+			}
+
+			// An ignored parent was descended into for its indexed files,
+			// so check the parents as well as the directory itself
+			ignored, _, err := matcher.IsIgnored(relPath, true)
+			if err != nil {
+				return err
+			}
+			if ignored {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// Symbolic links to directories are neither followed nor tracked
+		if d.Type()&fs.ModeSymlink != 0 && isDirSymlink(path) {
+			return nil
+		}
+
+		if !r.isInIndex(relPath) && !options.includeIgnored {
+			ignored, _, err := matcher.IsIgnored(relPath, false)
+			if err != nil {
+				return err
+			}
+			if ignored {
+				return nil
+			}
+		}
+
+		return fn(relPath, d)
+	})
+}
+
+// isDirSymlink reports whether the file at absPath is a symbolic link to a
+// directory
+func isDirSymlink(absPath string) bool {
+	linkInfo, err := os.Lstat(absPath)
+	if err != nil || linkInfo.Mode()&fs.ModeSymlink == 0 {
+		return false
+	}
+	info, err := os.Stat(absPath)
+	return err == nil && info.IsDir()
+}
+
+// indexedDirs returns every directory containing a tracked or staged file
+func (r *Repository) indexedDirs() map[string]bool {
+	dirs := make(map[string]bool)
+	for _, entries := range []map[string]string{r.State.Tracked, r.State.Stage} {
+		for path := range entries {
+			for dir := filepath.Dir(path); dir != "." && !dirs[dir]; dir = filepath.Dir(dir) {
+				dirs[dir] = true
+			}
+		}
+	}
+	return dirs
+}